@@ -13,6 +13,7 @@ require (
 	github.com/tickstep/cloudpan189-api v0.1.0
 	github.com/tickstep/library-go v0.1.1
 	github.com/urfave/cli v1.21.1-0.20190817182405-23c83030263f
+	golang.org/x/crypto v0.29.0
 )
 
 // 保持原版本 API 库，通过编译参数解决兼容性问题
@@ -26,7 +27,7 @@ require (
 	github.com/modern-go/reflect2 v0.0.0-20180701023420-4b7aa43c6742 // indirect
 	github.com/russross/blackfriday v1.5.2 // indirect
 	github.com/satori/go.uuid v1.2.0 // indirect
-	golang.org/x/sys v0.0.0-20200202164722-d101bd2416d5 // indirect
+	golang.org/x/sys v0.27.0 // indirect
 )
 
 //replace github.com/tickstep/bolt => /Users/tickstep/Documents/Workspace/go/projects/bolt
@@ -20,6 +20,8 @@ import (
 	"github.com/tickstep/cloudpan189-api/cloudpan/apierror"
 	"github.com/tickstep/cloudpan189-go/cmder"
 	"github.com/tickstep/cloudpan189-go/internal/config"
+	"github.com/tickstep/cloudpan189-go/internal/functions"
+	"github.com/tickstep/cloudpan189-go/internal/functions/panjournal"
 	"github.com/tickstep/cloudpan189-go/internal/functions/panupload"
 	"github.com/tickstep/library-go/logger"
 	"github.com/urfave/cli"
@@ -58,6 +60,9 @@ func CmdBackup() cli.Command {
     4. 将本地的 C:\Users\Administrator\Video 整个目录备份到网盘 /视频 目录，但是排除所有的 @eadir 文件夹
     cloudpan189-go backup -exn "^@eadir$" C:/Users/Administrator/Video /视频
 
+    5. 备份完成后彻底删除回收站中 30 天前从 /视频 删除的文件, 释放被旧版本占用的空间
+    cloudpan189-go backup -purge-recycle 30d C:/Users/Administrator/Video /视频
+
   参考：
     以下是典型的排除特定文件或者文件夹的例子，注意：参数值必须是正则表达式。在正则表达式中，^表示匹配开头，$表示匹配结尾。
     1)排除@eadir文件或者文件夹：-exn "^@eadir$"
@@ -76,6 +81,9 @@ func CmdBackup() cli.Command {
 		}, cli.BoolFlag{
 			Name:  "sync",
 			Usage: "本地同步到网盘（会同步删除网盘文件）",
+		}, maxDeleteFlag("同步删除网盘文件的数量上限, 文件数量 N 或占跟踪文件总数的比例 X%, 超过时需要确认, 0 表示不限制, 默认 1000 个或 50%"), cli.StringFlag{
+			Name:  "purge-recycle",
+			Usage: "备份完成后彻底删除回收站中删除时间早于指定时间之前、原位置在备份目录中的文件, 例如 30d, 不需要确认, 有文件上传失败时不清理",
 		}),
 	}
 }
@@ -85,7 +93,9 @@ func OpenSyncDb(path string) (panupload.SyncDb, error) {
 }
 
 // 删除那些本地不存在而网盘存在的网盘文件 默认使用本地数据库判断，如果 flagSync 为 true 则遍历网盘文件列表进行判断（速度较慢）。
-func DelRemoteFileFromDB(familyId int64, localDir string, savePath string, flagSync bool) {
+// 加密网盘模式下 enc 用于加密和解密文件名，未启用时为空。受保护的路径只有 forceProtected 为 true 时才删除。
+// 先找出全部需要删除的文件，数量超过 maxDelete 上限时需要确认，防止本地目录未挂载时删除整个网盘目录。
+func DelRemoteFileFromDB(familyId int64, localDir string, savePath string, flagSync, forceProtected bool, maxDelete *maxDeleteLimit, enc *UploadEncryption) {
 	activeUser := config.Config.ActiveUser()
 	var db panupload.SyncDb
 	var err error
@@ -99,11 +109,21 @@ func DelRemoteFileFromDB(familyId int64, localDir string, savePath string, flagS
 
 	defer db.Close()
 
-	savePath = path.Join(savePath, filepath.Base(localDir))
+	baseName, err := enc.encryptRelPath(filepath.Base(localDir))
+	if err != nil {
+		fmt.Println("加密目录名失败！", err)
+		return
+	}
+	savePath = path.Join(savePath, baseName)
+
+	// 同步删除的网盘文件记录为一条操作日志, 可以通过 undo 从回收站还原
+	journal := panjournal.NewEntry(panjournal.OpBackupDelete, familyId)
+	journal.TypeFlag = cloudpan.BatchTaskTypeDelete
+	defer panjournal.Record(journal)
 
-	//判断本地文件是否存在，如果存在返回 true 否则删除数据库相关记录和网盘上的文件。
+	//判断本地文件是否存在，不存在时返回 false。
 	isLocalFileExist := func(ent *panupload.UploadedFileMeta) (isExists bool) {
-		testPath := strings.TrimPrefix(ent.Path, savePath)
+		testPath := enc.decryptRelPath(strings.TrimPrefix(ent.Path, savePath))
 		testPath = filepath.Join(localDir, testPath)
 		logger.Verboseln("同步删除检测:", testPath, ent.Path)
 
@@ -115,8 +135,15 @@ func DelRemoteFileFromDB(familyId int64, localDir string, savePath string, flagS
 			}
 			return true
 		}
+		return false
+	}
 
-		var err *apierror.ApiError
+	//删除数据库相关记录和网盘上的文件。
+	delRemoteFile := func(ent *panupload.UploadedFileMeta) {
+		if err := checkProtectedPaths(familyId, forceProtected, ent.Path); err != nil {
+			fmt.Println("跳过同步删除:", err)
+			return
+		}
 
 		if ent.ParentId == "" {
 			if test := db.Get(path.Dir(ent.Path)); test != nil && test.IsFolder && test.FileID != "" {
@@ -145,42 +172,117 @@ func DelRemoteFileFromDB(familyId int64, localDir string, savePath string, flagS
 			return
 		}
 
-		var taskId string
+		efi := &cloudpan.AppFileEntity{
+			FileId:   ent.FileID,
+			FileName: path.Base(ent.Path),
+			Path:     ent.Path,
+			ParentId: ent.ParentId,
+			IsFolder: ent.IsFolder,
+		}
+		if err := functions.DeletePanFile(activeUser.PanClient(), familyId, efi); err != nil {
+			fmt.Println("删除网盘文件或目录失败", ent.Path, err)
+		} else {
+			db.DelWithPrefix(ent.Path)
+			journal.AddEntity(efi)
+			logger.Verboseln("删除网盘文件和数据库记录", ent.Path)
+		}
+	}
 
-		infoItem := &cloudpan.BatchTaskInfo{
-			FileId:      ent.FileID,
-			FileName:    path.Base(ent.Path),
-			IsFolder:    0,
-			SrcParentId: ent.ParentId,
+	//打包上传的文件是否仍存在，不修改记录中的时间，修改过的文件需要重新上传。
+	isPackMemberExist := func(ent *panupload.UploadedFileMeta) bool {
+		test := *ent
+		return isLocalFileExist(&test)
+	}
+
+	//删除打包上传的 tar 文件和索引，以及其中全部文件的记录，本地仍存在的文件随后重新打包上传。
+	retirePackSegment := func(segPath string, rec *packSegmentRecords) {
+		protectPaths := []string{segPath}
+		for _, ent := range rec.missing {
+			protectPaths = append(protectPaths, ent.Path)
 		}
-		if ent.IsFolder {
-			infoItem.IsFolder = 1
+		if err := checkProtectedPaths(familyId, forceProtected, protectPaths...); err != nil {
+			fmt.Println("跳过同步删除:", err)
+			return
 		}
 
-		delParam := &cloudpan.BatchTaskParam{
-			TypeFlag:  cloudpan.BatchTaskTypeDelete,
-			TaskInfos: cloudpan.BatchTaskInfoList{infoItem},
+		segmentId, indexId, parentId := rec.fileIds()
+		indexPath := segPath + functions.PackIndexSuffix
+		files := []*cloudpan.AppFileEntity{
+			{FileId: segmentId, FileName: path.Base(segPath), Path: segPath, ParentId: parentId},
+			{FileId: indexId, FileName: path.Base(indexPath), Path: indexPath, ParentId: parentId},
+		}
+		for _, efi := range files {
+			if efi.FileId == "" || efi.ParentId == "" {
+				info, err := activeUser.PanClient().AppGetBasicFileInfo(&cloudpan.AppGetFileInfoParam{
+					FileId:   efi.FileId,
+					FilePath: efi.Path,
+				})
+				if err != nil && err.Code == apierror.ApiCodeFileNotFoundCode {
+					continue
+				}
+				if info == nil {
+					return
+				}
+				efi.FileId = info.FileId
+				efi.ParentId = info.ParentId
+			}
+			if err := functions.DeletePanFile(activeUser.PanClient(), familyId, efi); err != nil {
+				fmt.Println("删除网盘文件失败", efi.Path, err)
+				return
+			}
+			journal.AddEntity(efi)
 		}
 
-		if familyId > 0 {
-			taskId, err = activeUser.PanClient().AppCreateBatchTask(familyId, delParam)
-		} else {
-			taskId, err = activeUser.PanClient().CreateBatchTask(delParam)
+		for _, ent := range rec.all() {
+			db.Del(ent.Path)
 		}
-		if err != nil || taskId == "" {
-			fmt.Println("删除网盘文件或目录失败", ent.Path, err)
-		} else {
-			db.DelWithPrefix(ent.Path)
-			logger.Verboseln("删除网盘文件和数据库记录", ent.Path)
+		if len(rec.alive) > 0 {
+			fmt.Printf("已删除打包文件 %s, 其中本地仍存在的 %d 个文件将重新上传\n", segPath, len(rec.alive))
+		}
+		logger.Verboseln("删除打包文件和数据库记录", segPath)
+	}
+
+	//删除本地不存在的文件，已删除目录中的文件不再单独删除。affected 为受影响的文件数量，total 为跟踪的文件总数。
+	//打包上传的文件按 tar 文件整体删除。
+	delMissingFiles := func(missing []*panupload.UploadedFileMeta, packs packRecords, affected, total int) {
+		retired := packs.retired()
+		if len(missing) == 0 && len(retired) == 0 {
+			return
+		}
+		if !maxDelete.confirmDelete(fmt.Sprintf("同步删除 %s 中本地不存在的文件（本地目录 %s，请检查是否未挂载或为空）", savePath, localDir), affected, total) {
+			return
+		}
+		deletedDir := ""
+		for _, ent := range missing {
+			if deletedDir != "" && strings.HasPrefix(ent.Path, deletedDir+"/") {
+				continue
+			}
+			if ent.IsFolder {
+				deletedDir = ent.Path
+			}
+			delRemoteFile(ent)
+		}
+		for _, segPath := range retired {
+			retirePackSegment(segPath, packs[segPath])
 		}
-		return
 	}
 
 	// 根据数据库记录删除不存在的文件
+	packs := packRecords{}
 	if !flagSync {
+		var missing []*panupload.UploadedFileMeta
+		total := 0
 		for ent, err := db.First(savePath); err == nil; ent, err = db.Next(savePath) {
-			isLocalFileExist(ent)
+			if packs.add(ent, isPackMemberExist) {
+				continue
+			}
+			total++
+			if !isLocalFileExist(ent) {
+				missing = append(missing, ent)
+			}
 		}
+		packTotal, packMissing := packs.members()
+		delMissingFiles(missing, packs, len(missing)+packMissing, total+packTotal)
 		return
 	}
 
@@ -196,7 +298,11 @@ func DelRemoteFileFromDB(familyId int64, localDir string, savePath string, flagS
 		parent.FileID = efi.FileId
 	}
 
-	var syncFunc func(curPath, parentID string)
+	var (
+		syncFunc func(curPath, parentID string)
+		missing  []*panupload.UploadedFileMeta
+		total    int
+	)
 
 	syncFunc = func(curPath, parentID string) {
 		param := cloudpan.NewAppFileListParam()
@@ -210,6 +316,10 @@ func DelRemoteFileFromDB(familyId int64, localDir string, savePath string, flagS
 			return
 		}
 		for _, fileEntity := range fileResult.FileList {
+			// tar 文件和索引根据打包上传的记录处理
+			if !fileEntity.IsFolder && functions.IsPackFileName(fileEntity.FileName) {
+				continue
+			}
 			ufm := &panupload.UploadedFileMeta{
 				FileID:   fileEntity.FileId,
 				ParentId: fileEntity.ParentId,
@@ -220,7 +330,9 @@ func DelRemoteFileFromDB(familyId int64, localDir string, savePath string, flagS
 				MD5:      strings.ToLower(fileEntity.FileMd5),
 			}
 
+			total++
 			if !isLocalFileExist(ufm) {
+				missing = append(missing, ufm)
 				continue
 			}
 
@@ -234,11 +346,25 @@ func DelRemoteFileFromDB(familyId int64, localDir string, savePath string, flagS
 		}
 	}
 
+	// 打包上传的文件在网盘上没有单独的文件, 先取出记录
+	for ent, err := db.First(savePath); err == nil; ent, err = db.Next(savePath) {
+		packs.add(ent, isPackMemberExist)
+	}
+
 	//开启自动清理功能
 	db.AutoClean(parent.Path, true)
 	db.Put(parent.Path, parent)
 
+	// 打包上传的记录不会在遍历网盘时更新, 重新写入以免被自动清理, 删除的 tar 文件随后删除记录
+	for _, rec := range packs {
+		for _, ent := range rec.all() {
+			db.Put(ent.Path, ent)
+		}
+	}
+
 	syncFunc(savePath, parent.FileID)
+	packTotal, packMissing := packs.members()
+	delMissingFiles(missing, packs, len(missing)+packMissing, total+packTotal)
 }
 
 func checkPath(localdir string) (string, error) {
@@ -282,16 +408,60 @@ func Backup(c *cli.Context) error {
 	flagSync := c.Bool("sync")
 	flagDelete := c.Bool("delete")
 
+	onConflict, err := panupload.ParseConflictPolicy(c.String("on-conflict"), true)
+	if err != nil {
+		fmt.Println(err)
+		return nil
+	}
+	symlinks, err := ParseSymlinkPolicy(c.String("symlinks"))
+	if err != nil {
+		fmt.Println(err)
+		return nil
+	}
+	splitSize, err := parseSplitSize(c.String("split-size"))
+	if err != nil {
+		fmt.Println(err)
+		return nil
+	}
+	packSmallSize, err := parsePackSmallSize(c.String("pack-small"))
+	if err != nil {
+		fmt.Println(err)
+		return nil
+	}
+	encryption, err := parseUploadEncryption(c)
+	if err != nil {
+		fmt.Println(err)
+		return nil
+	}
+	maxDelete, err := parseMaxDelete(c.String("max-delete"), true)
+	if err != nil {
+		fmt.Println(err)
+		return nil
+	}
+	var purgeOpt *recyclePurgeOptions
+	if c.String("purge-recycle") != "" {
+		if purgeOpt, err = parseRecyclePurgeOptions(c.String("purge-recycle"), "", ""); err != nil {
+			fmt.Println(err)
+			return nil
+		}
+	}
+
 	opt := &UploadOptions{
-		AllParallel:   c.Int("p"),
-		Parallel:      1, // 天翼云盘一个文件只支持单线程上传
-		MaxRetry:      c.Int("retry"),
-		NoRapidUpload: c.Bool("norapid"),
-		NoSplitFile:   true, // 天翼云盘不支持分片并发上传，只支持单线程上传，支持断点续传
-		ShowProgress:  !c.Bool("np"),
-		IsOverwrite:   true,
-		FamilyId:      parseFamilyId(c),
-		ExcludeNames:  c.StringSlice("exn"),
+		AllParallel:    c.Int("p"),
+		Parallel:       1, // 天翼云盘一个文件只支持单线程上传
+		MaxRetry:       c.Int("retry"),
+		NoRapidUpload:  c.Bool("norapid"),
+		NoSplitFile:    true, // 天翼云盘不支持分片并发上传，只支持单线程上传，支持断点续传
+		ShowProgress:   !c.Bool("np"),
+		OnConflict:     onConflict,
+		ForceProtected: c.Bool("force-protected"),
+		IsVerify:       c.Bool("verify"),
+		Symlinks:       symlinks,
+		SplitSize:      splitSize,
+		PackSmallSize:  packSmallSize,
+		Encryption:     encryption,
+		FamilyId:       parseFamilyId(c),
+		ExcludeNames:   c.StringSlice("exn"),
 	}
 
 	localCount := c.NArg() - 1
@@ -306,7 +476,7 @@ func Backup(c *cli.Context) error {
 			switch err {
 			case nil:
 				if flagSync || flagDelete {
-					DelRemoteFileFromDB(opt.FamilyId, fullPath, savePath, flagSync)
+					DelRemoteFileFromDB(opt.FamilyId, fullPath, savePath, flagSync, opt.ForceProtected, maxDelete, opt.Encryption)
 				}
 			case os.ErrInvalid:
 			default:
@@ -322,7 +492,19 @@ func Backup(c *cli.Context) error {
 		return nil
 	}
 
-	RunUpload(localpaths, savePath, opt)
+	ok := RunUpload(localpaths, savePath, opt)
+
+	// 覆盖和同步删除的旧文件都在回收站中, 按需清理, 只清理备份目录中删除的文件.
+	// 有文件上传失败时不清理, 旧版本可能仍需从回收站恢复
+	if purgeOpt != nil {
+		if !ok {
+			fmt.Printf("\n有文件上传失败, 跳过清理回收站\n")
+			return nil
+		}
+		purgeOpt.PathPrefix = savePath
+		fmt.Printf("\n清理回收站中 %s 之前删除的 %s 中的文件\n", c.String("purge-recycle"), savePath)
+		RunRecyclePurge(opt.FamilyId, purgeOpt, true)
+	}
 
 	return nil
 }
@@ -0,0 +1,111 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package command
+
+import (
+	"path"
+	"sort"
+
+	"github.com/tickstep/cloudpan189-go/internal/functions/panupload"
+)
+
+type (
+	// packSegmentRecords 同一个 tar 文件的备份记录
+	packSegmentRecords struct {
+		segment *panupload.UploadedFileMeta   // tar 文件本身的记录, 旧版本没有
+		alive   []*panupload.UploadedFileMeta // 本地仍存在的文件
+		missing []*panupload.UploadedFileMeta // 本地已删除的文件
+	}
+
+	// packRecords 打包上传的文件没有单独的网盘文件, 按 tar 文件分组处理, tar 文件的网盘路径 => 记录
+	packRecords map[string]*packSegmentRecords
+)
+
+// packSegmentPath 记录所在 tar 文件的网盘路径
+func packSegmentPath(ent *panupload.UploadedFileMeta) string {
+	return path.Join(path.Dir(ent.Path), ent.PackSegment)
+}
+
+// add 加入打包上传的记录, 不是打包上传的记录返回 false
+func (pr packRecords) add(ent *panupload.UploadedFileMeta, exists func(*panupload.UploadedFileMeta) bool) bool {
+	if ent.PackSegment == "" || ent.IsFolder {
+		return false
+	}
+	segPath := packSegmentPath(ent)
+	rec := pr[segPath]
+	if rec == nil {
+		rec = &packSegmentRecords{}
+		pr[segPath] = rec
+	}
+	switch {
+	case ent.IsPackSegment():
+		rec.segment = ent
+	case exists(ent):
+		rec.alive = append(rec.alive, ent)
+	default:
+		rec.missing = append(rec.missing, ent)
+	}
+	return true
+}
+
+// members 打包的文件数量和本地已删除的数量
+func (pr packRecords) members() (total, missing int) {
+	for _, rec := range pr {
+		total += len(rec.alive) + len(rec.missing)
+		missing += len(rec.missing)
+	}
+	return
+}
+
+// retired 需要删除的 tar 文件: 其中有本地已删除的文件, 或其中的文件都已重新上传到新的 tar 文件
+func (pr packRecords) retired() []string {
+	paths := make([]string, 0)
+	for segPath, rec := range pr {
+		if len(rec.missing) > 0 || len(rec.alive) == 0 {
+			paths = append(paths, segPath)
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// all 该 tar 文件的全部记录
+func (rec *packSegmentRecords) all() []*panupload.UploadedFileMeta {
+	ents := make([]*panupload.UploadedFileMeta, 0, len(rec.alive)+len(rec.missing)+1)
+	if rec.segment != nil {
+		ents = append(ents, rec.segment)
+	}
+	ents = append(ents, rec.alive...)
+	return append(ents, rec.missing...)
+}
+
+// fileIds tar 文件、索引和所在目录的网盘文件ID, 优先使用 tar 文件的记录
+func (rec *packSegmentRecords) fileIds() (segmentId, indexId, parentId string) {
+	for _, ent := range rec.all() {
+		if segmentId == "" {
+			if ent.IsPackSegment() {
+				segmentId = ent.FileID
+			} else {
+				segmentId = ent.PackSegmentId
+			}
+		}
+		if indexId == "" {
+			indexId = ent.PackIndexId
+		}
+		if parentId == "" {
+			parentId = ent.ParentId
+		}
+	}
+	return
+}
@@ -0,0 +1,63 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package command
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/tickstep/cloudpan189-go/internal/functions/panupload"
+)
+
+func TestPackRecords(t *testing.T) {
+	pr := packRecords{}
+	local := map[string]bool{"/d/a.txt": true, "/d/c.txt": true}
+	exists := func(ent *panupload.UploadedFileMeta) bool { return local[ent.Path] }
+	ents := []*panupload.UploadedFileMeta{
+		{Path: "/d/seg1.tar", FileID: "s1", ParentId: "p", PackSegment: "seg1.tar", PackIndexId: "i1"},
+		{Path: "/d/a.txt", PackSegment: "seg1.tar", PackSegmentId: "s1", PackIndexId: "i1"},
+		{Path: "/d/b.txt", PackSegment: "seg1.tar", PackSegmentId: "s1", PackIndexId: "i1"},
+		// 文件都已重新打包到 seg3.tar
+		{Path: "/d/seg2.tar", FileID: "s2", PackSegment: "seg2.tar"},
+		{Path: "/d/c.txt", PackSegment: "seg3.tar", PackSegmentId: "s3", PackIndexId: "i3", ParentId: "p"},
+		{Path: "/d/e.txt", FileID: "e"},
+	}
+	for _, ent := range ents {
+		isPack := pr.add(ent, exists)
+		if isPack != (ent.PackSegment != "") {
+			t.Errorf("add(%s) = %v", ent.Path, isPack)
+		}
+	}
+
+	total, missing := pr.members()
+	if total != 3 || missing != 1 {
+		t.Errorf("members() = %d, %d, want 3, 1", total, missing)
+	}
+	if got := pr.retired(); !reflect.DeepEqual(got, []string{"/d/seg1.tar", "/d/seg2.tar"}) {
+		t.Errorf("retired() = %v", got)
+	}
+	if len(pr["/d/seg1.tar"].all()) != 3 {
+		t.Errorf("seg1 records = %d, want 3", len(pr["/d/seg1.tar"].all()))
+	}
+
+	// tar 文件本身的记录没有时使用打包文件记录的ID
+	segmentId, indexId, parentId := pr["/d/seg3.tar"].fileIds()
+	if segmentId != "s3" || indexId != "i3" || parentId != "p" {
+		t.Errorf("seg3 fileIds() = %s, %s, %s", segmentId, indexId, parentId)
+	}
+	segmentId, indexId, parentId = pr["/d/seg1.tar"].fileIds()
+	if segmentId != "s1" || indexId != "i1" || parentId != "p" {
+		t.Errorf("seg1 fileIds() = %s, %s, %s", segmentId, indexId, parentId)
+	}
+}
@@ -0,0 +1,131 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package command
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/tickstep/cloudpan189-api/cloudpan/apierror"
+	"github.com/tickstep/cloudpan189-go/cmder"
+	"github.com/tickstep/cloudpan189-go/internal/config"
+	"github.com/tickstep/cloudpan189-go/internal/functions"
+	"github.com/tickstep/cloudpan189-go/internal/functions/pandownload"
+	"github.com/tickstep/cloudpan189-go/library/crypto"
+	"github.com/urfave/cli"
+)
+
+func CmdCat() cli.Command {
+	return cli.Command{
+		Name:      "cat",
+		Usage:     "输出网盘文件的内容",
+		UsageText: cmder.App().Name + " cat <网盘文件路径>",
+		Description: `
+	将网盘文件的内容输出到标准输出, 可配合管道使用.
+	使用 -encrypt 输出加密网盘模式上传的文件解密后的内容.
+
+	示例:
+
+	查看 /我的资源/readme.txt
+	cloudpan189-go cat /我的资源/readme.txt
+
+	查看加密上传的 /加密备份/notes.txt, 文件名也已加密
+	cloudpan189-go cat -encrypt -encrypt-names /加密备份/notes.txt
+`,
+		Category: "天翼云盘",
+		Before:   cmder.ReloadConfigFunc,
+		Action: func(c *cli.Context) error {
+			if c.NArg() != 1 {
+				cli.ShowCommandHelp(c, c.Command.Name)
+				return nil
+			}
+			if config.Config.ActiveUser() == nil {
+				fmt.Println("未登录账号")
+				return nil
+			}
+			decryption, err := parseDownloadDecryption(c)
+			if err != nil {
+				fmt.Println(err)
+				return nil
+			}
+			if err = RunCat(parseFamilyId(c), c.Args().Get(0), decryption, os.Stdout); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+			}
+			return nil
+		},
+		Flags: []cli.Flag{
+			cli.BoolFlag{
+				Name:  "encrypt",
+				Usage: "加密网盘模式, 输出解密后的内容, 密码从环境变量 " + config.EnvEncryptPassword + " 读取或交互输入",
+			},
+			cli.BoolFlag{
+				Name:  "encrypt-names",
+				Usage: "加密网盘模式下文件名和目录名已加密",
+			},
+			cli.StringFlag{
+				Name:  "key-file",
+				Usage: "加密网盘模式使用密钥文件代替密码",
+			},
+			cli.StringFlag{
+				Name:  "familyId",
+				Usage: "家庭云ID",
+				Value: "",
+			},
+		},
+	}
+}
+
+// RunCat 输出网盘文件的内容, decryption 不为空时解密加密的文件
+func RunCat(familyId int64, panPath string, decryption *pandownload.Decryption, w io.Writer) error {
+	activeUser := GetActiveUser()
+	panClient := activeUser.PanClient()
+	panPath = activeUser.PathJoin(familyId, panPath)
+
+	efi, apierr := panClient.AppFileInfoByPath(familyId, panPath)
+	if apierr != nil && apierr.ErrCode() == apierror.ApiCodeFileNotFoundCode {
+		// 文件名加密时, 输入的可能是明文路径
+		if p, ok := resolveEncryptedPanPath(familyId, decryption, panPath); ok {
+			efi, apierr = panClient.AppFileInfoByPath(familyId, p)
+		}
+	}
+	if apierr != nil {
+		return fmt.Errorf("获取文件信息失败: %s, %s", panPath, apierr)
+	}
+	if efi.IsFolder {
+		return fmt.Errorf("%s 是目录", panPath)
+	}
+
+	rc, err := functions.OpenPanFile(panClient, familyId, efi, 0, 0)
+	if err != nil {
+		return fmt.Errorf("读取文件失败: %s, %s", panPath, err)
+	}
+	defer rc.Close()
+
+	br := bufio.NewReader(rc)
+	var r io.Reader = br
+	if decryption != nil {
+		header, _ := br.Peek(crypto.StreamHeaderSize)
+		if crypto.IsEncrypted(header) {
+			if r, err = decryption.Keychain.NewReader(r); err != nil {
+				return fmt.Errorf("解密文件失败: %s, %s", panPath, err)
+			}
+		}
+	}
+	if _, err = io.Copy(w, r); err != nil {
+		return fmt.Errorf("读取文件失败: %s, %s", panPath, err)
+	}
+	return nil
+}
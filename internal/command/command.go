@@ -18,7 +18,6 @@ import (
 	"fmt"
 	"github.com/tickstep/cloudpan189-go/cmder"
 	"github.com/tickstep/cloudpan189-go/cmder/cmdutil"
-	"github.com/tickstep/cloudpan189-go/library/crypto"
 	"github.com/tickstep/library-go/getip"
 	"strconv"
 
@@ -28,22 +27,6 @@ import (
 	"github.com/tickstep/cloudpan189-go/internal/config"
 )
 
-const (
-	cryptoDescription = `
-	可用的方法 <method>:
-		aes-128-ctr, aes-192-ctr, aes-256-ctr,
-		aes-128-cfb, aes-192-cfb, aes-256-cfb,
-		aes-128-ofb, aes-192-ofb, aes-256-ofb.
-
-	密钥 <key>:
-		aes-128 对应key长度为16, aes-192 对应key长度为24, aes-256 对应key长度为32,
-		如果key长度不符合, 则自动修剪key, 舍弃超出长度的部分, 长度不足的部分用'\0'填充.
-
-	GZIP <disable-gzip>:
-		在文件加密之前, 启用GZIP压缩文件; 文件解密之后启用GZIP解压缩文件, 默认启用,
-		如果不启用, 则无法检测文件是否解密成功, 解密文件时会保留源文件, 避免解密失败造成文件数据丢失.`
-)
-
 var ErrBadArgs = errors.New("参数错误")
 var ErrNotLogined = errors.New("未登录账号")
 
@@ -120,6 +103,9 @@ func CmdConfig() cli.Command {
 					if c.IsSet("max_upload_parallel") {
 						config.Config.MaxUploadParallel = c.Int("max_upload_parallel")
 					}
+					if c.IsSet("max_hash_parallel") {
+						config.Config.MaxHashParallel = c.Int("max_hash_parallel")
+					}
 					if c.IsSet("max_download_rate") {
 						err := config.Config.SetMaxDownloadRateByStr(c.String("max_download_rate"))
 						if err != nil {
@@ -174,6 +160,10 @@ func CmdConfig() cli.Command {
 						Name:  "max_upload_parallel",
 						Usage: "上传文件最大并发量",
 					},
+					cli.IntFlag{
+						Name:  "max_hash_parallel",
+						Usage: "上传前计算文件MD5的最大并发量",
+					},
 					cli.StringFlag{
 						Name:  "max_download_rate",
 						Usage: "限制最大下载速度, 0代表不限制",
@@ -204,6 +194,7 @@ func CmdConfig() cli.Command {
 					},
 					},
 				},
+			cmdConfigProtect(),
 		},
 	}
 }
@@ -237,86 +228,8 @@ func CmdTool() cli.Command {
 					return nil
 				},
 			},
-			{
-				Name:        "enc",
-				Usage:       "加密文件",
-				UsageText:   cmder.App().Name + " enc -method=<method> -key=<key> [files...]",
-				Description: cryptoDescription,
-				Action: func(c *cli.Context) error {
-					if c.NArg() <= 0 {
-						cli.ShowCommandHelp(c, c.Command.Name)
-						return nil
-					}
-
-					for _, filePath := range c.Args() {
-						encryptedFilePath, err := crypto.EncryptFile(c.String("method"), []byte(c.String("key")), filePath, !c.Bool("disable-gzip"))
-						if err != nil {
-							fmt.Printf("%s\n", err)
-							continue
-						}
-
-						fmt.Printf("加密成功, %s -> %s\n", filePath, encryptedFilePath)
-					}
-
-					return nil
-				},
-				Flags: []cli.Flag{
-					cli.StringFlag{
-						Name:  "method",
-						Usage: "加密方法",
-						Value: "aes-128-ctr",
-					},
-					cli.StringFlag{
-						Name:  "key",
-						Usage: "加密密钥",
-						Value: cmder.App().Name,
-					},
-					cli.BoolFlag{
-						Name:  "disable-gzip",
-						Usage: "不启用GZIP",
-					},
-				},
-			},
-			{
-				Name:        "dec",
-				Usage:       "解密文件",
-				UsageText:   cmder.App().Name + " dec -method=<method> -key=<key> [files...]",
-				Description: cryptoDescription,
-				Action: func(c *cli.Context) error {
-					if c.NArg() <= 0 {
-						cli.ShowCommandHelp(c, c.Command.Name)
-						return nil
-					}
-
-					for _, filePath := range c.Args() {
-						decryptedFilePath, err := crypto.DecryptFile(c.String("method"), []byte(c.String("key")), filePath, !c.Bool("disable-gzip"))
-						if err != nil {
-							fmt.Printf("%s\n", err)
-							continue
-						}
-
-						fmt.Printf("解密成功, %s -> %s\n", filePath, decryptedFilePath)
-					}
-
-					return nil
-				},
-				Flags: []cli.Flag{
-					cli.StringFlag{
-						Name:  "method",
-						Usage: "加密方法",
-						Value: "aes-128-ctr",
-					},
-					cli.StringFlag{
-						Name:  "key",
-						Usage: "加密密钥",
-						Value: cmder.App().Name,
-					},
-					cli.BoolFlag{
-						Name:  "disable-gzip",
-						Usage: "不启用GZIP",
-					},
-				},
-			},
+			cmdToolEnc(),
+			cmdToolDec(),
 		},
 	}
 }
@@ -16,12 +16,21 @@ package command
 import (
 	"fmt"
 	"github.com/tickstep/cloudpan189-api/cloudpan"
+	"github.com/tickstep/cloudpan189-api/cloudpan/apierror"
 	"github.com/tickstep/cloudpan189-go/cmder"
 	"github.com/tickstep/cloudpan189-go/internal/config"
-	"github.com/tickstep/library-go/logger"
+	"github.com/tickstep/cloudpan189-go/internal/functions"
+	"github.com/tickstep/cloudpan189-go/internal/functions/panjournal"
 	"github.com/urfave/cli"
 	"path"
-	"time"
+)
+
+var (
+	// batchConflictFlag 复制和移动时目标目录已存在同名文件的处理策略
+	batchConflictFlag = cli.StringFlag{
+		Name:  "on-conflict",
+		Usage: "目标目录已存在同名文件时的处理策略: skip 跳过, overwrite 覆盖, rename-new 保留两者并重命名新文件, 默认不处理并提示冲突",
+	}
 )
 
 func CmdCp() cli.Command {
@@ -40,6 +49,11 @@ func CmdCp() cli.Command {
 
 	将 /我的资源/1.mp4 和 /我的资源/2.mp4 复制到 根目录 /
 	cloudpan189-go cp /我的资源/1.mp4 /我的资源/2.mp4 /
+
+	将 /我的资源/1.mp4 复制到 /备份, 覆盖已存在的同名文件
+	cloudpan189-go cp -on-conflict overwrite /我的资源/1.mp4 /备份
+
+	目标目录已存在同名文件时默认不处理, 完成后提示冲突的文件数量.
 `,
 		Category: "天翼云盘",
 		Before:   cmder.ReloadConfigFunc,
@@ -56,9 +70,18 @@ func CmdCp() cli.Command {
 				fmt.Println("家庭云不支持复制操作")
 				return nil
 			}
-			RunCopy(c.Args()...)
+			onConflict, err := functions.ParseBatchConflictPolicy(c.String("on-conflict"))
+			if err != nil {
+				fmt.Println(err)
+				return nil
+			}
+			RunCopy(onConflict, c.Bool("force-protected"), c.Args()...)
 			return nil
 		},
+		Flags: []cli.Flag{
+			batchConflictFlag,
+			forceProtectedFlag,
+		},
 	}
 }
 
@@ -75,6 +98,12 @@ func CmdMv() cli.Command {
 
 	将 /我的资源/1.mp4 移动到 根目录 /
 	cloudpan189-go mv /我的资源/1.mp4 /
+
+	将 /我的资源/1.mp4 移动到 /备份, 跳过已存在的同名文件
+	cloudpan189-go mv -on-conflict skip /我的资源/1.mp4 /备份
+
+	受保护的路径以及其上级目录需要使用 --force-protected 才能移动, 受保护的路径通过 config protect 设置.
+	目标目录已存在同名文件时默认不处理, 完成后提示冲突的文件数量. 家庭云逐个移动文件, 不支持 -on-conflict, 指定时拒绝执行.
 `,
 		Category: "天翼云盘",
 		Before:   cmder.ReloadConfigFunc,
@@ -88,10 +117,17 @@ func CmdMv() cli.Command {
 				return nil
 			}
 
-			RunMove(parseFamilyId(c), c.Args()...)
+			onConflict, err := functions.ParseBatchConflictPolicy(c.String("on-conflict"))
+			if err != nil {
+				fmt.Println(err)
+				return nil
+			}
+			RunMove(parseFamilyId(c), onConflict, c.Bool("force-protected"), c.Args()...)
 			return nil
 		},
 		Flags: []cli.Flag{
+			batchConflictFlag,
+			forceProtectedFlag,
 			cli.StringFlag{
 				Name:  "familyId",
 				Usage: "家庭云ID",
@@ -101,11 +137,10 @@ func CmdMv() cli.Command {
 	}
 }
 
-// RunCopy 执行复制文件/目录
-func RunCopy(paths ...string) {
+// RunCopy 执行复制文件/目录, onConflict 为目标目录已存在同名文件时的处理策略
+func RunCopy(onConflict functions.BatchConflictPolicy, forceProtected bool, paths ...string) {
 	// 只支持个人云
 	familyId := int64(0)
-	activeUser := GetActiveUser()
 	opFileList, targetFile, _, err := getFileInfo(familyId, paths...)
 	if err != nil {
 		fmt.Println(err)
@@ -119,46 +154,38 @@ func RunCopy(paths ...string) {
 		fmt.Println("没有有效的文件可复制")
 		return
 	}
+	if err = checkOverwriteProtected(familyId, onConflict, forceProtected, opFileList, targetFile); err != nil {
+		fmt.Println(err)
+		return
+	}
 
-	// create task
 	taskParam := &cloudpan.BatchTaskParam{
 		TypeFlag:       cloudpan.BatchTaskTypeCopy,
 		TaskInfos:      makeBatchTaskInfoList(opFileList),
 		TargetFolderId: targetFile.FileId,
 	}
-
-	taskId, err1 := activeUser.PanClient().CreateBatchTask(taskParam)
-	if err1 != nil {
-		fmt.Println("无法复制文件，请稍后重试")
-		return
-	}
-	logger.Verboseln("task id: " + taskId)
-
-	// check task
-	time.Sleep(time.Duration(200) * time.Millisecond)
-	taskRes, err2 := activeUser.PanClient().CheckBatchTask(cloudpan.BatchTaskTypeCopy, taskId)
-	if err2 != nil {
-		fmt.Println("无法复制文件，请稍后重试")
-		return
-	}
-	if taskRes.TaskStatus == cloudpan.BatchTaskStatusNotAction {
-		fmt.Println("无法复制文件，文件可能已经存在")
+	result, err := functions.RunBatchTask(GetActivePanClient(), taskParam, copyMoveTaskOptions(onConflict))
+	if err != nil {
+		fmt.Printf("无法复制文件: %s\n", err)
 		return
 	}
-
-	if taskRes.TaskStatus == cloudpan.BatchTaskStatusOk {
-		fmt.Println("操作成功, 已复制文件到目标目录: ", targetFile.Path)
-	}
+	printCopyMoveResult(familyId, "复制", result, targetFile)
 }
 
-// RunMove 执行移动文件/目录
-func RunMove(familyId int64, paths ...string) {
+// RunMove 执行移动文件/目录, 涉及受保护的路径时拒绝移动, onConflict 为目标目录已存在同名文件时的处理策略
+func RunMove(familyId int64, onConflict functions.BatchConflictPolicy, forceProtected bool, paths ...string) {
 	activeUser := GetActiveUser()
 	opFileList, targetFile, _, err := getFileInfo(familyId, paths...)
 	if err != nil {
 		fmt.Println(err)
 		return
 	}
+	for _, fi := range opFileList {
+		if err := checkProtectedPaths(familyId, forceProtected, fi.Path); err != nil {
+			fmt.Println(err)
+			return
+		}
+	}
 	if targetFile == nil {
 		fmt.Println("目标文件不存在")
 		return
@@ -167,17 +194,36 @@ func RunMove(familyId int64, paths ...string) {
 		fmt.Println("没有有效的文件可移动")
 		return
 	}
+	if IsFamilyCloud(familyId) && onConflict != functions.BatchConflictNone {
+		fmt.Println("家庭云不支持 -on-conflict, 目标目录已存在同名文件时不移动")
+		return
+	}
+	if err = checkOverwriteProtected(familyId, onConflict, forceProtected, opFileList, targetFile); err != nil {
+		fmt.Println(err)
+		return
+	}
 
 	if IsFamilyCloud(familyId) {
+		// 家庭云逐个移动, 统计方式和批量任务一致
+		result := &functions.BatchTaskResult{Finished: true}
 		failedMoveFiles := []*cloudpan.AppFileEntity{}
-		b := false
+		entry := panjournal.NewEntry(panjournal.OpMove, familyId)
+		entry.TargetFolderId, entry.TargetPath = targetFile.FileId, targetFile.Path
 		for _, mfi := range opFileList {
 			_, er := activeUser.PanClient().AppFamilyMoveFile(familyId, mfi.FileId, targetFile.FileId)
-			if er != nil {
+			switch {
+			case er == nil:
+				result.Succeeded++
+				entry.AddEntity(mfi)
+			case er.ErrCode() == apierror.ApiCodeFileAlreadyExisted:
+				result.Conflicts++
+				failedMoveFiles = append(failedMoveFiles, mfi)
+			default:
+				result.Failed++
 				failedMoveFiles = append(failedMoveFiles, mfi)
 			}
-			b = true
 		}
+		panjournal.Record(entry)
 		if len(failedMoveFiles) > 0 {
 			fmt.Println("以下文件移动失败：")
 			for _, f := range failedMoveFiles {
@@ -185,42 +231,63 @@ func RunMove(familyId int64, paths ...string) {
 			}
 			fmt.Println("")
 		}
-		if b {
-			fmt.Println("操作成功, 已移动文件到目标目录: ", targetFile.Path)
-		} else {
-			fmt.Println("无法移动文件，请稍后重试")
-		}
+		printCopyMoveResult(familyId, "移动", result, targetFile)
 	} else {
-		// create task
 		taskParam := &cloudpan.BatchTaskParam{
 			TypeFlag:       cloudpan.BatchTaskTypeMove,
 			TaskInfos:      makeBatchTaskInfoList(opFileList),
 			TargetFolderId: targetFile.FileId,
 		}
-
-		taskId, err1 := activeUser.PanClient().CreateBatchTask(taskParam)
-		if err1 != nil {
-			fmt.Println("无法移动文件，请稍后重试")
+		result, err := functions.RunBatchTask(activeUser.PanClient(), taskParam, copyMoveTaskOptions(onConflict))
+		if result == nil {
+			fmt.Printf("无法移动文件: %s\n", err)
 			return
 		}
-		logger.Verboseln("task id: " + taskId)
-
-		// check task
-		time.Sleep(time.Duration(200) * time.Millisecond)
-		taskRes, err2 := activeUser.PanClient().CheckBatchTask(cloudpan.BatchTaskTypeMove, taskId)
-		if err2 != nil {
-			fmt.Println("无法移动文件，请稍后重试")
-			return
-		}
-		if taskRes.TaskStatus == cloudpan.BatchTaskStatusNotAction {
-			fmt.Println("无法移动文件，文件可能已经存在")
+		// 任务已经创建, 部分失败或等待超时也记录全部文件, 撤销时跳过仍在原位置的文件
+		entry := panjournal.NewBatchTaskEntry(panjournal.OpMove, familyId, taskParam, opFileList)
+		entry.TaskId, entry.TargetPath = result.TaskId, targetFile.Path
+		panjournal.Record(entry)
+		if err != nil {
+			fmt.Printf("移动文件出错: %s, 已移动的文件可使用 undo %s 撤销\n", err, entry.Id)
 			return
 		}
+		printCopyMoveResult(familyId, "移动", result, targetFile)
+	}
+}
+
+// copyMoveTaskOptions 复制和移动任务的参数, 处理同名文件需要网页版接口的登录信息
+func copyMoveTaskOptions(onConflict functions.BatchConflictPolicy) functions.BatchTaskOptions {
+	return functions.BatchTaskOptions{
+		OnConflict: onConflict,
+		WebToken:   &GetActiveUser().WebToken,
+	}
+}
 
-		if taskRes.TaskStatus == cloudpan.BatchTaskStatusOk {
-			fmt.Println("操作成功, 已移动文件到目标目录: ", targetFile.Path)
+// checkOverwriteProtected 覆盖目标目录中的同名文件时, 检查被覆盖的路径是否受保护
+func checkOverwriteProtected(familyId int64, onConflict functions.BatchConflictPolicy, forceProtected bool, opFileList []*cloudpan.AppFileEntity, targetFile *cloudpan.AppFileEntity) error {
+	if onConflict != functions.BatchConflictOverwrite {
+		return nil
+	}
+	for _, fi := range opFileList {
+		if err := checkProtectedPaths(familyId, forceProtected, path.Join(targetFile.Path, fi.FileName)); err != nil {
+			return err
 		}
 	}
+	return nil
+}
+
+// printCopyMoveResult 输出复制或移动任务的结果
+func printCopyMoveResult(familyId int64, action string, result *functions.BatchTaskResult, targetFile *cloudpan.AppFileEntity) {
+	if result.OK() {
+		fmt.Printf("操作成功, 已%s文件到目标目录: %s, %s\n", action, targetFile.Path, result)
+		return
+	}
+	fmt.Printf("%s完成, %s\n", action, result)
+	if result.Conflicts > 0 && IsFamilyCloud(familyId) {
+		fmt.Printf("目标目录中已存在同名文件, 冲突的文件未%s\n", action)
+	} else if result.Conflicts > 0 {
+		fmt.Printf("目标目录中已存在同名文件, 冲突的文件未%s, 可以使用 -on-conflict 指定处理策略\n", action)
+	}
 }
 
 func getFileInfo(familyId int64, paths ...string) (opFileList []*cloudpan.AppFileEntity, targetFile *cloudpan.AppFileEntity, failedPaths []string, error error) {
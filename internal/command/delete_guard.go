@@ -0,0 +1,132 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package command
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/tickstep/cloudpan189-api/cloudpan"
+	"github.com/tickstep/cloudpan189-api/cloudpan/apierror"
+	"github.com/urfave/cli"
+)
+
+const (
+	// defaultMaxDeleteCount 默认一次最多删除的文件数量, 超过时需要确认
+	defaultMaxDeleteCount = 1000
+	// defaultMaxDeletePercent 默认一次最多删除跟踪文件总数的比例, 超过时需要确认
+	defaultMaxDeletePercent = 50
+	// maxDeletePercentMinCount 删除数量不超过该值时不检查比例, 避免小目录中删除少量文件也需要确认
+	maxDeletePercentMinCount = 10
+)
+
+type (
+	// maxDeleteLimit 一次删除的数量上限, 超过时需要确认
+	maxDeleteLimit struct {
+		Count   int     // 文件数量上限, 0 表示不限制
+		Percent float64 // 占跟踪文件总数的比例上限, 0 表示不限制
+	}
+)
+
+var (
+	// confirmDeleteMu 多个目录同时备份时逐个确认
+	confirmDeleteMu sync.Mutex
+)
+
+func maxDeleteFlag(usage string) cli.StringFlag {
+	return cli.StringFlag{
+		Name:  "max-delete",
+		Usage: usage,
+	}
+}
+
+// parseMaxDelete 解析 --max-delete 参数, 支持文件数量 N 或比例 X%, 为空时使用默认值, 0 表示不限制
+func parseMaxDelete(s string, allowPercent bool) (*maxDeleteLimit, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		limit := &maxDeleteLimit{Count: defaultMaxDeleteCount}
+		if allowPercent {
+			limit.Percent = defaultMaxDeletePercent
+		}
+		return limit, nil
+	}
+	if strings.HasSuffix(s, "%") {
+		if !allowPercent {
+			return nil, fmt.Errorf("--max-delete 只支持文件数量: %s", s)
+		}
+		percent, err := strconv.ParseFloat(strings.TrimSuffix(s, "%"), 64)
+		if err != nil || percent < 0 || percent > 100 {
+			return nil, fmt.Errorf("--max-delete 比例格式错误: %s, 例如 20%%", s)
+		}
+		return &maxDeleteLimit{Percent: percent}, nil
+	}
+	count, err := strconv.Atoi(s)
+	if err != nil || count < 0 {
+		return nil, fmt.Errorf("--max-delete 格式错误: %s, 例如 500 或 20%%", s)
+	}
+	return &maxDeleteLimit{Count: count}, nil
+}
+
+// exceeded 删除 count 个文件是否超过上限, total 为跟踪的文件总数, 超过时返回说明
+func (limit *maxDeleteLimit) exceeded(count, total int) string {
+	if limit.Count > 0 && count > limit.Count {
+		return fmt.Sprintf("将要删除 %d 个文件/目录, 超过上限 %d 个", count, limit.Count)
+	}
+	if limit.Percent > 0 && total > 0 && count > maxDeletePercentMinCount {
+		if percent := float64(count) * 100 / float64(total); percent > limit.Percent {
+			return fmt.Sprintf("将要删除 %d 个文件/目录, 占总数 %d 个的 %.1f%%, 超过上限 %g%%", count, total, percent, limit.Percent)
+		}
+	}
+	return ""
+}
+
+// confirmDelete 删除数量超过上限时需要确认, 无法确认时放弃删除
+func (limit *maxDeleteLimit) confirmDelete(what string, count, total int) bool {
+	reason := limit.exceeded(count, total)
+	if reason == "" {
+		return true
+	}
+	confirmDeleteMu.Lock()
+	defer confirmDeleteMu.Unlock()
+	fmt.Printf("%s: %s\n", what, reason)
+	if confirmAction("确认继续删除?", false) {
+		return true
+	}
+	fmt.Println("已放弃删除, 确认无误后可以使用 --max-delete 调整上限")
+	return false
+}
+
+// countPanFiles 统计网盘文件和目录中文件的数量, 超过 limit 时停止统计, limit 为 0 时不统计目录中的文件
+func countPanFiles(familyId int64, files []*cloudpan.AppFileEntity, limit int) int {
+	count := 0
+	for _, fi := range files {
+		count++
+		if !fi.IsFolder || limit <= 0 {
+			continue
+		}
+		GetActivePanClient().AppFilesDirectoriesRecurseList(familyId, fi.Path, func(depth int, _ string, fd *cloudpan.AppFileEntity, apiError *apierror.ApiError) bool {
+			if apiError != nil {
+				return false
+			}
+			count++
+			return count <= limit
+		})
+		if count > limit {
+			break
+		}
+	}
+	return count
+}
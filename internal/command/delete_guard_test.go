@@ -0,0 +1,79 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package command
+
+import (
+	"testing"
+)
+
+func TestParseMaxDelete(t *testing.T) {
+	testCases := []struct {
+		s            string
+		allowPercent bool
+		want         *maxDeleteLimit // 为空表示解析失败
+	}{
+		{"", false, &maxDeleteLimit{Count: defaultMaxDeleteCount}},
+		{"", true, &maxDeleteLimit{Count: defaultMaxDeleteCount, Percent: defaultMaxDeletePercent}},
+		{" 500 ", true, &maxDeleteLimit{Count: 500}},
+		{"0", false, &maxDeleteLimit{}},
+		{"20%", true, &maxDeleteLimit{Percent: 20}},
+		{"12.5%", true, &maxDeleteLimit{Percent: 12.5}},
+		{"0%", true, &maxDeleteLimit{}},
+		{"100%", true, &maxDeleteLimit{Percent: 100}},
+		{"20%", false, nil},
+		{"101%", true, nil},
+		{"-1%", true, nil},
+		{"%", true, nil},
+		{"-1", false, nil},
+		{"abc", false, nil},
+		{"1.5", false, nil},
+	}
+	for _, c := range testCases {
+		limit, err := parseMaxDelete(c.s, c.allowPercent)
+		if c.want == nil {
+			if err == nil {
+				t.Errorf("parseMaxDelete(%q, %v) = %+v, want error", c.s, c.allowPercent, limit)
+			}
+			continue
+		}
+		if err != nil || *limit != *c.want {
+			t.Errorf("parseMaxDelete(%q, %v) = %+v, %v, want %+v", c.s, c.allowPercent, limit, err, c.want)
+		}
+	}
+}
+
+func TestMaxDeleteExceeded(t *testing.T) {
+	testCases := []struct {
+		limit        maxDeleteLimit
+		count, total int
+		want         bool
+	}{
+		{maxDeleteLimit{Count: 10}, 10, 0, false},
+		{maxDeleteLimit{Count: 10}, 11, 0, true},
+		{maxDeleteLimit{}, 100000, 100000, false},
+		{maxDeleteLimit{Percent: 50}, 60, 100, true},
+		{maxDeleteLimit{Percent: 50}, 50, 100, false},
+		// 删除数量较少时不检查比例
+		{maxDeleteLimit{Percent: 50}, maxDeletePercentMinCount, maxDeletePercentMinCount, false},
+		{maxDeleteLimit{Percent: 50}, maxDeletePercentMinCount + 1, maxDeletePercentMinCount + 1, true},
+		// 跟踪的文件总数未知时不检查比例
+		{maxDeleteLimit{Percent: 50}, 100, 0, false},
+		{maxDeleteLimit{Count: 1000, Percent: 50}, 1001, 10000, true},
+	}
+	for _, c := range testCases {
+		if got := c.limit.exceeded(c.count, c.total) != ""; got != c.want {
+			t.Errorf("%+v exceeded(%d, %d) = %v, want %v", c.limit, c.count, c.total, got, c.want)
+		}
+	}
+}
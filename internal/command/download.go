@@ -36,10 +36,16 @@ type (
 		IsPrintStatus        bool
 		IsExecutedPermission bool
 		IsOverwrite          bool
+		OnConflict           pandownload.ConflictPolicy // 本地已存在同名文件时的处理策略
 		SaveTo               string
 		Parallel             int
 		MaxRetry             int
 		NoCheck              bool
+		IsInPlace            bool                    // 直接写入目标文件, 不使用临时文件
+		NoPreserveTime       bool                    // 不保留网盘文件的修改时间
+		IsMove               bool                    // 下载并校验成功后删除网盘文件
+		ForceProtected       bool                    // 移动模式下允许删除受保护的路径
+		Decryption           *pandownload.Decryption // 加密网盘模式的解密参数, 为空表示不解密
 		ShowProgress         bool
 		FamilyId             int64
 		ExcludeNames         []string // 排除的文件名，包括文件夹和文件。即这些文件/文件夹不进行下载，支持正则表达式
@@ -69,7 +75,11 @@ func CmdDownload() cli.Command {
 	下载的文件默认保存到, 程序所在目录的 download/ 目录.
 	通过 cloudpan189-go config set -savedir <savedir>, 自定义保存的目录.
 	支持多个文件或目录下载.
-	自动跳过下载重名的文件!
+	默认跳过下载重名的文件, 可使用 -on-conflict 指定处理策略.
+	文件默认先下载到同目录的隐藏临时文件, 校验通过后再重命名为目标文件, 使用 -inplace 直接写入目标文件.
+	下载的文件和目录默认保留网盘记录的修改时间, 使用 -nomtime 关闭.
+	使用 -move 时, 每个文件下载并校验成功后删除网盘文件, 删除的文件可在回收站找回.
+	使用 -encrypt 下载加密网盘模式上传的文件, 下载后自动解密, 未加密的文件保持不变.
 
 	示例:
 
@@ -88,6 +98,9 @@ func CmdDownload() cli.Command {
 	下载 /我的资源 整个目录，但是排除里面所有的jpg文件
 	cloudpan189-go download -exn "\.jpg$" /我的资源
 
+	下载加密上传的 /加密备份 目录并解密文件内容和文件名
+	cloudpan189-go download -encrypt -encrypt-names /加密备份
+
     下载 /我的资源/1.mp4 并保存下载的文件到本地的 d:/panfile
 	cloudpan189-go download --saveto d:/panfile /我的资源/1.mp4
 
@@ -107,80 +120,139 @@ func CmdDownload() cli.Command {
 				return nil
 			}
 
-			// 处理saveTo
-			var (
-				saveTo string
-			)
-			if c.Bool("save") {
-				saveTo = "."
-			} else if c.String("saveto") != "" {
-				saveTo = filepath.Clean(c.String("saveto"))
-			}
-
-			do := &DownloadOptions{
-				IsPrintStatus:        c.Bool("status"),
-				IsExecutedPermission: c.Bool("x"),
-				IsOverwrite:          c.Bool("ow"),
-				SaveTo:               saveTo,
-				Parallel:             c.Int("p"),
-				MaxRetry:             c.Int("retry"),
-				NoCheck:              c.Bool("nocheck"),
-				ShowProgress:         !c.Bool("np"),
-				FamilyId:             parseFamilyId(c),
-				ExcludeNames:         c.StringSlice("exn"),
+			do, err := parseDownloadOptions(c)
+			if err != nil {
+				fmt.Println(err)
+				return nil
 			}
 
 			RunDownload(c.Args(), do)
 			return nil
 		},
-		Flags: []cli.Flag{
-			cli.BoolFlag{
-				Name:  "ow",
-				Usage: "overwrite, 覆盖已存在的文件",
-			},
-			cli.BoolFlag{
-				Name:  "status",
-				Usage: "输出所有线程的工作状态",
-			},
-			cli.BoolFlag{
-				Name:  "save",
-				Usage: "将下载的文件直接保存到当前工作目录",
-			},
-			cli.StringFlag{
-				Name:  "saveto",
-				Usage: "将下载的文件直接保存到指定的目录",
-			},
-			cli.BoolFlag{
-				Name:  "x",
-				Usage: "为文件加上执行权限, (windows系统无效)",
-			},
-			cli.IntFlag{
-				Name:  "p",
-				Usage: "指定同时进行下载文件的数量（取值范围:1 ~ 20）",
-			},
-			cli.IntFlag{
-				Name:  "retry",
-				Usage: "下载失败最大重试次数",
-				Value: pandownload.DefaultDownloadMaxRetry,
-			},
-			cli.BoolFlag{
-				Name:  "nocheck",
-				Usage: "下载文件完成后不校验文件",
-			},
-			cli.BoolFlag{
-				Name:  "np",
-				Usage: "no progress 不展示下载进度条",
-			},
-			cli.StringFlag{
-				Name:  "familyId",
-				Usage: "家庭云ID",
-				Value: "",
-			},
-			cli.StringSliceFlag{
-				Name:  "exn",
-				Usage: "exclude name，指定排除的文件夹或者文件的名称，被排除的文件不会进行下载，只支持正则表达式。支持同时排除多个名称，每一个名称就是一个exn参数",
-				Value: nil,
-			},
+		Flags: downloadFlags(),
+	}
+}
+
+// parseDownloadOptions 解析下载命令的参数
+func parseDownloadOptions(c *cli.Context) (*DownloadOptions, error) {
+	// 处理saveTo
+	var (
+		saveTo string
+	)
+	if c.Bool("save") {
+		saveTo = "."
+	} else if c.String("saveto") != "" {
+		saveTo = filepath.Clean(c.String("saveto"))
+	}
+
+	onConflict, err := pandownload.ParseConflictPolicy(c.String("on-conflict"))
+	if err != nil {
+		return nil, err
+	}
+	decryption, err := parseDownloadDecryption(c)
+	if err != nil {
+		return nil, err
+	}
+
+	return &DownloadOptions{
+		IsPrintStatus:        c.Bool("status"),
+		IsExecutedPermission: c.Bool("x"),
+		IsOverwrite:          c.Bool("ow"),
+		OnConflict:           onConflict,
+		SaveTo:               saveTo,
+		Parallel:             c.Int("p"),
+		MaxRetry:             c.Int("retry"),
+		NoCheck:              c.Bool("nocheck"),
+		IsInPlace:            c.Bool("inplace"),
+		NoPreserveTime:       c.Bool("nomtime"),
+		IsMove:               c.Bool("move"),
+		ForceProtected:       c.Bool("force-protected"),
+		Decryption:           decryption,
+		ShowProgress:         !c.Bool("np"),
+		FamilyId:             parseFamilyId(c),
+		ExcludeNames:         c.StringSlice("exn"),
+	}, nil
+}
+
+// downloadFlags 下载命令的参数
+func downloadFlags() []cli.Flag {
+	return []cli.Flag{
+		cli.BoolFlag{
+			Name:  "ow",
+			Usage: "overwrite, 覆盖已存在的文件, 等同于 -on-conflict overwrite",
+		},
+		cli.StringFlag{
+			Name:  "on-conflict",
+			Usage: "本地已存在同名文件时的处理策略: skip 跳过, overwrite 覆盖, rename 重命名新文件, overwrite-if-different 大小或MD5不同时覆盖, overwrite-if-newer 网盘文件较新时覆盖",
+			Value: string(pandownload.ConflictSkip),
+		},
+		cli.BoolFlag{
+			Name:  "status",
+			Usage: "输出所有线程的工作状态",
+		},
+		cli.BoolFlag{
+			Name:  "save",
+			Usage: "将下载的文件直接保存到当前工作目录",
+		},
+		cli.StringFlag{
+			Name:  "saveto",
+			Usage: "将下载的文件直接保存到指定的目录",
+		},
+		cli.BoolFlag{
+			Name:  "x",
+			Usage: "为文件加上执行权限, (windows系统无效)",
+		},
+		cli.IntFlag{
+			Name:  "p",
+			Usage: "指定同时进行下载文件的数量（取值范围:1 ~ 20）",
+		},
+		cli.IntFlag{
+			Name:  "retry",
+			Usage: "下载失败最大重试次数",
+			Value: pandownload.DefaultDownloadMaxRetry,
+		},
+		cli.BoolFlag{
+			Name:  "nocheck",
+			Usage: "下载文件完成后不校验文件",
+		},
+		cli.BoolFlag{
+			Name:  "inplace",
+			Usage: "直接下载到目标文件, 不使用临时文件",
+		},
+		cli.BoolFlag{
+			Name:  "nomtime",
+			Usage: "不保留网盘文件的修改时间, 下载的文件和目录使用本地当前时间",
+		},
+		cli.BoolFlag{
+			Name:  "move",
+			Usage: "移动模式, 文件下载并校验MD5成功后删除网盘文件, 并删除清空的网盘目录",
+		},
+		forceProtectedFlag,
+		cli.BoolFlag{
+			Name:  "np",
+			Usage: "no progress 不展示下载进度条",
+		},
+		cli.BoolFlag{
+			Name:  "encrypt",
+			Usage: "加密网盘模式, 下载后解密加密上传的文件, 密码从环境变量 " + config.EnvEncryptPassword + " 读取或交互输入",
+		},
+		cli.BoolFlag{
+			Name:  "encrypt-names",
+			Usage: "加密网盘模式下同时解密文件名和目录名",
+		},
+		cli.StringFlag{
+			Name:  "key-file",
+			Usage: "加密网盘模式使用密钥文件代替密码",
+		},
+		cli.StringFlag{
+			Name:  "familyId",
+			Usage: "家庭云ID",
+			Value: "",
+		},
+		cli.StringSliceFlag{
+			Name:  "exn",
+			Usage: "exclude name，指定排除的文件夹或者文件的名称，被排除的文件不会进行下载，只支持正则表达式。支持同时排除多个名称，每一个名称就是一个exn参数",
+			Value: nil,
 		},
 	}
 }
@@ -189,12 +261,8 @@ func downloadPrintFormat() string {
 	return "\r[%s] ↓ %s/%s %s/s in %s, left %s ..."
 }
 
-// RunDownload 执行下载网盘内文件
-func RunDownload(paths []string, options *DownloadOptions) {
-	if options == nil {
-		options = &DownloadOptions{}
-	}
-
+// prepareDownload 补全下载参数的默认值, 生成下载配置
+func prepareDownload(options *DownloadOptions) *downloader.Config {
 	if options.MaxRetry < 0 {
 		options.MaxRetry = pandownload.DefaultDownloadMaxRetry
 	}
@@ -210,7 +278,7 @@ func RunDownload(paths []string, options *DownloadOptions) {
 		CacheSize:                  config.Config.CacheSize,
 		BlockSize:                  MaxDownloadRangeSize,
 		MaxRate:                    config.Config.MaxDownloadRate,
-		InstanceStateStorageFormat: downloader.InstanceStateStorageFormatJSON,
+		InstanceStateStorageFormat: downloader.InstanceStateStorageFormatProto3,
 		ShowProgress:               options.ShowProgress,
 		ExcludeNames:               options.ExcludeNames,
 	}
@@ -228,6 +296,39 @@ func RunDownload(paths []string, options *DownloadOptions) {
 	if options.Parallel > config.MaxFileDownloadParallelNum {
 		options.Parallel = config.MaxFileDownloadParallelNum
 	}
+	cfg.MaxParallel = options.Parallel
+	return cfg
+}
+
+// executeDownload 执行下载队列, 输出统计和失败的文件列表
+func executeDownload(executor *taskframework.TaskExecutor, statistic *pandownload.DownloadStatistic) {
+	// 开始计时
+	statistic.StartTimer()
+
+	// 开始执行
+	executor.Execute()
+
+	fmt.Printf("\n下载结束, 时间: %s, 数据总量: %s\n", statistic.Elapsed()/1e6*1e6, converter.ConvertFileSize(statistic.TotalSize()))
+
+	// 输出失败的文件列表
+	failedList := executor.FailedDeque()
+	if failedList.Size() != 0 {
+		fmt.Printf("以下文件下载失败: \n")
+		tb := cmdtable.NewTable(os.Stdout)
+		for e := failedList.Shift(); e != nil; e = failedList.Shift() {
+			item := e.(*taskframework.TaskInfoItem)
+			tb.Append([]string{item.Info.Id(), item.Unit.(*pandownload.DownloadTaskUnit).FilePanPath})
+		}
+		tb.Render()
+	}
+}
+
+// RunDownload 执行下载网盘内文件
+func RunDownload(paths []string, options *DownloadOptions) {
+	if options == nil {
+		options = &DownloadOptions{}
+	}
+	cfg := prepareDownload(options)
 
 	paths, err := makePathAbsolute(options.FamilyId, paths...)
 	if err != nil {
@@ -235,13 +336,15 @@ func RunDownload(paths []string, options *DownloadOptions) {
 		return
 	}
 
+	// 打开文件摘要缓存, 用于比较本地已存在的文件
+	defer openHashCache()()
+
 	fmt.Print("\n")
 	fmt.Printf("[0] 提示: 当前下载最大并发量为: %d, 下载缓存为: %d\n", options.Parallel, cfg.CacheSize)
 
 	var (
 		panClient = GetActivePanClient()
 	)
-	cfg.MaxParallel = options.Parallel
 
 	// 预测要下载的文件数量
 	//for k := range paths {
@@ -288,6 +391,12 @@ func RunDownload(paths []string, options *DownloadOptions) {
 	for k := range paths {
 		// 使用通配符匹配
 		fileList, err2 := matchPathByShellPattern(options.FamilyId, paths[k])
+		if err2 != nil || len(fileList) == 0 {
+			// 文件名加密时, 输入的可能是明文路径
+			if p, ok := resolveEncryptedPanPath(options.FamilyId, options.Decryption, paths[k]); ok {
+				fileList, err2 = matchPathByShellPattern(options.FamilyId, p)
+			}
+		}
 		if err2 != nil {
 			fmt.Printf("获取文件出错，请稍后重试: %s\n", paths[k])
 			continue
@@ -315,42 +424,30 @@ func RunDownload(paths []string, options *DownloadOptions) {
 				IsPrintStatus:        options.IsPrintStatus,
 				IsExecutedPermission: options.IsExecutedPermission,
 				IsOverwrite:          options.IsOverwrite,
+				OnConflict:           options.OnConflict,
 				NoCheck:              options.NoCheck,
+				IsInPlace:            options.IsInPlace,
+				NoPreserveTime:       options.NoPreserveTime,
+				IsMove:               options.IsMove,
+				ForceProtected:       options.ForceProtected,
+				Decryption:           options.Decryption,
 				FilePanPath:          f.Path,
 				FamilyId:             options.FamilyId,
 			}
 
-			// 设置储存的路径
+			// 设置储存的路径, 加密的文件名保存为明文
 			if options.SaveTo != "" {
 				unit.OriginSaveRootPath = options.SaveTo
-				unit.SavePath = filepath.Join(options.SaveTo, f.Path)
+				unit.SavePath = filepath.Join(options.SaveTo, options.Decryption.PlainPath(f.Path))
 			} else {
 				// 使用默认的保存路径
 				unit.OriginSaveRootPath = GetActiveUser().GetSavePath("")
-				unit.SavePath = GetActiveUser().GetSavePath(f.Path)
+				unit.SavePath = GetActiveUser().GetSavePath(options.Decryption.PlainPath(f.Path))
 			}
 			info := executor.Append(&unit, options.MaxRetry)
 			fmt.Printf("[%s] 加入下载队列: %s\n", info.Id(), f.Path)
 		}
 	}
 
-	// 开始计时
-	statistic.StartTimer()
-
-	// 开始执行
-	executor.Execute()
-
-	fmt.Printf("\n下载结束, 时间: %s, 数据总量: %s\n", statistic.Elapsed()/1e6*1e6, converter.ConvertFileSize(statistic.TotalSize()))
-
-	// 输出失败的文件列表
-	failedList := executor.FailedDeque()
-	if failedList.Size() != 0 {
-		fmt.Printf("以下文件下载失败: \n")
-		tb := cmdtable.NewTable(os.Stdout)
-		for e := failedList.Shift(); e != nil; e = failedList.Shift() {
-			item := e.(*taskframework.TaskInfoItem)
-			tb.Append([]string{item.Info.Id(), item.Unit.(*pandownload.DownloadTaskUnit).FilePanPath})
-		}
-		tb.Render()
-	}
+	executeDownload(&executor, statistic)
 }
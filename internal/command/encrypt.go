@@ -0,0 +1,218 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package command
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/tickstep/cloudpan189-go/cmder/cmdliner"
+	"github.com/tickstep/cloudpan189-go/internal/config"
+	"github.com/tickstep/cloudpan189-go/internal/functions/pandownload"
+	"github.com/tickstep/cloudpan189-go/internal/localfile"
+	"github.com/tickstep/cloudpan189-go/library/crypto"
+	"github.com/urfave/cli"
+)
+
+type (
+	// UploadEncryption 加密网盘模式上传时的加密参数
+	UploadEncryption struct {
+		File  *localfile.FileEncryption
+		Names *crypto.NameCipher // 文件名的加密, 为空表示不加密文件名
+	}
+)
+
+// newKeychain 从密钥文件、-key 参数、环境变量或交互输入获取加密密码
+func newKeychain(c *cli.Context, confirm bool) (*crypto.Keychain, error) {
+	if keyFile := c.String("key-file"); keyFile != "" {
+		key, err := ioutil.ReadFile(keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("读取密钥文件失败: %s", err)
+		}
+		if len(key) == 0 {
+			return nil, errors.New("密钥文件为空")
+		}
+		return crypto.NewKeyFileKeychain(key), nil
+	}
+
+	if password := c.String("key"); password != "" {
+		return crypto.NewPassphraseKeychain(password), nil
+	}
+	if password := os.Getenv(config.EnvEncryptPassword); password != "" {
+		return crypto.NewPassphraseKeychain(password), nil
+	}
+
+	line := cmdliner.NewLiner()
+	defer line.Close()
+	fmt.Printf("请输入加密密码(输入的密码无回显, 回车提交) > ")
+	password, err := line.State.PasswordPrompt("")
+	if err != nil {
+		return nil, err
+	}
+	if password == "" {
+		return nil, errors.New("加密密码不能为空")
+	}
+	if confirm {
+		fmt.Printf("请再次输入加密密码 > ")
+		again, err := line.State.PasswordPrompt("")
+		if err != nil {
+			return nil, err
+		}
+		if again != password {
+			return nil, errors.New("两次输入的密码不一致")
+		}
+	}
+	return crypto.NewPassphraseKeychain(password), nil
+}
+
+// encryptSalt 加密上传使用的密钥派生盐, 首次使用时生成并保存到配置文件
+// 相同的盐和密码才能得到相同的密文, 使同名文件比较和秒传有效
+func encryptSalt() ([]byte, error) {
+	if salt, err := hex.DecodeString(config.Config.EncryptSalt); err == nil && len(salt) == 16 {
+		return salt, nil
+	}
+	p, err := crypto.NewKDFParams(crypto.KDFScrypt, nil)
+	if err != nil {
+		return nil, err
+	}
+	config.Config.EncryptSalt = hex.EncodeToString(p.Salt[:])
+	if err = config.Config.Save(); err != nil {
+		return nil, fmt.Errorf("保存配置文件失败: %s", err)
+	}
+	return p.Salt[:], nil
+}
+
+// parseUploadEncryption 解析加密上传的参数, 未启用加密网盘模式时返回空
+func parseUploadEncryption(c *cli.Context) (*UploadEncryption, error) {
+	if !c.Bool("encrypt") {
+		if c.Bool("encrypt-names") {
+			return nil, errors.New("--encrypt-names 需要同时指定 --encrypt")
+		}
+		return nil, nil
+	}
+	method, err := crypto.ParseMethod(c.String("encrypt-method"))
+	if err != nil {
+		return nil, err
+	}
+	keychain, err := newKeychain(c, true)
+	if err != nil {
+		return nil, err
+	}
+
+	var p crypto.KDFParams
+	if keychain.IsKeyFile() {
+		p, err = crypto.NewKDFParams(crypto.KDFNone, make([]byte, 16))
+	} else {
+		var salt []byte
+		if salt, err = encryptSalt(); err == nil {
+			p, err = crypto.NewKDFParams(crypto.KDFScrypt, salt)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if _, err = keychain.MasterKey(p); err != nil {
+		return nil, err
+	}
+
+	ue := &UploadEncryption{
+		File: &localfile.FileEncryption{
+			Keychain:  keychain,
+			Method:    method,
+			KDFParams: p,
+		},
+	}
+	if c.Bool("encrypt-names") {
+		if ue.Names, err = keychain.NewNameCipher(); err != nil {
+			return nil, err
+		}
+	}
+	return ue, nil
+}
+
+// parseDownloadDecryption 解析解密下载的参数, 未启用加密网盘模式时返回空
+func parseDownloadDecryption(c *cli.Context) (*pandownload.Decryption, error) {
+	if !c.Bool("encrypt") {
+		if c.Bool("encrypt-names") {
+			return nil, errors.New("--encrypt-names 需要同时指定 --encrypt")
+		}
+		return nil, nil
+	}
+	keychain, err := newKeychain(c, false)
+	if err != nil {
+		return nil, err
+	}
+	d := &pandownload.Decryption{
+		Keychain: keychain,
+	}
+	if c.Bool("encrypt-names") {
+		if d.Names, err = keychain.NewNameCipher(); err != nil {
+			return nil, err
+		}
+	}
+	return d, nil
+}
+
+// encryptRelPath 加密相对路径中的文件名, 未启用文件名加密时原样返回
+func (ue *UploadEncryption) encryptRelPath(relPath string) (string, error) {
+	if ue == nil || ue.Names == nil {
+		return relPath, nil
+	}
+	return ue.Names.EncryptPath(relPath)
+}
+
+// decryptRelPath 解密相对路径中的文件名, 不是加密的文件名则原样保留
+func (ue *UploadEncryption) decryptRelPath(relPath string) string {
+	if ue == nil || ue.Names == nil {
+		return relPath
+	}
+	d := &pandownload.Decryption{Names: ue.Names}
+	return d.PlainPath(relPath)
+}
+
+// resolveEncryptedPanPath 文件名加密时, 用户输入的是明文路径,
+// 从最后一级开始依次尝试加密路径末尾的文件名, 返回网盘上存在的路径
+func resolveEncryptedPanPath(familyId int64, d *pandownload.Decryption, panPath string) (string, bool) {
+	if d == nil || d.Names == nil {
+		return panPath, false
+	}
+	names := strings.Split(path.Clean(panPath), "/")
+	panClient := GetActivePanClient()
+	for k := len(names) - 1; k >= 1; k-- {
+		candidate := make([]string, len(names))
+		copy(candidate, names)
+		ok := true
+		for i := k; i < len(names); i++ {
+			encrypted, err := d.EncryptName(names[i])
+			if err != nil {
+				ok = false
+				break
+			}
+			candidate[i] = encrypted
+		}
+		if !ok {
+			continue
+		}
+		p := strings.Join(candidate, "/")
+		if _, err := panClient.AppFileInfoByPath(familyId, p); err == nil {
+			return p, true
+		}
+	}
+	return panPath, false
+}
@@ -20,6 +20,7 @@ import (
 	"github.com/tickstep/cloudpan189-api/cloudpan/apierror"
 	"github.com/tickstep/cloudpan189-go/cmder"
 	"github.com/tickstep/cloudpan189-go/internal/config"
+	"github.com/tickstep/cloudpan189-go/internal/functions/panupload"
 	"github.com/tickstep/library-go/logger"
 	"github.com/urfave/cli"
 	"io/ioutil"
@@ -80,15 +81,26 @@ func CmdImport() cli.Command {
 				saveTo = filepath.Clean(c.String("saveto"))
 			}
 
+			onConflict, err := panupload.ParseConflictPolicy(c.String("on-conflict"), c.Bool("ow"))
+			if err != nil {
+				fmt.Println(err)
+				return nil
+			}
+
 			subArgs := c.Args()
-			RunImportFiles(parseFamilyId(c), c.Bool("ow"), saveTo, subArgs[0])
+			RunImportFiles(parseFamilyId(c), onConflict, c.Bool("force-protected"), saveTo, subArgs[0])
 			return nil
 		},
 		Flags: []cli.Flag{
 			cli.BoolFlag{
 				Name:  "ow",
-				Usage: "overwrite, 覆盖已存在的网盘文件",
+				Usage: "overwrite, 覆盖已存在的网盘文件, 等同于 -on-conflict skip-if-identical",
 			},
+			cli.StringFlag{
+				Name:  "on-conflict",
+				Usage: "网盘已存在同名文件时的处理策略: skip, overwrite, rename-new, rename-old, skip-if-identical",
+			},
+			forceProtectedFlag,
 			cli.StringFlag{
 				Name:  "familyId",
 				Usage: "家庭云ID",
@@ -102,7 +114,7 @@ func CmdImport() cli.Command {
 	}
 }
 
-func RunImportFiles(familyId int64, overwrite bool, panSavePath, localFilePath string) {
+func RunImportFiles(familyId int64, onConflict panupload.ConflictPolicy, forceProtected bool, panSavePath, localFilePath string) {
 	lfi, _ := os.Stat(localFilePath)
 	if lfi != nil {
 		if lfi.IsDir() {
@@ -166,7 +178,7 @@ func RunImportFiles(familyId int64, overwrite bool, panSavePath, localFilePath s
 	failedImportFiles := []ImportExportFileItem{}
 	for _, item := range importFileItems {
 		fmt.Printf("正在处理导入: %s\n", item.Path)
-		result, abort := processOneImport(familyId, overwrite, dirMap, item)
+		result, abort := processOneImport(familyId, onConflict, forceProtected, dirMap, item)
 		if abort {
 			fmt.Println("导入任务终止了")
 			break
@@ -188,54 +200,32 @@ func RunImportFiles(familyId int64, overwrite bool, panSavePath, localFilePath s
 	fmt.Printf("导入结果, 成功 %d, 失败 %d\n", len(successImportFiles), len(failedImportFiles))
 }
 
-func processOneImport(familyId int64, isOverwrite bool, dirMap map[string]*dirFileListData, item ImportExportFileItem) (result, abort bool) {
+func processOneImport(familyId int64, onConflict panupload.ConflictPolicy, forceProtected bool, dirMap map[string]*dirFileListData, item ImportExportFileItem) (result, abort bool) {
 	panClient := config.Config.ActiveUser().PanClient()
 	panDir, fileName := path.Split(item.Path)
 	dataItem := dirMap[path.Dir(panDir)]
-	if isOverwrite {
-		// 标记覆盖旧同名文件
-		// 检查同名文件是否存在
-		var efi *cloudpan.AppFileEntity = nil
-		for _, fileItem := range dataItem.File.FileList {
-			if !fileItem.IsFolder && fileItem.FileName == fileName {
-				efi = fileItem
-				break
-			}
-		}
-		if efi != nil && efi.FileId != "" {
-			// existed, delete it
-			infoList := cloudpan.BatchTaskInfoList{}
-			isFolder := 0
-			if efi.IsFolder {
-				isFolder = 1
-			}
-			infoItem := &cloudpan.BatchTaskInfo{
-				FileId:      efi.FileId,
-				FileName:    efi.FileName,
-				IsFolder:    isFolder,
-				SrcParentId: efi.ParentId,
-			}
-			infoList = append(infoList, infoItem)
-			delParam := &cloudpan.BatchTaskParam{
-				TypeFlag:  cloudpan.BatchTaskTypeDelete,
-				TaskInfos: infoList,
-			}
-
-			var taskId string
-			var err *apierror.ApiError
-			if familyId > 0 {
-				taskId, err = panClient.AppCreateBatchTask(familyId, delParam)
-			} else {
-				taskId, err = panClient.CreateBatchTask(delParam)
-			}
+	if dataItem == nil {
+		fmt.Println("创建云盘文件夹失败")
+		return false, false
+	}
 
-			if err != nil || taskId == "" {
-				fmt.Println("无法删除文件，请稍后重试")
-				return false, false
-			}
-			time.Sleep(time.Duration(500) * time.Millisecond)
-			fmt.Println("检测到同名文件，已移动到回收站")
-		}
+	// 处理同名文件
+	finalPath, _, action, err := panupload.ResolveConflict(panClient, familyId, item.Path, item.FileMd5, onConflict, forceProtected)
+	if err != nil {
+		fmt.Println(err)
+		return false, false
+	}
+	switch action {
+	case panupload.ConflictActionSkip:
+		fmt.Println("网盘已存在同名文件，跳过")
+		return true, false
+	case panupload.ConflictActionIdentical:
+		fmt.Println("网盘已存在相同的文件，跳过")
+		return true, false
+	}
+	if finalPath != item.Path {
+		fmt.Println("检测到同名文件，重命名保存为: " + finalPath)
+		fileName = path.Base(finalPath)
 	}
 
 	var r *cloudpan.AppCreateUploadFileResult
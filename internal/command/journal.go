@@ -0,0 +1,476 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package command
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/tickstep/cloudpan189-api/cloudpan"
+	"github.com/tickstep/cloudpan189-api/cloudpan/apierror"
+	"github.com/tickstep/cloudpan189-go/cmder"
+	"github.com/tickstep/cloudpan189-go/cmder/cmdtable"
+	"github.com/tickstep/cloudpan189-go/internal/config"
+	"github.com/tickstep/cloudpan189-go/internal/functions"
+	"github.com/tickstep/cloudpan189-go/internal/functions/panjournal"
+	"github.com/tickstep/cloudpan189-go/internal/functions/panrecycle"
+	"github.com/urfave/cli"
+)
+
+const (
+	// undoTaskTimeout 撤销时等待批量任务完成的最长时间
+	undoTaskTimeout = 10 * time.Minute
+)
+
+var (
+	journalOpNames = map[string]string{
+		panjournal.OpRemove:       "删除",
+		panjournal.OpMove:         "移动",
+		panjournal.OpRename:       "重命名",
+		panjournal.OpOverwrite:    "覆盖",
+		panjournal.OpBackupDelete: "备份同步删除",
+		panjournal.OpUndo:         "撤销",
+	}
+)
+
+func CmdHistory() cli.Command {
+	return cli.Command{
+		Name:      "history",
+		Usage:     "查看操作日志",
+		UsageText: cmder.App().Name + " history [-n=<数量>] [操作ID]",
+		Description: `
+	列出当前账号删除、移动、重命名、覆盖和备份同步删除网盘文件的操作记录, 最近的操作在前.
+	指定操作ID时列出该操作涉及的全部文件. 使用 undo 命令撤销操作.
+
+	示例:
+
+	列出最近 20 条操作记录
+	cloudpan189-go history
+
+	列出操作 20201018150405-a1b2 涉及的文件
+	cloudpan189-go history 20201018150405-a1b2
+`,
+		Category: "天翼云盘",
+		Before:   cmder.ReloadConfigFunc,
+		Action: func(c *cli.Context) error {
+			if config.Config.ActiveUser() == nil {
+				fmt.Println("未登录账号")
+				return nil
+			}
+			if c.NArg() > 0 {
+				RunHistoryDetail(c.Args().Get(0))
+				return nil
+			}
+			RunHistory(c.Int("n"))
+			return nil
+		},
+		Flags: []cli.Flag{
+			cli.IntFlag{
+				Name:  "n",
+				Usage: "列出的记录数量, 0 表示全部",
+				Value: 20,
+			},
+		},
+	}
+}
+
+func CmdUndo() cli.Command {
+	return cli.Command{
+		Name:      "undo",
+		Usage:     "撤销操作",
+		UsageText: cmder.App().Name + " undo [-y] [--force-protected] <操作ID>",
+		Description: `
+	根据操作日志撤销操作, 操作ID可以通过 history 命令查看.
+	删除、覆盖和备份同步删除的文件从回收站还原, 移动的文件移回原来的目录, 重命名的文件恢复原来的名称.
+	撤销覆盖时, 会先将当前的同名文件移到回收站, 需要确认. 当前文件在受保护的路径中时需要使用 --force-protected.
+
+	示例:
+
+	撤销操作 20201018150405-a1b2
+	cloudpan189-go undo 20201018150405-a1b2
+`,
+		Category: "天翼云盘",
+		Before:   cmder.ReloadConfigFunc,
+		Action: func(c *cli.Context) error {
+			if c.NArg() != 1 {
+				cli.ShowCommandHelp(c, c.Command.Name)
+				return nil
+			}
+			if config.Config.ActiveUser() == nil {
+				fmt.Println("未登录账号")
+				return nil
+			}
+			RunUndo(c.Args().Get(0), c.Bool("y"), c.Bool("force-protected"))
+			return nil
+		},
+		Flags: []cli.Flag{
+			cli.BoolFlag{
+				Name:  "y",
+				Usage: "不需要确认",
+			},
+			forceProtectedFlag,
+		},
+	}
+}
+
+// loadUserJournal 读取当前账号的操作记录, 以及已撤销的操作ID
+func loadUserJournal() ([]*panjournal.Entry, map[string]string, error) {
+	all, err := panjournal.ReadAll()
+	if err != nil {
+		return nil, nil, fmt.Errorf("读取操作日志失败: %s", err)
+	}
+	uid := GetActiveUser().UID
+	entries := make([]*panjournal.Entry, 0, len(all))
+	undone := map[string]string{}
+	for _, e := range all {
+		if e.UID != uid {
+			continue
+		}
+		entries = append(entries, e)
+		if e.Op == panjournal.OpUndo && e.UndoOf != "" {
+			undone[e.UndoOf] = e.Id
+		}
+	}
+	return entries, undone, nil
+}
+
+// findJournalEntry 查找当前账号的操作记录
+func findJournalEntry(opId string) (*panjournal.Entry, map[string]string, error) {
+	entries, undone, err := loadUserJournal()
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, e := range entries {
+		if e.Id == opId {
+			return e, undone, nil
+		}
+	}
+	return nil, nil, fmt.Errorf("操作记录不存在: %s", opId)
+}
+
+// journalEntryDesc 操作的简要说明
+func journalEntryDesc(e *panjournal.Entry) string {
+	desc := ""
+	if len(e.Files) > 0 {
+		desc = e.Files[0].Path
+		if len(e.Files) > 1 {
+			desc += fmt.Sprintf(" 等 %d 个文件/目录", len(e.Files))
+		}
+	}
+	switch e.Op {
+	case panjournal.OpMove:
+		desc += " -> " + e.TargetPath
+	case panjournal.OpRename:
+		desc += " -> " + e.NewName
+	case panjournal.OpUndo:
+		desc = "撤销 " + e.UndoOf + ": " + desc
+	}
+	return desc
+}
+
+// RunHistory 列出当前账号最近的操作记录
+func RunHistory(n int) {
+	entries, undone, err := loadUserJournal()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	if len(entries) == 0 {
+		fmt.Println("没有操作记录")
+		return
+	}
+
+	tb := cmdtable.NewTable(os.Stdout)
+	tb.SetHeader([]string{"#", "操作ID", "时间", "操作", "云盘", "文件/目录", "状态"})
+	count := 0
+	for i := len(entries) - 1; i >= 0 && (n <= 0 || count < n); i-- {
+		e := entries[i]
+		status := ""
+		if undoId, ok := undone[e.Id]; ok {
+			status = "已撤销(" + undoId + ")"
+		}
+		tb.Append([]string{strconv.Itoa(count), e.Id, e.Time, journalOpNames[e.Op], GetFamilyCloudMark(e.FamilyId), journalEntryDesc(e), status})
+		count++
+	}
+	tb.Render()
+	fmt.Printf("共 %d 条操作记录, 操作日志: %s\n", len(entries), panjournal.JournalPath())
+}
+
+// RunHistoryDetail 列出一条操作记录涉及的全部文件
+func RunHistoryDetail(opId string) {
+	e, undone, err := findJournalEntry(opId)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Printf("操作ID: %s\n时间: %s\n操作: %s\n云盘: %s\n", e.Id, e.Time, journalOpNames[e.Op], GetFamilyCloudMark(e.FamilyId))
+	if e.TargetPath != "" {
+		fmt.Printf("目标目录: %s\n", e.TargetPath)
+	}
+	if e.NewName != "" {
+		fmt.Printf("新名称: %s\n", e.NewName)
+	}
+	if undoId, ok := undone[e.Id]; ok {
+		fmt.Printf("已撤销: %s\n", undoId)
+	}
+
+	tb := cmdtable.NewTable(os.Stdout)
+	tb.SetHeader([]string{"#", "file_id", "路径", "原目录ID"})
+	for k, f := range e.Files {
+		tb.Append([]string{strconv.Itoa(k), f.FileId, f.Path, f.ParentId})
+	}
+	tb.Render()
+}
+
+// journalFileEntity 操作记录中的文件转换为网盘文件
+func journalFileEntity(f *panjournal.File) *cloudpan.AppFileEntity {
+	return &cloudpan.AppFileEntity{
+		FileId:   f.FileId,
+		FileName: f.FileName,
+		Path:     f.Path,
+		ParentId: f.ParentId,
+		IsFolder: f.IsFolder,
+	}
+}
+
+// RunUndo 撤销操作, 撤销成功的文件记录为一条撤销操作
+func RunUndo(opId string, yes, forceProtected bool) {
+	e, undone, err := findJournalEntry(opId)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	if e.Op == panjournal.OpUndo {
+		fmt.Println("撤销操作不能再撤销")
+		return
+	}
+	if undoId, ok := undone[e.Id]; ok {
+		fmt.Printf("该操作已经撤销: %s\n", undoId)
+		return
+	}
+
+	var reverted []*panjournal.File
+	switch e.Op {
+	case panjournal.OpRemove, panjournal.OpBackupDelete, panjournal.OpOverwrite:
+		reverted, err = undoRestore(e, yes, forceProtected)
+	case panjournal.OpMove:
+		reverted, err = undoMove(e)
+	case panjournal.OpRename:
+		reverted, err = undoRename(e)
+	default:
+		err = fmt.Errorf("不支持撤销该操作: %s", e.Op)
+	}
+	if err != nil {
+		fmt.Println(err)
+	}
+	if len(reverted) == 0 {
+		return
+	}
+
+	entry := panjournal.NewEntry(panjournal.OpUndo, e.FamilyId)
+	entry.UndoOf = e.Id
+	entry.Files = reverted
+	panjournal.Record(entry)
+	fmt.Printf("撤销完成, 成功 %d 个, 共 %d 个\n", len(reverted), len(e.Files))
+}
+
+// undoRestore 从回收站还原删除的文件, 撤销覆盖时先将当前的同名文件移到回收站, 受保护的路径需要 forceProtected
+func undoRestore(e *panjournal.Entry, yes, forceProtected bool) ([]*panjournal.File, error) {
+	filter := &recycleFilter{}
+	for _, f := range e.Files {
+		filter.Targets = append(filter.Targets, f.FileId)
+	}
+	recycleFiles, err := collectRecycleFiles(e.FamilyId, filter)
+	if err != nil {
+		return nil, fmt.Errorf("还原文件失败: %s", err)
+	}
+	files, missing := splitRecycled(e.Files, recycleFiles)
+	for _, f := range missing {
+		fmt.Printf("文件不在回收站中, 可能已经还原或彻底删除: %s\n", f.Path)
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("没有可以还原的文件")
+	}
+
+	panClient := GetActivePanClient()
+	if e.Op == panjournal.OpOverwrite {
+		// 覆盖后上传的文件占用了原来的路径
+		var current []*cloudpan.AppFileEntity
+		for _, f := range files {
+			efi, apierr := panClient.AppFileInfoByPath(e.FamilyId, f.Path)
+			if apierr != nil && apierr.Code != apierror.ApiCodeFileNotFoundCode {
+				return nil, fmt.Errorf("检测同名文件失败: %s", apierr)
+			}
+			if efi != nil && efi.FileId != "" && efi.FileId != f.FileId {
+				current = append(current, efi)
+			}
+		}
+		if len(current) > 0 {
+			for _, efi := range current {
+				if err := checkProtectedPaths(e.FamilyId, forceProtected, efi.Path); err != nil {
+					return nil, err
+				}
+			}
+			for _, efi := range current {
+				fmt.Println(efi.Path)
+			}
+			if !confirmAction(fmt.Sprintf("还原前需要将以上 %d 个当前文件移到回收站, 确认继续?", len(current)), yes) {
+				return nil, fmt.Errorf("已取消操作")
+			}
+			// 移到回收站的当前文件单独记录为一条覆盖操作, 可以再次撤销
+			overwritten := panjournal.NewEntry(panjournal.OpOverwrite, e.FamilyId)
+			defer func() {
+				panjournal.Record(overwritten)
+				if len(overwritten.Files) > 0 {
+					fmt.Printf("当前文件已移到回收站, 使用 undo %s 撤销\n", overwritten.Id)
+				}
+			}()
+			for _, efi := range current {
+				if err := functions.DeletePanFile(panClient, e.FamilyId, efi); err != nil {
+					return nil, fmt.Errorf("删除当前文件失败: %s, %s", efi.Path, err)
+				}
+				overwritten.AddEntity(efi)
+			}
+		}
+	}
+
+	rc := panrecycle.NewRecycleClient(GetActiveUser().WebToken)
+	taskId, err := rc.Restore(e.FamilyId, recycleFiles)
+	if err != nil {
+		return nil, err
+	}
+	result, err := functions.WaitBatchTask(panClient, cloudpan.BatchTaskTypeRecycleRestore, taskId, functions.BatchTaskOptions{
+		FamilyId: e.FamilyId,
+		Timeout:  undoTaskTimeout,
+	})
+	if err != nil {
+		return restoredFiles(e.FamilyId, files), fmt.Errorf("还原文件失败: %s", err)
+	}
+	if !result.OK() || result.Skipped > 0 {
+		return restoredFiles(e.FamilyId, files), fmt.Errorf("还原文件部分失败, %s, 请通过 recycle list 检查", result)
+	}
+	return files, nil
+}
+
+// splitRecycled 按是否在回收站中拆分文件
+func splitRecycled(files []*panjournal.File, recycleFiles panrecycle.RecycleFileList) (recycled, missing []*panjournal.File) {
+	inRecycle := map[string]bool{}
+	for _, rf := range recycleFiles {
+		inRecycle[rf.FileId] = true
+	}
+	for _, f := range files {
+		if inRecycle[f.FileId] {
+			recycled = append(recycled, f)
+		} else {
+			missing = append(missing, f)
+		}
+	}
+	return
+}
+
+// restoredFiles 还原部分失败时, 已不在回收站中的文件视为已还原, 用于记录撤销操作
+func restoredFiles(familyId int64, files []*panjournal.File) []*panjournal.File {
+	filter := &recycleFilter{}
+	for _, f := range files {
+		filter.Targets = append(filter.Targets, f.FileId)
+	}
+	recycleFiles, err := collectRecycleFiles(familyId, filter)
+	if err != nil {
+		fmt.Printf("检查还原结果失败: %s\n", err)
+		return nil
+	}
+	_, restored := splitRecycled(files, recycleFiles)
+	return restored
+}
+
+// undoMove 将移动的文件移回原来的目录, 跳过仍在原位置的文件
+func undoMove(e *panjournal.Entry) ([]*panjournal.File, error) {
+	panClient := GetActivePanClient()
+	moved := make([]*panjournal.File, 0, len(e.Files))
+	for _, f := range e.Files {
+		if efi, apierr := panClient.AppFileInfoByPath(e.FamilyId, f.Path); apierr == nil && efi.FileId == f.FileId {
+			fmt.Printf("文件仍在原位置, 可能没有移动成功: %s\n", f.Path)
+			continue
+		}
+		moved = append(moved, f)
+	}
+	if len(moved) == 0 {
+		return nil, fmt.Errorf("没有需要移回的文件")
+	}
+
+	reverted := make([]*panjournal.File, 0, len(moved))
+	if IsFamilyCloud(e.FamilyId) {
+		for _, f := range moved {
+			if _, apierr := panClient.AppFamilyMoveFile(e.FamilyId, f.FileId, f.ParentId); apierr != nil {
+				fmt.Printf("移回文件失败: %s, %s\n", f.Path, apierr)
+				continue
+			}
+			reverted = append(reverted, f)
+		}
+		return reverted, nil
+	}
+
+	// 按原来的目录分组, 每个目录一个移动任务
+	groups := map[string][]*panjournal.File{}
+	parentIds := []string{}
+	for _, f := range moved {
+		if _, ok := groups[f.ParentId]; !ok {
+			parentIds = append(parentIds, f.ParentId)
+		}
+		groups[f.ParentId] = append(groups[f.ParentId], f)
+	}
+	for _, parentId := range parentIds {
+		opFileList := make([]*cloudpan.AppFileEntity, 0, len(groups[parentId]))
+		for _, f := range groups[parentId] {
+			opFileList = append(opFileList, journalFileEntity(f))
+		}
+		result, err := functions.RunBatchTask(panClient, &cloudpan.BatchTaskParam{
+			TypeFlag:       cloudpan.BatchTaskTypeMove,
+			TaskInfos:      makeBatchTaskInfoList(opFileList),
+			TargetFolderId: parentId,
+		}, functions.BatchTaskOptions{Timeout: undoTaskTimeout})
+		if err != nil {
+			fmt.Printf("移回文件失败: %s\n", err)
+			continue
+		}
+		if !result.OK() || result.Skipped > 0 {
+			fmt.Printf("移回文件失败, 原来的目录中可能已经存在同名文件: %s\n", groups[parentId][0].Path)
+			continue
+		}
+		reverted = append(reverted, groups[parentId]...)
+	}
+	return reverted, nil
+}
+
+// undoRename 恢复重命名前的名称
+func undoRename(e *panjournal.Entry) ([]*panjournal.File, error) {
+	panClient := GetActivePanClient()
+	reverted := make([]*panjournal.File, 0, len(e.Files))
+	for _, f := range e.Files {
+		var apierr *apierror.ApiError
+		if IsFamilyCloud(e.FamilyId) {
+			_, apierr = panClient.AppFamilyRenameFile(e.FamilyId, f.FileId, f.FileName)
+		} else {
+			_, apierr = panClient.AppRenameFile(f.FileId, f.FileName)
+		}
+		if apierr != nil {
+			fmt.Printf("恢复文件名称失败: %s, %s\n", f.Path, apierr)
+			continue
+		}
+		reverted = append(reverted, f)
+	}
+	return reverted, nil
+}
@@ -0,0 +1,41 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package command
+
+import (
+	"testing"
+
+	"github.com/tickstep/cloudpan189-go/internal/functions/panjournal"
+	"github.com/tickstep/cloudpan189-go/internal/functions/panrecycle"
+)
+
+func TestSplitRecycled(t *testing.T) {
+	files := []*panjournal.File{
+		{FileId: "1", Path: "/a"},
+		{FileId: "2", Path: "/b"},
+		{FileId: "3", Path: "/c"},
+	}
+	recycleFiles := panrecycle.RecycleFileList{
+		{FileId: "2"},
+		{FileId: "4"},
+	}
+	recycled, missing := splitRecycled(files, recycleFiles)
+	if len(recycled) != 1 || recycled[0].FileId != "2" {
+		t.Errorf("recycled = %v", recycled)
+	}
+	// 还原部分失败时, 不在回收站中的文件视为已还原
+	if len(missing) != 2 || missing[0].FileId != "1" || missing[1].FileId != "3" {
+		t.Errorf("missing = %v", missing)
+	}
+}
@@ -0,0 +1,172 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package command
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/tickstep/cloudpan189-go/cmder"
+	"github.com/tickstep/cloudpan189-go/cmder/cmdtable"
+	"github.com/tickstep/cloudpan189-go/internal/config"
+	"github.com/urfave/cli"
+)
+
+var (
+	// forceProtectedFlag 允许删除或覆盖受保护的路径
+	forceProtectedFlag = cli.BoolFlag{
+		Name:  "force-protected",
+		Usage: "允许删除或覆盖受保护的路径, 受保护的路径通过 config protect 设置",
+	}
+)
+
+func cmdConfigProtect() cli.Command {
+	familyIdFlag := cli.StringFlag{
+		Name:  "familyId",
+		Usage: "家庭云ID",
+		Value: "",
+	}
+	return cli.Command{
+		Name:  "protect",
+		Usage: "设置受保护的网盘路径",
+		Description: `
+	受保护的网盘路径以及其中的文件不能被 rm、mv、upload、import、backup 删除或覆盖, 删除其上级目录同样会被拒绝.
+	需要操作时使用 --force-protected 参数. 受保护的路径按当前账号和个人云/家庭云分别设置.
+
+	例子:
+		cloudpan189-go config protect add /照片
+		cloudpan189-go config protect add -familyId 123456 /家庭相册
+		cloudpan189-go config protect list
+		cloudpan189-go config protect remove /照片`,
+		Action: func(c *cli.Context) error {
+			cli.ShowCommandHelp(c, c.Command.Name)
+			return nil
+		},
+		Subcommands: []cli.Command{
+			{
+				Name:      "add",
+				Usage:     "添加受保护的路径",
+				UsageText: cmder.App().Name + " config protect add <网盘路径1> <网盘路径2> ...",
+				Action: func(c *cli.Context) error {
+					if c.NArg() == 0 {
+						cli.ShowCommandHelp(c, c.Command.Name)
+						return nil
+					}
+					if config.Config.ActiveUser() == nil {
+						fmt.Println("未登录账号")
+						return nil
+					}
+					RunProtectAdd(parseFamilyId(c), c.Args()...)
+					return nil
+				},
+				Flags: []cli.Flag{familyIdFlag},
+			},
+			{
+				Name:      "remove",
+				Aliases:   []string{"rm"},
+				Usage:     "移除受保护的路径",
+				UsageText: cmder.App().Name + " config protect remove <网盘路径1> <网盘路径2> ...",
+				Action: func(c *cli.Context) error {
+					if c.NArg() == 0 {
+						cli.ShowCommandHelp(c, c.Command.Name)
+						return nil
+					}
+					if config.Config.ActiveUser() == nil {
+						fmt.Println("未登录账号")
+						return nil
+					}
+					RunProtectRemove(parseFamilyId(c), c.Args()...)
+					return nil
+				},
+				Flags: []cli.Flag{familyIdFlag},
+			},
+			{
+				Name:      "list",
+				Aliases:   []string{"ls"},
+				Usage:     "列出受保护的路径",
+				UsageText: cmder.App().Name + " config protect list",
+				Action: func(c *cli.Context) error {
+					if config.Config.ActiveUser() == nil {
+						fmt.Println("未登录账号")
+						return nil
+					}
+					RunProtectList(parseFamilyId(c))
+					return nil
+				},
+				Flags: []cli.Flag{familyIdFlag},
+			},
+		},
+	}
+}
+
+// RunProtectAdd 添加受保护的路径, 相对路径以当前工作目录为准
+func RunProtectAdd(familyId int64, paths ...string) {
+	activeUser := GetActiveUser()
+	for _, p := range paths {
+		p = activeUser.PathJoin(familyId, p)
+		if config.Config.AddProtectedPath(activeUser.UID, familyId, p) {
+			fmt.Printf("已添加%s受保护的路径: %s\n", GetFamilyCloudMark(familyId), p)
+		} else {
+			fmt.Printf("受保护的路径已存在: %s\n", p)
+		}
+	}
+	if err := config.Config.Save(); err != nil {
+		fmt.Printf("保存配置失败: %s\n", err)
+	}
+}
+
+// RunProtectRemove 移除受保护的路径
+func RunProtectRemove(familyId int64, paths ...string) {
+	activeUser := GetActiveUser()
+	for _, p := range paths {
+		p = activeUser.PathJoin(familyId, p)
+		if config.Config.RemoveProtectedPath(activeUser.UID, familyId, p) {
+			fmt.Printf("已移除%s受保护的路径: %s\n", GetFamilyCloudMark(familyId), p)
+		} else {
+			fmt.Printf("受保护的路径不存在: %s\n", p)
+		}
+	}
+	if err := config.Config.Save(); err != nil {
+		fmt.Printf("保存配置失败: %s\n", err)
+	}
+}
+
+// RunProtectList 列出受保护的路径
+func RunProtectList(familyId int64) {
+	paths := config.Config.ProtectedPathsOf(GetActiveUser().UID, familyId)
+	if len(paths) == 0 {
+		fmt.Printf("%s没有受保护的路径\n", GetFamilyCloudMark(familyId))
+		return
+	}
+	tb := cmdtable.NewTable(os.Stdout)
+	tb.SetHeader([]string{"#", GetFamilyCloudMark(familyId) + "受保护的路径"})
+	for k, p := range paths {
+		tb.Append([]string{strconv.Itoa(k), p})
+	}
+	tb.Render()
+}
+
+// checkProtectedPaths 检查要删除或覆盖的网盘路径是否涉及受保护的路径, force 为 true 时不检查
+func checkProtectedPaths(familyId int64, force bool, paths ...string) error {
+	if force {
+		return nil
+	}
+	for _, p := range paths {
+		if err := config.Config.CheckProtectedPath(familyId, p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -19,29 +19,77 @@ import (
 	"github.com/tickstep/cloudpan189-api/cloudpan"
 	"github.com/tickstep/cloudpan189-go/cmder"
 	"github.com/tickstep/cloudpan189-go/cmder/cmdtable"
+	"github.com/tickstep/cloudpan189-go/internal/config"
+	"github.com/tickstep/cloudpan189-go/internal/functions"
+	"github.com/tickstep/cloudpan189-go/internal/functions/panrecycle"
 	"github.com/tickstep/library-go/converter"
 	"github.com/urfave/cli"
 	"os"
+	"path"
 	"strconv"
+	"strings"
+	"time"
 )
 
+const (
+	// recycleRestoreTimeout 等待还原任务完成的最长时间
+	recycleRestoreTimeout = 10 * time.Minute
+)
+
+type (
+	// recycleFilter 筛选回收站中的文件
+	recycleFilter struct {
+		Targets    []string // 文件ID或删除前的路径
+		Name       string   // 文件名, 支持通配符
+		PathPrefix string   // 删除前所在的目录
+	}
+)
+
+func recycleFilterFlags() []cli.Flag {
+	return []cli.Flag{
+		cli.StringFlag{
+			Name:  "name",
+			Usage: "按文件名筛选, 支持通配符, 例如 *.mp4",
+		},
+		cli.StringFlag{
+			Name:  "path",
+			Usage: "按删除前所在的目录筛选, 包括子目录",
+		},
+		cli.StringFlag{
+			Name:  "familyId",
+			Usage: "家庭云ID",
+			Value: "",
+		},
+	}
+}
+
 func CmdRecycle() cli.Command {
 	return cli.Command{
 		Name:  "recycle",
 		Usage: "回收站",
 		Description: `
-	回收站操作.
+	回收站操作, 使用 -familyId 操作家庭云的回收站.
+	还原和删除时可以指定文件的 file_id 或删除前的路径, 也可以通过 -name 和 -path 筛选.
 
 	示例:
 
-	1. 从回收站还原两个文件, 其中的两个文件的 file_id 分别为 1013792297798440 和 643596340463870
+	1. 列出回收站中删除前在 /我的资源 目录中的 mp4 文件
+	cloudpan189-go recycle list -path /我的资源 -name "*.mp4"
+
+	2. 从回收站还原两个文件, 其中的两个文件的 file_id 分别为 1013792297798440 和 643596340463870
 	cloudpan189-go recycle restore 1013792297798440 643596340463870
 
-	2. 从回收站删除两个文件, 其中的两个文件的 file_id 分别为 1013792297798440 和 643596340463870
+	3. 按删除前的路径还原文件
+	cloudpan189-go recycle restore /我的资源/1.mp4
+
+	4. 从回收站删除两个文件, 其中的两个文件的 file_id 分别为 1013792297798440 和 643596340463870
 	cloudpan189-go recycle delete 1013792297798440 643596340463870
 
-	3. 清空回收站, 程序不会进行二次确认, 谨慎操作!!!
+	5. 清空回收站, 需要确认
 	cloudpan189-go recycle delete -all
+
+	6. 清理 30 天前删除的文件
+	cloudpan189-go recycle purge -older-than 30d
 `,
 		Category: "天翼云盘",
 		Before:   cmder.ReloadConfigFunc,
@@ -56,167 +104,247 @@ func CmdRecycle() cli.Command {
 				Name:      "list",
 				Aliases:   []string{"ls", "l"},
 				Usage:     "列出回收站文件列表",
-				UsageText: cmder.App().Name + " recycle list",
+				UsageText: cmder.App().Name + " recycle list [-page=<页数>] [-name=<文件名>] [-path=<目录>]",
+				Description: `列出回收站中的文件, 包括删除前的路径.
+	使用 -name 或 -path 筛选时列出全部页中符合条件的文件.`,
 				Action: func(c *cli.Context) error {
-					RunRecycleList(c.Int("page"))
+					if config.Config.ActiveUser() == nil {
+						fmt.Println("未登录账号")
+						return nil
+					}
+					RunRecycleList(parseFamilyId(c), c.Int("page"), parseRecycleFilter(c))
 					return nil
 				},
-				Flags: []cli.Flag{
+				Flags: append([]cli.Flag{
 					cli.IntFlag{
 						Name:  "page",
 						Usage: "回收站文件列表页数",
 						Value: 1,
 					},
-				},
+				}, recycleFilterFlags()...),
 			},
 			{
 				Name:        "restore",
 				Aliases:     []string{"r"},
 				Usage:       "还原回收站文件或目录",
-				UsageText:   cmder.App().Name + " recycle restore <file_id 1> <file_id 2> <file_id 3> ...",
-				Description: `根据文件/目录的 fs_id, 还原回收站指定的文件或目录`,
+				UsageText:   cmder.App().Name + " recycle restore [-name=<文件名>] [-path=<目录>] <file_id 或删除前的路径> ...",
+				Description: `根据文件/目录的 file_id 或删除前的路径, 还原回收站指定的文件或目录到原来的位置`,
 				Action: func(c *cli.Context) error {
-					if c.NArg() <= 0 {
+					filter := parseRecycleFilter(c)
+					if filter.isEmpty() {
 						cli.ShowCommandHelp(c, c.Command.Name)
 						return nil
 					}
-					RunRecycleRestore(c.Args()...)
+					if config.Config.ActiveUser() == nil {
+						fmt.Println("未登录账号")
+						return nil
+					}
+					RunRecycleRestore(parseFamilyId(c), filter)
 					return nil
 				},
+				Flags: recycleFilterFlags(),
 			},
 			{
 				Name:        "delete",
 				Aliases:     []string{"d"},
 				Usage:       "删除回收站文件或目录 / 清空回收站",
-				UsageText:   cmder.App().Name + " recycle delete [-all] <file_id 1> <file_id 2> <file_id 3> ...",
-				Description: `根据文件/目录的 file_id 或 -all 参数, 删除回收站指定的文件或目录或清空回收站`,
+				UsageText:   cmder.App().Name + " recycle delete [-all] [-name=<文件名>] [-path=<目录>] <file_id 或删除前的路径> ...",
+				Description: `根据文件/目录的 file_id、删除前的路径或 -all 参数, 彻底删除回收站指定的文件或目录或清空回收站, 按条件筛选和清空回收站时需要确认`,
 				Action: func(c *cli.Context) error {
+					if config.Config.ActiveUser() == nil {
+						fmt.Println("未登录账号")
+						return nil
+					}
 					if c.Bool("all") {
 						// 清空回收站
-						RunRecycleClear()
+						RunRecycleClear(parseFamilyId(c), c.Bool("y"))
 						return nil
 					}
 
-					if c.NArg() <= 0 {
+					filter := parseRecycleFilter(c)
+					if filter.isEmpty() {
 						cli.ShowCommandHelp(c, c.Command.Name)
 						return nil
 					}
-					RunRecycleDelete(c.Args()...)
+					RunRecycleDelete(parseFamilyId(c), filter, c.Bool("y"))
 					return nil
 				},
-				Flags: []cli.Flag{
+				Flags: append([]cli.Flag{
 					cli.BoolFlag{
 						Name:  "all",
-						Usage: "清空回收站, 程序不会进行二次确认, 谨慎操作!!!",
+						Usage: "清空回收站",
 					},
-				},
+					cli.BoolFlag{
+						Name:  "y",
+						Usage: "不需要确认",
+					},
+				}, recycleFilterFlags()...),
 			},
+			cmdRecyclePurge(),
 		},
 	}
 }
 
-// RunRecycleList 执行列出回收站文件列表
-func RunRecycleList(page int) {
-	if page < 1 {
-		page = 1
+func parseRecycleFilter(c *cli.Context) *recycleFilter {
+	filter := &recycleFilter{
+		Targets: c.Args(),
+		Name:    c.String("name"),
 	}
+	if c.String("path") != "" {
+		filter.PathPrefix = path.Clean("/" + c.String("path"))
+	}
+	return filter
+}
 
-	panClient := GetActivePanClient()
-	fdl, err := panClient.RecycleList(page, 0)
-	if err != nil {
-		fmt.Println(err)
-		return
+func (rf *recycleFilter) isEmpty() bool {
+	return len(rf.Targets) == 0 && rf.Name == "" && rf.PathPrefix == ""
+}
+
+// match 文件是否符合筛选条件, 指定的文件ID或路径中任意一个相同即可
+func (rf *recycleFilter) match(f *panrecycle.RecycleFile) bool {
+	if rf.Name != "" {
+		if ok, _ := path.Match(rf.Name, f.FileName); !ok {
+			return false
+		}
+	}
+	if rf.PathPrefix != "" && rf.PathPrefix != "/" {
+		if f.Path != rf.PathPrefix && !strings.HasPrefix(f.Path, rf.PathPrefix+"/") {
+			return false
+		}
 	}
+	if len(rf.Targets) == 0 {
+		return true
+	}
+	for _, t := range rf.Targets {
+		if t == f.FileId || (strings.HasPrefix(t, "/") && path.Clean(t) == f.Path) {
+			return true
+		}
+	}
+	return false
+}
+
+// collectRecycleFiles 列出回收站中符合条件的全部文件
+func collectRecycleFiles(familyId int64, filter *recycleFilter) (panrecycle.RecycleFileList, error) {
+	rc := panrecycle.NewRecycleClient(GetActiveUser().WebToken)
+	files := panrecycle.RecycleFileList{}
+	err := rc.Walk(familyId, func(f *panrecycle.RecycleFile) bool {
+		if filter.match(f) {
+			files = append(files, f)
+		}
+		return true
+	})
+	return files, err
+}
 
+// printRecycleFiles 输出回收站文件列表
+func printRecycleFiles(files panrecycle.RecycleFileList) {
 	tb := cmdtable.NewTable(os.Stdout)
-	tb.SetHeader([]string{"#", "file_id", "文件名", "文件大小", "创建日期", "修改日期"})
+	tb.SetHeader([]string{"#", "file_id", "文件大小", "删除前的路径", "修改日期"})
 	tb.SetColumnAlignment([]int{tablewriter.ALIGN_DEFAULT, tablewriter.ALIGN_RIGHT, tablewriter.ALIGN_RIGHT, tablewriter.ALIGN_LEFT, tablewriter.ALIGN_LEFT})
-	for k, file := range fdl.FileList {
-		tb.Append([]string{strconv.Itoa(k), strconv.FormatInt(file.FileId, 10), file.FileName, converter.ConvertFileSize(file.FileSize, 2), file.CreateDate, file.LastOpTime})
+	for k, file := range files {
+		size, name := converter.ConvertFileSize(file.FileSize, 2), file.Path
+		if file.IsFolder {
+			size, name = "-", name+cloudpan.PathSeparator
+		}
+		tb.Append([]string{strconv.Itoa(k), file.FileId, size, name, file.LastOpTime})
 	}
-
 	tb.Render()
 }
 
-// RunRecycleRestore 执行还原回收站文件或目录
-func RunRecycleRestore(fidStrList ...string) {
-	panClient := GetActivePanClient()
-	pageNum := 1
-
-	restoreFileList := []*cloudpan.RecycleFileInfo{}
+// RunRecycleList 执行列出回收站文件列表, 指定筛选条件时列出全部页中符合条件的文件
+func RunRecycleList(familyId int64, page int, filter *recycleFilter) {
+	if page < 1 {
+		page = 1
+	}
 
-	fdl, err := panClient.RecycleList(pageNum, 0)
+	var (
+		files panrecycle.RecycleFileList
+		count int
+		err   error
+	)
+	if filter.isEmpty() {
+		rc := panrecycle.NewRecycleClient(GetActiveUser().WebToken)
+		files, count, err = rc.List(familyId, page)
+	} else {
+		files, err = collectRecycleFiles(familyId, filter)
+		count = len(files)
+	}
 	if err != nil {
-		fmt.Printf("还原失败, 请稍后重试")
+		fmt.Println(err)
 		return
 	}
-	isContinue := true
-	for {
-		if !isContinue || fdl.Count <= 0 {
-			break
-		}
-		pageNum += 1
-		for _, f := range fdl.FileList {
-			if isFileIdInTheRestoreList(strconv.FormatInt(f.FileId, 10), fidStrList...) {
-				restoreFileList = append(restoreFileList, f)
-				if len(restoreFileList) >= len(fidStrList) {
-					isContinue = false
-					break
-				}
-			}
-		}
-		if isContinue {
-			fdl, err = panClient.RecycleList(pageNum, 0)
-			if err != nil {
-				fmt.Printf("还原失败, 请稍后重试")
-				return
-			}
-		}
+
+	printRecycleFiles(files)
+	fmt.Printf("%s回收站, 共 %d 个文件/目录\n", GetFamilyCloudMark(familyId), count)
+}
+
+// RunRecycleRestore 执行还原回收站文件或目录
+func RunRecycleRestore(familyId int64, filter *recycleFilter) {
+	restoreFileList, err := collectRecycleFiles(familyId, filter)
+	if err != nil {
+		fmt.Printf("还原失败: %s\n", err)
+		return
 	}
 	if len(restoreFileList) == 0 {
-		fmt.Printf("没有需要还原的文件")
+		fmt.Printf("没有需要还原的文件\n")
 		return
 	}
+	printRecycleFiles(restoreFileList)
 
-	taskId, err := panClient.RecycleRestore(restoreFileList)
+	rc := panrecycle.NewRecycleClient(GetActiveUser().WebToken)
+	taskId, err := rc.Restore(familyId, restoreFileList)
 	if err != nil {
-		fmt.Printf("还原文件失败：%s", err)
+		fmt.Println(err)
 		return
 	}
-
-	if taskId != "" {
-		fmt.Printf("还原成功\n")
+	result, err := functions.WaitBatchTask(GetActivePanClient(), cloudpan.BatchTaskTypeRecycleRestore, taskId, functions.BatchTaskOptions{
+		FamilyId: familyId,
+		Timeout:  recycleRestoreTimeout,
+	})
+	if err != nil {
+		fmt.Printf("还原文件失败: %s\n", err)
+		return
+	}
+	fmt.Printf("还原完成, %s\n", result)
+	if result.Conflicts > 0 {
+		fmt.Printf("原位置已存在同名文件, 冲突的文件未还原\n")
 	}
 }
 
-func isFileIdInTheRestoreList(fileId string, fidStrList ...string) bool {
-	for _, id := range fidStrList {
-		if id == fileId {
-			return true
+// RunRecycleDelete 执行彻底删除回收站文件或目录, 按条件筛选时需要确认
+func RunRecycleDelete(familyId int64, filter *recycleFilter, yes bool) {
+	deleteFileList, err := collectRecycleFiles(familyId, filter)
+	if err != nil {
+		fmt.Printf("彻底删除文件失败: %s\n", err)
+		return
+	}
+	if len(deleteFileList) == 0 {
+		fmt.Printf("没有需要删除的文件\n")
+		return
+	}
+	printRecycleFiles(deleteFileList)
+	if filter.Name != "" || filter.PathPrefix != "" {
+		if !confirmAction(fmt.Sprintf("确认彻底删除以上 %d 个文件/目录? 删除后无法恢复", len(deleteFileList)), yes) {
+			fmt.Println("已取消操作")
+			return
 		}
 	}
-	return false
-}
 
-// RunRecycleDelete 执行删除回收站文件或目录
-func RunRecycleDelete(fidStrList ...string) {
-	panClient := GetActivePanClient()
-	idList := []string{}
-	for _, s := range fidStrList {
-		idList = append(idList, s)
-	}
-	err := panClient.RecycleDelete(0, idList)
-	if err != nil {
-		fmt.Printf("彻底删除文件失败：%s", err)
+	apierr := GetActivePanClient().RecycleDelete(familyId, deleteFileList.FileIds())
+	if apierr != nil {
+		fmt.Printf("彻底删除文件失败：%s\n", apierr)
 		return
 	}
-	fmt.Printf("彻底删除文件成功\n")
+	fmt.Printf("彻底删除文件成功, 共 %d 个\n", len(deleteFileList))
 }
 
-// RunRecycleClear 清空回收站
-func RunRecycleClear() {
-	panClient := GetActivePanClient()
-	err := panClient.RecycleClear(0)
+// RunRecycleClear 清空回收站, 需要确认
+func RunRecycleClear(familyId int64, yes bool) {
+	if !confirmAction(fmt.Sprintf("确认清空%s回收站? 删除后无法恢复", GetFamilyCloudMark(familyId)), yes) {
+		fmt.Println("已取消操作")
+		return
+	}
+	err := GetActivePanClient().RecycleClear(familyId)
 	if err != nil {
 		fmt.Println(err)
 		return
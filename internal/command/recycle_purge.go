@@ -0,0 +1,198 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package command
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/tickstep/cloudpan189-go/cmder"
+	"github.com/tickstep/cloudpan189-go/internal/config"
+	"github.com/tickstep/cloudpan189-go/internal/functions/panrecycle"
+	"github.com/tickstep/cloudpan189-go/internal/utils"
+	"github.com/tickstep/library-go/converter"
+	"github.com/urfave/cli"
+)
+
+const (
+	// recyclePurgeBatchSize 清理回收站时每次彻底删除的数量
+	recyclePurgeBatchSize = 100
+)
+
+type (
+	// recyclePurgeOptions 清理回收站的条件
+	recyclePurgeOptions struct {
+		OlderThan  time.Duration  // 删除时间早于该时间之前
+		LargerThan int64          // 文件大小超过该大小, 0 表示不限制
+		Match      *regexp.Regexp // 删除前的路径匹配该正则表达式, 为空表示不限制
+		PathPrefix string         // 删除前的路径在该文件夹中, 为空表示不限制
+	}
+)
+
+func cmdRecyclePurge() cli.Command {
+	return cli.Command{
+		Name:      "purge",
+		Usage:     "按条件清理回收站",
+		UsageText: cmder.App().Name + " recycle purge -older-than=<时间> [-larger-than=<大小>] [-match=<正则表达式>]",
+		Description: `彻底删除回收站中删除时间早于指定时间之前的文件, 释放网盘空间.
+	遍历回收站的全部页, 列出符合条件的文件和总大小, 确认后分批彻底删除.
+	-match 匹配文件删除前的完整路径, 参数值必须是正则表达式.
+
+示例:
+
+    清理 30 天前删除的文件
+	cloudpan189-go recycle purge -older-than 30d
+
+    清理 7 天前删除的大于 1GB 的 mp4 文件, 不需要确认
+	cloudpan189-go recycle purge -older-than 7d -larger-than 1GB -match "\.mp4$" -y
+`,
+		Action: func(c *cli.Context) error {
+			if !c.IsSet("older-than") {
+				cli.ShowCommandHelp(c, c.Command.Name)
+				return nil
+			}
+			if config.Config.ActiveUser() == nil {
+				fmt.Println("未登录账号")
+				return nil
+			}
+			opt, err := parseRecyclePurgeOptions(c.String("older-than"), c.String("larger-than"), c.String("match"))
+			if err != nil {
+				fmt.Println(err)
+				return nil
+			}
+			RunRecyclePurge(parseFamilyId(c), opt, c.Bool("y"))
+			return nil
+		},
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "older-than",
+				Usage: "只清理删除时间早于指定时间之前的文件, 例如 30d, 12h",
+			},
+			cli.StringFlag{
+				Name:  "larger-than",
+				Usage: "只清理大于指定大小的文件, 例如 100MB, 1GB",
+			},
+			cli.StringFlag{
+				Name:  "match",
+				Usage: "只清理删除前的路径匹配正则表达式的文件",
+			},
+			cli.BoolFlag{
+				Name:  "y",
+				Usage: "不需要确认",
+			},
+			cli.StringFlag{
+				Name:  "familyId",
+				Usage: "家庭云ID",
+				Value: "",
+			},
+		},
+	}
+}
+
+// parseRecyclePurgeOptions 解析清理回收站的条件
+func parseRecyclePurgeOptions(olderThan, largerThan, match string) (*recyclePurgeOptions, error) {
+	opt := &recyclePurgeOptions{}
+	var err error
+	if opt.OlderThan, err = parseAge(olderThan); err != nil {
+		return nil, err
+	}
+	if largerThan != "" {
+		opt.LargerThan, err = converter.ParseFileSizeStr(largerThan)
+		if err != nil || opt.LargerThan < 0 {
+			return nil, fmt.Errorf("文件大小格式错误: %s", largerThan)
+		}
+	}
+	if match != "" {
+		if opt.Match, err = regexp.Compile(match); err != nil {
+			return nil, fmt.Errorf("正则表达式错误: %s", err)
+		}
+	}
+	return opt, nil
+}
+
+// match 回收站中的文件是否符合清理条件, 无法确定删除时间的文件不清理
+func (opt *recyclePurgeOptions) match(f *panrecycle.RecycleFile, deadline time.Time) bool {
+	deleteTime, err := utils.ParseCloudTime(f.LastOpTime)
+	if err != nil || !deleteTime.Before(deadline) {
+		return false
+	}
+	if opt.LargerThan > 0 && f.FileSize <= opt.LargerThan {
+		return false
+	}
+	if opt.Match != nil && !opt.Match.MatchString(f.Path) {
+		return false
+	}
+	if opt.PathPrefix != "" && f.Path != opt.PathPrefix && !strings.HasPrefix(f.Path, strings.TrimSuffix(opt.PathPrefix, "/")+"/") {
+		return false
+	}
+	return true
+}
+
+// RunRecyclePurge 按条件清理回收站, 分批彻底删除符合条件的文件
+func RunRecyclePurge(familyId int64, opt *recyclePurgeOptions, yes bool) {
+	rc := panrecycle.NewRecycleClient(GetActiveUser().WebToken)
+	deadline := time.Now().Add(-opt.OlderThan)
+	var (
+		files     panrecycle.RecycleFileList
+		total     int
+		totalSize int64
+	)
+	err := rc.Walk(familyId, func(f *panrecycle.RecycleFile) bool {
+		total++
+		if opt.match(f, deadline) {
+			files = append(files, f)
+			totalSize += f.FileSize
+		}
+		return true
+	})
+	if err != nil {
+		fmt.Printf("清理回收站失败: %s\n", err)
+		return
+	}
+	if len(files) == 0 {
+		fmt.Printf("%s回收站共 %d 个文件/目录, 没有符合条件的文件\n", GetFamilyCloudMark(familyId), total)
+		return
+	}
+
+	printRecycleFiles(files)
+	fmt.Printf("%s回收站共 %d 个文件/目录, 符合条件的 %d 个, 共 %s\n",
+		GetFamilyCloudMark(familyId), total, len(files), converter.ConvertFileSize(totalSize, 2))
+	if !confirmAction("确认彻底删除以上文件/目录? 删除后无法恢复", yes) {
+		fmt.Println("已取消操作")
+		return
+	}
+
+	panClient := GetActivePanClient()
+	var succeed, failed int
+	var freedSize int64
+	for start := 0; start < len(files); start += recyclePurgeBatchSize {
+		end := start + recyclePurgeBatchSize
+		if end > len(files) {
+			end = len(files)
+		}
+		batch := files[start:end]
+		if apierr := panClient.RecycleDelete(familyId, batch.FileIds()); apierr != nil {
+			fmt.Printf("彻底删除文件失败: %s\n", apierr)
+			failed += len(batch)
+			continue
+		}
+		succeed += len(batch)
+		for _, f := range batch {
+			freedSize += f.FileSize
+		}
+	}
+	fmt.Printf("清理回收站完成, 成功 %d 个, 失败 %d 个, 释放空间 %s\n", succeed, failed, converter.ConvertFileSize(freedSize, 2))
+}
@@ -20,6 +20,7 @@ import (
 	"github.com/tickstep/cloudpan189-api/cloudpan/apiutil"
 	"github.com/tickstep/cloudpan189-go/cmder"
 	"github.com/tickstep/cloudpan189-go/internal/config"
+	"github.com/tickstep/cloudpan189-go/internal/functions/panjournal"
 	"github.com/urfave/cli"
 	"path"
 	"strings"
@@ -109,5 +110,9 @@ func RunRename(familyId int64, oldName string, newName string) {
 		fmt.Println("重命名文件失败")
 		return
 	}
+	entry := panjournal.NewEntry(panjournal.OpRename, familyId)
+	entry.NewName = path.Base(newName)
+	entry.AddEntity(r)
+	panjournal.Record(entry)
 	fmt.Printf("重命名文件成功：%s -> %s\n", path.Base(oldName), path.Base(newName))
 }
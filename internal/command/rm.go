@@ -19,12 +19,12 @@ import (
 	"github.com/tickstep/cloudpan189-go/cmder"
 	"github.com/tickstep/cloudpan189-go/cmder/cmdtable"
 	"github.com/tickstep/cloudpan189-go/internal/config"
-	"github.com/tickstep/library-go/logger"
+	"github.com/tickstep/cloudpan189-go/internal/functions"
+	"github.com/tickstep/cloudpan189-go/internal/functions/panjournal"
 	"github.com/urfave/cli"
 	"os"
 	"path"
 	"strconv"
-	"time"
 )
 
 func CmdRm() cli.Command {
@@ -46,6 +46,9 @@ func CmdRm() cli.Command {
 
 	删除 /我的资源 整个目录 !!
 	cloudpan189-go rm /我的资源
+
+	受保护的路径以及其上级目录需要使用 --force-protected 才能删除, 受保护的路径通过 config protect 设置.
+	删除的文件数量(包括目录中的文件)超过 --max-delete 上限时需要确认, 默认上限为 1000 个.
 `,
 		Category: "天翼云盘",
 		Before:   cmder.ReloadConfigFunc,
@@ -58,10 +61,17 @@ func CmdRm() cli.Command {
 				fmt.Println("未登录账号")
 				return nil
 			}
-			RunRemove(parseFamilyId(c), c.Args()...)
+			maxDelete, err := parseMaxDelete(c.String("max-delete"), false)
+			if err != nil {
+				fmt.Println(err)
+				return nil
+			}
+			RunRemove(parseFamilyId(c), c.Bool("force-protected"), maxDelete, c.Args()...)
 			return nil
 		},
 		Flags: []cli.Flag{
+			forceProtectedFlag,
+			maxDeleteFlag("一次删除的文件数量上限, 包括目录中的文件, 超过时需要确认, 0 表示不限制, 默认 1000 个"),
 			cli.StringFlag{
 				Name:  "familyId",
 				Usage: "家庭云ID",
@@ -71,43 +81,44 @@ func CmdRm() cli.Command {
 	}
 }
 
-// RunRemove 执行 批量删除文件/目录
-func RunRemove(familyId int64, paths ...string) {
-	if IsFamilyCloud(familyId) {
-		delFamilyCloudFiles(familyId, paths...)
-	} else {
-		delPersonCloudFiles(familyId, paths...)
+// RunRemove 执行 批量删除文件/目录, 涉及受保护的路径时拒绝删除, 删除数量超过上限时需要确认
+func RunRemove(familyId int64, forceProtected bool, maxDelete *maxDeleteLimit, paths ...string) {
+	activeUser := GetActiveUser()
+	for _, p := range paths {
+		if err := checkProtectedPaths(familyId, forceProtected, activeUser.PathJoin(familyId, p)); err != nil {
+			fmt.Println(err)
+			return
+		}
 	}
+	delPanFiles(familyId, maxDelete, paths...)
 }
 
-func delFamilyCloudFiles(familyId int64, paths ...string) {
-	activeUser := GetActiveUser()
+// delPanFiles 删除网盘文件/目录并等待删除任务完成, 家庭云使用客户端接口
+func delPanFiles(familyId int64, maxDelete *maxDeleteLimit, paths ...string) {
 	infoList, _, delFileInfos := getBatchTaskInfoList(familyId, paths...)
 	if infoList == nil || len(*infoList) == 0 {
 		fmt.Println("没有有效的文件可删除")
 		return
 	}
+	if !maxDelete.confirmDelete("删除文件/目录", countPanFiles(familyId, *delFileInfos, maxDelete.Count), 0) {
+		return
+	}
 
 	// create delete files task
 	delParam := &cloudpan.BatchTaskParam{
 		TypeFlag:  cloudpan.BatchTaskTypeDelete,
 		TaskInfos: *infoList,
 	}
-
-	taskId, err := activeUser.PanClient().AppCreateBatchTask(familyId, delParam)
-	if err != nil {
-		fmt.Println("无法删除文件，请稍后重试")
+	result, err := functions.RunBatchTask(GetActivePanClient(), delParam, functions.BatchTaskOptions{FamilyId: familyId})
+	if result == nil {
+		fmt.Printf("无法删除文件，请稍后重试: %s\n", err)
 		return
 	}
-	logger.Verboseln("delete file task id: " + taskId)
 
-	// check task
-	time.Sleep(time.Duration(200) * time.Millisecond)
-	taskRes, err := activeUser.PanClient().AppCheckBatchTask(cloudpan.BatchTaskTypeDelete, taskId)
-	if err != nil || taskRes.TaskStatus != cloudpan.BatchTaskStatusOk {
-		fmt.Println("无法删除文件，请稍后重试")
-		return
-	}
+	// 任务已经创建, 部分失败或等待超时也记录全部文件, 撤销时跳过不在回收站中的文件
+	entry := panjournal.NewBatchTaskEntry(panjournal.OpRemove, familyId, delParam, *delFileInfos)
+	entry.TaskId = result.TaskId
+	panjournal.Record(entry)
 
 	pnt := func() {
 		tb := cmdtable.NewTable(os.Stdout)
@@ -117,64 +128,18 @@ func delFamilyCloudFiles(familyId int64, paths ...string) {
 		}
 		tb.Render()
 	}
-	if taskRes.TaskStatus == cloudpan.BatchTaskStatusOk {
-		fmt.Println("操作成功, 以下文件/目录已删除, 可在云盘文件回收站找回: ")
-		pnt()
-	}
-}
-
-func delPersonCloudFiles(familyId int64, paths ...string) {
-	activeUser := GetActiveUser()
-	infoList, _, delFileInfos := getBatchTaskInfoList(familyId, paths...)
-	if infoList == nil || len(*infoList) == 0 {
-		fmt.Println("没有有效的文件可删除")
-		return
-	}
-
-	// create delete files task
-	delParam := &cloudpan.BatchTaskParam{
-		TypeFlag:  cloudpan.BatchTaskTypeDelete,
-		TaskInfos: *infoList,
-	}
-
-	taskId, err := activeUser.PanClient().CreateBatchTask(delParam)
 	if err != nil {
-		fmt.Println("无法删除文件，请稍后重试")
-		return
-	}
-	logger.Verboseln("delete file task id: " + taskId)
-
-	// check task
-	checkTime := 5
-	var taskRes *cloudpan.CheckTaskResult
-	for checkTime >= 0 {
-		checkTime--
-		time.Sleep(time.Duration(1000) * time.Millisecond)
-		taskRes, err = activeUser.PanClient().CheckBatchTask(cloudpan.BatchTaskTypeDelete, taskId)
-		if err == nil {
-			if taskRes.TaskStatus == cloudpan.BatchTaskStatusOk {
-				// success
-				break
-			}
-		}
-	}
-	if taskRes == nil || taskRes.TaskStatus != cloudpan.BatchTaskStatusOk {
-		fmt.Println("无法删除文件，请稍后重试")
+		fmt.Printf("删除文件出错: %s, 已删除的文件可使用 undo %s 撤销, 请检查以下文件/目录: \n", err, entry.Id)
+		pnt()
 		return
 	}
-
-	pnt := func() {
-		tb := cmdtable.NewTable(os.Stdout)
-		tb.SetHeader([]string{"#", "文件/目录"})
-		for k := range *delFileInfos {
-			tb.Append([]string{strconv.Itoa(k), (*delFileInfos)[k].Path})
-		}
-		tb.Render()
-	}
-	if taskRes.TaskStatus == cloudpan.BatchTaskStatusOk {
-		fmt.Println("操作成功, 以下文件/目录已删除, 可在云盘文件回收站找回: ")
+	if !result.OK() {
+		fmt.Printf("部分文件/目录删除失败, %s, 已删除的文件可使用 undo %s 撤销, 请检查以下文件/目录: \n", result, entry.Id)
 		pnt()
+		return
 	}
+	fmt.Printf("操作成功, 以下文件/目录已删除, 可在云盘文件回收站找回, 使用 undo %s 撤销: \n", entry.Id)
+	pnt()
 }
 
 func getBatchTaskInfoList(familyId int64, paths ...string) (*cloudpan.BatchTaskInfoList, *[]string, *[]*cloudpan.AppFileEntity) {
@@ -17,16 +17,24 @@ import (
 	"fmt"
 	"github.com/tickstep/cloudpan189-go/cmder"
 	"github.com/tickstep/cloudpan189-go/internal/config"
+	"github.com/tickstep/cloudpan189-go/internal/functions"
+	"github.com/tickstep/cloudpan189-go/internal/functions/panshare"
 	"github.com/tickstep/library-go/converter"
 	"github.com/urfave/cli"
 	"os"
+	"path"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/tickstep/cloudpan189-api/cloudpan"
+	"github.com/tickstep/cloudpan189-api/cloudpan/apierror"
 	"github.com/tickstep/cloudpan189-go/cmder/cmdtable"
-	"github.com/tickstep/library-go/text"
+)
+
+const (
+	// shareSaveTimeout 等待转存任务完成的最长时间
+	shareSaveTimeout = 30 * time.Minute
 )
 
 func CmdShare() cli.Command {
@@ -54,6 +62,9 @@ func CmdShare() cli.Command {
 
     创建文件 1.mp4 的分享链接，并指定有效期为1天
 	cloudpan189-go share set -time 1 1.mp4
+
+    分别分享 /交付 目录中的每个文件, 并把分享链接保存到 out.csv
+	cloudpan189-go share set -recursive-files -manifest out.csv /交付
 `,
 				Action: func(c *cli.Context) error {
 					if c.NArg() < 1 {
@@ -88,7 +99,13 @@ func CmdShare() cli.Command {
 							sm = cloudpan.ShareModePublic
 						}
 					}
-					RunShareSet(config.Config.ActiveUser().ActiveFamilyId, c.Args(), et, sm)
+					RunShareSet(config.Config.ActiveUser().ActiveFamilyId, c.Args(), &shareSetOptions{
+						ExpiredTime:    et,
+						ShareMode:      sm,
+						Manifest:       c.String("manifest"),
+						RecursiveFiles: c.Bool("recursive-files"),
+						Parallel:       c.Int("p"),
+					})
 					return nil
 				},
 				Flags: []cli.Flag{
@@ -100,6 +117,19 @@ func CmdShare() cli.Command {
 						Name:  "mode",
 						Usage: "有效期，1-私密分享，2-公开分享",
 					},
+					cli.StringFlag{
+						Name:  "manifest",
+						Usage: "保存分享清单, 包括路径、链接、访问码、过期时间和分享模式, 文件以 .json 结尾时使用 JSON 格式, 否则使用 CSV 格式",
+					},
+					cli.BoolFlag{
+						Name:  "recursive-files",
+						Usage: "分别分享目录中的每个文件, 而不是分享目录本身",
+					},
+					cli.IntFlag{
+						Name:  "p",
+						Usage: "同时创建分享的数量",
+						Value: shareSetDefaultParallel,
+					},
 				},
 			},
 			{
@@ -120,73 +150,108 @@ func CmdShare() cli.Command {
 				},
 			},
 			{
-				Name:        "cancel",
-				Aliases:     []string{"c"},
-				Usage:       "取消分享文件/目录",
-				UsageText:   cmder.App().Name + " share cancel <shareid_1> <shareid_2> ...",
-				Description: `目前只支持通过分享id (shareid) 来取消分享.`,
+				Name:      "cancel",
+				Aliases:   []string{"c"},
+				Usage:     "取消分享文件/目录",
+				UsageText: cmder.App().Name + " share cancel [-older-than=<时间>] [-public-only] [shareid_1] [shareid_2] ...",
+				Description: `通过分享id (shareid) 取消分享, 或按条件批量取消分享.
+	使用 -older-than 或 -public-only 时, 先列出符合条件的分享, 确认后再取消, 指定的分享id用于进一步限定范围.
+
+示例:
+
+    取消分享id为 12345 的分享
+	cloudpan189-go share cancel 12345
+
+    取消 30 天前创建的全部公开分享
+	cloudpan189-go share cancel -older-than 30d -public-only
+`,
 				Action: func(c *cli.Context) error {
-					if c.NArg() < 1 {
+					if c.NArg() < 1 && !c.IsSet("older-than") && !c.Bool("public-only") {
 						cli.ShowCommandHelp(c, c.Command.Name)
 						return nil
 					}
-					RunShareCancel(converter.SliceStringToInt64(c.Args()))
+					if config.Config.ActiveUser() == nil {
+						fmt.Println("未登录账号")
+						return nil
+					}
+					if !c.IsSet("older-than") && !c.Bool("public-only") {
+						RunShareCancel(converter.SliceStringToInt64(c.Args()))
+						return nil
+					}
+					filter := &shareCancelFilter{
+						ShareIds:   converter.SliceStringToInt64(c.Args()),
+						PublicOnly: c.Bool("public-only"),
+					}
+					if c.IsSet("older-than") {
+						olderThan, err := parseAge(c.String("older-than"))
+						if err != nil {
+							fmt.Println(err)
+							return nil
+						}
+						filter.OlderThan = olderThan
+					}
+					RunShareCancelFiltered(filter, c.Bool("y"))
 					return nil
 				},
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:  "older-than",
+						Usage: "只取消创建时间早于指定时间之前的分享, 例如 30d, 12h",
+					},
+					cli.BoolFlag{
+						Name:  "public-only",
+						Usage: "只取消公开分享",
+					},
+					cli.BoolFlag{
+						Name:  "y",
+						Usage: "批量取消时不需要确认",
+					},
+				},
 			},
-			//			{
-			//				Name:      "save",
-			//				Usage:     "转存分享的全部文件到指定文件夹",
-			//				UsageText: cmder.App().Name + " share save [save_dir_path] \"[share_url]\"",
-			//				Description: `转存分享的全部文件到指定文件夹
-			//示例:
-			//    将 https://cloud.189.cn/t/RzUNre7nq2Uf 分享链接里面的全部文件转存到 /我的文档 这个网盘目录里面
-			//    注意：转存需要一定的时间才能生效，需要等待一会才能完全转存到网盘文件夹里面
-			//	cloudpan189-go share save /我的文档 https://cloud.189.cn/t/RzUNre7nq2Uf（访问码：io7x）
-			//`,
-			//				Action: func(c *cli.Context) error {
-			//					if c.NArg() < 2 {
-			//						cli.ShowCommandHelp(c, c.Command.Name)
-			//						return nil
-			//					}
-			//					if config.Config.ActiveUser() == nil {
-			//						fmt.Println("未登录账号")
-			//						return nil
-			//					}
-			//					RunShareSave(c.Args().Get(1), c.Args().Get(0))
-			//					return nil
-			//				},
-			//			},
-		},
-	}
-}
+			{
+				Name:      "save",
+				Usage:     "转存分享的全部文件到指定文件夹",
+				UsageText: cmder.App().Name + " share save <网盘文件夹> <分享链接> [访问码]",
+				Description: `转存分享的全部文件到指定文件夹, 等待转存完成后列出转存的文件.
+	分享链接支持短链接、带 ?pwd= 访问码的链接和复制的整段分享文本, 访问码也可以作为最后一个参数单独指定.
+	转存到家庭云时, 先转存到个人云的临时文件夹, 再复制到家庭云的目标文件夹.
 
-// RunShareSet 执行分享
-func RunShareSet(familyId int64, paths []string, expiredTime cloudpan.ShareExpiredTime, shareMode cloudpan.ShareMode) {
-	fileList, _, err := GetAppFileInfoByPaths(familyId, paths[:len(paths)]...)
-	if err != nil {
-		fmt.Println(err)
-		return
-	}
+示例:
 
-	if shareMode == 1 {
-		for _, fi := range fileList {
-			r, err := GetActivePanClient().SharePrivate(fi.FileId, expiredTime)
-			if err != nil {
-				fmt.Printf("创建分享链接失败: %s - %s\n", fi.Path, err)
-				continue
-			}
-			fmt.Printf("路径: %s\n链接: %s（访问码：%s）\n", fi.Path, r.ShortShareUrl, r.AccessCode)
-		}
-	} else {
-		for _, fi := range fileList {
-			r, err := GetActivePanClient().SharePublic(fi.FileId, expiredTime)
-			if err != nil {
-				fmt.Printf("创建分享链接失败: %s - %s\n", fi.Path, err)
-				continue
-			}
-			fmt.Printf("路径: %s\n链接: %s\n", fi.Path, r.ShortShareUrl)
-		}
+    将 https://cloud.189.cn/t/RzUNre7nq2Uf 分享链接里面的全部文件转存到 /我的文档 这个网盘目录里面
+	cloudpan189-go share save /我的文档 "https://cloud.189.cn/t/RzUNre7nq2Uf（访问码：io7x）"
+
+    单独指定访问码
+	cloudpan189-go share save /我的文档 https://cloud.189.cn/t/RzUNre7nq2Uf io7x
+
+    转存到家庭云的 /共享资料 目录
+	cloudpan189-go share save -familyId 123456 /共享资料 https://cloud.189.cn/t/RzUNre7nq2Uf?pwd=io7x
+`,
+				Action: func(c *cli.Context) error {
+					if c.NArg() < 2 {
+						cli.ShowCommandHelp(c, c.Command.Name)
+						return nil
+					}
+					if config.Config.ActiveUser() == nil {
+						fmt.Println("未登录账号")
+						return nil
+					}
+					RunShareSave(parseFamilyId(c), c.Args().Get(0), strings.Join(c.Args()[1:], " "))
+					return nil
+				},
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:  "familyId",
+						Usage: "家庭云ID, 转存到家庭云",
+						Value: "",
+					},
+				},
+			},
+			cmdShareLs(),
+			cmdShareDownload(),
+			cmdShareExport(),
+			cmdShareAudit(),
+		},
 	}
 }
 
@@ -235,54 +300,198 @@ func RunShareCancel(shareIDs []int64) {
 	}
 }
 
-func RunShareSave(shareUrl, savePanDirPath string) {
+// parseShareLink 解析分享链接, 获取分享信息
+func parseShareLink(linkText string) (*panshare.ShareClient, *panshare.ShareInfo, error) {
+	link, err := panshare.ParseShareLink(linkText)
+	if err != nil {
+		return nil, nil, err
+	}
+	client := panshare.NewShareClient(GetActiveUser().WebToken)
+	info, err := client.GetShareInfo(link)
+	if err != nil {
+		return nil, nil, err
+	}
+	return client, info, nil
+}
+
+// RunShareSave 转存分享的全部文件到网盘文件夹, 家庭云先转存到个人云的临时文件夹再复制
+func RunShareSave(familyId int64, savePanDirPath, linkText string) {
 	activeUser := GetActiveUser()
+	panClient := activeUser.PanClient()
 
-	if shareUrl == "" || strings.Index(shareUrl, cloudpan.WEB_URL) < 0 {
-		fmt.Printf("分享链接错误\n")
+	shareClient, info, err := parseShareLink(linkText)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	files, err := shareClient.ListRoot(info)
+	if err != nil {
+		fmt.Println(err)
 		return
 	}
-	if savePanDirPath == "" {
-		fmt.Printf("指定的网盘文件夹路径有误\n")
+	if len(files) == 0 {
+		fmt.Println("分享中没有文件")
 		return
 	}
 
-	shareUrl = strings.ReplaceAll(shareUrl, "（访问码：", " ")
-	shareUrl = strings.ReplaceAll(shareUrl, "）", "")
+	savePanDirPath = path.Clean(activeUser.PathJoin(familyId, savePanDirPath))
+	saveDir, apierr := panClient.AppFileInfoByPath(familyId, savePanDirPath)
+	if apierr != nil {
+		fmt.Printf("指定的网盘文件夹路径有误: %s\n", apierr)
+		return
+	}
+	if !saveDir.IsFolder {
+		fmt.Printf("指定的网盘路径不是文件夹\n")
+		return
+	}
 
-	idxBlank := strings.Index(shareUrl, " ")
+	// 转存只能保存到个人云, 家庭云使用个人云的临时文件夹中转
+	targetFolderId := saveDir.FileId
+	var tmpDir *cloudpan.AppFileEntity
+	if IsFamilyCloud(familyId) {
+		tmpDirPath := fmt.Sprintf("/.cloudpan189-share-save-%d", time.Now().UnixNano())
+		rs, apierr := panClient.AppMkdirRecursive(0, "", "", 0, strings.Split(tmpDirPath, "/"))
+		if apierr != nil || rs.FileId == "" {
+			fmt.Printf("创建个人云临时文件夹失败: %s\n", apierr)
+			return
+		}
+		tmpDir = &cloudpan.AppFileEntity{FileId: rs.FileId, FileName: rs.FileName, ParentId: rs.ParentId, Path: tmpDirPath, IsFolder: true}
+		targetFolderId = tmpDir.FileId
+		defer func() {
+			// 删除操作会将临时文件夹移入个人云回收站
+			if err := functions.DeletePanFile(panClient, 0, tmpDir); err != nil {
+				fmt.Printf("删除个人云临时文件夹失败: %s, %s\n", tmpDirPath, err)
+				return
+			}
+			fmt.Printf("个人云临时文件夹已移入回收站: %s\n", tmpDirPath)
+		}()
+	}
 
-	if idxBlank < 0 {
-		fmt.Printf("分享链接错误\n")
+	fmt.Printf("分享: %s, 共 %d 个文件/目录, 正在转存到 %s: %s\n", info.FileName, len(files), GetFamilyCloudMark(familyId), savePanDirPath)
+	result, err := functions.RunBatchTask(panClient, &cloudpan.BatchTaskParam{
+		TypeFlag:       cloudpan.BatchTaskTypeShareSave,
+		TaskInfos:      files.SaveTaskInfos(),
+		TargetFolderId: targetFolderId,
+		ShareId:        info.ShareId,
+	}, functions.BatchTaskOptions{Timeout: shareSaveTimeout})
+	if err != nil {
+		fmt.Printf("转存出错: %s\n", err)
 		return
 	}
+	if result.Conflicts > 0 {
+		fmt.Printf("目标文件夹中已存在同名文件, 冲突的文件未转存\n")
+	}
+
+	if tmpDir != nil {
+		if err = copyShareSaveToFamily(familyId, tmpDir, saveDir); err != nil {
+			fmt.Printf("复制到家庭云失败: %s, 转存的文件可以在个人云回收站中找到\n", err)
+			return
+		}
+	}
+	fmt.Printf("转存完成, %s\n", result)
+	printSavedShareFiles(familyId, saveDir, files)
+}
 
-	accessUrl := strings.Trim(shareUrl[:idxBlank], " ")
-	accessCode := strings.Trim(shareUrl[idxBlank+1:], " ")
+// copyShareSaveToFamily 将个人云临时文件夹中转存的文件复制到家庭云的目标文件夹.
+// 复制只能到家庭云的根目录, 再移动到目标文件夹, 移动失败时删除根目录中的副本
+func copyShareSaveToFamily(familyId int64, tmpDir, saveDir *cloudpan.AppFileEntity) error {
+	panClient := GetActivePanClient()
+	saved, apierr := listPanDir(0, tmpDir.FileId)
+	if apierr != nil {
+		return apierr
+	}
+	if len(saved) == 0 {
+		return nil
+	}
+	familyRoot, apierr := panClient.AppFileInfoByPath(familyId, "/")
+	if apierr != nil {
+		return apierr
+	}
+	rootFiles, apierr := listPanDir(familyId, familyRoot.FileId)
+	if apierr != nil {
+		return apierr
+	}
+	// 根目录中原有的文件, 不是本次复制的副本
+	existed := map[string]bool{}
+	for _, f := range rootFiles {
+		existed[f.FileId] = true
+	}
 
-	if accessUrl == "" || accessCode == "" {
-		fmt.Printf("分享链接提取错误\n")
-		return
+	fileIdList := make([]string, 0, len(saved))
+	names := map[string]bool{}
+	for _, f := range saved {
+		fileIdList = append(fileIdList, f.FileId)
+		names[f.FileName] = true
+	}
+	if _, apierr = panClient.AppSaveFileToFamilyCloud(familyId, fileIdList); apierr != nil {
+		if apierr.ErrCode() == apierror.ApiCodeFileAlreadyExisted {
+			return fmt.Errorf("家庭云根目录已经存在同名的文件")
+		}
+		return apierr
+	}
+	if familyRoot.FileId == saveDir.FileId {
+		return nil
+	}
+	if rootFiles, apierr = listPanDir(familyId, familyRoot.FileId); apierr != nil {
+		return apierr
 	}
 
-	savePanDirPath = activeUser.PathJoin(0, savePanDirPath)
-	if savePanDirPath[len(savePanDirPath)-1] == '/' {
-		savePanDirPath = text.Substr(savePanDirPath, 0, len(savePanDirPath)-1)
+	failed := 0
+	for _, f := range rootFiles {
+		if !names[f.FileName] || existed[f.FileId] {
+			continue
+		}
+		if _, apierr = panClient.AppFamilyMoveFile(familyId, f.FileId, saveDir.FileId); apierr == nil {
+			continue
+		}
+		failed++
+		fmt.Printf("移动到目标文件夹失败: %s, %s\n", f.FileName, apierr)
+		f.Path = path.Join("/", f.FileName)
+		if err := functions.DeletePanFile(panClient, familyId, f); err != nil {
+			fmt.Printf("删除家庭云根目录中的副本失败, 请手动删除: %s, %s\n", f.Path, err)
+		}
 	}
-	fi, apier := activeUser.PanClient().FileInfoByPath(savePanDirPath)
-	if apier != nil {
-		fmt.Printf("指定的网盘文件夹路径有误\n")
-		return
+	if failed > 0 {
+		return fmt.Errorf("%d 个文件移动到目标文件夹失败", failed)
 	}
-	if fi == nil || !fi.IsFolder {
-		fmt.Printf("指定的网盘路径不是文件夹\n")
-		return
+	return nil
+}
+
+// listPanDir 列出网盘文件夹中的全部文件
+func listPanDir(familyId int64, fileId string) (cloudpan.AppFileList, *apierror.ApiError) {
+	param := cloudpan.NewAppFileListParam()
+	param.FamilyId = familyId
+	param.FileId = fileId
+	result, apierr := GetActivePanClient().AppGetAllFileList(param)
+	if apierr != nil {
+		return nil, apierr
 	}
+	return result.FileList, nil
+}
 
-	b, apier := activeUser.PanClient().ShareSave(accessUrl, accessCode, fi.FileId)
-	if apier != nil || !b {
-		fmt.Printf("转存出错：%s\n", apier)
+// printSavedShareFiles 列出目标文件夹中转存的文件
+func printSavedShareFiles(familyId int64, saveDir *cloudpan.AppFileEntity, files panshare.ShareFileList) {
+	names := map[string]bool{}
+	for _, f := range files {
+		names[f.FileName] = true
+	}
+	list, apierr := listPanDir(familyId, saveDir.FileId)
+	if apierr != nil {
 		return
 	}
-	fmt.Printf("转存成功\n")
+	tb := cmdtable.NewTable(os.Stdout)
+	tb.SetHeader([]string{"#", "文件大小", "修改日期", "文件(目录)"})
+	k := 0
+	for _, f := range list {
+		if !names[f.FileName] {
+			continue
+		}
+		size, name := converter.ConvertFileSize(f.FileSize, 2), f.FileName
+		if f.IsFolder {
+			size, name = "-", name+cloudpan.PathSeparator
+		}
+		tb.Append([]string{strconv.Itoa(k), size, f.LastOpTime, path.Join(saveDir.Path, name)})
+		k++
+	}
+	tb.Render()
 }
@@ -0,0 +1,289 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package command
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/tickstep/cloudpan189-api/cloudpan"
+	"github.com/tickstep/cloudpan189-go/cmder"
+	"github.com/tickstep/cloudpan189-go/cmder/cmdtable"
+	"github.com/tickstep/cloudpan189-go/internal/config"
+	"github.com/tickstep/cloudpan189-go/internal/functions/pandownload"
+	"github.com/tickstep/cloudpan189-go/internal/functions/panshare"
+	"github.com/tickstep/cloudpan189-go/internal/taskframework"
+	"github.com/tickstep/cloudpan189-go/internal/utils"
+	"github.com/tickstep/library-go/converter"
+	"github.com/urfave/cli"
+)
+
+func cmdShareLs() cli.Command {
+	return cli.Command{
+		Name:      "ls",
+		Usage:     "列出分享链接中的文件",
+		UsageText: cmder.App().Name + " share ls <分享链接> [访问码]",
+		Description: `列出分享链接中的全部文件和目录, 包括子目录中的内容, 路径为文件在分享中的路径.
+
+示例:
+
+    列出 https://cloud.189.cn/t/RzUNre7nq2Uf 分享中的文件
+	cloudpan189-go share ls "https://cloud.189.cn/t/RzUNre7nq2Uf（访问码：io7x）"
+`,
+		Action: func(c *cli.Context) error {
+			if c.NArg() < 1 {
+				cli.ShowCommandHelp(c, c.Command.Name)
+				return nil
+			}
+			if config.Config.ActiveUser() == nil {
+				fmt.Println("未登录账号")
+				return nil
+			}
+			RunShareLs(strings.Join(c.Args(), " "))
+			return nil
+		},
+	}
+}
+
+func cmdShareDownload() cli.Command {
+	return cli.Command{
+		Name:      "download",
+		Aliases:   []string{"d"},
+		Usage:     "直接下载分享链接中的文件",
+		UsageText: cmder.App().Name + " share download <分享链接> [访问码] [分享中的路径1] [分享中的路径2] ...",
+		Description: `直接下载分享链接中的文件到本地, 不需要先转存到网盘, 不占用网盘空间.
+	分享中的路径以 / 开始, 可以通过 share ls 查看, 指定目录时下载目录中的全部文件, 不指定时下载分享中的全部文件.
+	保存位置和下载参数与 download 命令相同, 分享的是目录时保存在以该目录命名的本地目录中.
+
+示例:
+
+    下载分享中的全部文件
+	cloudpan189-go share download "https://cloud.189.cn/t/RzUNre7nq2Uf（访问码：io7x）"
+
+    只下载分享中的 /视频/1.mp4 和 /文档 目录, 保存到 d:/share
+	cloudpan189-go share download -saveto d:/share https://cloud.189.cn/t/RzUNre7nq2Uf io7x /视频/1.mp4 /文档
+`,
+		Action: func(c *cli.Context) error {
+			if c.NArg() < 1 {
+				cli.ShowCommandHelp(c, c.Command.Name)
+				return nil
+			}
+			if config.Config.ActiveUser() == nil {
+				fmt.Println("未登录账号")
+				return nil
+			}
+			do, err := parseDownloadOptions(c)
+			if err != nil {
+				fmt.Println(err)
+				return nil
+			}
+			linkText, paths := splitShareDownloadArgs(c.Args())
+			RunShareDownload(linkText, paths, do)
+			return nil
+		},
+		Flags: shareDownloadFlags(),
+	}
+}
+
+// shareDownloadFlags 下载分享的参数, 去掉只对网盘文件有效的参数
+func shareDownloadFlags() []cli.Flag {
+	excluded := map[string]bool{
+		"move":          true,
+		"encrypt":       true,
+		"encrypt-names": true,
+		"key-file":      true,
+		"familyId":      true,
+	}
+	flags := []cli.Flag{}
+	for _, f := range downloadFlags() {
+		if !excluded[f.GetName()] {
+			flags = append(flags, f)
+		}
+	}
+	return flags
+}
+
+// splitShareDownloadArgs 拆分分享链接和分享中的路径, 第一个以 / 开始的参数之后都是分享中的路径
+func splitShareDownloadArgs(args []string) (linkText string, paths []string) {
+	for i, arg := range args {
+		if strings.HasPrefix(arg, "/") {
+			return strings.Join(args[:i], " "), args[i:]
+		}
+	}
+	return strings.Join(args, " "), nil
+}
+
+// RunShareLs 列出分享中的全部文件
+func RunShareLs(linkText string) {
+	shareClient, info, err := parseShareLink(linkText)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	tb := cmdtable.NewTable(os.Stdout)
+	tb.SetHeader([]string{"#", "文件大小", "修改日期", "路径"})
+	var (
+		k                   int
+		fileCount, dirCount int
+		totalSize           int64
+	)
+	err = shareClient.Walk(info, func(f *panshare.ShareFile) bool {
+		size, name := converter.ConvertFileSize(f.FileSize, 2), f.Path
+		if f.IsFolder {
+			size, name = "-", name+cloudpan.PathSeparator
+			dirCount++
+		} else {
+			fileCount++
+			totalSize += f.FileSize
+		}
+		tb.Append([]string{strconv.Itoa(k), size, f.LastOpTime, name})
+		k++
+		return true
+	})
+	fmt.Printf("分享: %s\n", info.FileName)
+	tb.Render()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Printf("文件总数: %d, 目录总数: %d, 文件总大小: %s\n", fileCount, dirCount, converter.ConvertFileSize(totalSize, 2))
+}
+
+// matchShareFiles 查找分享中的路径, 不指定路径时为分享的根
+func matchShareFiles(shareClient *panshare.ShareClient, info *panshare.ShareInfo, paths []string) (panshare.ShareFileList, error) {
+	if len(paths) == 0 {
+		return shareClient.ListRoot(info)
+	}
+
+	wanted := map[string]bool{}
+	for _, p := range paths {
+		wanted[path.Clean(p)] = true
+	}
+	if wanted["/"] {
+		return shareClient.ListRoot(info)
+	}
+	files := panshare.ShareFileList{}
+	err := shareClient.Walk(info, func(f *panshare.ShareFile) bool {
+		if wanted[f.Path] {
+			files = append(files, f)
+			delete(wanted, f.Path)
+			// 目录中的文件由下载任务处理
+			return false
+		}
+		// 只进入包含待查找路径的目录
+		for p := range wanted {
+			if strings.HasPrefix(p, f.Path+"/") {
+				return true
+			}
+		}
+		return false
+	})
+	if err != nil {
+		return nil, err
+	}
+	for p := range wanted {
+		fmt.Printf("分享中不存在该路径: %s\n", p)
+	}
+	return files, nil
+}
+
+// RunShareDownload 直接下载分享中的文件, 不转存到网盘
+func RunShareDownload(linkText string, paths []string, options *DownloadOptions) {
+	if options == nil {
+		options = &DownloadOptions{}
+	}
+	// 分享的文件不在网盘中, 不能移动或解密
+	options.IsMove, options.Decryption, options.FamilyId = false, nil, 0
+	cfg := prepareDownload(options)
+
+	shareClient, info, err := parseShareLink(linkText)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	files, err := matchShareFiles(shareClient, info, paths)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	if len(files) == 0 {
+		fmt.Println("没有需要下载的文件")
+		return
+	}
+
+	// 打开文件摘要缓存, 用于比较本地已存在的文件
+	defer openHashCache()()
+
+	fmt.Print("\n")
+	fmt.Printf("[0] 提示: 当前下载最大并发量为: %d, 下载缓存为: %d\n", options.Parallel, cfg.CacheSize)
+
+	// 分享的是目录时, 保存在以该目录命名的本地目录中
+	saveRoot := options.SaveTo
+	if saveRoot == "" {
+		saveRoot = GetActiveUser().GetSavePath("")
+	}
+	if info.IsFolder {
+		saveRoot = filepath.Join(saveRoot, info.FileName)
+	}
+
+	var (
+		executor = taskframework.TaskExecutor{
+			IsFailedDeque: true, // 统计失败的列表
+		}
+		statistic = &pandownload.DownloadStatistic{}
+		share     = &pandownload.ShareSource{
+			Client: shareClient,
+			Info:   info,
+		}
+	)
+	executor.SetParallel(cfg.MaxParallel)
+
+	for _, f := range files {
+		newCfg := *cfg
+		// 是否排除下载
+		if utils.IsExcludeFile(f.Path, &newCfg.ExcludeNames) {
+			fmt.Printf("排除文件: %s\n", f.Path)
+			continue
+		}
+		unit := pandownload.DownloadTaskUnit{
+			Cfg:                  &newCfg, // 复制一份新的cfg
+			PanClient:            GetActivePanClient(),
+			VerbosePrinter:       panCommandVerbose,
+			PrintFormat:          downloadPrintFormat(),
+			ParentTaskExecutor:   &executor,
+			DownloadStatistic:    statistic,
+			IsPrintStatus:        options.IsPrintStatus,
+			IsExecutedPermission: options.IsExecutedPermission,
+			IsOverwrite:          options.IsOverwrite,
+			OnConflict:           options.OnConflict,
+			NoCheck:              options.NoCheck,
+			IsInPlace:            options.IsInPlace,
+			NoPreserveTime:       options.NoPreserveTime,
+			Share:                share,
+			FilePanPath:          f.Path,
+			OriginSaveRootPath:   saveRoot,
+			SavePath:             filepath.Join(saveRoot, f.Path),
+		}
+		unit.SetShareFile(f)
+		taskInfo := executor.Append(&unit, options.MaxRetry)
+		fmt.Printf("[%s] 加入下载队列: %s\n", taskInfo.Id(), f.Path)
+	}
+
+	executeDownload(&executor, statistic)
+}
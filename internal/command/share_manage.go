@@ -0,0 +1,393 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package command
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tickstep/cloudpan189-api/cloudpan"
+	"github.com/tickstep/cloudpan189-api/cloudpan/apierror"
+	"github.com/tickstep/cloudpan189-go/cmder"
+	"github.com/tickstep/cloudpan189-go/cmder/cmdtable"
+	"github.com/tickstep/cloudpan189-go/internal/config"
+	"github.com/tickstep/cloudpan189-go/internal/functions/panshare"
+	"github.com/tickstep/library-go/converter"
+	"github.com/urfave/cli"
+)
+
+const (
+	// shareCancelBatchSize 批量取消分享时每次提交的数量
+	shareCancelBatchSize = 50
+
+	// shareExpirePermanent 永久有效的分享的过期时间
+	shareExpirePermanent = "永久"
+)
+
+type (
+	// shareRecord 导出的分享记录
+	shareRecord struct {
+		ShareId       int64  `json:"shareId"`
+		Url           string `json:"url"`
+		AccessCode    string `json:"accessCode"`
+		Path          string `json:"path"`
+		FileId        string `json:"fileId"`
+		IsFolder      bool   `json:"isFolder"`
+		Public        bool   `json:"public"`
+		CreateTime    string `json:"createTime"`
+		ExpireTime    string `json:"expireTime"` // 永久有效为 "永久", 获取失败为空
+		VisitCount    int    `json:"visitCount"`
+		DownloadCount int    `json:"downloadCount"`
+		CopyCount     int    `json:"copyCount"`
+
+		createTime time.Time
+		infoErr    error // 获取分享信息的错误, 分享可能已失效
+	}
+
+	// shareCancelFilter 批量取消分享的筛选条件
+	shareCancelFilter struct {
+		ShareIds   []int64       // 只处理指定的分享, 为空表示全部
+		OlderThan  time.Duration // 只处理创建时间早于该时间之前的分享
+		PublicOnly bool          // 只处理公开分享
+	}
+)
+
+func cmdShareExport() cli.Command {
+	return cli.Command{
+		Name:      "export",
+		Usage:     "导出全部分享记录",
+		UsageText: cmder.App().Name + " share export [-format=csv|json] [-out=<文件>]",
+		Description: `导出全部分享记录, 包括分享链接、访问码、文件路径、文件ID、创建时间、过期时间和访问/下载/转存次数.
+	默认以 CSV 格式输出到标准输出, -out 指定的文件以 .json 结尾时使用 JSON 格式.
+
+示例:
+
+    导出到 shares.csv
+	cloudpan189-go share export -out shares.csv
+
+    以 JSON 格式导出
+	cloudpan189-go share export -format json -out shares.json
+`,
+		Action: func(c *cli.Context) error {
+			if config.Config.ActiveUser() == nil {
+				fmt.Println("未登录账号")
+				return nil
+			}
+			format := strings.ToLower(c.String("format"))
+			if format == "" {
+				format = "csv"
+				if strings.EqualFold(filepath.Ext(c.String("out")), ".json") {
+					format = "json"
+				}
+			}
+			if format != "csv" && format != "json" {
+				fmt.Printf("不支持的导出格式: %s\n", format)
+				return nil
+			}
+			RunShareExport(format, c.String("out"))
+			return nil
+		},
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "format",
+				Usage: "导出格式: csv, json",
+			},
+			cli.StringFlag{
+				Name:  "out",
+				Usage: "导出到文件, 默认输出到标准输出",
+			},
+		},
+	}
+}
+
+func cmdShareAudit() cli.Command {
+	return cli.Command{
+		Name:      "audit",
+		Usage:     "检查有风险的分享",
+		UsageText: cmder.App().Name + " share audit",
+		Description: `检查全部分享, 列出以下有风险或无效的分享:
+	永久有效的公开分享, 任何人都可以一直访问;
+	分享的文件已被删除;
+	分享已失效或无法访问.
+	可以使用 share cancel 取消列出的分享.`,
+		Action: func(c *cli.Context) error {
+			if config.Config.ActiveUser() == nil {
+				fmt.Println("未登录账号")
+				return nil
+			}
+			RunShareAudit()
+			return nil
+		},
+	}
+}
+
+// listAllShares 获取全部分享, 自动翻页
+func listAllShares() (cloudpan.ShareItemList, error) {
+	panClient := GetActivePanClient()
+	param := cloudpan.NewShareListParam()
+	list := cloudpan.ShareItemList{}
+	for {
+		result, apierr := panClient.ShareList(param)
+		if apierr != nil {
+			return nil, apierr
+		}
+		list = append(list, result.Data...)
+		if len(result.Data) == 0 || len(list) >= result.RecordCount {
+			break
+		}
+		param.PageNum++
+	}
+	return list, nil
+}
+
+// collectShareRecords 获取全部分享记录, withExpire 为 true 时逐个获取分享的有效期
+func collectShareRecords(withExpire bool) ([]*shareRecord, error) {
+	items, err := listAllShares()
+	if err != nil {
+		return nil, fmt.Errorf("获取分享列表失败: %s", err)
+	}
+
+	var shareClient *panshare.ShareClient
+	if withExpire {
+		shareClient = panshare.NewShareClient(GetActiveUser().WebToken)
+	}
+	records := make([]*shareRecord, 0, len(items))
+	for _, item := range items {
+		r := &shareRecord{
+			ShareId:       item.ShareId,
+			Url:           item.ShortShareUrl,
+			AccessCode:    item.AccessCode,
+			Path:          item.FilePath,
+			FileId:        item.FileId,
+			IsFolder:      item.IsFolder,
+			Public:        item.ShareMode == cloudpan.ShareModePublic,
+			VisitCount:    item.AccessCount.PreviewCount,
+			DownloadCount: item.AccessCount.DownloadCount,
+			CopyCount:     item.AccessCount.CopyCount,
+			createTime:    time.Unix(item.ShareTime/1000, 0),
+		}
+		if r.Url == "" || r.Url == "https:" {
+			r.Url = item.AccessURL
+		}
+		if path.Base(r.Path) != item.FileName {
+			r.Path = path.Join(r.Path, item.FileName)
+		}
+		r.CreateTime = r.createTime.Format("2006-01-02 15:04:05")
+		if withExpire {
+			r.fillExpire(shareClient)
+		}
+		records = append(records, r)
+	}
+	return records, nil
+}
+
+// fillExpire 获取分享的有效期
+func (r *shareRecord) fillExpire(shareClient *panshare.ShareClient) {
+	link, err := panshare.ParseShareLink(r.Url)
+	if err != nil {
+		r.infoErr = err
+		return
+	}
+	link.AccessCode = r.AccessCode
+	info, err := shareClient.GetShareInfo(link)
+	if err != nil {
+		r.infoErr = err
+		return
+	}
+	if info.Permanent() {
+		r.ExpireTime = shareExpirePermanent
+	} else {
+		r.ExpireTime = info.ExpireAt(r.createTime).Format("2006-01-02 15:04:05")
+	}
+}
+
+// RunShareExport 导出全部分享记录
+func RunShareExport(format, outPath string) {
+	records, err := collectShareRecords(true)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	var w io.Writer = os.Stdout
+	if outPath != "" {
+		f, err := os.Create(outPath)
+		if err != nil {
+			fmt.Printf("创建导出文件失败: %s\n", err)
+			return
+		}
+		defer f.Close()
+		w = f
+	}
+
+	if format == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(records)
+	} else {
+		err = writeShareRecordsCsv(w, records)
+	}
+	if err != nil {
+		fmt.Printf("导出分享记录失败: %s\n", err)
+		return
+	}
+	if outPath != "" {
+		fmt.Printf("导出分享记录成功, 共 %d 个, 保存到: %s\n", len(records), outPath)
+	}
+}
+
+// writeShareRecordsCsv 以 CSV 格式写入分享记录
+func writeShareRecordsCsv(w io.Writer, records []*shareRecord) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"share_id", "url", "access_code", "path", "file_id", "is_folder", "public",
+		"create_time", "expire_time", "visit_count", "download_count", "copy_count"})
+	for _, r := range records {
+		cw.Write([]string{
+			strconv.FormatInt(r.ShareId, 10),
+			r.Url,
+			r.AccessCode,
+			r.Path,
+			r.FileId,
+			strconv.FormatBool(r.IsFolder),
+			strconv.FormatBool(r.Public),
+			r.CreateTime,
+			r.ExpireTime,
+			strconv.Itoa(r.VisitCount),
+			strconv.Itoa(r.DownloadCount),
+			strconv.Itoa(r.CopyCount),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// isShareFileDeleted 分享的文件是否已被删除
+func isShareFileDeleted(panClient *cloudpan.PanClient, fileId string) bool {
+	_, apierr := panClient.AppGetBasicFileInfo(&cloudpan.AppGetFileInfoParam{FileId: fileId})
+	return apierr != nil && apierr.ErrCode() == apierror.ApiCodeFileNotFoundCode
+}
+
+// RunShareAudit 检查有风险或无效的分享
+func RunShareAudit() {
+	records, err := collectShareRecords(true)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	panClient := GetActivePanClient()
+	tb := cmdtable.NewTable(os.Stdout)
+	tb.SetHeader([]string{"#", "SHARE_ID", "分享链接", "路径", "分享时间", "问题"})
+	var permanentPublic, deleted, invalid int
+	k := 0
+	for _, r := range records {
+		problems := []string{}
+		if r.Public && r.ExpireTime == shareExpirePermanent {
+			problems = append(problems, "永久有效的公开分享")
+			permanentPublic++
+		}
+		if isShareFileDeleted(panClient, r.FileId) {
+			problems = append(problems, "分享的文件已删除")
+			deleted++
+		} else if r.infoErr != nil {
+			problems = append(problems, "分享已失效或无法访问: "+r.infoErr.Error())
+			invalid++
+		}
+		if len(problems) == 0 {
+			continue
+		}
+		tb.Append([]string{strconv.Itoa(k), strconv.FormatInt(r.ShareId, 10), r.Url, r.Path, r.CreateTime, strings.Join(problems, ", ")})
+		k++
+	}
+	if k == 0 {
+		fmt.Printf("检查了 %d 个分享, 没有发现问题\n", len(records))
+		return
+	}
+	tb.Render()
+	fmt.Printf("检查了 %d 个分享, 永久有效的公开分享 %d 个, 文件已删除 %d 个, 已失效 %d 个\n",
+		len(records), permanentPublic, deleted, invalid)
+}
+
+// RunShareCancelFiltered 按条件批量取消分享, 取消前列出要取消的分享并确认
+func RunShareCancelFiltered(filter *shareCancelFilter, yes bool) {
+	records, err := collectShareRecords(false)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	wanted := map[int64]bool{}
+	for _, id := range filter.ShareIds {
+		wanted[id] = true
+	}
+	deadline := time.Now().Add(-filter.OlderThan)
+	matched := []*shareRecord{}
+	for _, r := range records {
+		if len(wanted) > 0 && !wanted[r.ShareId] {
+			continue
+		}
+		if filter.OlderThan > 0 && !r.createTime.Before(deadline) {
+			continue
+		}
+		if filter.PublicOnly && !r.Public {
+			continue
+		}
+		matched = append(matched, r)
+	}
+	if len(matched) == 0 {
+		fmt.Println("没有符合条件的分享")
+		return
+	}
+
+	tb := cmdtable.NewTable(os.Stdout)
+	tb.SetHeader([]string{"#", "SHARE_ID", "分享链接", "路径", "分享时间", "访问/下载次数"})
+	for k, r := range matched {
+		tb.Append([]string{strconv.Itoa(k), strconv.FormatInt(r.ShareId, 10), r.Url, r.Path, r.CreateTime,
+			fmt.Sprintf("%d/%d", r.VisitCount, r.DownloadCount)})
+	}
+	tb.Render()
+	if !confirmAction(fmt.Sprintf("确认取消以上 %d 个分享?", len(matched)), yes) {
+		fmt.Println("已取消操作")
+		return
+	}
+
+	panClient := GetActivePanClient()
+	var succeed, failed int
+	for start := 0; start < len(matched); start += shareCancelBatchSize {
+		end := start + shareCancelBatchSize
+		if end > len(matched) {
+			end = len(matched)
+		}
+		ids := make([]int64, 0, end-start)
+		for _, r := range matched[start:end] {
+			ids = append(ids, r.ShareId)
+		}
+		ok, apierr := panClient.ShareCancel(ids)
+		if apierr != nil || !ok {
+			fmt.Printf("取消分享失败: %s\n", strings.Join(converter.SliceInt64ToString(ids), ", "))
+			failed += len(ids)
+			continue
+		}
+		succeed += len(ids)
+	}
+	fmt.Printf("取消分享完成, 成功 %d 个, 失败 %d 个\n", succeed, failed)
+}
@@ -0,0 +1,216 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package command
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tickstep/cloudpan189-api/cloudpan"
+	"github.com/tickstep/cloudpan189-api/cloudpan/apierror"
+	"github.com/tickstep/cloudpan189-go/internal/waitgroup"
+)
+
+const (
+	// shareSetDefaultParallel 批量创建分享的默认并发数
+	shareSetDefaultParallel = 4
+	// shareSetMaxParallel 批量创建分享的最大并发数
+	shareSetMaxParallel = 10
+	// shareSetInterval 两次创建分享请求的最小间隔, 避免触发接口限流
+	shareSetInterval = 300 * time.Millisecond
+)
+
+type (
+	// shareSetOptions 创建分享的参数
+	shareSetOptions struct {
+		ExpiredTime    cloudpan.ShareExpiredTime
+		ShareMode      cloudpan.ShareMode
+		Manifest       string // 分享清单的保存路径, 为空表示不保存
+		RecursiveFiles bool   // 分别分享目录中的每个文件
+		Parallel       int
+	}
+
+	// shareSetResult 创建分享的结果, 即分享清单中的一项
+	shareSetResult struct {
+		Path       string `json:"path"`
+		Url        string `json:"url"`
+		AccessCode string `json:"accessCode"`
+		ExpireTime string `json:"expireTime"`
+		Mode       string `json:"mode"`
+		Error      string `json:"error,omitempty"`
+	}
+)
+
+// shareModeName 分享模式的名称
+func shareModeName(sm cloudpan.ShareMode) string {
+	if sm == cloudpan.ShareModePrivate {
+		return "private"
+	}
+	return "public"
+}
+
+// shareExpireTime 从现在开始计算的分享过期时间
+func shareExpireTime(et cloudpan.ShareExpiredTime) string {
+	if et == cloudpan.ShareExpiredTimeForever {
+		return shareExpirePermanent
+	}
+	return time.Now().AddDate(0, 0, int(et)).Format("2006-01-02 15:04:05")
+}
+
+// collectShareSetFiles 展开要分享的文件, recursiveFiles 为 true 时以目录中的全部文件代替目录
+func collectShareSetFiles(familyId int64, fileList []*cloudpan.AppFileEntity, recursiveFiles bool) []*cloudpan.AppFileEntity {
+	if !recursiveFiles {
+		return fileList
+	}
+	panClient := GetActivePanClient()
+	files := make([]*cloudpan.AppFileEntity, 0, len(fileList))
+	for _, fi := range fileList {
+		if !fi.IsFolder {
+			files = append(files, fi)
+			continue
+		}
+		panClient.AppFilesDirectoriesRecurseList(familyId, fi.Path, func(depth int, _ string, fd *cloudpan.AppFileEntity, apiError *apierror.ApiError) bool {
+			if apiError != nil {
+				fmt.Printf("获取目录中的文件失败: %s, %s\n", fi.Path, apiError)
+				return true
+			}
+			if !fd.IsFolder {
+				files = append(files, fd)
+			}
+			return true
+		})
+	}
+	return files
+}
+
+// createShare 创建一个分享
+func createShare(panClient *cloudpan.PanClient, fi *cloudpan.AppFileEntity, opt *shareSetOptions) *shareSetResult {
+	result := &shareSetResult{
+		Path: fi.Path,
+		Mode: shareModeName(opt.ShareMode),
+	}
+	if opt.ShareMode == cloudpan.ShareModePrivate {
+		r, apierr := panClient.SharePrivate(fi.FileId, opt.ExpiredTime)
+		if apierr != nil {
+			result.Error = apierr.Error()
+			return result
+		}
+		result.Url, result.AccessCode = r.ShortShareUrl, r.AccessCode
+	} else {
+		r, apierr := panClient.SharePublic(fi.FileId, opt.ExpiredTime)
+		if apierr != nil {
+			result.Error = apierr.Error()
+			return result
+		}
+		result.Url = r.ShortShareUrl
+	}
+	result.ExpireTime = shareExpireTime(opt.ExpiredTime)
+	return result
+}
+
+// writeShareManifest 保存分享清单, 文件以 .json 结尾时使用 JSON 格式, 否则使用 CSV 格式
+func writeShareManifest(manifestPath string, results []*shareSetResult) error {
+	f, err := os.Create(manifestPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if strings.EqualFold(filepath.Ext(manifestPath), ".json") {
+		enc := json.NewEncoder(f)
+		enc.SetIndent("", "  ")
+		return enc.Encode(results)
+	}
+
+	cw := csv.NewWriter(f)
+	cw.Write([]string{"path", "url", "access_code", "expire_time", "mode", "error"})
+	for _, r := range results {
+		cw.Write([]string{r.Path, r.Url, r.AccessCode, r.ExpireTime, r.Mode, r.Error})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// RunShareSet 执行分享, 并发创建分享, 两次请求之间保持最小间隔
+func RunShareSet(familyId int64, paths []string, opt *shareSetOptions) {
+	fileList, _, err := GetAppFileInfoByPaths(familyId, paths...)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	files := collectShareSetFiles(familyId, fileList, opt.RecursiveFiles)
+	if len(files) == 0 {
+		fmt.Println("没有需要分享的文件")
+		return
+	}
+
+	if opt.Parallel < 1 {
+		opt.Parallel = shareSetDefaultParallel
+	}
+	if opt.Parallel > shareSetMaxParallel {
+		opt.Parallel = shareSetMaxParallel
+	}
+
+	var (
+		panClient = GetActivePanClient()
+		results   = make([]*shareSetResult, len(files))
+		wg        = waitgroup.NewWaitGroup(opt.Parallel)
+		throttle  = time.NewTicker(shareSetInterval)
+		printMu   sync.Mutex
+	)
+	defer throttle.Stop()
+	for k := range files {
+		wg.AddDelta()
+		go func(k int) {
+			defer wg.Done()
+			<-throttle.C
+			r := createShare(panClient, files[k], opt)
+			results[k] = r
+
+			printMu.Lock()
+			defer printMu.Unlock()
+			if r.Error != "" {
+				fmt.Printf("创建分享链接失败: %s - %s\n", r.Path, r.Error)
+			} else if r.AccessCode != "" {
+				fmt.Printf("路径: %s\n链接: %s（访问码：%s）\n", r.Path, r.Url, r.AccessCode)
+			} else {
+				fmt.Printf("路径: %s\n链接: %s\n", r.Path, r.Url)
+			}
+		}(k)
+	}
+	wg.Wait()
+
+	failed := 0
+	for _, r := range results {
+		if r.Error != "" {
+			failed++
+		}
+	}
+	if len(results) > 1 {
+		fmt.Printf("创建分享完成, 成功 %d 个, 失败 %d 个\n", len(results)-failed, failed)
+	}
+	if opt.Manifest != "" {
+		if err := writeShareManifest(opt.Manifest, results); err != nil {
+			fmt.Printf("保存分享清单失败: %s\n", err)
+			return
+		}
+		fmt.Printf("分享清单已保存到: %s\n", opt.Manifest)
+	}
+}
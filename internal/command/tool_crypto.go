@@ -0,0 +1,343 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package command
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/tickstep/cloudpan189-go/cmder"
+	"github.com/tickstep/cloudpan189-go/internal/config"
+	"github.com/tickstep/cloudpan189-go/library/crypto"
+	"github.com/urfave/cli"
+)
+
+const (
+	cryptoDescription = `
+	加密方法 <method>:
+		aes-256-gcm (默认), chacha20-poly1305.
+		文件按 64KB 分块加密并校验, 文件头部记录加密方法、密钥派生参数和盐,
+		解密时自动识别, 密码错误或文件被修改时解密失败, 不会输出错误的内容.
+
+	密码:
+		依次从 -key-file 密钥文件、-key 参数、环境变量 ` + config.EnvEncryptPassword + ` 读取, 都未指定时交互输入.
+		密码使用 -kdf 指定的 scrypt (默认) 或 argon2id 派生密钥, 使用密钥文件时不需要派生.
+
+	输出文件:
+		加密后的文件名为原文件名加上 .encrypt 后缀, 解密时去掉该后缀, 默认保存在源文件所在目录,
+		可以通过 -out 指定保存目录. 目标文件已存在时跳过, 使用 -ow 覆盖.
+		源文件不会被修改, 使用 -rm 在成功后删除源文件.
+
+	旧版本的加密方法:
+		aes-128-ctr, aes-192-ctr, aes-256-ctr,
+		aes-128-cfb, aes-192-cfb, aes-256-cfb,
+		aes-128-ofb, aes-192-ofb, aes-256-ofb.
+		没有完整性校验, 只用于兼容旧版本加密的文件, 必须使用 -key 指定密钥, 可通过 -disable-gzip 不启用GZIP.`
+)
+
+type (
+	// cryptoTarget 需要加密或解密的文件
+	cryptoTarget struct {
+		src string
+		dst string
+	}
+
+	// cryptoOptions 加密解密的参数
+	cryptoOptions struct {
+		IsEncrypt    bool
+		IsRecursive  bool
+		OutDir       string
+		IsOverwrite  bool
+		RemoveSource bool
+
+		// 新版本的加密
+		Keychain  *crypto.Keychain
+		Method    crypto.Method
+		KDFParams crypto.KDFParams
+
+		// 旧版本的加密方法
+		LegacyMethod string
+		LegacyKey    []byte
+		IsGzip       bool
+	}
+)
+
+func cmdToolCryptoFlags(isEncrypt bool) []cli.Flag {
+	methodFlag := cli.StringFlag{
+		Name:  "method",
+		Usage: "加密方法: aes-256-gcm, chacha20-poly1305, 或旧版本的方法",
+		Value: "aes-256-gcm",
+	}
+	if !isEncrypt {
+		methodFlag = cli.StringFlag{
+			Name:  "method",
+			Usage: "解密旧版本加密的文件时指定加密方法, 新版本加密的文件自动识别",
+		}
+	}
+	flags := []cli.Flag{
+		methodFlag,
+		cli.StringFlag{
+			Name:  "key",
+			Usage: "加密密码",
+		},
+		cli.StringFlag{
+			Name:  "key-file",
+			Usage: "使用密钥文件代替密码",
+		},
+		cli.BoolFlag{
+			Name:  "r",
+			Usage: "处理目录中的所有文件",
+		},
+		cli.StringFlag{
+			Name:  "out",
+			Usage: "输出文件的保存目录, 默认保存在源文件所在目录",
+		},
+		cli.BoolFlag{
+			Name:  "ow",
+			Usage: "覆盖已存在的目标文件",
+		},
+		cli.BoolFlag{
+			Name:  "rm",
+			Usage: "成功后删除源文件",
+		},
+		cli.BoolFlag{
+			Name:  "disable-gzip",
+			Usage: "旧版本的加密方法不启用GZIP",
+		},
+	}
+	if isEncrypt {
+		flags = append(flags, cli.StringFlag{
+			Name:  "kdf",
+			Usage: "密码的密钥派生函数: scrypt, argon2id",
+			Value: "scrypt",
+		})
+	}
+	return flags
+}
+
+func cmdToolEnc() cli.Command {
+	return cli.Command{
+		Name:        "enc",
+		Usage:       "加密文件",
+		UsageText:   cmder.App().Name + " tool enc [-method=<method>] [-key-file=<file>] [-r] <文件或目录1> <文件或目录2> ...",
+		Description: cryptoDescription,
+		Action: func(c *cli.Context) error {
+			if c.NArg() <= 0 {
+				cli.ShowCommandHelp(c, c.Command.Name)
+				return nil
+			}
+			opt, err := parseCryptoOptions(c, true)
+			if err != nil {
+				fmt.Println(err)
+				return nil
+			}
+			RunCryptoFiles(c.Args(), opt)
+			return nil
+		},
+		Flags: cmdToolCryptoFlags(true),
+	}
+}
+
+func cmdToolDec() cli.Command {
+	return cli.Command{
+		Name:        "dec",
+		Usage:       "解密文件",
+		UsageText:   cmder.App().Name + " tool dec [-key-file=<file>] [-r] <文件或目录1> <文件或目录2> ...",
+		Description: cryptoDescription,
+		Action: func(c *cli.Context) error {
+			if c.NArg() <= 0 {
+				cli.ShowCommandHelp(c, c.Command.Name)
+				return nil
+			}
+			opt, err := parseCryptoOptions(c, false)
+			if err != nil {
+				fmt.Println(err)
+				return nil
+			}
+			RunCryptoFiles(c.Args(), opt)
+			return nil
+		},
+		Flags: cmdToolCryptoFlags(false),
+	}
+}
+
+// parseCryptoOptions 解析加密解密的参数, 新版本的加密在处理文件前获取密码并派生密钥
+func parseCryptoOptions(c *cli.Context, isEncrypt bool) (*cryptoOptions, error) {
+	opt := &cryptoOptions{
+		IsEncrypt:    isEncrypt,
+		IsRecursive:  c.Bool("r"),
+		OutDir:       c.String("out"),
+		IsOverwrite:  c.Bool("ow"),
+		RemoveSource: c.Bool("rm"),
+		IsGzip:       !c.Bool("disable-gzip"),
+	}
+
+	method := strings.ToLower(c.String("method"))
+	if crypto.CryptoMethodSupport(method) {
+		opt.LegacyMethod = method
+		opt.LegacyKey = []byte(c.String("key"))
+		if len(opt.LegacyKey) == 0 {
+			if isEncrypt {
+				return nil, errors.New("旧版本的加密方法必须使用 -key 指定密钥")
+			}
+			// 旧版本的默认密钥
+			opt.LegacyKey = []byte(cmder.App().Name)
+		}
+		return opt, nil
+	}
+
+	var err error
+	if isEncrypt {
+		if opt.Method, err = crypto.ParseMethod(method); err != nil {
+			return nil, err
+		}
+	} else if method != "" {
+		return nil, fmt.Errorf("不支持的加密方法: %s, 新版本加密的文件不需要指定加密方法", method)
+	}
+
+	if opt.Keychain, err = newKeychain(c, isEncrypt); err != nil {
+		return nil, err
+	}
+	if !isEncrypt {
+		return opt, nil
+	}
+
+	// 同一次加密的文件使用相同的密钥派生参数, 只需要派生一次密钥
+	kdf := crypto.KDFNone
+	if !opt.Keychain.IsKeyFile() {
+		if kdf, err = crypto.ParseKDF(c.String("kdf")); err != nil {
+			return nil, err
+		}
+	}
+	if opt.KDFParams, err = crypto.NewKDFParams(kdf, nil); err != nil {
+		return nil, err
+	}
+	if _, err = opt.Keychain.MasterKey(opt.KDFParams); err != nil {
+		return nil, err
+	}
+	return opt, nil
+}
+
+// cryptoOutputName 输出文件的文件名
+func cryptoOutputName(name string, isEncrypt bool) string {
+	if isEncrypt {
+		return name + crypto.EncryptedFileSuffix
+	}
+	if strings.HasSuffix(name, crypto.EncryptedFileSuffix) && name != crypto.EncryptedFileSuffix {
+		return strings.TrimSuffix(name, crypto.EncryptedFileSuffix)
+	}
+	return name + ".decrypt"
+}
+
+// collectCryptoTargets 列出需要处理的文件, 目录中加密时跳过已加密的文件, 解密时只处理加密后缀的文件
+func collectCryptoTargets(paths []string, opt *cryptoOptions) []*cryptoTarget {
+	targets := make([]*cryptoTarget, 0, len(paths))
+	for _, p := range paths {
+		info, err := os.Stat(p)
+		if err != nil {
+			fmt.Printf("%s\n", err)
+			continue
+		}
+		if !info.IsDir() {
+			dstDir := filepath.Dir(p)
+			if opt.OutDir != "" {
+				dstDir = opt.OutDir
+			}
+			targets = append(targets, &cryptoTarget{
+				src: p,
+				dst: filepath.Join(dstDir, cryptoOutputName(info.Name(), opt.IsEncrypt)),
+			})
+			continue
+		}
+		if !opt.IsRecursive {
+			fmt.Printf("%s 是目录, 使用 -r 处理目录中的所有文件\n", p)
+			continue
+		}
+
+		root := filepath.Clean(p)
+		filepath.Walk(root, func(file string, fi os.FileInfo, err error) error {
+			if err != nil {
+				fmt.Printf("%s\n", err)
+				return nil
+			}
+			if !fi.Mode().IsRegular() || strings.HasSuffix(fi.Name(), ".cloudpan189-tmp") {
+				return nil
+			}
+			if strings.HasSuffix(fi.Name(), crypto.EncryptedFileSuffix) == opt.IsEncrypt {
+				return nil
+			}
+			dstDir := filepath.Dir(file)
+			if opt.OutDir != "" {
+				// 在保存目录中保持原来的目录结构
+				rel, _ := filepath.Rel(root, dstDir)
+				dstDir = filepath.Join(opt.OutDir, filepath.Base(root), rel)
+			}
+			targets = append(targets, &cryptoTarget{
+				src: file,
+				dst: filepath.Join(dstDir, cryptoOutputName(fi.Name(), opt.IsEncrypt)),
+			})
+			return nil
+		})
+	}
+	return targets
+}
+
+// RunCryptoFiles 加密或解密文件, 输出到新的文件
+func RunCryptoFiles(paths []string, opt *cryptoOptions) {
+	action := "解密"
+	if opt.IsEncrypt {
+		action = "加密"
+	}
+
+	var succeed, failed, skipped int
+	for _, t := range collectCryptoTargets(paths, opt) {
+		if _, err := os.Stat(t.dst); err == nil && !opt.IsOverwrite {
+			fmt.Printf("目标文件已存在, 跳过: %s, 使用 -ow 覆盖\n", t.dst)
+			skipped++
+			continue
+		}
+
+		var err error
+		switch {
+		case opt.LegacyMethod != "" && opt.IsEncrypt:
+			err = crypto.LegacyEncryptFile(opt.LegacyMethod, opt.LegacyKey, t.src, t.dst, opt.IsGzip)
+		case opt.LegacyMethod != "":
+			err = crypto.LegacyDecryptFile(opt.LegacyMethod, opt.LegacyKey, t.src, t.dst, opt.IsGzip)
+		case opt.IsEncrypt:
+			err = crypto.EncryptFile(opt.Keychain, opt.Method, opt.KDFParams, t.src, t.dst)
+		default:
+			err = crypto.DecryptFile(opt.Keychain, t.src, t.dst)
+			if err == crypto.ErrNotEncrypted {
+				err = errors.New("不是加密文件, 旧版本加密的文件请使用 -method 指定加密方法")
+			}
+		}
+		if err != nil {
+			fmt.Printf("%s失败, %s: %s\n", action, t.src, err)
+			failed++
+			continue
+		}
+		fmt.Printf("%s成功, %s -> %s\n", action, t.src, t.dst)
+		succeed++
+
+		if opt.RemoveSource {
+			if err = os.Remove(t.src); err != nil {
+				fmt.Printf("删除源文件失败, %s: %s\n", t.src, err)
+			}
+		}
+	}
+	fmt.Printf("%s完成, 成功 %d 个, 失败 %d 个, 跳过 %d 个\n", action, succeed, failed, skipped)
+}
@@ -36,6 +36,7 @@ import (
 	"github.com/tickstep/cloudpan189-api/cloudpan/apierror"
 	"github.com/tickstep/cloudpan189-go/cmder/cmdtable"
 	"github.com/tickstep/cloudpan189-go/internal/config"
+	"github.com/tickstep/cloudpan189-go/internal/functions"
 	"github.com/tickstep/cloudpan189-go/internal/functions/panupload"
 	"github.com/tickstep/cloudpan189-go/internal/localfile"
 	"github.com/tickstep/cloudpan189-go/internal/taskframework"
@@ -52,15 +53,23 @@ const (
 type (
 	// UploadOptions 上传可选项
 	UploadOptions struct {
-		AllParallel   int // 所有文件并发上传数量，即可以同时并发上传多少个文件
-		Parallel      int // 单个文件并发上传数量
-		MaxRetry      int
-		NoRapidUpload bool
-		NoSplitFile   bool // 禁用分片上传
-		ShowProgress  bool
-		IsOverwrite   bool // 覆盖已存在的文件，如果同名文件已存在则移到回收站里
-		FamilyId      int64
-		ExcludeNames  []string // 排除的文件名，包括文件夹和文件。即这些文件/文件夹不进行上传，支持正则表达式
+		AllParallel    int // 所有文件并发上传数量，即可以同时并发上传多少个文件
+		Parallel       int // 单个文件并发上传数量
+		HashParallel   int // 上传前计算文件MD5的并发数量
+		MaxRetry       int
+		NoRapidUpload  bool
+		NoSplitFile    bool // 禁用分片上传
+		ShowProgress   bool
+		OnConflict     panupload.ConflictPolicy // 网盘已存在同名文件时的处理策略
+		ForceProtected bool                     // 允许覆盖受保护的路径
+		IsMove         bool                     // 上传并确认网盘文件一致后删除本地文件
+		IsVerify       bool                     // 上传完成后校验网盘文件的MD5和大小
+		Symlinks       SymlinkPolicy            // 符号链接的处理方式
+		SplitSize      int64                    // 超过该大小的文件分片上传, 0 表示不分片
+		PackSmallSize  int64                    // 小于该大小的文件按目录打包上传, 0 表示不打包
+		Encryption     *UploadEncryption        // 加密网盘模式, 为空表示不加密
+		FamilyId       int64
+		ExcludeNames   []string // 排除的文件名，包括文件夹和文件。即这些文件/文件夹不进行上传，支持正则表达式
 	}
 )
 
@@ -81,12 +90,51 @@ var UploadFlags = []cli.Flag{
 	},
 	cli.BoolFlag{
 		Name:  "ow",
-		Usage: "overwrite, 覆盖已存在的同名文件，注意已存在的文件会被移到回收站",
+		Usage: "overwrite, 覆盖已存在的同名文件，注意已存在的文件会被移到回收站，等同于 -on-conflict skip-if-identical",
 	},
+	cli.StringFlag{
+		Name:  "on-conflict",
+		Usage: "网盘已存在同名文件时的处理策略: skip 跳过, overwrite 覆盖, rename-new 上传的文件重命名, rename-old 已存在的文件重命名为带时间戳的名称, skip-if-identical MD5一致时跳过否则覆盖",
+	},
+	forceProtectedFlag,
 	cli.BoolFlag{
 		Name:  "norapid",
 		Usage: "不检测秒传",
 	},
+	cli.BoolFlag{
+		Name:  "verify",
+		Usage: "上传完成后重新查询网盘文件, 校验MD5和大小, 不一致时删除网盘文件并重新上传",
+	},
+	cli.StringFlag{
+		Name:  "symlinks",
+		Usage: "符号链接的处理方式: follow 上传链接指向的文件或目录, skip 跳过, store 上传记录链接目标的 .symlink 标记文件",
+		Value: "follow",
+	},
+	cli.StringFlag{
+		Name:  "split-size",
+		Usage: "超过该大小的文件分成多个该大小的分片文件上传, 并上传分片清单, 下载时自动合并, 例如 4GB",
+	},
+	cli.StringFlag{
+		Name:  "pack-small",
+		Usage: "小于该大小的文件按目录打包为 tar 文件上传, 并上传记录文件位置的索引, 下载时自动解包, 例如 1MB",
+	},
+	cli.BoolFlag{
+		Name:  "encrypt",
+		Usage: "加密网盘模式, 上传前在本地加密文件内容, 密码从环境变量 " + config.EnvEncryptPassword + " 读取或交互输入",
+	},
+	cli.BoolFlag{
+		Name:  "encrypt-names",
+		Usage: "加密网盘模式下同时加密文件名和目录名",
+	},
+	cli.StringFlag{
+		Name:  "encrypt-method",
+		Usage: "加密网盘模式的加密方法: aes-256-gcm, chacha20-poly1305",
+		Value: "aes-256-gcm",
+	},
+	cli.StringFlag{
+		Name:  "key-file",
+		Usage: "加密网盘模式使用密钥文件代替密码",
+	},
 	cli.StringFlag{
 		Name:  "familyId",
 		Usage: "家庭云ID",
@@ -125,15 +173,24 @@ func CmdUpload() cli.Command {
     5. 覆盖上传，已存在的同名文件会被移到回收站
     cloudpan189-go upload -ow 1.mp4 /视频
 
-    6. 将本地的 C:\Users\Administrator\Video 整个目录上传到网盘 /视频 目录，但是排除所有的.jpg文件
+    6. 上传时保留网盘中已存在的同名文件，旧文件会被重命名为带时间戳的名称
+    cloudpan189-go upload -on-conflict rename-old 1.mp4 /视频
+
+    7. 移动上传，每个文件上传并确认网盘文件一致后删除本地文件，清空的本地目录也会被删除
+    cloudpan189-go upload -move C:/Users/Administrator/Video /视频
+
+    8. 将本地的 C:\Users\Administrator\Video 整个目录上传到网盘 /视频 目录，但是排除所有的.jpg文件
     cloudpan189-go upload -exn "\.jpg$" C:/Users/Administrator/Video /视频
 
-    7. 将本地的 C:\Users\Administrator\Video 整个目录上传到网盘 /视频 目录，但是排除所有的.jpg文件和.mp3文件，每一个排除项就是一个exn参数
+    9. 将本地的 C:\Users\Administrator\Video 整个目录上传到网盘 /视频 目录，但是排除所有的.jpg文件和.mp3文件，每一个排除项就是一个exn参数
     cloudpan189-go upload -exn "\.jpg$" -exn "\.mp3$" C:/Users/Administrator/Video /视频
 
-    8. 将本地的 C:\Users\Administrator\Video 整个目录上传到网盘 /视频 目录，但是排除所有的 @eadir 文件夹
+    10. 将本地的 C:\Users\Administrator\Video 整个目录上传到网盘 /视频 目录，但是排除所有的 @eadir 文件夹
     cloudpan189-go upload -exn "^@eadir$" C:/Users/Administrator/Video /视频
 
+    11. 加密上传，文件内容和文件名在本地加密后再上传，下载时使用相同的密码和参数解密
+    cloudpan189-go upload -encrypt -encrypt-names C:/Users/Administrator/Video /视频
+
   参考：
     以下是典型的排除特定文件或者文件夹的例子，注意：参数值必须是正则表达式。在正则表达式中，^表示匹配开头，$表示匹配结尾。
     1)排除@eadir文件或者文件夹：-exn "^@eadir$"
@@ -149,21 +206,57 @@ func CmdUpload() cli.Command {
 				return nil
 			}
 
+			onConflict, err := panupload.ParseConflictPolicy(c.String("on-conflict"), c.Bool("ow"))
+			if err != nil {
+				fmt.Println(err)
+				return nil
+			}
+			symlinks, err := ParseSymlinkPolicy(c.String("symlinks"))
+			if err != nil {
+				fmt.Println(err)
+				return nil
+			}
+			splitSize, err := parseSplitSize(c.String("split-size"))
+			if err != nil {
+				fmt.Println(err)
+				return nil
+			}
+			packSmallSize, err := parsePackSmallSize(c.String("pack-small"))
+			if err != nil {
+				fmt.Println(err)
+				return nil
+			}
+			encryption, err := parseUploadEncryption(c)
+			if err != nil {
+				fmt.Println(err)
+				return nil
+			}
+
 			subArgs := c.Args()
 			RunUpload(subArgs[:c.NArg()-1], subArgs[c.NArg()-1], &UploadOptions{
-				AllParallel:   c.Int("p"),
-				Parallel:      1, // 天翼云盘一个文件只支持单线程上传
-				MaxRetry:      c.Int("retry"),
-				NoRapidUpload: c.Bool("norapid"),
-				NoSplitFile:   true, // 天翼云盘不支持分片并发上传，只支持单线程上传，支持断点续传
-				ShowProgress:  !c.Bool("np"),
-				IsOverwrite:   c.Bool("ow"),
-				FamilyId:      parseFamilyId(c),
-				ExcludeNames:  c.StringSlice("exn"),
+				AllParallel:    c.Int("p"),
+				Parallel:       1, // 天翼云盘一个文件只支持单线程上传
+				MaxRetry:       c.Int("retry"),
+				NoRapidUpload:  c.Bool("norapid"),
+				NoSplitFile:    true, // 天翼云盘不支持分片并发上传，只支持单线程上传，支持断点续传
+				ShowProgress:   !c.Bool("np"),
+				OnConflict:     onConflict,
+				ForceProtected: c.Bool("force-protected"),
+				IsMove:         c.Bool("move"),
+				IsVerify:       c.Bool("verify"),
+				Symlinks:       symlinks,
+				SplitSize:      splitSize,
+				PackSmallSize:  packSmallSize,
+				Encryption:     encryption,
+				FamilyId:       parseFamilyId(c),
+				ExcludeNames:   c.StringSlice("exn"),
 			})
 			return nil
 		},
-		Flags: UploadFlags,
+		Flags: append(UploadFlags, cli.BoolFlag{
+			Name:  "move",
+			Usage: "移动模式, 文件上传并确认网盘文件的MD5和大小一致后删除本地文件, 并删除清空的本地目录",
+		}),
 	}
 }
 
@@ -190,7 +283,13 @@ func CmdRapidUpload() cli.Command {
 				return nil
 			}
 
-			RunRapidUpload(parseFamilyId(c), c.Bool("ow"), c.Args().Get(0), c.String("md5"), c.Int64("size"))
+			onConflict, err := panupload.ParseConflictPolicy(c.String("on-conflict"), c.Bool("ow"))
+			if err != nil {
+				fmt.Println(err)
+				return nil
+			}
+
+			RunRapidUpload(parseFamilyId(c), onConflict, c.Bool("force-protected"), c.Args().Get(0), c.String("md5"), c.Int64("size"))
 			return nil
 		},
 		Flags: []cli.Flag{
@@ -206,8 +305,13 @@ func CmdRapidUpload() cli.Command {
 			},
 			cli.BoolFlag{
 				Name:  "ow",
-				Usage: "overwrite, 覆盖已存在的文件",
+				Usage: "overwrite, 覆盖已存在的文件, 等同于 -on-conflict skip-if-identical",
 			},
+			cli.StringFlag{
+				Name:  "on-conflict",
+				Usage: "网盘已存在同名文件时的处理策略: skip, overwrite, rename-new, rename-old, skip-if-identical",
+			},
+			forceProtectedFlag,
 			cli.StringFlag{
 				Name:  "familyId",
 				Usage: "家庭云ID",
@@ -217,8 +321,8 @@ func CmdRapidUpload() cli.Command {
 	}
 }
 
-// RunUpload 执行文件上传
-func RunUpload(localPaths []string, savePath string, opt *UploadOptions) {
+// RunUpload 执行文件上传, 返回全部文件是否上传成功
+func RunUpload(localPaths []string, savePath string, opt *UploadOptions) (ok bool) {
 	activeUser := GetActiveUser()
 	if opt == nil {
 		opt = &UploadOptions{}
@@ -237,6 +341,15 @@ func RunUpload(localPaths []string, savePath string, opt *UploadOptions) {
 	if opt.Parallel <= 0 {
 		opt.Parallel = 1
 	}
+	if opt.HashParallel <= 0 {
+		opt.HashParallel = config.Config.MaxHashParallel
+		if opt.HashParallel <= 0 {
+			opt.HashParallel = config.DefaultFileHashParallelNum
+		}
+	}
+	if opt.HashParallel > config.MaxFileHashParallelNum {
+		opt.HashParallel = config.MaxFileHashParallelNum
+	}
 
 	if opt.MaxRetry < 0 {
 		opt.MaxRetry = DefaultUploadMaxRetry
@@ -251,17 +364,20 @@ func RunUpload(localPaths []string, savePath string, opt *UploadOptions) {
 	switch len(localPaths) {
 	case 0:
 		fmt.Printf("本地路径为空\n")
-		return
+		return false
 	}
 
 	// 打开上传状态
 	uploadDatabase, err := panupload.NewUploadingDatabase()
 	if err != nil {
 		fmt.Printf("打开上传未完成数据库错误: %s\n", err)
-		return
+		return false
 	}
 	defer uploadDatabase.Close()
 
+	// 打开文件摘要缓存
+	defer openHashCache()()
+
 	var (
 		// 使用 task framework
 		executor = &taskframework.TaskExecutor{
@@ -271,12 +387,30 @@ func RunUpload(localPaths []string, savePath string, opt *UploadOptions) {
 		statistic = &panupload.UploadStatistic{}
 
 		folderCreateMutex = &sync.Mutex{}
+		folderCache       = map[string]*cloudpan.AppMkdirResult{}
+
+		// 上传流水线, 提前计算MD5和创建上传任务
+		pipeline = panupload.NewUploadPipeline(opt.HashParallel, opt.AllParallel+2*opt.HashParallel)
+
+		// 符号链接处理
+		symlinks = newSymlinkTracker()
+
+		// 小文件打包
+		packs *packTracker
 	)
+	if opt.PackSmallSize > 0 {
+		packs = newPackTracker(opt, activeUser.PanClient())
+	}
+	if opt.Symlinks == "" {
+		opt.Symlinks = SymlinkFollow
+	}
+	defer symlinks.cleanup()
 	executor.SetParallel(opt.AllParallel)
 
 	statistic.StartTimer() // 开始计时
 
 	wg := sync.WaitGroup{}
+	ok = true
 
 	// 启动上传任务
 	Done := make(chan struct{})
@@ -303,6 +437,7 @@ func RunUpload(localPaths []string, savePath string, opt *UploadOptions) {
 		// 输出上传失败的文件列表
 		for _, failed := range failedList {
 			if failed.Size() != 0 {
+				ok = false
 				fmt.Printf("以下文件上传失败: \n")
 				tb := cmdtable.NewTable(os.Stdout)
 				for e := failed.Shift(); e != nil; e = failed.Shift() {
@@ -347,11 +482,104 @@ func RunUpload(localPaths []string, savePath string, opt *UploadOptions) {
 					}(db)
 				} else {
 					fmt.Println(curPath, "同步数据库打开失败,跳过该目录的备份", err)
+					ok = false
 					continue
 				}
 			}
 		}
 
+		if opt.Symlinks == SymlinkFollow {
+			symlinks.enterDir(curPath)
+		}
+
+		localRootPath, syncDb := curPath, db
+		newUploadUnit := func(lfe *localfile.LocalFileEntity, saveTo string) *panupload.UploadTaskUnit {
+			if opt.Encryption != nil {
+				lfe.Encryption = opt.Encryption.File
+			}
+			return &panupload.UploadTaskUnit{
+				LocalFileChecksum: lfe,
+				SavePath:          saveTo,
+				FamilyId:          opt.FamilyId,
+				PanClient:         activeUser.PanClient(),
+				UploadingDatabase: uploadDatabase,
+				FolderCreateMutex: folderCreateMutex,
+				FolderCache:       folderCache,
+				Pipeline:          pipeline,
+				Parallel:          opt.Parallel,
+				NoRapidUpload:     opt.NoRapidUpload,
+				NoSplitFile:       opt.NoSplitFile,
+				UploadStatistic:   statistic,
+				ShowProgress:      opt.ShowProgress,
+				OnConflict:        opt.OnConflict,
+				ForceProtected:    opt.ForceProtected,
+				IsMove:            opt.IsMove,
+				IsVerify:          opt.IsVerify,
+				LocalRootPath:     localRootPath,
+				FolderSyncDb:      syncDb,
+			}
+		}
+
+		// 上传单个文件
+		submitFile := func(localFile, savePathOfFile string, isSymlinkMarker bool) {
+			localFileEntity := localfile.NewLocalFileEntity(localFile)
+			// 标记文件的修改时间取自符号链接, 不能用于摘要缓存
+			localFileEntity.NoHashCache = isSymlinkMarker
+			unit := newUploadUnit(localFileEntity, savePathOfFile)
+			taskinfo := executor.Append(unit, opt.MaxRetry)
+			pipeline.Submit(unit)
+
+			fmt.Printf("%s [%s] 加入上传队列: %s\n", time.Now().Format("2006-01-02 15:04:05"), taskinfo.Id(), localFile)
+		}
+
+		// 上传打包的小文件, tar 文件上传成功后再上传索引
+		submitPack := func(b *panupload.PackBuilder) {
+			if b.Len() == 1 {
+				// 只有一个文件时没有必要打包
+				localPath, savePathOfFile := b.LocalPaths()[0], b.SavePath(0)
+				b.Discard()
+				submitFile(localPath, savePathOfFile, false)
+				return
+			}
+			group, err := b.Finish()
+			if err != nil {
+				fmt.Printf("打包小文件失败, 改为逐个上传: %s, %s\n", b.LocalDir, err)
+				for k, localPath := range b.LocalPaths() {
+					submitFile(localPath, b.SavePath(k), false)
+				}
+				return
+			}
+			group.IsMove = opt.IsMove
+			group.LocalRootPath = localRootPath
+			group.FolderSyncDb = syncDb
+			group.OnSegmentUploaded = func() {
+				lfe := localfile.NewLocalFileEntity(group.IndexFile)
+				lfe.NoHashCache = true
+				unit := newUploadUnit(lfe, group.IndexSavePath())
+				unit.Pipeline = nil
+				unit.IsMove = false
+				unit.OnConflict = panupload.ConflictSkipIfIdentical
+				unit.FolderSyncDb = nil
+				unit.Group = group
+				unit.GroupIndex = panupload.PackIndexIndex
+				taskinfo := executor.Append(unit, opt.MaxRetry)
+				fmt.Printf("%s [%s] 加入上传队列: %s\n", time.Now().Format("2006-01-02 15:04:05"), taskinfo.Id(), group.IndexSavePath())
+			}
+			lfe := localfile.NewLocalFileEntity(group.SegmentFile)
+			lfe.NoHashCache = true
+			unit := newUploadUnit(lfe, group.SegmentSavePath())
+			unit.IsMove = false
+			// 移动模式需要确认 tar 文件已上传成功
+			unit.IsVerify = opt.IsVerify || opt.IsMove
+			unit.OnConflict = panupload.ConflictSkipIfIdentical
+			unit.FolderSyncDb = nil
+			unit.Group = group
+			unit.GroupIndex = panupload.PackSegmentIndex
+			taskinfo := executor.Append(unit, opt.MaxRetry)
+			pipeline.Submit(unit)
+			fmt.Printf("%s [%s] 加入上传队列: %s 打包 %d 个文件\n", time.Now().Format("2006-01-02 15:04:05"), taskinfo.Id(), b.LocalDir, len(group.LocalPaths))
+		}
+
 		walkFunc = func(file string, fi os.FileInfo, err error) error {
 			if err != nil {
 				return err
@@ -363,9 +591,43 @@ func RunUpload(localPaths []string, savePath string, opt *UploadOptions) {
 				return filepath.SkipDir
 			}
 
+			localFile := file
+			isSymlinkMarker := false
 			if fi.Mode()&os.ModeSymlink != 0 { // 读取 symbol link
-				err = WalkAllFile(file+string(os.PathSeparator), walkFunc)
-				return err
+				switch opt.Symlinks {
+				case SymlinkSkip:
+					symlinks.skip(file, "跳过符号链接")
+					return nil
+				case SymlinkStore:
+					marker, err := symlinks.createMarker(file, fi)
+					if err != nil {
+						symlinks.skip(file, "读取链接目标失败: "+err.Error())
+						return nil
+					}
+					localFile = marker
+					isSymlinkMarker = true
+					if fi, err = os.Stat(marker); err != nil {
+						return nil
+					}
+				default:
+					target, err := os.Stat(file)
+					if err != nil {
+						symlinks.skip(file, "链接目标不存在")
+						return nil
+					}
+					if target.IsDir() {
+						if symlinks.isLoop(file) {
+							symlinks.skip(file, "循环链接")
+							return nil
+						}
+						symlinks.enterDir(file)
+						return WalkAllFile(file+string(os.PathSeparator), walkFunc)
+					}
+					// 链接指向文件, 按普通文件上传
+					fi = target
+				}
+			} else if fi.IsDir() && opt.Symlinks == SymlinkFollow {
+				symlinks.enterDir(file)
 			}
 
 			subSavePath := strings.TrimPrefix(file, localPathDir)
@@ -375,6 +637,19 @@ func RunUpload(localPaths []string, savePath string, opt *UploadOptions) {
 				subSavePath = cmdutil.ConvertToUnixPathSeparator(subSavePath)
 			}
 
+			if isSymlinkMarker {
+				subSavePath += SymlinkMarkerSuffix
+			}
+
+			// 加密网盘模式, 加密文件名和目录名
+			if subSavePath, err = opt.Encryption.encryptRelPath(subSavePath); err != nil {
+				fmt.Printf("跳过文件: %s, %s\n", file, err)
+				if fi.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+
 			subSavePath = path.Clean(savePath + cloudpan.PathSeparator + subSavePath)
 			var ufm *panupload.UploadedFileMeta
 
@@ -397,7 +672,7 @@ func RunUpload(localPaths []string, savePath string, opt *UploadOptions) {
 				fmt.Println(subSavePath, "云盘文件夹预创建")
 				//首先尝试直接创建文件夹
 				if ufm = db.Get(path.Dir(subSavePath)); ufm.IsFolder == true && ufm.FileID != "" {
-					rs, err := panClient.AppMkdir(opt.FamilyId, ufm.FileID, fi.Name())
+					rs, err := panClient.AppMkdir(opt.FamilyId, ufm.FileID, path.Base(subSavePath))
 					if err == nil && rs != nil && rs.FileId != "" {
 						db.Put(subSavePath, &panupload.UploadedFileMeta{FileID: rs.FileId, IsFolder: true, ModTime: fi.ModTime().Unix(), Rev: rs.Rev, ParentId: rs.ParentId})
 						return nil
@@ -409,35 +684,96 @@ func RunUpload(localPaths []string, savePath string, opt *UploadOptions) {
 					return nil
 				}
 				fmt.Println(subSavePath, "创建云盘文件夹失败", err)
+				ok = false
 				return filepath.SkipDir
 			}
 
-			taskinfo := executor.Append(&panupload.UploadTaskUnit{
-				LocalFileChecksum: localfile.NewLocalFileEntity(file),
-				SavePath:          subSavePath,
-				FamilyId:          opt.FamilyId,
-				PanClient:         activeUser.PanClient(),
-				UploadingDatabase: uploadDatabase,
-				FolderCreateMutex: folderCreateMutex,
-				Parallel:          opt.Parallel,
-				NoRapidUpload:     opt.NoRapidUpload,
-				NoSplitFile:       opt.NoSplitFile,
-				UploadStatistic:   statistic,
-				ShowProgress:      opt.ShowProgress,
-				IsOverwrite:       opt.IsOverwrite,
-				FolderSyncDb:      db,
-			}, opt.MaxRetry)
+			// 小文件打包上传
+			if packs.shouldPack(fi) && !isSymlinkMarker {
+				switch packPath, action := packs.check(localFile, subSavePath, fi, db); action {
+				case packActionSkip:
+					return nil
+				case packActionAdd:
+					b, err := packs.add(localFile, packPath, fi)
+					if err == nil {
+						if b != nil {
+							submitPack(b)
+						}
+						return nil
+					}
+					fmt.Printf("打包小文件失败, 改为单独上传: %s, %s\n", file, err)
+				}
+			}
+
+			// 超过分片大小的文件分成多个分片文件上传, 全部上传成功后再上传分片清单
+			if opt.SplitSize > 0 && fi.Size() > opt.SplitSize && !isSymlinkMarker {
+				manifest := functions.NewSplitManifest(path.Base(subSavePath), fi.Size(), fi.ModTime().Unix(), opt.SplitSize)
+				group := panupload.NewSplitUploadGroup(localFile, subSavePath, manifest)
+				group.IsMove = opt.IsMove
+				group.LocalRootPath = localRootPath
+				group.FolderSyncDb = syncDb
+				group.OnPartsUploaded = func(manifestFile string) {
+					lfe := localfile.NewLocalFileEntity(manifestFile)
+					lfe.NoHashCache = true
+					unit := newUploadUnit(lfe, group.ManifestSavePath())
+					unit.Pipeline = nil
+					unit.IsMove = false
+					unit.OnConflict = panupload.ConflictSkipIfIdentical
+					unit.FolderSyncDb = nil
+					unit.Group = group
+					unit.GroupIndex = panupload.SplitManifestIndex
+					taskinfo := executor.Append(unit, opt.MaxRetry)
+					fmt.Printf("%s [%s] 加入上传队列: %s\n", time.Now().Format("2006-01-02 15:04:05"), taskinfo.Id(), group.ManifestSavePath())
+				}
+				for k, part := range manifest.Parts {
+					unit := newUploadUnit(localfile.NewLocalFileSectionEntity(localFile, part.Offset, part.Size), path.Join(path.Dir(subSavePath), part.Name))
+					unit.IsMove = false
+					// 移动模式需要确认每个分片都已上传成功
+					unit.IsVerify = opt.IsVerify || opt.IsMove
+					// 分片文件名固定, 同名时只能覆盖
+					unit.OnConflict = panupload.ConflictSkipIfIdentical
+					unit.FolderSyncDb = nil
+					unit.Group = group
+					unit.GroupIndex = k
+					taskinfo := executor.Append(unit, opt.MaxRetry)
+					pipeline.Submit(unit)
+					fmt.Printf("%s [%s] 加入上传队列: %s 分片 %d/%d\n", time.Now().Format("2006-01-02 15:04:05"), taskinfo.Id(), file, k+1, len(manifest.Parts))
+				}
+				return nil
+			}
 
-			fmt.Printf("%s [%s] 加入上传队列: %s\n", time.Now().Format("2006-01-02 15:04:05"), taskinfo.Id(), file)
+			submitFile(localFile, subSavePath, isSymlinkMarker)
 			return nil
 		}
 		if err := WalkAllFile(curPath, walkFunc); err != nil {
 			fmt.Printf("警告: 遍历错误: %s\n", err)
+			ok = false
+		}
+		for _, b := range packs.drain() {
+			submitPack(b)
 		}
 	}
+	pipeline.Close()
 	time.Sleep(500 * time.Millisecond)
 	close(Done)
 	wg.Wait()
+	symlinks.printSummary()
+	return ok
+}
+
+// parseSplitSize 解析分片大小, 为空表示不分片
+func parseSplitSize(sizeStr string) (int64, error) {
+	if sizeStr == "" {
+		return 0, nil
+	}
+	size, err := converter.ParseFileSizeStr(sizeStr)
+	if err != nil || size < 0 {
+		return 0, fmt.Errorf("分片大小格式错误: %s", sizeStr)
+	}
+	if size > 0 && size < panupload.MinUploadBlockSize {
+		return 0, fmt.Errorf("分片大小不能小于 %s", converter.ConvertFileSize(panupload.MinUploadBlockSize))
+	}
+	return size, nil
 }
 
 // 是否是排除上传的文件
@@ -495,7 +831,7 @@ func walkAllFile(dirPath string, info os.FileInfo, walkFn filepath.WalkFunc) err
 	return nil
 }
 
-func RunRapidUpload(familyId int64, isOverwrite bool, panFilePath string, md5Str string, length int64) {
+func RunRapidUpload(familyId int64, onConflict panupload.ConflictPolicy, forceProtected bool, panFilePath string, md5Str string, length int64) {
 	activeUser := GetActiveUser()
 	panClient := activeUser.PanClient()
 
@@ -527,48 +863,24 @@ func RunRapidUpload(familyId int64, isOverwrite bool, panFilePath string, md5Str
 	}
 	time.Sleep(time.Duration(2) * time.Second)
 
-	if isOverwrite {
-		// 标记覆盖旧同名文件
-		// 检查同名文件是否存在
-		efi, apierr := panClient.AppFileInfoByPath(familyId, saveFilePath)
-		if apierr != nil && apierr.Code != apierror.ApiCodeFileNotFoundCode {
-			fmt.Println("检测同名文件失败，请稍后重试")
-			return
-		}
-		if efi != nil && efi.FileId != "" {
-			// existed, delete it
-			infoList := cloudpan.BatchTaskInfoList{}
-			isFolder := 0
-			if efi.IsFolder {
-				isFolder = 1
-			}
-			infoItem := &cloudpan.BatchTaskInfo{
-				FileId:      efi.FileId,
-				FileName:    efi.FileName,
-				IsFolder:    isFolder,
-				SrcParentId: efi.ParentId,
-			}
-			infoList = append(infoList, infoItem)
-			delParam := &cloudpan.BatchTaskParam{
-				TypeFlag:  cloudpan.BatchTaskTypeDelete,
-				TaskInfos: infoList,
-			}
-
-			var taskId string
-			var err *apierror.ApiError
-			if familyId > 0 {
-				taskId, err = panClient.AppCreateBatchTask(familyId, delParam)
-			} else {
-				taskId, err = panClient.CreateBatchTask(delParam)
-			}
-
-			if err != nil || taskId == "" {
-				fmt.Println("无法删除文件，请稍后重试")
-				return
-			}
-			time.Sleep(time.Duration(500) * time.Millisecond)
-			fmt.Println("检测到同名文件，已移动到回收站: " + saveFilePath)
-		}
+	// 处理同名文件
+	finalPath, _, action, err := panupload.ResolveConflict(panClient, familyId, saveFilePath, md5Str, onConflict, forceProtected)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	switch action {
+	case panupload.ConflictActionSkip:
+		fmt.Println("网盘已存在同名文件，跳过: " + saveFilePath)
+		return
+	case panupload.ConflictActionIdentical:
+		fmt.Println("网盘已存在相同的文件，无需秒传: " + saveFilePath)
+		return
+	}
+	if finalPath != saveFilePath {
+		fmt.Println("检测到同名文件，重命名保存为: " + finalPath)
+		saveFilePath = finalPath
+		panFileName = path.Base(finalPath)
 	}
 
 	appCreateUploadFileParam = &cloudpan.AppCreateUploadFileParam{
@@ -0,0 +1,200 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package command
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/tickstep/cloudpan189-api/cloudpan"
+	"github.com/tickstep/cloudpan189-go/internal/functions"
+	"github.com/tickstep/cloudpan189-go/internal/functions/panupload"
+	"github.com/tickstep/cloudpan189-go/internal/localfile"
+	"github.com/tickstep/cloudpan189-go/library/crypto"
+	"github.com/tickstep/library-go/converter"
+	"github.com/tickstep/library-go/logger"
+)
+
+type (
+	// packTracker 按本地目录收集需要打包上传的小文件
+	packTracker struct {
+		threshold int64  // 小于该大小的文件打包上传
+		stamp     string // tar 文件名中的时间
+		seq       int
+		builders  map[string]*panupload.PackBuilder // 本地目录 => 正在写入的 tar 文件
+
+		opt       *UploadOptions
+		panClient *cloudpan.PanClient
+		remote    map[string]*panupload.PackRemoteDir // 网盘目录 => 已存在的文件, 读取失败时为空
+	}
+
+	// packAction 打包前处理同名文件后的动作
+	packAction int
+)
+
+const (
+	// packActionAdd 加入 tar 文件
+	packActionAdd packAction = iota
+	// packActionSkip 跳过上传
+	packActionSkip
+	// packActionSingle 不打包, 单独上传
+	packActionSingle
+)
+
+func newPackTracker(opt *UploadOptions, panClient *cloudpan.PanClient) *packTracker {
+	return &packTracker{
+		threshold: opt.PackSmallSize,
+		stamp:     time.Now().Format("20060102150405"),
+		builders:  map[string]*panupload.PackBuilder{},
+		opt:       opt,
+		panClient: panClient,
+		remote:    map[string]*panupload.PackRemoteDir{},
+	}
+}
+
+// parsePackSmallSize 解析打包上传的文件大小阈值, 为空表示不打包
+func parsePackSmallSize(sizeStr string) (int64, error) {
+	if sizeStr == "" {
+		return 0, nil
+	}
+	size, err := converter.ParseFileSizeStr(sizeStr)
+	if err != nil || size < 0 {
+		return 0, fmt.Errorf("打包文件大小格式错误: %s", sizeStr)
+	}
+	if size > panupload.MaxPackFileSize {
+		return 0, fmt.Errorf("打包文件大小不能超过 %s", converter.ConvertFileSize(panupload.MaxPackFileSize))
+	}
+	return size, nil
+}
+
+// shouldPack 文件是否需要打包上传
+func (pt *packTracker) shouldPack(fi os.FileInfo) bool {
+	return pt != nil && fi.Mode().IsRegular() && fi.Size() < pt.threshold
+}
+
+// remoteDir 网盘目录中已存在的文件和打包的文件, 每个目录只读取一次
+func (pt *packTracker) remoteDir(saveDir string) *panupload.PackRemoteDir {
+	if rd, ok := pt.remote[saveDir]; ok {
+		return rd
+	}
+	var keychain *crypto.Keychain
+	if pt.opt.Encryption != nil {
+		keychain = pt.opt.Encryption.File.Keychain
+	}
+	rd, err := panupload.LoadPackRemoteDir(pt.panClient, pt.opt.FamilyId, saveDir, keychain)
+	if err != nil {
+		fmt.Printf("%s, 该目录的小文件改为单独上传\n", err)
+	}
+	pt.remote[saveDir] = rd
+	return rd
+}
+
+// check 打包前按同名文件处理策略检查网盘上已存在的文件, 返回打包使用的保存路径.
+// 已存在单独上传的同名文件时改为单独上传, 由上传任务按策略处理; 跳过的相同文件记录到备份数据库
+func (pt *packTracker) check(localPath, savePath string, fi os.FileInfo, db panupload.SyncDb) (string, packAction) {
+	rd := pt.remoteDir(path.Dir(savePath))
+	if rd == nil || rd.Names[path.Base(savePath)] {
+		return savePath, packActionSingle
+	}
+
+	policy := pt.opt.OnConflict
+	if db != nil && policy == panupload.ConflictDefault {
+		// 和备份的单个文件一致, 未指定策略时相同文件跳过, 否则覆盖
+		policy = panupload.ConflictSkipIfIdentical
+	}
+	var md5Str string
+	localMD5 := func() (string, error) {
+		lfc, err := localfile.GetFileSum(localPath, localfile.CHECKSUM_MD5)
+		if err != nil {
+			return "", err
+		}
+		md5Str = lfc.MD5
+		return md5Str, nil
+	}
+	finalPath, action, err := rd.CheckConflict(pt.opt.FamilyId, savePath, fi.Size(), localMD5, policy, pt.opt.ForceProtected)
+	if err != nil {
+		fmt.Printf("跳过文件: %s, %s\n", localPath, err)
+		return savePath, packActionSkip
+	}
+	switch action {
+	case panupload.ConflictActionSkip:
+		fmt.Printf("云端已存在同名的打包文件, 跳过上传: %s\n", localPath)
+		return savePath, packActionSkip
+	case panupload.ConflictActionIdentical:
+		logger.Verbosef("云端已存在相同的打包文件, 跳过上传: %s\n", localPath)
+		if db != nil {
+			db.Put(savePath, &panupload.UploadedFileMeta{
+				MD5:         md5Str,
+				Size:        fi.Size(),
+				ModTime:     fi.ModTime().Unix(),
+				PackSegment: rd.Segment(path.Base(savePath)),
+			})
+		}
+		if pt.opt.IsMove {
+			if err = os.Remove(localPath); err != nil {
+				fmt.Printf("删除本地文件失败: %s\n", err)
+			}
+		}
+		return savePath, packActionSkip
+	}
+	if finalPath != savePath {
+		fmt.Printf("检测到同名的打包文件，重命名保存为: %s\n", finalPath)
+	}
+	return finalPath, packActionAdd
+}
+
+// add 将文件加入所在目录的 tar 文件, tar 文件达到大小上限时返回该 tar 文件
+func (pt *packTracker) add(localPath, savePath string, fi os.FileInfo) (*panupload.PackBuilder, error) {
+	dir, saveDir := filepath.Dir(localPath), path.Dir(savePath)
+	b := pt.builders[dir]
+	if b == nil {
+		pt.seq++
+		var err error
+		b, err = panupload.NewPackBuilder(dir, saveDir, functions.PackSegmentName(pt.stamp, pt.seq))
+		if err != nil {
+			return nil, err
+		}
+		pt.builders[dir] = b
+	}
+	if err := b.Add(localPath, path.Base(savePath), fi); err != nil {
+		return nil, err
+	}
+	if b.Size() < panupload.DefaultPackSegmentSize {
+		return nil, nil
+	}
+	delete(pt.builders, dir)
+	return b, nil
+}
+
+// drain 取出所有未满的 tar 文件
+func (pt *packTracker) drain() []*panupload.PackBuilder {
+	if pt == nil {
+		return nil
+	}
+	dirs := make([]string, 0, len(pt.builders))
+	for dir := range pt.builders {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+	builders := make([]*panupload.PackBuilder, 0, len(dirs))
+	for _, dir := range dirs {
+		builders = append(builders, pt.builders[dir])
+		delete(pt.builders, dir)
+	}
+	return builders
+}
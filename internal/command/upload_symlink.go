@@ -0,0 +1,159 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package command
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/tickstep/cloudpan189-go/cmder/cmdtable"
+	"github.com/tickstep/cloudpan189-go/internal/localfile"
+)
+
+type (
+	// SymlinkPolicy 上传时符号链接的处理方式
+	SymlinkPolicy string
+
+	// symlinkTracker 记录遍历过的目录和未上传的符号链接
+	symlinkTracker struct {
+		visited     map[string][]string // 目录标识 => 遍历时的路径
+		skipped     [][]string          // 未上传的符号链接及原因
+		markerFiles []string            // 为 store 模式创建的临时文件
+		mu          sync.Mutex
+	}
+)
+
+const (
+	// SymlinkFollow 上传链接指向的文件或目录, 检测循环链接
+	SymlinkFollow SymlinkPolicy = "follow"
+	// SymlinkSkip 跳过符号链接
+	SymlinkSkip SymlinkPolicy = "skip"
+	// SymlinkStore 上传记录链接目标的标记文件, 文件名为链接名加 .symlink 后缀
+	SymlinkStore SymlinkPolicy = "store"
+
+	// SymlinkMarkerSuffix 链接标记文件的后缀
+	SymlinkMarkerSuffix = ".symlink"
+)
+
+// ParseSymlinkPolicy 解析符号链接处理方式, 默认为 follow
+func ParseSymlinkPolicy(s string) (SymlinkPolicy, error) {
+	switch p := SymlinkPolicy(strings.ToLower(strings.TrimSpace(s))); p {
+	case "":
+		return SymlinkFollow, nil
+	case SymlinkFollow, SymlinkSkip, SymlinkStore:
+		return p, nil
+	}
+	return "", fmt.Errorf("未知的符号链接处理方式: %s, 可选值: follow, skip, store", s)
+}
+
+func newSymlinkTracker() *symlinkTracker {
+	return &symlinkTracker{
+		visited: map[string][]string{},
+	}
+}
+
+func fileIDKey(localPath string) (string, bool) {
+	dev, ino, ok := localfile.GetFileID(localPath)
+	if !ok {
+		return "", false
+	}
+	return fmt.Sprintf("%x-%x", dev, ino), true
+}
+
+// enterDir 记录正在遍历的目录
+func (st *symlinkTracker) enterDir(dirPath string) {
+	key, ok := fileIDKey(dirPath)
+	if !ok {
+		return
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.visited[key] = append(st.visited[key], filepath.Clean(dirPath))
+}
+
+// isLoop 链接指向的目录是否为链接所在路径的上级目录, 即继续遍历会形成循环
+func (st *symlinkTracker) isLoop(linkPath string) bool {
+	key, ok := fileIDKey(linkPath)
+	if !ok {
+		return false
+	}
+	parentDir := filepath.Dir(filepath.Clean(linkPath)) + string(os.PathSeparator)
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	for _, dirPath := range st.visited[key] {
+		if strings.HasPrefix(parentDir, strings.TrimSuffix(dirPath, string(os.PathSeparator))+string(os.PathSeparator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// skip 记录未上传的符号链接
+func (st *symlinkTracker) skip(linkPath, reason string) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.skipped = append(st.skipped, []string{linkPath, reason})
+}
+
+// createMarker 创建记录链接目标的临时文件, 修改时间和链接一致
+func (st *symlinkTracker) createMarker(linkPath string, linkInfo os.FileInfo) (string, error) {
+	target, err := os.Readlink(linkPath)
+	if err != nil {
+		return "", err
+	}
+	f, err := ioutil.TempFile("", "cloudpan189-symlink-")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if _, err = f.WriteString(target + "\n"); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	os.Chtimes(f.Name(), linkInfo.ModTime(), linkInfo.ModTime())
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.markerFiles = append(st.markerFiles, f.Name())
+	return f.Name(), nil
+}
+
+// cleanup 删除创建的临时文件
+func (st *symlinkTracker) cleanup() {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	for _, name := range st.markerFiles {
+		os.Remove(name)
+	}
+	st.markerFiles = nil
+}
+
+// printSummary 输出未上传的符号链接
+func (st *symlinkTracker) printSummary() {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if len(st.skipped) == 0 {
+		return
+	}
+	fmt.Printf("以下符号链接未上传: \n")
+	tb := cmdtable.NewTable(os.Stdout)
+	tb.SetHeader([]string{"符号链接", "原因"})
+	tb.AppendBulk(st.skipped)
+	tb.Render()
+}
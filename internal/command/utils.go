@@ -17,12 +17,23 @@ import (
 	"fmt"
 	"github.com/tickstep/cloudpan189-api/cloudpan"
 	"github.com/tickstep/cloudpan189-go/internal/config"
+	"github.com/tickstep/cloudpan189-go/internal/localfile"
 	"github.com/tickstep/library-go/logger"
 	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 )
 
 var (
 	panCommandVerbose = logger.New("PANCOMMAND", config.EnvVerbose)
+
+	// 本地文件摘要缓存及其使用计数
+	hashCache      *localfile.HashCache
+	hashCacheRefs  int
+	hashCacheMutex sync.Mutex
 )
 
 // GetAppFileInfoByPaths 获取指定文件路径的文件详情信息
@@ -76,3 +87,60 @@ func GetFamilyCloudMark(familyId int64) string {
 	}
 	return "个人云"
 }
+
+// openHashCache 打开本地文件摘要缓存, 返回关闭缓存的函数
+// 同时运行的多个上传任务(如 backup 多个目录)共用同一个缓存, 最后一个任务结束时关闭
+func openHashCache() func() {
+	hashCacheMutex.Lock()
+	defer hashCacheMutex.Unlock()
+	if hashCacheRefs == 0 {
+		hc, err := localfile.OpenHashCache(filepath.Join(config.GetConfigDir(), localfile.HashCacheFileName))
+		if err != nil {
+			logger.Verbosef("打开文件摘要缓存失败, 不使用缓存: %s\n", err)
+			return func() {}
+		}
+		hashCache = hc
+		localfile.SetHashCache(hc)
+	}
+	hashCacheRefs++
+	return func() {
+		hashCacheMutex.Lock()
+		defer hashCacheMutex.Unlock()
+		hashCacheRefs--
+		if hashCacheRefs == 0 {
+			localfile.SetHashCache(nil)
+			hashCache.Close()
+			hashCache = nil
+		}
+	}
+}
+
+// parseAge 解析时间长度, 支持 30d, 12h 等带天数的写法和 time.ParseDuration 的格式
+func parseAge(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if strings.HasSuffix(s, "d") {
+		days, err := strconv.ParseFloat(strings.TrimSuffix(s, "d"), 64)
+		if err != nil || days < 0 {
+			return 0, fmt.Errorf("时间长度格式错误: %s, 例如 30d, 12h", s)
+		}
+		return time.Duration(days * float64(24*time.Hour)), nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("时间长度格式错误: %s, 例如 30d, 12h", s)
+	}
+	return d, nil
+}
+
+// confirmAction 交互确认操作, yes 为 true 时不需要确认
+func confirmAction(prompt string, yes bool) bool {
+	if yes {
+		return true
+	}
+	var confirm string
+	fmt.Printf("%s (y/n) > ", prompt)
+	if _, err := fmt.Scanln(&confirm); err != nil {
+		return false
+	}
+	return confirm == "y" || confirm == "Y"
+}
@@ -36,6 +36,8 @@ const (
 	EnvVerbose = "CLOUD189_VERBOSE"
 	// EnvConfigDir 配置路径环境变量
 	EnvConfigDir = "CLOUD189_CONFIG_DIR"
+	// EnvEncryptPassword 加密网盘模式的密码环境变量
+	EnvEncryptPassword = "CLOUD189_ENCRYPT_PASSWORD"
 	// ConfigName 配置文件名
 	ConfigName = "cloud189_config.json"
 	// ConfigVersion 配置文件版本
@@ -47,6 +49,12 @@ const (
 	// MaxFileUploadParallelNum 最大文件上传并发数量
 	MaxFileUploadParallelNum = 20
 
+	// DefaultFileHashParallelNum 默认的上传前计算文件MD5的并发数量
+	DefaultFileHashParallelNum = 2
+
+	// MaxFileHashParallelNum 最大计算文件MD5的并发数量
+	MaxFileHashParallelNum = 16
+
 	// DefaultFileDownloadParallelNum 默认的文件下载并发数量
 	DefaultFileDownloadParallelNum = 5
 
@@ -80,6 +88,7 @@ type PanConfig struct {
 	CacheSize           int `json:"cacheSize"`           // 下载缓存
 	MaxDownloadParallel int `json:"maxDownloadParallel"` // 最大下载并发量，即同时下载文件最大数量
 	MaxUploadParallel   int `json:"maxUploadParallel"`   // 最大上传并发量，即同时上传文件最大数量
+	MaxHashParallel     int `json:"maxHashParallel"`     // 上传前计算文件MD5的最大并发量
 
 	MaxDownloadRate int64 `json:"maxDownloadRate"` // 限制最大下载速度，单位 B/s, 即字节/每秒
 	MaxUploadRate   int64 `json:"maxUploadRate"`   // 限制最大上传速度，单位 B/s, 即字节/每秒
@@ -92,6 +101,10 @@ type PanConfig struct {
 	DNSServer       string          `json:"dnsServer"`    // DNS服务器地址
 	UpdateCheckInfo UpdateCheckInfo `json:"updateCheckInfo"`
 
+	EncryptSalt string `json:"encryptSalt"` // 加密网盘模式的密钥派生盐, 首次使用时生成, 在其他设备使用相同的盐可得到相同的密文
+
+	ProtectedPaths ProtectedPathList `json:"protectedPaths"` // 受保护的网盘路径, 按账号和家庭云区分
+
 	configFilePath string
 	configFile     *os.File
 	fileMu         sync.Mutex
@@ -87,6 +87,7 @@ func (c *PanConfig) PrintTable() {
 		[]string{"cache_size", converter.ConvertFileSize(int64(c.CacheSize), 2), "1KB ~ 256KB", "下载缓存, 如果硬盘占用高或下载速度慢, 请尝试调大此值"},
 		[]string{"max_download_parallel", strconv.Itoa(c.MaxDownloadParallel), "1 ~ 20", "最大下载并发量，即同时下载文件最大数量"},
 		[]string{"max_upload_parallel", strconv.Itoa(c.MaxUploadParallel), "1 ~ 20", "最大上传并发量，即同时上传文件最大数量"},
+		[]string{"max_hash_parallel", strconv.Itoa(c.MaxHashParallel), "1 ~ 16", "上传前计算文件MD5的最大并发量，和上传并发量分开设置，0代表使用默认值"},
 		[]string{"max_download_rate", showMaxRate(c.MaxDownloadRate), "", "限制最大下载速度, 0代表不限制"},
 		[]string{"max_upload_rate", showMaxRate(c.MaxUploadRate), "", "限制最大上传速度, 0代表不限制"},
 		[]string{"savedir", c.SaveDir, "", "下载文件的储存目录"},
@@ -0,0 +1,112 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package config
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+type (
+	// ProtectedPath 受保护的网盘路径前缀, 该路径以及其中的文件不能被删除或覆盖
+	ProtectedPath struct {
+		UID      uint64 `json:"uid"`
+		FamilyId int64  `json:"familyId"` // 0代表个人云
+		Path     string `json:"path"`
+	}
+
+	// ProtectedPathList 受保护的网盘路径列表
+	ProtectedPathList []*ProtectedPath
+
+	// ProtectedPathError 操作受保护的路径
+	ProtectedPathError struct {
+		Path          string // 操作的路径
+		ProtectedPath string // 匹配的受保护路径
+	}
+)
+
+func (e *ProtectedPathError) Error() string {
+	if e.Path == e.ProtectedPath {
+		return fmt.Sprintf("%s 是受保护的路径, 使用 --force-protected 强制操作", e.Path)
+	}
+	return fmt.Sprintf("%s 涉及受保护的路径 %s, 使用 --force-protected 强制操作", e.Path, e.ProtectedPath)
+}
+
+// cleanPanPath 规范网盘路径
+func cleanPanPath(p string) string {
+	return path.Clean("/" + strings.TrimSpace(p))
+}
+
+// pathAffects 删除或覆盖 p 是否会影响受保护的路径 pp, 包括 pp 本身、pp 中的文件以及 pp 所在的上级目录
+func pathAffects(p, pp string) bool {
+	if p == pp || p == "/" || pp == "/" {
+		return true
+	}
+	return strings.HasPrefix(p, pp+"/") || strings.HasPrefix(pp, p+"/")
+}
+
+// ProtectedPathsOf 账号在个人云或家庭云中受保护的路径
+func (c *PanConfig) ProtectedPathsOf(uid uint64, familyId int64) []string {
+	paths := []string{}
+	for _, pp := range c.ProtectedPaths {
+		if pp.UID == uid && pp.FamilyId == familyId {
+			paths = append(paths, pp.Path)
+		}
+	}
+	return paths
+}
+
+// AddProtectedPath 添加受保护的路径, 已存在时返回 false
+func (c *PanConfig) AddProtectedPath(uid uint64, familyId int64, p string) bool {
+	p = cleanPanPath(p)
+	for _, pp := range c.ProtectedPaths {
+		if pp.UID == uid && pp.FamilyId == familyId && pp.Path == p {
+			return false
+		}
+	}
+	c.ProtectedPaths = append(c.ProtectedPaths, &ProtectedPath{
+		UID:      uid,
+		FamilyId: familyId,
+		Path:     p,
+	})
+	return true
+}
+
+// RemoveProtectedPath 移除受保护的路径, 不存在时返回 false
+func (c *PanConfig) RemoveProtectedPath(uid uint64, familyId int64, p string) bool {
+	p = cleanPanPath(p)
+	for k, pp := range c.ProtectedPaths {
+		if pp.UID == uid && pp.FamilyId == familyId && pp.Path == p {
+			c.ProtectedPaths = append(c.ProtectedPaths[:k], c.ProtectedPaths[k+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// CheckProtectedPath 检查当前账号删除或覆盖网盘路径 p 是否会影响受保护的路径
+func (c *PanConfig) CheckProtectedPath(familyId int64, p string) error {
+	user := c.ActiveUser()
+	if user == nil {
+		return nil
+	}
+	p = cleanPanPath(p)
+	for _, pp := range c.ProtectedPathsOf(user.UID, familyId) {
+		if pathAffects(p, pp) {
+			return &ProtectedPathError{Path: p, ProtectedPath: pp}
+		}
+	}
+	return nil
+}
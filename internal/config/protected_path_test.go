@@ -0,0 +1,92 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package config
+
+import (
+	"testing"
+)
+
+func TestPathAffects(t *testing.T) {
+	testCases := []struct {
+		p, pp string
+		want  bool
+	}{
+		{"/a", "/a", true},
+		{"/", "/a", true},
+		{"/a", "/", true},
+		{"/a", "/a/b", true},
+		{"/a/b/c.txt", "/a", true},
+		{"/a/b", "/a", true},
+		{"/ab", "/a", false},
+		{"/a", "/ab", false},
+		{"/a/bc", "/a/b", false},
+		{"/b", "/a", false},
+	}
+	for _, c := range testCases {
+		if got := pathAffects(c.p, c.pp); got != c.want {
+			t.Errorf("pathAffects(%q, %q) = %v, want %v", c.p, c.pp, got, c.want)
+		}
+	}
+}
+
+func TestCheckProtectedPath(t *testing.T) {
+	c := &PanConfig{activeUser: &PanUser{UID: 1}}
+	c.AddProtectedPath(1, 0, "/a/")
+	c.AddProtectedPath(1, 100, "/family")
+	c.AddProtectedPath(2, 0, "/other")
+	if c.AddProtectedPath(1, 0, " /a") {
+		t.Error("AddProtectedPath should reject duplicated path")
+	}
+
+	testCases := []struct {
+		familyId int64
+		p        string
+		want     string // 匹配的受保护路径, 为空表示不受保护
+	}{
+		{0, "/a", "/a"},
+		{0, "a/", "/a"},
+		{0, "/", "/a"},
+		{0, "/a/b.txt", "/a"},
+		{0, "/ab", ""},
+		{0, "/family", ""},
+		{0, "/other", ""},
+		{100, "/family/x", "/family"},
+		{100, "/", "/family"},
+		{100, "/a", ""},
+		{200, "/family", ""},
+	}
+	for _, tc := range testCases {
+		err := c.CheckProtectedPath(tc.familyId, tc.p)
+		if tc.want == "" {
+			if err != nil {
+				t.Errorf("CheckProtectedPath(%d, %q) = %v, want nil", tc.familyId, tc.p, err)
+			}
+			continue
+		}
+		ppe, ok := err.(*ProtectedPathError)
+		if !ok || ppe.ProtectedPath != tc.want {
+			t.Errorf("CheckProtectedPath(%d, %q) = %v, want protected by %s", tc.familyId, tc.p, err, tc.want)
+		}
+	}
+
+	if !c.RemoveProtectedPath(1, 0, "/a") {
+		t.Error("RemoveProtectedPath should remove existing path")
+	}
+	if err := c.CheckProtectedPath(0, "/a"); err != nil {
+		t.Errorf("CheckProtectedPath after remove = %v", err)
+	}
+	if c.RemoveProtectedPath(1, 0, "/a") {
+		t.Error("RemoveProtectedPath should return false for missing path")
+	}
+}
@@ -54,6 +54,7 @@ type (
 		familyId                int64
 		loadBalancerCompareFunc LoadBalancerCompareFunc // 负载均衡检测函数
 		durlCheckFunc           DURLCheckFunc           // 下载url检测函数
+		downloadUrlFunc         DownloadUrlFunc         // 获取下载链接的函数, 为空时获取网盘文件的下载链接
 		statusCodeBodyCheckFunc StatusCodeBodyCheckFunc
 		executeTime             time.Time
 		loadBalansers           []string
@@ -69,6 +70,8 @@ type (
 	DURLCheckFunc func(client *requester.HTTPClient, durl string) (contentLength int64, resp *http.Response, err error)
 	// StatusCodeBodyCheckFunc 响应状态码出错的检查函数
 	StatusCodeBodyCheckFunc func(respBody io.Reader) error
+	// DownloadUrlFunc 获取下载链接的函数, 用于下载分享中的文件等不在网盘中的文件
+	DownloadUrlFunc func() (string, error)
 )
 
 // NewDownloader 初始化Downloader
@@ -96,6 +99,29 @@ func (der *Downloader) SetClient(client *requester.HTTPClient) {
 	der.client = client
 }
 
+// SetDownloadUrlFunc 设置获取下载链接的函数, 下载链接直接请求, 不使用网盘的签名
+func (der *Downloader) SetDownloadUrlFunc(f DownloadUrlFunc) {
+	der.downloadUrlFunc = f
+}
+
+// downloadUrl 获取下载链接
+func (der *Downloader) downloadUrl() (string, error) {
+	if der.downloadUrlFunc != nil {
+		return der.downloadUrlFunc()
+	}
+	var durl string
+	var apierr *apierror.ApiError
+	if der.familyId > 0 {
+		durl, apierr = der.panClient.AppFamilyGetFileDownloadUrl(der.familyId, der.fileInfo.FileId)
+	} else {
+		durl, apierr = der.panClient.AppGetFileDownloadUrl(der.fileInfo.FileId)
+	}
+	if apierr != nil {
+		return "", apierr
+	}
+	return durl, nil
+}
+
 // SetLoadBalancerCompareFunc 设置负载均衡检测函数
 func (der *Downloader) SetLoadBalancerCompareFunc(f LoadBalancerCompareFunc) {
 	der.loadBalancerCompareFunc = f
@@ -374,15 +400,9 @@ func (der *Downloader) Execute() error {
 		}
 
 		// 获取下载链接
-		var durl string
-		var apierr *apierror.ApiError
-		if der.familyId > 0 {
-			durl, apierr = der.panClient.AppFamilyGetFileDownloadUrl(der.familyId, der.fileInfo.FileId)
-		} else {
-			durl, apierr = der.panClient.AppGetFileDownloadUrl(der.fileInfo.FileId)
-		}
+		durl, err := der.downloadUrl()
 		time.Sleep(time.Duration(200) * time.Millisecond)
-		if apierr != nil {
+		if err != nil {
 			logger.Verbosef("ERROR: get download url error: %s\n", der.fileInfo.FileId)
 			continue
 		}
@@ -394,6 +414,7 @@ func (der *Downloader) Execute() error {
 		worker := NewWorker(k, der.familyId, der.fileInfo.FileId, durl, writer)
 		worker.SetClient(client)
 		worker.SetPanClient(der.panClient)
+		worker.SetDownloadUrlFunc(der.downloadUrlFunc)
 		worker.SetWriteMutex(writeMu)
 		worker.SetTotalSize(der.fileInfo.FileSize)
 
@@ -14,12 +14,14 @@
 package downloader
 
 import (
+	"bytes"
 	"errors"
 	"github.com/json-iterator/go"
 	"github.com/tickstep/cloudpan189-go/library/requester/transfer"
 	"github.com/tickstep/library-go/cachepool"
 	"github.com/tickstep/library-go/crypto"
 	"github.com/tickstep/library-go/logger"
+	"io/ioutil"
 	"os"
 	"sync"
 )
@@ -28,8 +30,10 @@ type (
 	//InstanceState 状态, 断点续传信息
 	InstanceState struct {
 		saveFile *os.File
+		savePath string // 断点续传文件路径, 不为空时二进制格式通过替换文件的方式写入
 		format   InstanceStateStorageFormat
 		ii       *transfer.DownloadInstanceInfoExport
+		lastData []byte // 上一次写入的内容, 没有变化时不重复写入
 		mu       sync.Mutex
 	}
 
@@ -40,7 +44,7 @@ type (
 const (
 	// InstanceStateStorageFormatJSON json 格式
 	InstanceStateStorageFormatJSON = iota
-	// InstanceStateStorageFormatProto3 protobuf 格式
+	// InstanceStateStorageFormatProto3 紧凑的二进制格式, 带版本号和校验值
 	InstanceStateStorageFormatProto3
 )
 
@@ -75,7 +79,7 @@ func (is *InstanceState) getSaveFileContents() []byte {
 	buf := cachepool.RawMallocByteSlice(intSize)
 
 	n, _ := is.saveFile.ReadAt(buf, 0)
-	return crypto.Base64Decode(buf[:n])
+	return buf[:n]
 }
 
 // Get 获取断点续传信息
@@ -94,7 +98,12 @@ func (is *InstanceState) Get() (eii *transfer.DownloadInstanceInfo) {
 
 	is.ii = &transfer.DownloadInstanceInfoExport{}
 	var err error
-	err = jsoniter.Unmarshal(contents, is.ii)
+	if isBinaryInstanceState(contents) {
+		err = unmarshalInstanceStateBinary(contents, is.ii)
+	} else {
+		// 兼容旧版本的 json 格式
+		err = jsoniter.Unmarshal(crypto.Base64Decode(contents), is.ii)
+	}
 
 	if err != nil {
 		logger.Verbosef("DEBUG: InstanceInfo unmarshal error: %s\n", err)
@@ -118,24 +127,72 @@ func (is *InstanceState) Put(eii *transfer.DownloadInstanceInfo) {
 		is.ii = &transfer.DownloadInstanceInfoExport{}
 	}
 	is.ii.SetInstanceInfo(eii)
-	var (
-		data []byte
-		err  error
-	)
-	data, err = jsoniter.Marshal(is.ii)
+
+	var data []byte
+	switch is.format {
+	case InstanceStateStorageFormatProto3:
+		data = marshalInstanceStateBinary(is.ii)
+	default:
+		jsonData, err := jsoniter.Marshal(is.ii)
+		if err != nil {
+			panic(err)
+		}
+		data = crypto.Base64Encode(jsonData)
+	}
+	if bytes.Equal(data, is.lastData) {
+		return
+	}
+
+	var err error
+	if is.format == InstanceStateStorageFormatProto3 && is.savePath != "" {
+		err = is.replaceSaveFile(data)
+		if err != nil {
+			// 无法替换打开中的文件(如windows), 改为直接写入, 由校验值保证不读取写入一半的内容
+			logger.Verbosef("DEBUG: replace instance state file error: %s\n", err)
+			is.savePath = ""
+			err = is.writeSaveFile(data)
+		}
+	} else {
+		err = is.writeSaveFile(data)
+	}
 	if err != nil {
-		panic(err)
+		logger.Verbosef("DEBUG: write instance state error: %s\n", err)
+		return
 	}
+	is.lastData = data
+}
 
-	err = is.saveFile.Truncate(int64(len(data)))
+// writeSaveFile 直接覆盖写入断点续传文件
+func (is *InstanceState) writeSaveFile(data []byte) error {
+	err := is.saveFile.Truncate(int64(len(data)))
 	if err != nil {
 		logger.Verbosef("DEBUG: truncate file error: %s\n", err)
 	}
+	_, err = is.saveFile.WriteAt(data, 0)
+	return err
+}
 
-	_, err = is.saveFile.WriteAt(crypto.Base64Encode(data), 0)
+// replaceSaveFile 先写入临时文件再替换断点续传文件, 中断时不会留下写入一半的文件
+func (is *InstanceState) replaceSaveFile(data []byte) error {
+	tmpPath := is.savePath + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, data, 0666); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, is.savePath); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	// 原来打开的文件已被替换, 重新打开, 之后的读取和直接写入都作用于新文件
+	f, err := os.OpenFile(is.savePath, os.O_RDWR, 0)
 	if err != nil {
-		logger.Verbosef("DEBUG: write instance state error: %s\n", err)
+		// 内容已写入, 下次仍通过替换文件写入
+		logger.Verbosef("DEBUG: reopen instance state file error: %s\n", err)
+		return nil
 	}
+	is.saveFile.Close()
+	is.saveFile = f
+	return nil
 }
 
 // Close 关闭
@@ -161,12 +218,14 @@ func (der *Downloader) initInstanceState(format InstanceStateStorageFormat) (err
 	}
 
 	der.instanceState = NewInstanceState(saveFile, format)
+	der.instanceState.savePath = der.config.InstanceStatePath
 	return nil
 }
 
 func (der *Downloader) removeInstanceState() error {
 	der.instanceState.Close()
 	if der.config.InstanceStatePath != "" {
+		os.Remove(der.config.InstanceStatePath + ".tmp")
 		return os.Remove(der.config.InstanceStatePath)
 	}
 	return nil
@@ -0,0 +1,131 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package downloader
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+
+	"github.com/tickstep/cloudpan189-go/library/requester/transfer"
+)
+
+// 二进制断点续传文件格式:
+//
+//	magic(4) | version(1) | payload | crc32(4)
+//
+// payload 依次为 rangeGenMode, totalSize, genBegin, blockSize, range 数量, 各 range 的 begin 和 end, 均为 varint 编码.
+// crc32 为 magic 到 payload 结尾的 IEEE 校验值, 写入不完整的文件校验失败, 不会被当作有效的断点信息.
+const (
+	instanceStateBinaryVersion = 1
+)
+
+var (
+	instanceStateBinaryMagic = []byte("C189")
+
+	// ErrInstanceStateChecksum 断点续传文件校验失败
+	ErrInstanceStateChecksum = errors.New("instance state checksum mismatch")
+	// ErrInstanceStateVersion 断点续传文件版本不支持
+	ErrInstanceStateVersion = errors.New("unsupported instance state version")
+)
+
+// isBinaryInstanceState 是否为二进制格式的断点续传文件
+func isBinaryInstanceState(data []byte) bool {
+	return bytes.HasPrefix(data, instanceStateBinaryMagic)
+}
+
+// marshalInstanceStateBinary 编码断点续传信息
+func marshalInstanceStateBinary(ii *transfer.DownloadInstanceInfoExport) []byte {
+	buf := make([]byte, 0, 64+len(ii.Ranges)*2*binary.MaxVarintLen64)
+	buf = append(buf, instanceStateBinaryMagic...)
+	buf = append(buf, instanceStateBinaryVersion)
+
+	var tmp [binary.MaxVarintLen64]byte
+	putVarint := func(v int64) {
+		n := binary.PutVarint(tmp[:], v)
+		buf = append(buf, tmp[:n]...)
+	}
+	putVarint(int64(ii.RangeGenMode))
+	putVarint(ii.TotalSize)
+	putVarint(ii.GenBegin)
+	putVarint(ii.BlockSize)
+
+	n := binary.PutUvarint(tmp[:], uint64(len(ii.Ranges)))
+	buf = append(buf, tmp[:n]...)
+	for _, r := range ii.Ranges {
+		if r == nil {
+			putVarint(0)
+			putVarint(0)
+			continue
+		}
+		putVarint(r.LoadBegin())
+		putVarint(r.LoadEnd())
+	}
+
+	var sum [4]byte
+	binary.BigEndian.PutUint32(sum[:], crc32.ChecksumIEEE(buf))
+	return append(buf, sum[:]...)
+}
+
+// unmarshalInstanceStateBinary 解码断点续传信息, 并校验完整性
+func unmarshalInstanceStateBinary(data []byte, ii *transfer.DownloadInstanceInfoExport) error {
+	headerLen := len(instanceStateBinaryMagic) + 1
+	if len(data) < headerLen+4 {
+		return ErrInstanceStateChecksum
+	}
+	body, sum := data[:len(data)-4], data[len(data)-4:]
+	if crc32.ChecksumIEEE(body) != binary.BigEndian.Uint32(sum) {
+		return ErrInstanceStateChecksum
+	}
+	if body[headerLen-1] != instanceStateBinaryVersion {
+		return ErrInstanceStateVersion
+	}
+
+	r := bytes.NewReader(body[headerLen:])
+	var err error
+	readVarint := func() int64 {
+		if err != nil {
+			return 0
+		}
+		var v int64
+		v, err = binary.ReadVarint(r)
+		return v
+	}
+	ii.RangeGenMode = transfer.RangeGenMode(readVarint())
+	ii.TotalSize = readVarint()
+	ii.GenBegin = readVarint()
+	ii.BlockSize = readVarint()
+	if err != nil {
+		return err
+	}
+
+	count, err := binary.ReadUvarint(r)
+	if err != nil {
+		return err
+	}
+	// 每个 range 至少占用 2 字节
+	if count > uint64(r.Len()/2) {
+		return ErrInstanceStateChecksum
+	}
+	ii.Ranges = make([]*transfer.Range, 0, count)
+	for i := uint64(0); i < count; i++ {
+		begin, end := readVarint(), readVarint()
+		if err != nil {
+			return err
+		}
+		ii.Ranges = append(ii.Ranges, &transfer.Range{Begin: begin, End: end})
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package downloader
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/tickstep/cloudpan189-go/library/requester/transfer"
+)
+
+func TestInstanceStateBinaryRoundTrip(t *testing.T) {
+	tests := []*transfer.DownloadInstanceInfoExport{
+		{},
+		{
+			RangeGenMode: transfer.RangeGenMode_BlockSize,
+			TotalSize:    1 << 40,
+			GenBegin:     4096,
+			BlockSize:    1 << 20,
+			Ranges: []*transfer.Range{
+				{Begin: 0, End: 1 << 20},
+				{Begin: 1 << 20, End: 1 << 21},
+				{Begin: 1<<40 - 1, End: 1 << 40},
+			},
+		},
+		{
+			RangeGenMode: transfer.RangeGenMode_Default,
+			TotalSize:    10,
+			Ranges:       []*transfer.Range{{Begin: 3, End: 10}},
+		},
+	}
+	for i, want := range tests {
+		data := marshalInstanceStateBinary(want)
+		if !isBinaryInstanceState(data) {
+			t.Fatalf("case %d: missing magic", i)
+		}
+		got := &transfer.DownloadInstanceInfoExport{}
+		if err := unmarshalInstanceStateBinary(data, got); err != nil {
+			t.Fatalf("case %d: unmarshal: %s", i, err)
+		}
+		if want.Ranges == nil {
+			want.Ranges = []*transfer.Range{}
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("case %d: got %+v, want %+v", i, got, want)
+		}
+	}
+}
+
+func TestInstanceStateBinaryCorrupted(t *testing.T) {
+	data := marshalInstanceStateBinary(&transfer.DownloadInstanceInfoExport{
+		TotalSize: 100,
+		Ranges:    []*transfer.Range{{Begin: 0, End: 50}, {Begin: 50, End: 100}},
+	})
+
+	flipped := append([]byte(nil), data...)
+	flipped[len(instanceStateBinaryMagic)+3] ^= 0xff
+
+	// 版本号不支持但校验值正确
+	badVersion := append([]byte(nil), data[:len(data)-4]...)
+	badVersion[len(instanceStateBinaryMagic)] = instanceStateBinaryVersion + 1
+	badVersion = binary.BigEndian.AppendUint32(badVersion, crc32.ChecksumIEEE(badVersion))
+
+	tests := []struct {
+		name string
+		data []byte
+		want error
+	}{
+		{"payload bit flip", flipped, ErrInstanceStateChecksum},
+		{"checksum bit flip", append(append([]byte(nil), data[:len(data)-1]...), data[len(data)-1]^1), ErrInstanceStateChecksum},
+		{"truncated", data[:len(data)-3], ErrInstanceStateChecksum},
+		{"header only", data[:len(instanceStateBinaryMagic)+1], ErrInstanceStateChecksum},
+		{"empty", nil, ErrInstanceStateChecksum},
+		{"version", badVersion, ErrInstanceStateVersion},
+	}
+	for _, tt := range tests {
+		err := unmarshalInstanceStateBinary(tt.data, &transfer.DownloadInstanceInfoExport{})
+		if err != tt.want {
+			t.Errorf("%s: err = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestInstanceStateReplaceFallback(t *testing.T) {
+	savePath := filepath.Join(t.TempDir(), "state")
+	f, err := os.OpenFile(savePath, os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	is := NewInstanceState(f, InstanceStateStorageFormatProto3)
+	is.savePath = savePath
+	defer is.Close()
+
+	put := func(begin int64) {
+		status := transfer.NewDownloadStatus()
+		status.SetTotalSize(100)
+		is.Put(&transfer.DownloadInstanceInfo{
+			DownloadStatus: status,
+			Ranges:         transfer.RangeList{{Begin: begin, End: 100}},
+		})
+	}
+	read := func() *transfer.DownloadInstanceInfoExport {
+		data, err := os.ReadFile(savePath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ii := &transfer.DownloadInstanceInfoExport{}
+		if err := unmarshalInstanceStateBinary(data, ii); err != nil {
+			t.Fatalf("unmarshal: %s", err)
+		}
+		return ii
+	}
+
+	put(10)
+	if ii := read(); len(ii.Ranges) != 1 || ii.Ranges[0].Begin != 10 {
+		t.Fatalf("replaced state = %+v", ii)
+	}
+
+	// 临时文件无法创建时改为直接写入, 写入的必须是替换后的文件
+	if err := os.Mkdir(savePath+".tmp", 0755); err != nil {
+		t.Fatal(err)
+	}
+	put(20)
+	if ii := read(); len(ii.Ranges) != 1 || ii.Ranges[0].Begin != 20 {
+		t.Errorf("fallback state = %+v", ii)
+	}
+	if eii := is.Get(); eii == nil || len(eii.Ranges) != 1 || eii.Ranges[0].Begin != 20 {
+		t.Errorf("Get after fallback = %+v", eii)
+	}
+}
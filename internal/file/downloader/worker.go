@@ -42,6 +42,7 @@ type (
 		acceptRanges string
 		panClient    *cloudpan.PanClient
 		client       *requester.HTTPClient
+		durlFunc     DownloadUrlFunc // 获取下载链接的函数, 为空时下载网盘文件
 		writerAt     io.WriterAt
 		writeMu      *sync.Mutex
 		execMu       sync.Mutex
@@ -206,11 +207,25 @@ func (wer *Worker) Reset() {
 	go wer.Execute()
 }
 
+// SetDownloadUrlFunc 设置获取下载链接的函数
+func (wer *Worker) SetDownloadUrlFunc(f DownloadUrlFunc) {
+	wer.durlFunc = f
+}
+
 // RefreshDownloadUrl 重新刷新下载链接
 func (wer *Worker) RefreshDownloadUrl() {
 	var durl string
 	var apierr *apierror.ApiError
 
+	if wer.durlFunc != nil {
+		durl, err := wer.durlFunc()
+		if err != nil {
+			wer.status.statusCode = StatusCodeTooManyConnections
+			return
+		}
+		wer.url = durl
+		return
+	}
 	if wer.familyId > 0 {
 		durl, apierr = wer.panClient.AppFamilyGetFileDownloadUrl(wer.familyId, wer.fileId)
 	} else {
@@ -298,17 +313,25 @@ func (wer *Worker) Execute() {
 	wer.status.statusCode = StatusCodePending
 
 	var resp *http.Response
+	var apierr *apierror.ApiError
 
-	apierr := wer.panClient.AppDownloadFileData(wer.url, cloudpan.AppFileDownloadRange{
-		Offset: wer.wrange.Begin,
-		End:    wer.wrange.End - 1,
-	}, func(httpMethod, fullUrl string, headers map[string]string) (*http.Response, error) {
-		resp, wer.err = wer.client.Req(httpMethod, fullUrl, nil, headers)
-		if wer.err != nil {
-			return nil, wer.err
-		}
-		return resp, wer.err
-	})
+	if wer.durlFunc != nil {
+		// 下载链接不需要签名, 直接请求
+		resp, wer.err = wer.client.Req(http.MethodGet, wer.url, nil, map[string]string{
+			"range": fmt.Sprintf("bytes=%d-%d", wer.wrange.Begin, wer.wrange.End-1),
+		})
+	} else {
+		apierr = wer.panClient.AppDownloadFileData(wer.url, cloudpan.AppFileDownloadRange{
+			Offset: wer.wrange.Begin,
+			End:    wer.wrange.End - 1,
+		}, func(httpMethod, fullUrl string, headers map[string]string) (*http.Response, error) {
+			resp, wer.err = wer.client.Req(httpMethod, fullUrl, nil, headers)
+			if wer.err != nil {
+				return nil, wer.err
+			}
+			return resp, wer.err
+		})
+	}
 
 	if resp != nil {
 		defer func() {
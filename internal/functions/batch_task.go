@@ -0,0 +1,275 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package functions
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tickstep/cloudpan189-api/cloudpan"
+	"github.com/tickstep/cloudpan189-api/cloudpan/apierror"
+	"github.com/tickstep/library-go/logger"
+	"github.com/tickstep/library-go/requester"
+)
+
+const (
+	// DefaultBatchTaskTimeout 默认等待批量任务完成的最长时间
+	DefaultBatchTaskTimeout = 5 * time.Minute
+
+	// batchTaskStatusConflict 目标目录存在同名文件, 等待处理
+	batchTaskStatusConflict = cloudpan.BatchTaskStatusNotAction
+	// batchTaskMaxCheckErrors 连续查询失败的最大次数
+	batchTaskMaxCheckErrors = 3
+	// batchTaskMaxConflictChecks 处理同名文件后任务仍在等待处理时, 最多再查询的次数
+	batchTaskMaxConflictChecks = 5
+)
+
+const (
+	// BatchConflictNone 不处理同名文件, 计为冲突
+	BatchConflictNone BatchConflictPolicy = iota
+	// BatchConflictSkip 跳过同名文件
+	BatchConflictSkip
+	// BatchConflictRename 保留两个文件, 新文件自动重命名
+	BatchConflictRename
+	// BatchConflictOverwrite 覆盖同名文件
+	BatchConflictOverwrite
+)
+
+type (
+	// BatchConflictPolicy 批量任务中目标目录已存在同名文件时的处理策略
+	BatchConflictPolicy int
+
+	// BatchTaskOptions 执行批量任务的参数
+	BatchTaskOptions struct {
+		FamilyId   int64                   // 家庭云ID, 0 表示个人云
+		Timeout    time.Duration           // 等待任务完成的最长时间, 0 表示使用默认值
+		OnConflict BatchConflictPolicy     // 同名文件的处理策略
+		WebToken   *cloudpan.WebLoginToken // 处理同名文件需要使用网页版接口, 为空时不处理
+		AppApi     bool                    // 任务由客户端接口创建, 需要使用客户端接口查询
+	}
+
+	// BatchTaskResult 批量任务的结果
+	BatchTaskResult struct {
+		TaskId    string
+		Finished  bool // 任务是否已经完成
+		Succeeded int
+		Failed    int
+		Skipped   int
+		Conflicts int // 未处理的同名文件数量
+	}
+
+	// batchTaskResp 网页版接口的通用响应, res_code 可能是数字或字符串
+	batchTaskResp struct {
+		ResCode    interface{} `json:"res_code"`
+		ResMessage string      `json:"res_message"`
+	}
+
+	batchConflictTaskInfo struct {
+		FileId      string `json:"fileId"`
+		FileName    string `json:"fileName"`
+		IsConflict  int    `json:"isConflict"`
+		IsFolder    int    `json:"isFolder"`
+		SrcParentId string `json:"srcParentId"`
+		DealWay     int    `json:"dealWay"`
+	}
+)
+
+// ParseBatchConflictPolicy 解析批量任务的同名文件处理策略, 为空表示不处理
+func ParseBatchConflictPolicy(s string) (BatchConflictPolicy, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "":
+		return BatchConflictNone, nil
+	case "skip":
+		return BatchConflictSkip, nil
+	case "rename", "rename-new":
+		return BatchConflictRename, nil
+	case "overwrite":
+		return BatchConflictOverwrite, nil
+	}
+	return BatchConflictNone, fmt.Errorf("不支持的同名文件处理策略: %s", s)
+}
+
+// String 结果统计
+func (r *BatchTaskResult) String() string {
+	s := fmt.Sprintf("成功 %d 个, 失败 %d 个, 跳过 %d 个", r.Succeeded, r.Failed, r.Skipped)
+	if r.Conflicts > 0 {
+		s += fmt.Sprintf(", 同名文件 %d 个", r.Conflicts)
+	}
+	return s
+}
+
+// OK 任务已经完成, 并且没有失败和未处理的同名文件
+func (r *BatchTaskResult) OK() bool {
+	return r.Finished && r.Failed == 0 && r.Conflicts == 0
+}
+
+// RunBatchTask 创建批量任务并等待完成, 家庭云的删除任务使用客户端接口
+func RunBatchTask(panClient *cloudpan.PanClient, param *cloudpan.BatchTaskParam, opt BatchTaskOptions) (*BatchTaskResult, error) {
+	var (
+		taskId string
+		apierr *apierror.ApiError
+	)
+	if opt.FamilyId > 0 && param.TypeFlag == cloudpan.BatchTaskTypeDelete {
+		opt.AppApi = true
+		taskId, apierr = panClient.AppCreateBatchTask(opt.FamilyId, param)
+	} else {
+		taskId, apierr = panClient.CreateBatchTask(param)
+	}
+	if apierr != nil {
+		return nil, apierr
+	}
+	if taskId == "" {
+		return nil, errors.New("创建批量任务失败")
+	}
+	logger.Verboseln("batch task id: " + taskId)
+	return WaitBatchTask(panClient, param.TypeFlag, taskId, opt)
+}
+
+// WaitBatchTask 轮询批量任务直到完成或超时, 查询间隔逐渐增加.
+// 目标目录存在同名文件时按 opt.OnConflict 处理, 不处理时返回同名文件的数量
+func WaitBatchTask(panClient *cloudpan.PanClient, typeFlag cloudpan.BatchTaskType, taskId string, opt BatchTaskOptions) (*BatchTaskResult, error) {
+	if opt.Timeout <= 0 {
+		opt.Timeout = DefaultBatchTaskTimeout
+	}
+	result := &BatchTaskResult{TaskId: taskId}
+	interval := 200 * time.Millisecond
+	deadline := time.Now().Add(opt.Timeout)
+	checkErrors := 0
+	conflictHandled := false
+	conflictChecks := 0
+	for {
+		time.Sleep(interval)
+		if interval < 3*time.Second {
+			interval = interval * 3 / 2
+		}
+
+		var (
+			r      *cloudpan.CheckTaskResult
+			apierr *apierror.ApiError
+		)
+		if opt.AppApi {
+			r, apierr = panClient.AppCheckBatchTask(typeFlag, taskId)
+		} else {
+			r, apierr = panClient.CheckBatchTask(typeFlag, taskId)
+		}
+		if apierr != nil {
+			checkErrors++
+			if checkErrors >= batchTaskMaxCheckErrors {
+				return result, fmt.Errorf("查询批量任务失败: %s", apierr)
+			}
+			continue
+		}
+		checkErrors = 0
+		result.Succeeded, result.Failed, result.Skipped = r.SuccessedCount, r.FailedCount, r.SkipCount
+
+		switch r.TaskStatus {
+		case cloudpan.BatchTaskStatusOk:
+			result.Finished = true
+			return result, nil
+		case batchTaskStatusConflict:
+			if opt.OnConflict == BatchConflictNone || opt.WebToken == nil {
+				result.Finished = true
+				result.Conflicts = conflictCount(r)
+				return result, nil
+			}
+			// 处理后任务继续执行, 状态可能短时间内保持不变, 多次查询仍未继续时不再等待
+			if !conflictHandled {
+				if err := resolveBatchConflict(opt, typeFlag, taskId); err != nil {
+					return result, err
+				}
+				conflictHandled = true
+			} else {
+				conflictChecks++
+				if conflictChecks >= batchTaskMaxConflictChecks {
+					result.Conflicts = conflictCount(r)
+					return result, errors.New("同名文件处理后任务仍未继续, 请稍后检查目标目录")
+				}
+			}
+		}
+
+		if time.Now().After(deadline) {
+			return result, errors.New("等待任务完成超时, 任务仍在后台进行")
+		}
+	}
+}
+
+// conflictCount 任务中未处理的同名文件数量
+func conflictCount(r *cloudpan.CheckTaskResult) int {
+	if n := r.SubTaskCount - r.SuccessedCount - r.FailedCount - r.SkipCount; n > 0 {
+		return n
+	}
+	return 1
+}
+
+// resolveBatchConflict 按策略处理批量任务中的同名文件, 使用网页版接口
+func resolveBatchConflict(opt BatchTaskOptions, typeFlag cloudpan.BatchTaskType, taskId string) error {
+	client := NewWebClient(*opt.WebToken)
+	post := map[string]string{
+		"type":   string(typeFlag),
+		"taskId": taskId,
+	}
+	if opt.FamilyId > 0 {
+		post["familyId"] = strconv.FormatInt(opt.FamilyId, 10)
+	}
+
+	info := &struct {
+		batchTaskResp
+		TaskInfos      []*batchConflictTaskInfo `json:"taskInfos"`
+		TargetFolderId json.Number              `json:"targetFolderId"`
+	}{}
+	if err := batchTaskPost(client, "/api/open/batch/getConflictTaskInfo.action", post, info); err != nil {
+		return fmt.Errorf("获取同名文件失败: %s", err)
+	}
+	for _, ti := range info.TaskInfos {
+		ti.DealWay = int(opt.OnConflict)
+	}
+	taskInfos, err := json.Marshal(info.TaskInfos)
+	if err != nil {
+		return err
+	}
+	post["targetFolderId"] = info.TargetFolderId.String()
+	post["taskInfos"] = string(taskInfos)
+	if err = batchTaskPost(client, "/api/open/batch/manageBatchTask.action", post, &batchTaskResp{}); err != nil {
+		return fmt.Errorf("处理同名文件失败: %s", err)
+	}
+	return nil
+}
+
+// batchTaskPost 请求网页版批量任务接口, 检查 res_code 后解析响应
+func batchTaskPost(client *requester.HTTPClient, api string, post map[string]string, v interface{}) error {
+	header := map[string]string{
+		"accept":       "application/json;charset=UTF-8",
+		"Content-Type": "application/x-www-form-urlencoded; charset=UTF-8",
+	}
+	body, err := client.Fetch("POST", cloudpan.WEB_URL+api, post, header)
+	if err != nil {
+		return err
+	}
+	resp := &batchTaskResp{}
+	if err = json.Unmarshal(body, resp); err != nil {
+		logger.Verboseln("batch task response: " + string(body))
+		return err
+	}
+	if code := fmt.Sprint(resp.ResCode); code != "0" && code != "<nil>" {
+		if resp.ResMessage != "" {
+			return errors.New(resp.ResMessage)
+		}
+		return fmt.Errorf("接口返回错误: %s", code)
+	}
+	return json.Unmarshal(body, v)
+}
@@ -0,0 +1,81 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package functions
+
+import (
+	"testing"
+
+	"github.com/tickstep/cloudpan189-api/cloudpan"
+)
+
+func TestParseBatchConflictPolicy(t *testing.T) {
+	testCases := []struct {
+		s    string
+		want BatchConflictPolicy
+		ok   bool
+	}{
+		{"", BatchConflictNone, true},
+		{"skip", BatchConflictSkip, true},
+		{" Skip ", BatchConflictSkip, true},
+		{"rename", BatchConflictRename, true},
+		{"rename-new", BatchConflictRename, true},
+		{"OVERWRITE", BatchConflictOverwrite, true},
+		{"replace", BatchConflictNone, false},
+	}
+	for _, c := range testCases {
+		got, err := ParseBatchConflictPolicy(c.s)
+		if (err == nil) != c.ok || got != c.want {
+			t.Errorf("ParseBatchConflictPolicy(%q) = %v, %v, want %v, ok %v", c.s, got, err, c.want, c.ok)
+		}
+	}
+}
+
+func TestConflictCount(t *testing.T) {
+	testCases := []struct {
+		r    cloudpan.CheckTaskResult
+		want int
+	}{
+		{cloudpan.CheckTaskResult{SubTaskCount: 10, SuccessedCount: 5, FailedCount: 1, SkipCount: 1}, 3},
+		// 子任务数量不可用时至少有一个同名文件
+		{cloudpan.CheckTaskResult{SubTaskCount: 0}, 1},
+		{cloudpan.CheckTaskResult{SubTaskCount: 3, SuccessedCount: 3}, 1},
+	}
+	for _, c := range testCases {
+		if got := conflictCount(&c.r); got != c.want {
+			t.Errorf("conflictCount(%+v) = %d, want %d", c.r, got, c.want)
+		}
+	}
+}
+
+func TestBatchTaskResult(t *testing.T) {
+	testCases := []struct {
+		r   BatchTaskResult
+		str string
+		ok  bool
+	}{
+		{BatchTaskResult{Finished: true, Succeeded: 3}, "成功 3 个, 失败 0 个, 跳过 0 个", true},
+		{BatchTaskResult{Finished: true, Succeeded: 2, Skipped: 1}, "成功 2 个, 失败 0 个, 跳过 1 个", true},
+		{BatchTaskResult{Finished: true, Succeeded: 2, Failed: 1}, "成功 2 个, 失败 1 个, 跳过 0 个", false},
+		{BatchTaskResult{Finished: true, Succeeded: 1, Conflicts: 2}, "成功 1 个, 失败 0 个, 跳过 0 个, 同名文件 2 个", false},
+		{BatchTaskResult{Succeeded: 1}, "成功 1 个, 失败 0 个, 跳过 0 个", false},
+	}
+	for _, c := range testCases {
+		if got := c.r.String(); got != c.str {
+			t.Errorf("%+v String() = %q, want %q", c.r, got, c.str)
+		}
+		if got := c.r.OK(); got != c.ok {
+			t.Errorf("%+v OK() = %v, want %v", c.r, got, c.ok)
+		}
+	}
+}
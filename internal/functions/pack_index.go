@@ -0,0 +1,110 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package functions
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	jsoniter "github.com/json-iterator/go"
+)
+
+const (
+	// PackSegmentPrefix 小文件打包的 tar 文件名前缀
+	PackSegmentPrefix = "cloudpan189-pack-"
+	// PackSegmentExt 小文件打包的 tar 文件扩展名
+	PackSegmentExt = ".tar"
+	// PackIndexSuffix 打包索引的后缀, 索引文件名为 tar 文件名加该后缀
+	PackIndexSuffix = ".cloudpan189-pack"
+
+	// PackIndexVersion 打包索引格式版本
+	PackIndexVersion = 1
+)
+
+type (
+	// PackMember 打包的文件, Offset 为文件数据在 tar 文件中的偏移
+	PackMember struct {
+		Name    string `json:"name"`
+		Offset  int64  `json:"offset"`
+		Size    int64  `json:"size"`
+		MD5     string `json:"md5"`
+		ModTime int64  `json:"modtime"`
+	}
+
+	// PackIndex 打包索引, 和 tar 文件保存在同一目录
+	PackIndex struct {
+		Version int           `json:"version"`
+		Segment string        `json:"segment"` // tar 文件名
+		Size    int64         `json:"size"`    // tar 文件大小
+		Members []*PackMember `json:"members"`
+	}
+)
+
+var (
+	// ErrPackIndexInvalid 打包索引无效
+	ErrPackIndexInvalid = errors.New("打包索引无效")
+)
+
+// PackSegmentName 打包文件名, 如 cloudpan189-pack-20210101120000-0001.tar
+func PackSegmentName(stamp string, seq int) string {
+	return fmt.Sprintf("%s%s-%04d%s", PackSegmentPrefix, stamp, seq, PackSegmentExt)
+}
+
+// IsPackIndexName 是否为打包索引文件名
+func IsPackIndexName(name string) bool {
+	return strings.HasPrefix(name, PackSegmentPrefix) && strings.HasSuffix(name, PackSegmentExt+PackIndexSuffix)
+}
+
+// IsPackFileName 是否为打包上传生成的 tar 文件或索引文件名
+func IsPackFileName(name string) bool {
+	return strings.HasPrefix(name, PackSegmentPrefix) && (strings.HasSuffix(name, PackSegmentExt) || IsPackIndexName(name))
+}
+
+// PackSegmentOfIndex 打包索引对应的 tar 文件名
+func PackSegmentOfIndex(indexName string) string {
+	return strings.TrimSuffix(indexName, PackIndexSuffix)
+}
+
+// Member 按文件名查找打包的文件
+func (pi *PackIndex) Member(name string) *PackMember {
+	for _, m := range pi.Members {
+		if m.Name == name {
+			return m
+		}
+	}
+	return nil
+}
+
+// ParsePackIndex 解析并检查打包索引
+func ParsePackIndex(data []byte) (*PackIndex, error) {
+	pi := &PackIndex{}
+	if err := jsoniter.Unmarshal(data, pi); err != nil {
+		return nil, err
+	}
+	if pi.Version > PackIndexVersion {
+		return nil, fmt.Errorf("不支持的打包索引版本: %d", pi.Version)
+	}
+	if pi.Segment == "" || strings.ContainsAny(pi.Segment, "/\\") || pi.Size < 0 {
+		return nil, ErrPackIndexInvalid
+	}
+	for _, m := range pi.Members {
+		// 分开比较偏移和大小, 避免 Offset+Size 溢出
+		if m == nil || m.Name == "" || m.Name == "." || m.Name == ".." || strings.ContainsAny(m.Name, "/\\") ||
+			m.Offset < 0 || m.Size < 0 || m.Size > pi.Size || m.Offset > pi.Size-m.Size {
+			return nil, ErrPackIndexInvalid
+		}
+	}
+	return pi, nil
+}
@@ -0,0 +1,90 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package functions
+
+import (
+	"math"
+	"strconv"
+	"testing"
+)
+
+func TestParsePackIndex(t *testing.T) {
+	testCases := []struct {
+		name string
+		data string
+		ok   bool
+	}{
+		{"valid", `{"version":1,"segment":"a.tar","size":2048,"members":[{"name":"a.txt","offset":512,"size":10}]}`, true},
+		{"empty members", `{"version":1,"segment":"a.tar","size":0,"members":[]}`, true},
+		{"member at end", `{"version":1,"segment":"a.tar","size":522,"members":[{"name":"a.txt","offset":512,"size":10}]}`, true},
+		{"newer version", `{"version":2,"segment":"a.tar","size":2048,"members":[]}`, false},
+		{"invalid json", `{"version":1,`, false},
+		{"empty segment", `{"version":1,"segment":"","size":2048,"members":[]}`, false},
+		{"segment with slash", `{"version":1,"segment":"../a.tar","size":2048,"members":[]}`, false},
+		{"segment with backslash", `{"version":1,"segment":"a\\b.tar","size":2048,"members":[]}`, false},
+		{"negative size", `{"version":1,"segment":"a.tar","size":-1,"members":[]}`, false},
+		{"nil member", `{"version":1,"segment":"a.tar","size":2048,"members":[null]}`, false},
+		{"empty name", `{"version":1,"segment":"a.tar","size":2048,"members":[{"name":"","offset":512,"size":10}]}`, false},
+		{"dot name", `{"version":1,"segment":"a.tar","size":2048,"members":[{"name":".","offset":512,"size":10}]}`, false},
+		{"dot dot name", `{"version":1,"segment":"a.tar","size":2048,"members":[{"name":"..","offset":512,"size":10}]}`, false},
+		{"name with slash", `{"version":1,"segment":"a.tar","size":2048,"members":[{"name":"../a.txt","offset":512,"size":10}]}`, false},
+		{"negative offset", `{"version":1,"segment":"a.tar","size":2048,"members":[{"name":"a.txt","offset":-1,"size":10}]}`, false},
+		{"negative member size", `{"version":1,"segment":"a.tar","size":2048,"members":[{"name":"a.txt","offset":512,"size":-10}]}`, false},
+		{"member past end", `{"version":1,"segment":"a.tar","size":521,"members":[{"name":"a.txt","offset":512,"size":10}]}`, false},
+		{"offset overflow", `{"version":1,"segment":"a.tar","size":2048,"members":[{"name":"a.txt","offset":` +
+			strconv.FormatInt(math.MaxInt64, 10) + `,"size":1}]}`, false},
+		{"size overflow", `{"version":1,"segment":"a.tar","size":2048,"members":[{"name":"a.txt","offset":1,"size":` +
+			strconv.FormatInt(math.MaxInt64, 10) + `}]}`, false},
+	}
+	for _, c := range testCases {
+		pi, err := ParsePackIndex([]byte(c.data))
+		if (err == nil) != c.ok {
+			t.Errorf("%s: ParsePackIndex err = %v, want ok %v", c.name, err, c.ok)
+		}
+		if err == nil && pi == nil {
+			t.Errorf("%s: ParsePackIndex returned nil index", c.name)
+		}
+	}
+}
+
+func TestPackNames(t *testing.T) {
+	segment := PackSegmentName("20210101120000", 1)
+	if segment != "cloudpan189-pack-20210101120000-0001.tar" {
+		t.Errorf("PackSegmentName = %s", segment)
+	}
+	indexName := segment + PackIndexSuffix
+	if !IsPackIndexName(indexName) {
+		t.Errorf("IsPackIndexName(%s) = false", indexName)
+	}
+	if IsPackIndexName(segment) {
+		t.Errorf("IsPackIndexName(%s) = true", segment)
+	}
+	if IsPackIndexName("a.tar" + PackIndexSuffix) {
+		t.Errorf("IsPackIndexName without prefix = true")
+	}
+	if !IsPackFileName(segment) || !IsPackFileName(indexName) || IsPackFileName("a.tar") {
+		t.Errorf("IsPackFileName mismatch")
+	}
+	if got := PackSegmentOfIndex(indexName); got != segment {
+		t.Errorf("PackSegmentOfIndex = %s, want %s", got, segment)
+	}
+
+	pi := &PackIndex{Members: []*PackMember{{Name: "a"}, {Name: "b"}}}
+	if m := pi.Member("b"); m == nil || m.Name != "b" {
+		t.Errorf("Member(b) = %v", m)
+	}
+	if m := pi.Member("c"); m != nil {
+		t.Errorf("Member(c) = %v, want nil", m)
+	}
+}
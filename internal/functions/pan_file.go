@@ -0,0 +1,132 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package functions
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/tickstep/cloudpan189-api/cloudpan"
+	"github.com/tickstep/cloudpan189-api/cloudpan/apierror"
+	"github.com/tickstep/library-go/requester"
+)
+
+// DeletePanFile 将网盘文件或目录移到回收站, 等待删除任务完成
+func DeletePanFile(panClient *cloudpan.PanClient, familyId int64, efi *cloudpan.AppFileEntity) error {
+	isFolder := 0
+	if efi.IsFolder {
+		isFolder = 1
+	}
+	delParam := &cloudpan.BatchTaskParam{
+		TypeFlag: cloudpan.BatchTaskTypeDelete,
+		TaskInfos: cloudpan.BatchTaskInfoList{
+			&cloudpan.BatchTaskInfo{
+				FileId:      efi.FileId,
+				FileName:    efi.FileName,
+				IsFolder:    isFolder,
+				SrcParentId: efi.ParentId,
+			},
+		},
+	}
+
+	result, err := RunBatchTask(panClient, delParam, BatchTaskOptions{FamilyId: familyId})
+	if err != nil {
+		return fmt.Errorf("无法删除文件，请稍后重试: %s", err)
+	}
+	if !result.OK() {
+		return fmt.Errorf("删除文件失败: %s", result)
+	}
+	return nil
+}
+
+// ReadPanFile 读取网盘文件从 offset 开始的 length 字节, length 为 0 表示读取到文件末尾
+// 只适合读取较小的内容, 如分片清单
+func ReadPanFile(panClient *cloudpan.PanClient, familyId int64, efi *cloudpan.AppFileEntity, offset, length int64) ([]byte, error) {
+	rc, err := OpenPanFile(panClient, familyId, efi, offset, length)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return ioutil.ReadAll(rc)
+}
+
+type (
+	panFileReader struct {
+		io.Reader
+		io.Closer
+	}
+)
+
+// OpenPanFile 以流的方式读取网盘文件从 offset 开始的 length 字节, length 为 0 表示读取到文件末尾
+func OpenPanFile(panClient *cloudpan.PanClient, familyId int64, efi *cloudpan.AppFileEntity, offset, length int64) (io.ReadCloser, error) {
+	var durl string
+	var apierr *apierror.ApiError
+	if familyId > 0 {
+		durl, apierr = panClient.AppFamilyGetFileDownloadUrl(familyId, efi.FileId)
+	} else {
+		durl, apierr = panClient.AppGetFileDownloadUrl(efi.FileId)
+	}
+	if apierr != nil {
+		return nil, apierr
+	}
+
+	fileRange := cloudpan.AppFileDownloadRange{Offset: offset}
+	if length > 0 {
+		fileRange.End = offset + length - 1
+	}
+	var (
+		client = requester.NewHTTPClient()
+		resp   *http.Response
+		err    error
+	)
+	client.SetTimeout(5 * time.Minute)
+	downloadFunc := func(httpMethod, fullUrl string, headers map[string]string) (*http.Response, error) {
+		resp, err = client.Req(httpMethod, fullUrl, nil, headers)
+		return resp, err
+	}
+	if familyId > 0 {
+		apierr = panClient.AppFamilyDownloadFileData(durl, fileRange, downloadFunc)
+	} else {
+		apierr = panClient.AppDownloadFileData(durl, fileRange, downloadFunc)
+	}
+	if apierr != nil {
+		if resp != nil {
+			resp.Body.Close()
+		}
+		return nil, apierr
+	}
+
+	var body io.Reader = resp.Body
+	switch resp.StatusCode {
+	case 206:
+	case 200:
+		// 服务器忽略了Range, 跳过前面的内容
+		if offset > 0 {
+			if _, err = io.CopyN(ioutil.Discard, body, offset); err != nil {
+				resp.Body.Close()
+				return nil, err
+			}
+		}
+	default:
+		resp.Body.Close()
+		return nil, fmt.Errorf("读取网盘文件失败, %s", resp.Status)
+	}
+	if length > 0 {
+		body = io.LimitReader(body, length)
+	}
+	return &panFileReader{Reader: body, Closer: resp.Body}, nil
+}
@@ -0,0 +1,130 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package pandownload
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/tickstep/cloudpan189-go/internal/localfile"
+)
+
+type (
+	// ConflictPolicy 本地已存在同名文件时的处理策略
+	ConflictPolicy string
+)
+
+const (
+	// ConflictSkip 跳过
+	ConflictSkip ConflictPolicy = "skip"
+	// ConflictOverwrite 覆盖
+	ConflictOverwrite ConflictPolicy = "overwrite"
+	// ConflictRename 重命名新下载的文件, 文件名后追加 (1)
+	ConflictRename ConflictPolicy = "rename"
+	// ConflictOverwriteIfDifferent 文件大小或MD5不同时覆盖
+	ConflictOverwriteIfDifferent ConflictPolicy = "overwrite-if-different"
+	// ConflictOverwriteIfNewer 网盘文件的修改时间比本地文件新时覆盖
+	ConflictOverwriteIfNewer ConflictPolicy = "overwrite-if-newer"
+)
+
+// ParseConflictPolicy 解析冲突处理策略, 为空则默认跳过
+func ParseConflictPolicy(s string) (ConflictPolicy, error) {
+	p := ConflictPolicy(strings.ToLower(strings.TrimSpace(s)))
+	switch p {
+	case "":
+		return ConflictSkip, nil
+	case ConflictSkip, ConflictOverwrite, ConflictRename, ConflictOverwriteIfDifferent, ConflictOverwriteIfNewer:
+		return p, nil
+	}
+	return "", fmt.Errorf("不支持的冲突处理策略: %s", s)
+}
+
+// RenameSavePath 返回不和本地已有文件重名的保存路径, 如 a.txt -> a (1).txt
+func RenameSavePath(savePath string) string {
+	dir, name := filepath.Split(savePath)
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	for i := 1; ; i++ {
+		p := filepath.Join(dir, fmt.Sprintf("%s (%d)%s", base, i, ext))
+		if _, err := os.Stat(p); os.IsNotExist(err) {
+			return p
+		}
+	}
+}
+
+// resolveConflict 处理本地已存在同名文件的情况, 返回是否需要继续下载
+func (dtu *DownloadTaskUnit) resolveConflict() bool {
+	if !FileExist(dtu.SavePath) {
+		return true
+	}
+
+	policy := dtu.OnConflict
+	if dtu.IsOverwrite {
+		policy = ConflictOverwrite
+	}
+
+	switch policy {
+	case ConflictOverwrite:
+		return true
+	case ConflictRename:
+		dtu.SavePath = RenameSavePath(dtu.SavePath)
+		fmt.Printf("[%s] 文件已经存在, 重命名为: %s\n", dtu.taskInfo.Id(), dtu.SavePath)
+		return true
+	case ConflictOverwriteIfDifferent:
+		if dtu.isLocalFileSame() {
+			fmt.Printf("[%s] 文件已经存在且内容相同: %s, 跳过...\n", dtu.taskInfo.Id(), dtu.SavePath)
+			return false
+		}
+		return true
+	case ConflictOverwriteIfNewer:
+		info, err := os.Stat(dtu.SavePath)
+		if err != nil {
+			return true
+		}
+		if modTime, ok := remoteModTime(dtu.fileInfo); ok && modTime.After(info.ModTime()) {
+			return true
+		}
+		fmt.Printf("[%s] 本地文件不比网盘文件旧: %s, 跳过...\n", dtu.taskInfo.Id(), dtu.SavePath)
+		return false
+	}
+
+	fmt.Printf("[%s] 文件已经存在: %s, 跳过...\n", dtu.taskInfo.Id(), dtu.SavePath)
+	return false
+}
+
+// isLocalFileSame 比较本地文件和网盘文件的大小和MD5是否一致
+func (dtu *DownloadTaskUnit) isLocalFileSame() bool {
+	info, err := os.Stat(dtu.SavePath)
+	if err != nil {
+		return false
+	}
+	if same, ok := dtu.isEncryptedLocalFileSame(info.Size()); ok {
+		return same
+	}
+	if info.Size() != dtu.fileInfo.FileSize {
+		return false
+	}
+	if dtu.fileInfo.FileMd5 == "" {
+		// 网盘没有MD5, 无法比较内容
+		return false
+	}
+	lfc, err := localfile.GetFileSum(dtu.SavePath, localfile.CHECKSUM_MD5)
+	if err != nil {
+		return false
+	}
+	dtu.checksumMatched = strings.EqualFold(lfc.MD5, dtu.fileInfo.FileMd5)
+	return dtu.checksumMatched
+}
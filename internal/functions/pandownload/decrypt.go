@@ -0,0 +1,208 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package pandownload
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/tickstep/cloudpan189-api/cloudpan"
+	"github.com/tickstep/cloudpan189-go/internal/functions"
+	"github.com/tickstep/cloudpan189-go/internal/localfile"
+	"github.com/tickstep/cloudpan189-go/library/crypto"
+)
+
+type (
+	// Decryption 加密网盘模式下载时的解密参数
+	Decryption struct {
+		Keychain *crypto.Keychain
+		Names    *crypto.NameCipher // 文件名的解密, 为空表示文件名未加密
+	}
+)
+
+var (
+	// ErrFileEncrypted 网盘文件已加密, 但未启用解密
+	ErrFileEncrypted = errors.New("网盘文件已加密, 请使用 --encrypt 参数并提供密码")
+)
+
+// PlainName 解密网盘文件名, 不是加密的文件名则原样返回
+func (d *Decryption) PlainName(name string) string {
+	if d == nil || d.Names == nil {
+		return name
+	}
+	if name == "" {
+		return name
+	}
+	if plain, err := d.Names.DecryptName(name); err == nil {
+		return plain
+	}
+	// 分片和分片清单的文件名为加密的原文件名加上后缀
+	base, suffix := name, ""
+	if strings.HasSuffix(name, functions.SplitManifestSuffix) {
+		base, suffix = strings.TrimSuffix(name, functions.SplitManifestSuffix), functions.SplitManifestSuffix
+	} else if b, ok := functions.SplitPartBaseName(name); ok {
+		base, suffix = b, strings.TrimPrefix(name, b)
+	}
+	if suffix != "" {
+		if plain, err := d.Names.DecryptName(base); err == nil {
+			return plain + suffix
+		}
+	}
+	return name
+}
+
+// PlainPath 逐级解密网盘路径中的文件名
+func (d *Decryption) PlainPath(panPath string) string {
+	if d == nil || d.Names == nil {
+		return panPath
+	}
+	names := strings.Split(panPath, "/")
+	for i := range names {
+		names[i] = d.PlainName(names[i])
+	}
+	return strings.Join(names, "/")
+}
+
+// EncryptName 加密网盘文件名, 未启用文件名加密时原样返回
+func (d *Decryption) EncryptName(name string) (string, error) {
+	if d == nil || d.Names == nil {
+		return name, nil
+	}
+	return d.Names.EncryptName(name)
+}
+
+// subSavePath 目录中的文件或子目录在本地的保存路径
+func (dtu *DownloadTaskUnit) subSavePath(f *cloudpan.AppFileEntity) string {
+	if dtu.Decryption == nil || dtu.Decryption.Names == nil {
+		return filepath.Join(dtu.OriginSaveRootPath, f.Path)
+	}
+	return filepath.Join(dtu.SavePath, dtu.Decryption.PlainName(f.FileName))
+}
+
+// readPanFile 读取整个网盘文件, 如分片清单和打包索引, 加密的内容会被解密
+func (dtu *DownloadTaskUnit) readPanFile(efi *cloudpan.AppFileEntity) ([]byte, error) {
+	data, err := functions.ReadPanFile(dtu.PanClient, dtu.FamilyId, efi, 0, 0)
+	if err != nil || !crypto.IsEncrypted(data) {
+		return data, err
+	}
+	if dtu.Decryption == nil {
+		return nil, ErrFileEncrypted
+	}
+	return dtu.Decryption.Keychain.DecryptBytes(data)
+}
+
+// readPanFileRange 读取网盘文件中明文从 offset 开始的 length 字节, 加密的文件只读取所在的分块
+func (dtu *DownloadTaskUnit) readPanFileRange(efi *cloudpan.AppFileEntity, offset, length int64) ([]byte, error) {
+	if dtu.Decryption == nil {
+		return functions.ReadPanFile(dtu.PanClient, dtu.FamilyId, efi, offset, length)
+	}
+	header, err := functions.ReadPanFile(dtu.PanClient, dtu.FamilyId, efi, 0, int64(crypto.StreamHeaderSize))
+	if err != nil {
+		return nil, err
+	}
+	if !crypto.IsEncrypted(header) {
+		return functions.ReadPanFile(dtu.PanClient, dtu.FamilyId, efi, offset, length)
+	}
+	sc, err := dtu.Decryption.Keychain.OpenStreamCipher(header)
+	if err != nil {
+		return nil, err
+	}
+	encOffset, encLength, firstChunk := sc.EncryptedRange(offset, length)
+	data, err := functions.ReadPanFile(dtu.PanClient, dtu.FamilyId, efi, encOffset, encLength)
+	if err != nil {
+		return nil, err
+	}
+	return sc.DecryptRange(data, firstChunk, efi.FileSize, offset, length)
+}
+
+// decryptDownloadedFile 解密已下载的文件, 明文写入临时文件后再重命名为目标文件, 不是加密文件则保持不变.
+// 解密成功后删除下载的密文临时文件, 解密失败时不修改目标文件
+func (dtu *DownloadTaskUnit) decryptDownloadedFile() (decrypted bool, err error) {
+	if dtu.Decryption == nil {
+		return false, nil
+	}
+	srcPath := dtu.downloadPath()
+	in, err := os.Open(srcPath)
+	if err != nil {
+		return false, err
+	}
+	defer in.Close()
+	header := make([]byte, crypto.StreamHeaderSize)
+	if n, _ := io.ReadFull(in, header); !crypto.IsEncrypted(header[:n]) {
+		return false, nil
+	}
+	if _, err = in.Seek(0, io.SeekStart); err != nil {
+		return false, err
+	}
+	r, err := dtu.Decryption.Keychain.NewReader(in)
+	if err != nil {
+		return false, err
+	}
+
+	tmpPath := TempSavePath(dtu.SavePath) + ".decrypt"
+	out, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0666)
+	if err != nil {
+		return false, err
+	}
+	_, err = io.Copy(out, r)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	in.Close()
+	if err == nil {
+		err = os.Rename(tmpPath, dtu.SavePath)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return false, err
+	}
+	if srcPath != dtu.SavePath {
+		os.Remove(srcPath)
+	}
+	return true, nil
+}
+
+// isEncryptedLocalFileSame 按网盘文件头部的加密参数和文件盐加密本地文件后比较密文的MD5
+func (dtu *DownloadTaskUnit) isEncryptedLocalFileSame(localSize int64) (same, handled bool) {
+	if dtu.Decryption == nil || dtu.fileInfo.FileId == "" || dtu.fileInfo.FileSize < int64(crypto.StreamHeaderSize) {
+		return false, false
+	}
+	header, err := functions.ReadPanFile(dtu.PanClient, dtu.FamilyId, dtu.fileInfo, 0, int64(crypto.StreamHeaderSize))
+	if err != nil || !crypto.IsEncrypted(header) {
+		return false, false
+	}
+	h, err := crypto.ParseHeader(header)
+	if err != nil || h.ChunkSize != crypto.DefaultChunkSize || crypto.EncryptedSizeOf(localSize) != dtu.fileInfo.FileSize {
+		return false, false
+	}
+	lfc := localfile.NewLocalFileEntity(dtu.SavePath)
+	lfc.Encryption = &localfile.FileEncryption{
+		Keychain:  dtu.Decryption.Keychain,
+		Method:    h.Method,
+		KDFParams: h.KDFParams,
+		FileSalt:  &h.FileSalt,
+	}
+	if err = lfc.OpenPath(); err != nil {
+		return false, true
+	}
+	defer lfc.Close()
+	if err = lfc.Sum(localfile.CHECKSUM_MD5); err != nil {
+		return false, true
+	}
+	dtu.checksumMatched = strings.EqualFold(lfc.MD5, dtu.fileInfo.FileMd5)
+	return dtu.checksumMatched, true
+}
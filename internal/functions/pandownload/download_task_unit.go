@@ -19,8 +19,10 @@ import (
 	"github.com/tickstep/cloudpan189-api/cloudpan"
 	"github.com/tickstep/cloudpan189-api/cloudpan/apierror"
 	"github.com/tickstep/cloudpan189-go/cmder/cmdtable"
+	"github.com/tickstep/cloudpan189-go/internal/config"
 	"github.com/tickstep/cloudpan189-go/internal/file/downloader"
 	"github.com/tickstep/cloudpan189-go/internal/functions"
+	"github.com/tickstep/cloudpan189-go/internal/functions/panjournal"
 	"github.com/tickstep/cloudpan189-go/internal/taskframework"
 	"github.com/tickstep/cloudpan189-go/internal/utils"
 	"github.com/tickstep/cloudpan189-go/library/requester/transfer"
@@ -51,10 +53,17 @@ type (
 		// 可选项
 		VerbosePrinter       *logger.CmdVerbose
 		PrintFormat          string
-		IsPrintStatus        bool // 是否输出各个下载线程的详细信息
-		IsExecutedPermission bool // 下载成功后是否加上执行权限
-		IsOverwrite          bool // 是否覆盖已存在的文件
-		NoCheck              bool // 不校验文件
+		IsPrintStatus        bool           // 是否输出各个下载线程的详细信息
+		IsExecutedPermission bool           // 下载成功后是否加上执行权限
+		IsOverwrite          bool           // 是否覆盖已存在的文件
+		OnConflict           ConflictPolicy // 本地已存在同名文件时的处理策略
+		NoCheck              bool           // 不校验文件
+		IsInPlace            bool           // 是否直接写入目标文件, 否则先下载到同目录的隐藏临时文件, 校验后再重命名
+		NoPreserveTime       bool           // 不保留网盘文件的修改时间
+		IsMove               bool           // 下载并校验成功后删除网盘文件
+		ForceProtected       bool           // 移动模式下允许删除受保护的路径
+		Decryption           *Decryption    // 加密网盘模式的解密参数, 为空表示不解密
+		Share                *ShareSource   // 下载分享中的文件, 为空表示下载网盘文件
 
 		FilePanPath        string // 要下载的网盘文件路径
 		SavePath           string // 文件保存在本地的路径
@@ -62,6 +71,14 @@ type (
 		FamilyId           int64  // 家庭云ID, 个人云默认为0
 
 		fileInfo *cloudpan.AppFileEntity // 文件或目录详情
+
+		parentFolder    *folderNode // 所在目录的节点
+		folderNode      *folderNode // 当前目录的节点, 只对目录有效
+		checksumMatched bool        // 本地文件和网盘文件的MD5是否一致
+
+		packIndexes    []*cloudpan.AppFileEntity // 目录中全部的打包索引, 由一个任务统一解包
+		packLooseNames map[string]bool           // 目录中单独上传的文件名, 不再从 tar 文件中解包同名文件
+		isPackSegment  bool                      // 整个下载用于解包的 tar 文件, 不计入下载统计
 	}
 )
 
@@ -70,6 +87,8 @@ const (
 	DefaultPrintFormat = "\r[%s] ↓ %s/%s %s/s in %s, left %s ............"
 	//DownloadSuffix 文件下载后缀
 	DownloadSuffix = ".cloudpan189-downloading"
+	// DownloadTempSuffix 下载临时文件后缀
+	DownloadTempSuffix = ".cloudpan189-tmp"
 	//StrDownloadInitError 初始化下载发生错误
 	StrDownloadInitError = "初始化下载发生错误"
 	// StrDownloadFailed 下载文件失败
@@ -92,14 +111,32 @@ func (dtu *DownloadTaskUnit) verboseInfof(format string, a ...interface{}) {
 	}
 }
 
+// downloadPath 下载数据实际写入的本地路径
+func (dtu *DownloadTaskUnit) downloadPath() string {
+	if dtu.IsInPlace {
+		return dtu.SavePath
+	}
+	return TempSavePath(dtu.SavePath)
+}
+
+// commitDownload 将已校验的临时文件重命名为目标文件
+func (dtu *DownloadTaskUnit) commitDownload() error {
+	if dtu.IsInPlace {
+		return nil
+	}
+	return os.Rename(dtu.downloadPath(), dtu.SavePath)
+}
+
 // download 执行下载
 func (dtu *DownloadTaskUnit) download() (err error) {
 	var (
-		writer downloader.Writer
-		file   *os.File
+		writer    downloader.Writer
+		file      *os.File
+		writePath = dtu.downloadPath()
 	)
 
-	dtu.Cfg.InstanceStatePath = dtu.SavePath + DownloadSuffix
+	// 断点续传信息跟随实际写入的文件
+	dtu.Cfg.InstanceStatePath = writePath + DownloadSuffix
 
 	// 创建下载的目录
 	// 获取SavePath所在的目录
@@ -117,7 +154,7 @@ func (dtu *DownloadTaskUnit) download() (err error) {
 	}
 
 	// 打开文件
-	writer, file, err = downloader.NewDownloaderWriterByFilename(dtu.SavePath, os.O_CREATE|os.O_WRONLY, 0666)
+	writer, file, err = downloader.NewDownloaderWriterByFilename(writePath, os.O_CREATE|os.O_WRONLY, 0666)
 	if err != nil {
 		return fmt.Errorf("%s, %s", StrDownloadInitError, err)
 	}
@@ -126,6 +163,9 @@ func (dtu *DownloadTaskUnit) download() (err error) {
 	der := downloader.NewDownloader(writer, dtu.Cfg, dtu.PanClient)
 	der.SetFileInfo(dtu.fileInfo)
 	der.SetFamilyId(dtu.FamilyId)
+	if dtu.Share != nil {
+		der.SetDownloadUrlFunc(dtu.Share.downloadUrlFunc(dtu.fileInfo.FileId))
+	}
 	der.SetStatusCodeBodyCheckFunc(func(respBody io.Reader) error {
 		// 解析错误
 		return apierror.NewFailedApiError("")
@@ -201,8 +241,8 @@ func (dtu *DownloadTaskUnit) download() (err error) {
 			if info, infoErr := file.Stat(); infoErr == nil {
 				if info.Size() == 0 {
 					// 空文件, 应该删除
-					dtu.verboseInfof("[%s] remove empty file: %s\n", dtu.taskInfo.Id(), dtu.SavePath)
-					removeErr := os.Remove(dtu.SavePath)
+					dtu.verboseInfof("[%s] remove empty file: %s\n", dtu.taskInfo.Id(), writePath)
+					removeErr := os.Remove(writePath)
 					if removeErr != nil {
 						dtu.verboseInfof("[%s] remove file error: %s\n", dtu.taskInfo.Id(), removeErr)
 					}
@@ -219,8 +259,6 @@ func (dtu *DownloadTaskUnit) download() (err error) {
 			fmt.Printf("[%s] 警告, 加执行权限错误: %s\n", dtu.taskInfo.Id(), err)
 		}
 	}
-	fmt.Printf("[%s] 下载完成, 保存位置: %s\n", dtu.taskInfo.Id(), dtu.SavePath)
-
 	return nil
 }
 
@@ -259,9 +297,9 @@ func (dtu *DownloadTaskUnit) handleError(result *taskframework.TaskUnitRunResult
 
 // checkFileValid 检测文件有效性
 func (dtu *DownloadTaskUnit) checkFileValid(result *taskframework.TaskUnitRunResult) (ok bool) {
-	if dtu.NoCheck {
-		// 不检测文件有效性
-		return
+	if dtu.NoCheck && !dtu.IsMove {
+		// 不检测文件有效性, 移动模式必须校验
+		return true
 	}
 
 	if dtu.fileInfo.FileSize >= 128*converter.MB {
@@ -270,13 +308,16 @@ func (dtu *DownloadTaskUnit) checkFileValid(result *taskframework.TaskUnitRunRes
 	}
 
 	// 就在这里处理校验出错
-	err := CheckFileValid(dtu.SavePath, dtu.fileInfo)
+	err := CheckFileValid(dtu.downloadPath(), dtu.fileInfo)
 	if err != nil {
 		result.ResultMessage = StrDownloadChecksumFailed
 		result.Err = err
 		switch err {
 		case ErrDownloadNotSupportChecksum:
 			// 文件不支持校验
+			if dtu.IsMove {
+				fmt.Printf("[%s] 文件不支持校验, 不会删除网盘文件\n", dtu.taskInfo.Id())
+			}
 			result.ResultMessage = "检验文件有效性"
 			result.Err = err
 			fmt.Printf("[%s] 检验文件有效性: %s\n", dtu.taskInfo.Id(), err)
@@ -297,7 +338,8 @@ func (dtu *DownloadTaskUnit) checkFileValid(result *taskframework.TaskUnitRunRes
 		}
 	}
 
-	fmt.Printf("[%s] 检验文件有效性成功: %s\n", dtu.taskInfo.Id(), dtu.SavePath)
+	fmt.Printf("[%s] 检验文件有效性成功: %s\n", dtu.taskInfo.Id(), dtu.downloadPath())
+	dtu.checksumMatched = true
 	return true
 }
 
@@ -312,6 +354,13 @@ func (dtu *DownloadTaskUnit) OnRetry(lastRunResult *taskframework.TaskUnitRunRes
 }
 
 func (dtu *DownloadTaskUnit) OnSuccess(lastRunResult *taskframework.TaskUnitRunResult) {
+	if dtu.folderNode == nil {
+		if dtu.IsMove && dtu.checksumMatched {
+			dtu.removePanFile()
+		}
+		// 目录在所有子任务结束后才会通知上级目录
+		dtu.parentFolder.done()
+	}
 }
 
 func (dtu *DownloadTaskUnit) OnFailed(lastRunResult *taskframework.TaskUnitRunResult) {
@@ -319,9 +368,10 @@ func (dtu *DownloadTaskUnit) OnFailed(lastRunResult *taskframework.TaskUnitRunRe
 	if lastRunResult.Err == nil {
 		// result中不包含Err, 忽略输出
 		fmt.Printf("[%s] %s\n", dtu.taskInfo.Id(), lastRunResult.ResultMessage)
-		return
+	} else {
+		fmt.Printf("[%s] %s, %s\n", dtu.taskInfo.Id(), lastRunResult.ResultMessage, lastRunResult.Err)
 	}
-	fmt.Printf("[%s] %s, %s\n", dtu.taskInfo.Id(), lastRunResult.ResultMessage, lastRunResult.Err)
+	dtu.parentFolder.done()
 }
 
 func (dtu *DownloadTaskUnit) OnComplete(lastRunResult *taskframework.TaskUnitRunResult) {
@@ -335,11 +385,22 @@ func (dtu *DownloadTaskUnit) Run() (result *taskframework.TaskUnitRunResult) {
 	result = &taskframework.TaskUnitRunResult{}
 	// 获取文件信息
 	var apierr *apierror.ApiError
-	if dtu.fileInfo == nil || dtu.taskInfo.Retry() > 0 {
+	if dtu.Share == nil && (dtu.fileInfo == nil || dtu.taskInfo.Retry() > 0) {
 		// 没有获取文件信息
 		// 如果是动态添加的下载任务, 是会写入文件信息的
 		// 如果该任务重试过, 则应该再获取一次文件信息
 		dtu.fileInfo, apierr = dtu.PanClient.AppFileInfoByPath(dtu.FamilyId, dtu.FilePanPath)
+		if apierr != nil && apierr.ErrCode() == apierror.ApiCodeFileNotFoundCode && !functions.IsSplitManifestName(dtu.FilePanPath) {
+			// 文件可能是分片上传的, 尝试下载分片清单
+			if fileInfo, err := dtu.PanClient.AppFileInfoByPath(dtu.FamilyId, dtu.FilePanPath+functions.SplitManifestSuffix); err == nil {
+				dtu.FilePanPath += functions.SplitManifestSuffix
+				dtu.fileInfo, apierr = fileInfo, nil
+			}
+		}
+		if apierr != nil && apierr.ErrCode() == apierror.ApiCodeFileNotFoundCode && dtu.downloadPackMember(result) {
+			// 文件在打包文件中, 已按偏移读取
+			return
+		}
 		if apierr != nil {
 			// 如果不是未登录或文件不存在, 则不重试
 			result.ResultMessage = "获取下载路径信息错误"
@@ -361,19 +422,39 @@ func (dtu *DownloadTaskUnit) Run() (result *taskframework.TaskUnitRunResult) {
 		}
 
 		// 获取该目录下的文件列表
-		fileListParam := cloudpan.NewAppFileListParam()
-		fileListParam.FamilyId = dtu.FamilyId
-		fileListParam.FileId = dtu.fileInfo.FileId
-		fileListResult, apierr := dtu.PanClient.AppGetAllFileList(fileListParam)
-		//fileList := dtu.PanClient.AppFilesDirectoriesRecurseList(dtu.FamilyId, dtu.FilePanPath, nil)
-		if apierr != nil {
+		var fileList cloudpan.AppFileList
+		if dtu.Share != nil {
+			fileList, err = dtu.listShareFolder()
+		} else {
+			fileListParam := cloudpan.NewAppFileListParam()
+			fileListParam.FamilyId = dtu.FamilyId
+			fileListParam.FileId = dtu.fileInfo.FileId
+			fileListResult, apierr := dtu.PanClient.AppGetAllFileList(fileListParam)
+			//fileList := dtu.PanClient.AppFilesDirectoriesRecurseList(dtu.FamilyId, dtu.FilePanPath, nil)
+			if apierr != nil {
+				err = apierr
+			} else {
+				fileList = fileListResult.FileList
+			}
+		}
+		if err != nil {
 			result.ResultMessage = "获取目录信息错误"
 			result.Err = err
 			result.NeedRetry = true
 			return
 		}
 
-		fileList := fileListResult.FileList
+		// 分享中的文件只按普通文件下载
+		splitNames, packNames := map[string]bool{}, map[string]bool{}
+		var (
+			packIndexes    []*cloudpan.AppFileEntity
+			packLooseNames map[string]bool
+		)
+		if dtu.Share == nil {
+			splitNames = splitManifestNames(fileList)
+			packNames, packIndexes, packLooseNames = packDirFiles(fileList, splitNames)
+		}
+		dtu.folderNode = newFolderNode(dtu.parentFolder, dtu.onFolderFinish)
 		for k := range fileList {
 			fileList[k].Path = path.Join(dtu.FilePanPath, fileList[k].FileName)
 
@@ -382,6 +463,14 @@ func (dtu *DownloadTaskUnit) Run() (result *taskframework.TaskUnitRunResult) {
 				fmt.Printf("排除文件: %s\n", fileList[k].Path)
 				continue
 			}
+			// 分片由分片清单的任务下载并合并
+			if !fileList[k].IsFolder && isSplitPartOf(fileList[k].FileName, splitNames) {
+				continue
+			}
+			// 打包的 tar 文件和索引由一个任务统一解包
+			if !fileList[k].IsFolder && (packNames[fileList[k].FileName] || functions.IsPackIndexName(fileList[k].FileName)) {
+				continue
+			}
 			if fileList[k].IsFolder {
 				logger.Verbosef("[%s] create sub folder download task: %s\n",
 					dtu.taskInfo.Id(), fileList[k].Path)
@@ -393,12 +482,35 @@ func (dtu *DownloadTaskUnit) Run() (result *taskframework.TaskUnitRunResult) {
 			subUnit.Cfg = &newCfg
 			subUnit.fileInfo = fileList[k] // 保存文件信息
 			subUnit.FilePanPath = fileList[k].Path
-			subUnit.SavePath = filepath.Join(dtu.OriginSaveRootPath, fileList[k].Path) // 保存位置
+			subUnit.SavePath = dtu.subSavePath(fileList[k]) // 保存位置
+			subUnit.parentFolder = dtu.folderNode
+			subUnit.folderNode = nil
+			subUnit.checksumMatched = false
+			dtu.folderNode.add()
 
 			// 加入父队列
 			info := dtu.ParentTaskExecutor.Append(&subUnit, dtu.taskInfo.MaxRetry())
 			fmt.Printf("[%s] 加入下载队列: %s\n", info.Id(), fileList[k].Path)
 		}
+		if len(packIndexes) > 0 {
+			// 以最新的索引代表目录中全部的打包文件
+			subUnit := *dtu
+			newCfg := *dtu.Cfg
+			subUnit.Cfg = &newCfg
+			subUnit.fileInfo = packIndexes[0]
+			subUnit.FilePanPath = path.Join(dtu.FilePanPath, packIndexes[0].FileName)
+			subUnit.SavePath = dtu.subSavePath(packIndexes[0])
+			subUnit.parentFolder = dtu.folderNode
+			subUnit.folderNode = nil
+			subUnit.checksumMatched = false
+			subUnit.packIndexes = packIndexes
+			subUnit.packLooseNames = packLooseNames
+			dtu.folderNode.add()
+			info := dtu.ParentTaskExecutor.Append(&subUnit, dtu.taskInfo.MaxRetry())
+			fmt.Printf("[%s] 加入下载队列: %s 中的 %d 个打包文件\n", info.Id(), dtu.FilePanPath, len(packIndexes))
+		}
+		// 遍历结束
+		dtu.folderNode.done()
 
 		result.Succeed = true // 执行成功
 		return
@@ -406,8 +518,19 @@ func (dtu *DownloadTaskUnit) Run() (result *taskframework.TaskUnitRunResult) {
 
 	fmt.Printf("[%s] 准备下载: %s\n", dtu.taskInfo.Id(), dtu.FilePanPath)
 
-	if !dtu.IsOverwrite && FileExist(dtu.SavePath) {
-		fmt.Printf("[%s] 文件已经存在: %s, 跳过...\n", dtu.taskInfo.Id(), dtu.SavePath)
+	// 分片上传的文件, 下载所有分片后合并
+	if dtu.Share == nil && functions.IsSplitManifestName(dtu.fileInfo.FileName) {
+		dtu.downloadSplitFile(result)
+		return
+	}
+
+	// 小文件打包上传的 tar 文件, 按索引解包
+	if dtu.Share == nil && functions.IsPackIndexName(dtu.fileInfo.FileName) {
+		dtu.downloadPack(result)
+		return
+	}
+
+	if !dtu.resolveConflict() {
 		result.Succeed = true // 执行成功
 		return
 	}
@@ -432,9 +555,100 @@ func (dtu *DownloadTaskUnit) Run() (result *taskframework.TaskUnitRunResult) {
 		return result
 	}
 
-	// 统计下载
-	dtu.DownloadStatistic.AddTotalSize(dtu.fileInfo.FileSize)
+	// 加密网盘模式, 先解密临时文件, 解密成功的明文才会移动到目标位置, 解密失败时不影响已存在的本地文件
+	decrypted, er := dtu.decryptDownloadedFile()
+	if er != nil {
+		if !dtu.IsInPlace {
+			os.Remove(dtu.downloadPath())
+		}
+		result.ResultMessage = "解密文件失败"
+		result.Err = er
+		result.NeedRetry = false
+		return result
+	}
+	if decrypted {
+		fmt.Printf("[%s] 解密完成: %s\n", dtu.taskInfo.Id(), dtu.SavePath)
+	} else if er = dtu.commitDownload(); er != nil {
+		// 校验通过, 移动到目标位置
+		result.ResultMessage = StrDownloadFailed
+		result.Err = er
+		dtu.handleError(result)
+		return result
+	}
+	fmt.Printf("[%s] 下载完成, 保存位置: %s\n", dtu.taskInfo.Id(), dtu.SavePath)
+
+	// 保留网盘文件的修改时间
+	if !dtu.NoPreserveTime {
+		if modTime, ok := remoteModTime(dtu.fileInfo); ok {
+			setLocalFileTime(dtu.SavePath, modTime)
+		}
+	}
+
+	// 统计下载, 用于解包的 tar 文件按解包的文件统计
+	if !dtu.isPackSegment {
+		dtu.DownloadStatistic.AddTotalSize(dtu.fileInfo.FileSize)
+	}
 	// 下载成功
 	result.Succeed = true
 	return
 }
+
+// onFolderFinish 目录中所有子任务结束后执行
+func (dtu *DownloadTaskUnit) onFolderFinish() {
+	// 保留网盘目录的修改时间
+	if !dtu.NoPreserveTime {
+		if modTime, ok := remoteModTime(dtu.fileInfo); ok {
+			setLocalFileTime(dtu.SavePath, modTime)
+		}
+	}
+
+	// 移动模式下删除已清空的网盘目录
+	if dtu.IsMove {
+		fileListParam := cloudpan.NewAppFileListParam()
+		fileListParam.FamilyId = dtu.FamilyId
+		fileListParam.FileId = dtu.fileInfo.FileId
+		fileListResult, apierr := dtu.PanClient.AppGetAllFileList(fileListParam)
+		if apierr != nil || len(fileListResult.FileList) > 0 {
+			return
+		}
+		dtu.removePanFile()
+	}
+}
+
+// removePanFile 删除已下载的网盘文件或已清空的网盘目录
+func (dtu *DownloadTaskUnit) removePanFile() {
+	if err := dtu.deletePanFiles(panFileEntity(dtu.FilePanPath, dtu.fileInfo)); err != nil {
+		fmt.Printf("[%s] 删除网盘文件失败: %s\n", dtu.taskInfo.Id(), err)
+		return
+	}
+	fmt.Printf("[%s] 已删除网盘文件: %s\n", dtu.taskInfo.Id(), dtu.FilePanPath)
+}
+
+// panFileEntity 删除和记录操作日志使用的网盘文件, 路径使用网盘的完整路径
+func panFileEntity(panPath string, efi *cloudpan.AppFileEntity) *cloudpan.AppFileEntity {
+	e := *efi
+	e.Path = panPath
+	return &e
+}
+
+// deletePanFiles 移动模式下按顺序删除网盘文件, 删除成功的文件记录为一条删除操作, 可以撤销.
+// 涉及受保护的路径时不删除任何文件
+func (dtu *DownloadTaskUnit) deletePanFiles(files ...*cloudpan.AppFileEntity) error {
+	if !dtu.ForceProtected {
+		for _, efi := range files {
+			if err := config.Config.CheckProtectedPath(dtu.FamilyId, efi.Path); err != nil {
+				return err
+			}
+		}
+	}
+	entry := panjournal.NewEntry(panjournal.OpRemove, dtu.FamilyId)
+	entry.TypeFlag = cloudpan.BatchTaskTypeDelete
+	defer panjournal.Record(entry)
+	for _, efi := range files {
+		if err := functions.DeletePanFile(dtu.PanClient, dtu.FamilyId, efi); err != nil {
+			return fmt.Errorf("%s, %s", efi.Path, err)
+		}
+		entry.AddEntity(efi)
+	}
+	return nil
+}
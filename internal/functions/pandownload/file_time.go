@@ -0,0 +1,46 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package pandownload
+
+import (
+	"os"
+	"time"
+
+	"github.com/tickstep/cloudpan189-api/cloudpan"
+	"github.com/tickstep/cloudpan189-go/internal/utils"
+	"github.com/tickstep/library-go/logger"
+)
+
+// remoteModTime 获取网盘文件的修改时间
+func remoteModTime(fileInfo *cloudpan.AppFileEntity) (time.Time, bool) {
+	if fileInfo == nil {
+		return time.Time{}, false
+	}
+	timeStr := fileInfo.LastOpTime
+	if timeStr == "" {
+		timeStr = fileInfo.CreateTime
+	}
+	t, err := utils.ParseCloudTime(timeStr)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// setLocalFileTime 设置本地文件的访问时间和修改时间
+func setLocalFileTime(savePath string, modTime time.Time) {
+	if err := os.Chtimes(savePath, modTime, modTime); err != nil {
+		logger.Verbosef("set file time error: %s, %s\n", savePath, err)
+	}
+}
@@ -0,0 +1,61 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package pandownload
+
+import "sync"
+
+type (
+	// folderNode 下载目录的节点, 记录目录下未结束的子任务
+	// 所有子任务结束后才执行目录的收尾工作, 如设置目录的修改时间
+	folderNode struct {
+		mu       sync.Mutex
+		parent   *folderNode
+		pending  int
+		onFinish func()
+	}
+)
+
+func newFolderNode(parent *folderNode, onFinish func()) *folderNode {
+	return &folderNode{
+		parent:   parent,
+		pending:  1, // 目录本身的遍历, 遍历结束后释放
+		onFinish: onFinish,
+	}
+}
+
+// add 增加一个未结束的子任务
+func (n *folderNode) add() {
+	n.mu.Lock()
+	n.pending++
+	n.mu.Unlock()
+}
+
+// done 一个子任务结束, 所有子任务都结束时执行目录的收尾工作, 并通知上级目录
+func (n *folderNode) done() {
+	if n == nil {
+		return
+	}
+	n.mu.Lock()
+	n.pending--
+	finished := n.pending == 0
+	n.mu.Unlock()
+	if !finished {
+		return
+	}
+
+	if n.onFinish != nil {
+		n.onFinish()
+	}
+	n.parent.done()
+}
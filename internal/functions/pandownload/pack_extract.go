@@ -0,0 +1,356 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package pandownload
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/tickstep/cloudpan189-api/cloudpan"
+	"github.com/tickstep/cloudpan189-go/internal/functions"
+	"github.com/tickstep/cloudpan189-go/internal/taskframework"
+	"github.com/tickstep/cloudpan189-go/internal/utils"
+)
+
+const (
+	// packRangeMaxMembers 需要解包的文件不超过该数量时, 按偏移逐个读取, 否则下载整个 tar 文件
+	packRangeMaxMembers = 8
+)
+
+type (
+	// packTarget 需要从 tar 文件中取出的文件
+	packTarget struct {
+		member   *functions.PackMember
+		savePath string
+	}
+
+	// packSegment 一个 tar 文件中需要解包的文件
+	packSegment struct {
+		index      *functions.PackIndex
+		indexInfo  *cloudpan.AppFileEntity
+		targets    []*packTarget
+		allMatched bool // 不需要解包的文件都和本地文件一致, 移动模式下才能删除网盘文件
+	}
+)
+
+// packDirFiles 目录中的打包文件, 返回有打包索引的 tar 文件名, 打包索引, 以及单独上传的文件名.
+// 分片上传的文件按原文件名计算
+func packDirFiles(fileList cloudpan.AppFileList, splitNames map[string]bool) (segments map[string]bool, indexes []*cloudpan.AppFileEntity, loose map[string]bool) {
+	segments, loose = map[string]bool{}, map[string]bool{}
+	for _, f := range fileList {
+		switch {
+		case f.IsFolder:
+			loose[f.FileName] = true
+		case functions.IsPackIndexName(f.FileName):
+			segments[functions.PackSegmentOfIndex(f.FileName)] = true
+			indexes = append(indexes, f)
+		case functions.IsPackFileName(f.FileName), isSplitPartOf(f.FileName, splitNames):
+		case functions.IsSplitManifestName(f.FileName):
+			loose[strings.TrimSuffix(f.FileName, functions.SplitManifestSuffix)] = true
+		default:
+			loose[f.FileName] = true
+		}
+	}
+	sortPackIndexes(indexes)
+	return
+}
+
+// sortPackIndexes 较新的打包文件在前, 同名文件以最新的为准
+func sortPackIndexes(indexes []*cloudpan.AppFileEntity) {
+	sort.Slice(indexes, func(i, j int) bool {
+		return indexes[i].FileName > indexes[j].FileName
+	})
+}
+
+// memberFileInfo 打包文件的信息, 用于处理本地同名文件
+func memberFileInfo(m *functions.PackMember) *cloudpan.AppFileEntity {
+	return &cloudpan.AppFileEntity{
+		FileName:   m.Name,
+		FileSize:   m.Size,
+		FileMd5:    m.MD5,
+		LastOpTime: utils.FormatCloudTime(time.Unix(m.ModTime, 0)),
+	}
+}
+
+// readPackIndex 读取网盘上的打包索引
+func (dtu *DownloadTaskUnit) readPackIndex(indexInfo *cloudpan.AppFileEntity) (*functions.PackIndex, error) {
+	data, err := dtu.readPanFile(indexInfo)
+	if err != nil {
+		return nil, err
+	}
+	return functions.ParsePackIndex(data)
+}
+
+// downloadPack 读取目录中全部的打包索引, 解包其中需要下载的文件.
+// 同名文件只解包一次: 单独上传的文件优先, 其次是较新的 tar 文件中的
+func (dtu *DownloadTaskUnit) downloadPack(result *taskframework.TaskUnitRunResult) {
+	indexList := append([]*cloudpan.AppFileEntity{}, dtu.packIndexes...)
+	if len(indexList) == 0 {
+		indexList = append(indexList, dtu.fileInfo)
+	}
+	sortPackIndexes(indexList)
+	segments := make([]*packSegment, 0, len(indexList))
+	for _, indexInfo := range indexList {
+		index, err := dtu.readPackIndex(indexInfo)
+		if err != nil {
+			result.ResultMessage = "读取打包索引失败"
+			result.Err = fmt.Errorf("%s, %s", indexInfo.FileName, err)
+			if err == functions.ErrPackIndexInvalid {
+				result.NeedRetry = false
+			} else {
+				dtu.handleError(result)
+			}
+			return
+		}
+		segments = append(segments, &packSegment{index: index, indexInfo: indexInfo, allMatched: true})
+	}
+
+	// 按每个文件处理本地同名文件
+	localDir := filepath.Dir(dtu.SavePath)
+	assigned := map[string]bool{}
+	for name := range dtu.packLooseNames {
+		assigned[name] = true
+	}
+	for _, seg := range segments {
+		for _, m := range seg.index.Members {
+			if assigned[m.Name] {
+				continue
+			}
+			assigned[m.Name] = true
+			mu := *dtu
+			mu.fileInfo = memberFileInfo(m)
+			mu.SavePath = filepath.Join(localDir, dtu.Decryption.PlainName(m.Name))
+			mu.checksumMatched = false
+			if !mu.resolveConflict() {
+				seg.allMatched = seg.allMatched && mu.checksumMatched
+				continue
+			}
+			seg.targets = append(seg.targets, &packTarget{member: m, savePath: mu.SavePath})
+		}
+	}
+
+	// 下载整个 tar 文件的子任务都结束后, 该任务才结束
+	result.Succeed = true
+	dtu.folderNode = newFolderNode(dtu.parentFolder, nil)
+	for _, seg := range segments {
+		dtu.extractPackSegment(seg, localDir)
+	}
+	dtu.folderNode.done()
+}
+
+// extractPackSegment 解包一个 tar 文件中需要的文件, 需要的文件较少时按偏移逐个读取, 否则下载整个 tar 文件
+func (dtu *DownloadTaskUnit) extractPackSegment(seg *packSegment, localDir string) {
+	segmentPath := path.Join(path.Dir(dtu.FilePanPath), seg.index.Segment)
+	if len(seg.targets) == 0 {
+		if dtu.IsMove && seg.allMatched {
+			dtu.removePackFiles(seg.index, seg.indexInfo)
+		}
+		return
+	}
+
+	if len(seg.targets) <= packRangeMaxMembers {
+		segmentInfo, apierr := dtu.PanClient.AppFileInfoByPath(dtu.FamilyId, segmentPath)
+		if apierr != nil {
+			fmt.Printf("[%s] 获取打包文件信息错误: %s, %s\n", dtu.taskInfo.Id(), segmentPath, apierr)
+			return
+		}
+		ok := true
+		for _, t := range seg.targets {
+			ok = dtu.extractPackMemberByRange(segmentInfo, t) && ok
+		}
+		if ok && seg.allMatched && dtu.IsMove {
+			dtu.removePackFiles(seg.index, seg.indexInfo)
+		}
+		return
+	}
+
+	// 下载整个 tar 文件后解包
+	fmt.Printf("[%s] 打包文件, 共 %d 个文件需要解包到: %s\n", dtu.taskInfo.Id(), len(seg.targets), localDir)
+	localSegment := TempSavePath(filepath.Join(localDir, seg.index.Segment))
+	node := newFolderNode(dtu.folderNode, func() {
+		ok := dtu.extractPack(localSegment, seg.index, seg.targets)
+		if ok && seg.allMatched && dtu.IsMove {
+			dtu.removePackFiles(seg.index, seg.indexInfo)
+		}
+	})
+	dtu.folderNode.add()
+	subUnit := *dtu
+	newCfg := *dtu.Cfg
+	subUnit.Cfg = &newCfg
+	subUnit.fileInfo = nil
+	subUnit.FilePanPath = segmentPath
+	subUnit.SavePath = localSegment
+	subUnit.OnConflict = ConflictOverwriteIfDifferent
+	subUnit.IsOverwrite = false
+	subUnit.IsMove = false
+	subUnit.NoPreserveTime = true
+	subUnit.parentFolder = node
+	subUnit.folderNode = nil
+	subUnit.checksumMatched = false
+	subUnit.packIndexes = nil
+	subUnit.packLooseNames = nil
+	subUnit.isPackSegment = true
+	node.add()
+	info := dtu.ParentTaskExecutor.Append(&subUnit, dtu.taskInfo.MaxRetry())
+	fmt.Printf("[%s] 加入下载队列: %s\n", info.Id(), segmentPath)
+	node.done()
+}
+
+// extractPack 从下载的 tar 文件中取出需要的文件
+func (dtu *DownloadTaskUnit) extractPack(localSegment string, index *functions.PackIndex, targets []*packTarget) bool {
+	defer os.Remove(localSegment)
+	f, err := os.Open(localSegment)
+	if err != nil {
+		fmt.Printf("[%s] 解包失败: %s, %s\n", dtu.taskInfo.Id(), index.Segment, err)
+		return false
+	}
+	defer f.Close()
+
+	ok := true
+	for _, t := range targets {
+		r := io.NewSectionReader(f, t.member.Offset, t.member.Size)
+		if err = dtu.writePackMember(r, t); err != nil {
+			fmt.Printf("[%s] 解包失败: %s, %s\n", dtu.taskInfo.Id(), t.savePath, err)
+			ok = false
+			continue
+		}
+		dtu.DownloadStatistic.AddTotalSize(t.member.Size)
+		fmt.Printf("[%s] 解包完成, 保存位置: %s\n", dtu.taskInfo.Id(), t.savePath)
+	}
+	return ok
+}
+
+// extractPackMemberByRange 只读取 tar 文件中该文件的数据
+func (dtu *DownloadTaskUnit) extractPackMemberByRange(segmentInfo *cloudpan.AppFileEntity, t *packTarget) bool {
+	data, err := dtu.readPanFileRange(segmentInfo, t.member.Offset, t.member.Size)
+	if err == nil {
+		err = dtu.writePackMember(bytes.NewReader(data), t)
+	}
+	if err != nil {
+		fmt.Printf("[%s] 解包失败: %s, %s\n", dtu.taskInfo.Id(), t.savePath, err)
+		return false
+	}
+	dtu.DownloadStatistic.AddTotalSize(t.member.Size)
+	fmt.Printf("[%s] 解包完成, 保存位置: %s\n", dtu.taskInfo.Id(), t.savePath)
+	return true
+}
+
+// writePackMember 写入临时文件, 校验大小和MD5后重命名为目标文件
+func (dtu *DownloadTaskUnit) writePackMember(r io.Reader, t *packTarget) error {
+	if err := os.MkdirAll(filepath.Dir(t.savePath), 0777); err != nil {
+		return err
+	}
+	tmpPath := TempSavePath(t.savePath)
+	out, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0666)
+	if err != nil {
+		return err
+	}
+	h := md5.New()
+	n, err := io.Copy(io.MultiWriter(out, h), r)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && (n != t.member.Size || !strings.EqualFold(hex.EncodeToString(h.Sum(nil)), t.member.MD5)) {
+		err = ErrDownloadChecksumFailed
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, t.savePath)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if !dtu.NoPreserveTime {
+		setLocalFileTime(t.savePath, time.Unix(t.member.ModTime, 0))
+	}
+	return nil
+}
+
+// downloadPackMember 网盘上不存在要下载的文件时, 查找同目录的打包索引, 找到后只读取该文件
+func (dtu *DownloadTaskUnit) downloadPackMember(result *taskframework.TaskUnitRunResult) bool {
+	panDir, name := path.Split(dtu.FilePanPath)
+	dirInfo, apierr := dtu.PanClient.AppFileInfoByPath(dtu.FamilyId, path.Clean(panDir))
+	if apierr != nil || !dirInfo.IsFolder {
+		return false
+	}
+	fileListParam := cloudpan.NewAppFileListParam()
+	fileListParam.FamilyId = dtu.FamilyId
+	fileListParam.FileId = dirInfo.FileId
+	fileListResult, apierr := dtu.PanClient.AppGetAllFileList(fileListParam)
+	if apierr != nil {
+		return false
+	}
+
+	// 较新的打包文件在前, 同名文件以最新的为准
+	indexList := make([]*cloudpan.AppFileEntity, 0)
+	for _, f := range fileListResult.FileList {
+		if !f.IsFolder && functions.IsPackIndexName(f.FileName) {
+			indexList = append(indexList, f)
+		}
+	}
+	sortPackIndexes(indexList)
+	for _, indexInfo := range indexList {
+		index, err := dtu.readPackIndex(indexInfo)
+		if err != nil {
+			continue
+		}
+		m := index.Member(name)
+		if m == nil {
+			continue
+		}
+		segmentInfo, apierr := dtu.PanClient.AppFileInfoByPath(dtu.FamilyId, path.Join(panDir, index.Segment))
+		if apierr != nil {
+			continue
+		}
+
+		fmt.Printf("[%s] 文件在打包文件中: %s\n", dtu.taskInfo.Id(), path.Join(panDir, index.Segment))
+		dtu.fileInfo = memberFileInfo(m)
+		if !dtu.resolveConflict() {
+			result.Succeed = true
+			return true
+		}
+		if dtu.extractPackMemberByRange(segmentInfo, &packTarget{member: m, savePath: dtu.SavePath}) {
+			result.Succeed = true
+		} else {
+			result.ResultMessage = StrDownloadFailed
+		}
+		return true
+	}
+	return false
+}
+
+// removePackFiles 删除网盘上的 tar 文件和索引
+func (dtu *DownloadTaskUnit) removePackFiles(index *functions.PackIndex, indexInfo *cloudpan.AppFileEntity) {
+	panDir := path.Dir(dtu.FilePanPath)
+	segmentPath := path.Join(panDir, index.Segment)
+	segmentInfo, apierr := dtu.PanClient.AppFileInfoByPath(dtu.FamilyId, segmentPath)
+	if apierr != nil {
+		fmt.Printf("[%s] 删除网盘文件失败: %s, %s\n", dtu.taskInfo.Id(), segmentPath, apierr)
+		return
+	}
+	if err := dtu.deletePanFiles(panFileEntity(segmentPath, segmentInfo), panFileEntity(path.Join(panDir, indexInfo.FileName), indexInfo)); err != nil {
+		fmt.Printf("[%s] 删除网盘文件失败: %s\n", dtu.taskInfo.Id(), err)
+		return
+	}
+	fmt.Printf("[%s] 已删除网盘文件: %s 及其打包索引\n", dtu.taskInfo.Id(), segmentPath)
+}
@@ -0,0 +1,69 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package pandownload
+
+import (
+	"github.com/tickstep/cloudpan189-api/cloudpan"
+	"github.com/tickstep/cloudpan189-go/internal/file/downloader"
+	"github.com/tickstep/cloudpan189-go/internal/functions/panshare"
+)
+
+type (
+	// ShareSource 直接下载他人分享中的文件, 不需要先转存到网盘
+	ShareSource struct {
+		Client *panshare.ShareClient
+		Info   *panshare.ShareInfo
+	}
+)
+
+// ShareFileEntity 将分享中的文件转换为网盘文件的格式, Path 为在分享中的路径
+func ShareFileEntity(f *panshare.ShareFile) *cloudpan.AppFileEntity {
+	return &cloudpan.AppFileEntity{
+		FileId:     f.FileId,
+		FileName:   f.FileName,
+		FileSize:   f.FileSize,
+		FileMd5:    f.MD5,
+		LastOpTime: f.LastOpTime,
+		Path:       f.Path,
+		IsFolder:   f.IsFolder,
+	}
+}
+
+// downloadUrlFunc 获取分享中文件的下载链接
+func (ss *ShareSource) downloadUrlFunc(fileId string) downloader.DownloadUrlFunc {
+	return func() (string, error) {
+		return ss.Client.GetDownloadUrl(ss.Info, fileId)
+	}
+}
+
+// listShareFolder 列出分享中的目录
+func (dtu *DownloadTaskUnit) listShareFolder() (cloudpan.AppFileList, error) {
+	list, err := dtu.Share.Client.ListDir(dtu.Share.Info, &panshare.ShareFile{
+		FileId: dtu.fileInfo.FileId,
+		Path:   dtu.FilePanPath,
+	})
+	if err != nil {
+		return nil, err
+	}
+	fileList := make(cloudpan.AppFileList, 0, len(list))
+	for _, f := range list {
+		fileList = append(fileList, ShareFileEntity(f))
+	}
+	return fileList, nil
+}
+
+// SetShareFile 设置要下载的分享中的文件或目录
+func (dtu *DownloadTaskUnit) SetShareFile(f *panshare.ShareFile) {
+	dtu.fileInfo = ShareFileEntity(f)
+}
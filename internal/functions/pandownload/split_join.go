@@ -0,0 +1,206 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package pandownload
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/tickstep/cloudpan189-api/cloudpan"
+	"github.com/tickstep/cloudpan189-go/internal/functions"
+	"github.com/tickstep/cloudpan189-go/internal/taskframework"
+)
+
+// splitManifestNames 目录中有分片清单的原文件名
+func splitManifestNames(fileList cloudpan.AppFileList) map[string]bool {
+	names := map[string]bool{}
+	for _, f := range fileList {
+		if !f.IsFolder && functions.IsSplitManifestName(f.FileName) {
+			names[strings.TrimSuffix(f.FileName, functions.SplitManifestSuffix)] = true
+		}
+	}
+	return names
+}
+
+// isSplitPartOf 是否为 names 中某个文件的分片, 分片由清单任务统一下载
+func isSplitPartOf(fileName string, names map[string]bool) bool {
+	baseName, ok := functions.SplitPartBaseName(fileName)
+	return ok && names[baseName]
+}
+
+// splitPartSavePath 第 index 个分片在本地的临时保存路径
+func splitPartSavePath(savePath string, index int) string {
+	return functions.SplitPartName(TempSavePath(savePath), index)
+}
+
+// downloadSplitFile 读取分片清单, 将所有分片加入下载队列, 全部结束后合并为原文件
+func (dtu *DownloadTaskUnit) downloadSplitFile(result *taskframework.TaskUnitRunResult) {
+	data, err := dtu.readPanFile(dtu.fileInfo)
+	if err != nil {
+		result.ResultMessage = "读取分片清单失败"
+		result.Err = err
+		dtu.handleError(result)
+		return
+	}
+	manifest, err := functions.ParseSplitManifest(data)
+	if err != nil {
+		result.ResultMessage = "读取分片清单失败"
+		result.Err = err
+		result.NeedRetry = false
+		return
+	}
+
+	// 按原文件处理本地同名文件
+	manifestInfo := dtu.fileInfo
+	originInfo := *manifestInfo
+	originInfo.FileName = manifest.Name
+	originInfo.FileSize = manifest.Size
+	originInfo.FileMd5 = manifest.MD5
+	dtu.fileInfo = &originInfo
+	dtu.SavePath = strings.TrimSuffix(dtu.SavePath, functions.SplitManifestSuffix)
+	if !dtu.resolveConflict() {
+		if dtu.IsMove && dtu.checksumMatched {
+			dtu.removeSplitFiles(manifest, manifestInfo)
+		}
+		// 清单不在OnSuccess中删除
+		dtu.checksumMatched = false
+		result.Succeed = true
+		return
+	}
+	fmt.Printf("[%s] 分片文件, 共 %d 个分片, 下载后合并到: %s\n", dtu.taskInfo.Id(), len(manifest.Parts), dtu.SavePath)
+
+	panDir := path.Dir(dtu.FilePanPath)
+	dtu.folderNode = newFolderNode(dtu.parentFolder, func() {
+		dtu.joinSplitParts(manifest, manifestInfo)
+	})
+	for k, part := range manifest.Parts {
+		subUnit := *dtu
+		newCfg := *dtu.Cfg
+		subUnit.Cfg = &newCfg
+		subUnit.fileInfo = nil
+		subUnit.FilePanPath = path.Join(panDir, part.Name)
+		subUnit.SavePath = splitPartSavePath(dtu.SavePath, k)
+		// 已下载完整的分片不再重复下载
+		subUnit.OnConflict = ConflictOverwriteIfDifferent
+		subUnit.IsOverwrite = false
+		subUnit.IsMove = false
+		subUnit.NoPreserveTime = true
+		subUnit.parentFolder = dtu.folderNode
+		subUnit.folderNode = nil
+		subUnit.checksumMatched = false
+		dtu.folderNode.add()
+
+		info := dtu.ParentTaskExecutor.Append(&subUnit, dtu.taskInfo.MaxRetry())
+		fmt.Printf("[%s] 加入下载队列: %s\n", info.Id(), subUnit.FilePanPath)
+	}
+	dtu.folderNode.done()
+
+	result.Succeed = true
+}
+
+// joinSplitParts 所有分片任务结束后合并分片, 并用清单中的MD5校验
+func (dtu *DownloadTaskUnit) joinSplitParts(manifest *functions.SplitManifest, manifestInfo *cloudpan.AppFileEntity) {
+	tmpPath := TempSavePath(dtu.SavePath)
+	if err := joinFiles(tmpPath, dtu.SavePath, manifest); err != nil {
+		os.Remove(tmpPath)
+		fmt.Printf("[%s] 合并分片失败, 已下载的分片会保留用于下次续传: %s, %s\n", dtu.taskInfo.Id(), dtu.SavePath, err)
+		return
+	}
+	if err := os.Rename(tmpPath, dtu.SavePath); err != nil {
+		os.Remove(tmpPath)
+		fmt.Printf("[%s] 合并分片失败: %s, %s\n", dtu.taskInfo.Id(), dtu.SavePath, err)
+		return
+	}
+	for k := range manifest.Parts {
+		os.Remove(splitPartSavePath(dtu.SavePath, k))
+	}
+	fmt.Printf("[%s] 分片合并完成, 保存位置: %s\n", dtu.taskInfo.Id(), dtu.SavePath)
+
+	// 保留原文件的修改时间
+	if !dtu.NoPreserveTime && manifest.ModTime > 0 {
+		modTime := time.Unix(manifest.ModTime, 0)
+		setLocalFileTime(dtu.SavePath, modTime)
+	}
+
+	if dtu.IsMove {
+		if manifest.MD5 == "" {
+			fmt.Printf("[%s] 分片清单没有MD5, 不会删除网盘文件\n", dtu.taskInfo.Id())
+			return
+		}
+		dtu.removeSplitFiles(manifest, manifestInfo)
+	}
+}
+
+// joinFiles 按顺序将分片写入 tmpPath, 检查大小和MD5
+func joinFiles(tmpPath, savePath string, manifest *functions.SplitManifest) error {
+	out, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0666)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	h := md5.New()
+	w := io.MultiWriter(out, h)
+	for k, part := range manifest.Parts {
+		partPath := splitPartSavePath(savePath, k)
+		info, err := os.Stat(partPath)
+		if err != nil || info.Size() != part.Size {
+			return fmt.Errorf("分片不完整: %s", filepath.Base(partPath))
+		}
+		f, err := os.Open(partPath)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(w, f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+	if err = out.Close(); err != nil {
+		return err
+	}
+	if manifest.MD5 != "" && !strings.EqualFold(hex.EncodeToString(h.Sum(nil)), manifest.MD5) {
+		return ErrDownloadChecksumFailed
+	}
+	return nil
+}
+
+// removeSplitFiles 删除网盘上的分片和清单
+func (dtu *DownloadTaskUnit) removeSplitFiles(manifest *functions.SplitManifest, manifestInfo *cloudpan.AppFileEntity) {
+	panDir := path.Dir(dtu.FilePanPath)
+	files := make([]*cloudpan.AppFileEntity, 0, len(manifest.Parts)+1)
+	for _, part := range manifest.Parts {
+		partPath := path.Join(panDir, part.Name)
+		partInfo, apierr := dtu.PanClient.AppFileInfoByPath(dtu.FamilyId, partPath)
+		if apierr != nil {
+			fmt.Printf("[%s] 删除网盘文件失败: %s, %s\n", dtu.taskInfo.Id(), partPath, apierr)
+			return
+		}
+		files = append(files, panFileEntity(partPath, partInfo))
+	}
+	files = append(files, panFileEntity(dtu.FilePanPath, manifestInfo))
+	if err := dtu.deletePanFiles(files...); err != nil {
+		fmt.Printf("[%s] 删除网盘文件失败: %s\n", dtu.taskInfo.Id(), err)
+		return
+	}
+	fmt.Printf("[%s] 已删除网盘文件: %s 及其 %d 个分片\n", dtu.taskInfo.Id(), dtu.FilePanPath, len(manifest.Parts))
+}
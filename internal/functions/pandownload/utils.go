@@ -15,14 +15,34 @@ package pandownload
 
 import (
 	"github.com/tickstep/cloudpan189-api/cloudpan"
+	"github.com/tickstep/cloudpan189-go/internal/localfile"
 	"os"
+	"path/filepath"
+	"strings"
 )
 
 // CheckFileValid 检测文件有效性
 func CheckFileValid(filePath string, fileInfo *cloudpan.AppFileEntity) error {
-	// 检查MD5
 	// 检查文件大小
-	// 检查digest签名
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return err
+	}
+	if info.Size() != fileInfo.FileSize {
+		return ErrDownloadChecksumFailed
+	}
+
+	// 检查MD5
+	if fileInfo.FileMd5 == "" {
+		return ErrDownloadNotSupportChecksum
+	}
+	lfc, err := localfile.GetFileSum(filePath, localfile.CHECKSUM_MD5)
+	if err != nil {
+		return err
+	}
+	if !strings.EqualFold(lfc.MD5, fileInfo.FileMd5) {
+		return ErrDownloadChecksumFailed
+	}
 	return nil
 }
 
@@ -40,3 +60,9 @@ func FileExist(path string) bool {
 
 	return false
 }
+
+// TempSavePath 返回下载临时文件的路径, 临时文件是和目标文件同目录的隐藏文件
+func TempSavePath(savePath string) string {
+	dir, name := filepath.Split(savePath)
+	return filepath.Join(dir, "."+name+DownloadTempSuffix)
+}
@@ -0,0 +1,190 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package panjournal
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/tickstep/cloudpan189-api/cloudpan"
+	"github.com/tickstep/cloudpan189-go/internal/config"
+	"github.com/tickstep/library-go/logger"
+)
+
+const (
+	// JournalFileName 操作日志文件名, 每行一条 JSON 格式的记录, 只追加不修改
+	JournalFileName = "cloud189_journal.jsonl"
+
+	// OpRemove 删除文件/目录
+	OpRemove = "rm"
+	// OpMove 移动文件/目录
+	OpMove = "mv"
+	// OpRename 重命名文件/目录
+	OpRename = "rename"
+	// OpOverwrite 上传或导入时覆盖同名文件, 旧文件移到回收站
+	OpOverwrite = "overwrite"
+	// OpBackupDelete 备份时同步删除网盘文件
+	OpBackupDelete = "backup-delete"
+	// OpUndo 撤销操作
+	OpUndo = "undo"
+
+	timeFormat = "2006-01-02 15:04:05"
+)
+
+type (
+	// File 操作涉及的文件/目录, 记录操作前的信息
+	File struct {
+		FileId   string `json:"fileId"`
+		FileName string `json:"fileName"`
+		Path     string `json:"path"`
+		ParentId string `json:"parentId"`
+		IsFolder bool   `json:"isFolder"`
+	}
+
+	// Entry 一条操作记录
+	Entry struct {
+		Id             string                 `json:"id"`
+		Time           string                 `json:"time"`
+		UID            uint64                 `json:"uid"`
+		FamilyId       int64                  `json:"familyId"`
+		Op             string                 `json:"op"`
+		TypeFlag       cloudpan.BatchTaskType `json:"typeFlag,omitempty"`
+		TaskId         string                 `json:"taskId,omitempty"`
+		TargetFolderId string                 `json:"targetFolderId,omitempty"`
+		TargetPath     string                 `json:"targetPath,omitempty"`
+		NewName        string                 `json:"newName,omitempty"` // 重命名后的名称
+		UndoOf         string                 `json:"undoOf,omitempty"`  // 撤销的操作ID
+		Files          []*File                `json:"files"`
+	}
+)
+
+var (
+	journalMu sync.Mutex
+)
+
+// JournalPath 操作日志的文件路径
+func JournalPath() string {
+	return filepath.Join(config.GetConfigDir(), JournalFileName)
+}
+
+// newId 生成操作ID, 由时间和随机数组成
+func newId(t time.Time) string {
+	b := make([]byte, 2)
+	rand.Read(b)
+	return t.Format("20060102150405") + "-" + hex.EncodeToString(b)
+}
+
+// NewEntry 创建当前账号的操作记录
+func NewEntry(op string, familyId int64) *Entry {
+	now := time.Now()
+	e := &Entry{
+		Id:       newId(now),
+		Time:     now.Format(timeFormat),
+		FamilyId: familyId,
+		Op:       op,
+		Files:    []*File{},
+	}
+	if user := config.Config.ActiveUser(); user != nil {
+		e.UID = user.UID
+	}
+	return e
+}
+
+// NewBatchTaskEntry 根据批量任务参数创建操作记录, files 为任务涉及的文件
+func NewBatchTaskEntry(op string, familyId int64, param *cloudpan.BatchTaskParam, files []*cloudpan.AppFileEntity) *Entry {
+	e := NewEntry(op, familyId)
+	e.TypeFlag = param.TypeFlag
+	e.TargetFolderId = param.TargetFolderId
+	for _, f := range files {
+		e.AddEntity(f)
+	}
+	return e
+}
+
+// AddEntity 添加操作涉及的网盘文件
+func (e *Entry) AddEntity(efi *cloudpan.AppFileEntity) {
+	e.Files = append(e.Files, &File{
+		FileId:   efi.FileId,
+		FileName: efi.FileName,
+		Path:     efi.Path,
+		ParentId: efi.ParentId,
+		IsFolder: efi.IsFolder,
+	})
+}
+
+// Record 追加操作记录到操作日志, 没有涉及文件的记录不保存. 写入失败只输出提示, 不影响操作本身
+func Record(e *Entry) {
+	if e == nil || len(e.Files) == 0 {
+		return
+	}
+	if err := Append(e); err != nil {
+		fmt.Printf("写入操作日志失败: %s\n", err)
+	}
+}
+
+// Append 追加操作记录到操作日志
+func Append(e *Entry) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	journalMu.Lock()
+	defer journalMu.Unlock()
+	f, err := os.OpenFile(JournalPath(), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// ReadAll 读取全部操作记录, 按记录的先后顺序返回, 无法解析的行会被忽略
+func ReadAll() ([]*Entry, error) {
+	f, err := os.Open(JournalPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	entries := []*Entry{}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		e := &Entry{}
+		if err := json.Unmarshal(line, e); err != nil || e.Id == "" {
+			logger.Verboseln("skip invalid journal line: ", string(line))
+			continue
+		}
+		entries = append(entries, e)
+	}
+	return entries, scanner.Err()
+}
@@ -0,0 +1,76 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package panjournal
+
+import (
+	"os"
+	"testing"
+
+	"github.com/tickstep/cloudpan189-api/cloudpan"
+	"github.com/tickstep/cloudpan189-go/internal/config"
+)
+
+func TestJournalAppendReadAll(t *testing.T) {
+	t.Setenv(config.EnvConfigDir, t.TempDir())
+
+	entries, err := ReadAll()
+	if err != nil || len(entries) != 0 {
+		t.Fatalf("ReadAll without journal = %v, %v", entries, err)
+	}
+
+	param := &cloudpan.BatchTaskParam{TypeFlag: cloudpan.BatchTaskTypeMove, TargetFolderId: "100"}
+	e1 := NewBatchTaskEntry(OpMove, 0, param, []*cloudpan.AppFileEntity{
+		{FileId: "1", FileName: "a.txt", Path: "/a/a.txt", ParentId: "10"},
+		{FileId: "2", FileName: "b", Path: "/a/b", ParentId: "10", IsFolder: true},
+	})
+	e1.TargetPath = "/c"
+	if err := Append(e1); err != nil {
+		t.Fatalf("Append: %s", err)
+	}
+
+	// 无效的行和空行被忽略
+	f, err := os.OpenFile(JournalPath(), os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("not json\n\n{\"op\":\"rm\"}\n")
+	f.Close()
+
+	// 没有涉及文件的记录不保存
+	Record(NewEntry(OpRemove, 0))
+	Record(nil)
+
+	e2 := NewEntry(OpRemove, 123)
+	e2.AddEntity(&cloudpan.AppFileEntity{FileId: "3", FileName: "c.txt", Path: "/c.txt", ParentId: "-11"})
+	Record(e2)
+
+	entries, err = ReadAll()
+	if err != nil {
+		t.Fatalf("ReadAll: %s", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("ReadAll returned %d entries, want 2", len(entries))
+	}
+	got := entries[0]
+	if got.Id != e1.Id || got.Op != OpMove || got.TypeFlag != cloudpan.BatchTaskTypeMove ||
+		got.TargetFolderId != "100" || got.TargetPath != "/c" || len(got.Files) != 2 {
+		t.Errorf("entry 0 = %+v", got)
+	}
+	if f := got.Files[1]; f.FileId != "2" || f.Path != "/a/b" || f.ParentId != "10" || !f.IsFolder {
+		t.Errorf("entry 0 file 1 = %+v", f)
+	}
+	if got := entries[1]; got.Id != e2.Id || got.FamilyId != 123 || len(got.Files) != 1 || got.Files[0].FileId != "3" {
+		t.Errorf("entry 1 = %+v", got)
+	}
+}
@@ -0,0 +1,238 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package panrecycle
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+	"strconv"
+
+	"github.com/tickstep/cloudpan189-api/cloudpan"
+	"github.com/tickstep/cloudpan189-go/internal/functions"
+	"github.com/tickstep/library-go/logger"
+	"github.com/tickstep/library-go/requester"
+)
+
+type (
+	// RecycleClient 回收站的网页版接口, 支持个人云和家庭云
+	RecycleClient struct {
+		client *requester.HTTPClient
+	}
+
+	// RecycleFile 回收站中的文件或目录
+	RecycleFile struct {
+		FileId     string
+		FileName   string
+		FileSize   int64
+		MD5        string
+		IsFolder   bool
+		ParentId   string
+		Path       string // 删除前的完整路径
+		CreateDate string
+		LastOpTime string
+	}
+
+	// RecycleFileList 回收站中的文件列表
+	RecycleFileList []*RecycleFile
+
+	// recycleResp 接口的通用响应, res_code 可能是数字或字符串
+	recycleResp struct {
+		ResCode    interface{} `json:"res_code"`
+		ResMessage string      `json:"res_message"`
+	}
+
+	recycleItem struct {
+		Id         json.Number `json:"id"`
+		Name       string      `json:"name"`
+		Size       int64       `json:"size"`
+		Md5        string      `json:"md5"`
+		IsFolder   bool        `json:"isFolder"`
+		ParentId   json.Number `json:"parentId"`
+		PathStr    string      `json:"pathStr"`
+		CreateDate string      `json:"createDate"`
+		LastOpTime string      `json:"lastOpTime"`
+	}
+
+	listRecycleResp struct {
+		recycleResp
+		Count      int           `json:"count"`
+		FileList   []recycleItem `json:"fileList"`
+		FolderList []recycleItem `json:"folderList"`
+	}
+)
+
+const (
+	// ListPageSize 列出回收站时每页的数量
+	ListPageSize = 60
+)
+
+// NewRecycleClient 创建访问回收站的客户端
+func NewRecycleClient(webToken cloudpan.WebLoginToken) *RecycleClient {
+	return &RecycleClient{client: functions.NewWebClient(webToken)}
+}
+
+// doJSON 请求网页版接口, 检查 res_code 后解析响应
+func (rc *RecycleClient) doJSON(method, api string, params url.Values, v interface{}) error {
+	fullUrl := cloudpan.WEB_URL + api
+	var post map[string]string
+	if method == "GET" {
+		fullUrl += "?" + params.Encode()
+	} else {
+		post = map[string]string{}
+		for k := range params {
+			post[k] = params.Get(k)
+		}
+	}
+	header := map[string]string{
+		"accept":       "application/json;charset=UTF-8",
+		"Content-Type": "application/x-www-form-urlencoded; charset=UTF-8",
+	}
+	logger.Verboseln("do request url: " + fullUrl)
+	body, err := rc.client.Fetch(method, fullUrl, post, header)
+	if err != nil {
+		return err
+	}
+	resp := &recycleResp{}
+	if err = json.Unmarshal(body, resp); err != nil {
+		logger.Verboseln("recycle response: " + string(body))
+		return fmt.Errorf("解析回收站接口的响应失败: %s", err)
+	}
+	if code := fmt.Sprint(resp.ResCode); code != "0" && code != "<nil>" {
+		if resp.ResMessage != "" {
+			return errors.New(resp.ResMessage)
+		}
+		return fmt.Errorf("回收站接口返回错误: %s", code)
+	}
+	return json.Unmarshal(body, v)
+}
+
+func (item *recycleItem) toRecycleFile(isFolder bool) *RecycleFile {
+	f := &RecycleFile{
+		FileId:     item.Id.String(),
+		FileName:   item.Name,
+		FileSize:   item.Size,
+		MD5:        item.Md5,
+		IsFolder:   isFolder || item.IsFolder,
+		ParentId:   item.ParentId.String(),
+		Path:       item.PathStr,
+		CreateDate: item.CreateDate,
+		LastOpTime: item.LastOpTime,
+	}
+	// pathStr 可能只是所在目录的路径
+	if f.Path == "" {
+		f.Path = "/" + f.FileName
+	} else if path.Base(f.Path) != f.FileName {
+		f.Path = path.Join(f.Path, f.FileName)
+	}
+	return f
+}
+
+// List 列出回收站中的一页文件, 返回该页的文件和回收站中文件的总数
+func (rc *RecycleClient) List(familyId int64, pageNum int) (RecycleFileList, int, error) {
+	if pageNum < 1 {
+		pageNum = 1
+	}
+	params := url.Values{
+		"pageNum":    {strconv.Itoa(pageNum)},
+		"pageSize":   {strconv.Itoa(ListPageSize)},
+		"iconOption": {"1"},
+		"family":     {strconv.FormatBool(familyId > 0)},
+	}
+	if familyId > 0 {
+		params.Set("familyId", strconv.FormatInt(familyId, 10))
+	}
+	resp := &listRecycleResp{}
+	if err := rc.doJSON("GET", "/api/open/file/listRecycleBinFiles.action", params, resp); err != nil {
+		return nil, 0, fmt.Errorf("获取回收站文件列表失败: %s", err)
+	}
+	list := make(RecycleFileList, 0, len(resp.FolderList)+len(resp.FileList))
+	for k := range resp.FolderList {
+		list = append(list, resp.FolderList[k].toRecycleFile(true))
+	}
+	for k := range resp.FileList {
+		list = append(list, resp.FileList[k].toRecycleFile(false))
+	}
+	return list, resp.Count, nil
+}
+
+// Walk 遍历回收站中的全部文件, 自动翻页, fn 返回 false 时停止
+func (rc *RecycleClient) Walk(familyId int64, fn func(f *RecycleFile) bool) error {
+	got := 0
+	for pageNum := 1; ; pageNum++ {
+		list, count, err := rc.List(familyId, pageNum)
+		if err != nil {
+			return err
+		}
+		for _, f := range list {
+			if !fn(f) {
+				return nil
+			}
+		}
+		got += len(list)
+		if len(list) == 0 || got >= count {
+			return nil
+		}
+	}
+}
+
+// Restore 还原回收站中的文件到原来的位置, 返回批量任务的ID
+func (rc *RecycleClient) Restore(familyId int64, files RecycleFileList) (string, error) {
+	infos := make(cloudpan.BatchTaskInfoList, 0, len(files))
+	for _, f := range files {
+		isFolder := 0
+		if f.IsFolder {
+			isFolder = 1
+		}
+		infos = append(infos, &cloudpan.BatchTaskInfo{
+			FileId:      f.FileId,
+			FileName:    f.FileName,
+			IsFolder:    isFolder,
+			SrcParentId: f.ParentId,
+		})
+	}
+	taskInfos, err := json.Marshal(infos)
+	if err != nil {
+		return "", err
+	}
+	params := url.Values{
+		"type":      {string(cloudpan.BatchTaskTypeRecycleRestore)},
+		"taskInfos": {string(taskInfos)},
+	}
+	if familyId > 0 {
+		params.Set("familyId", strconv.FormatInt(familyId, 10))
+	}
+	resp := &struct {
+		recycleResp
+		TaskId string `json:"taskId"`
+	}{}
+	if err = rc.doJSON("POST", "/api/open/batch/createBatchTask.action", params, resp); err != nil {
+		return "", fmt.Errorf("还原文件失败: %s", err)
+	}
+	if resp.TaskId == "" {
+		return "", errors.New("还原文件失败")
+	}
+	return resp.TaskId, nil
+}
+
+// FileIds 文件ID列表
+func (fl RecycleFileList) FileIds() []string {
+	ids := make([]string, 0, len(fl))
+	for _, f := range fl {
+		ids = append(ids, f.FileId)
+	}
+	return ids
+}
@@ -0,0 +1,337 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package panshare
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tickstep/cloudpan189-api/cloudpan"
+	"github.com/tickstep/cloudpan189-go/internal/functions"
+	"github.com/tickstep/library-go/logger"
+	"github.com/tickstep/library-go/requester"
+)
+
+type (
+	// ShareClient 访问他人分享的接口, 使用网页版的登录状态
+	ShareClient struct {
+		client *requester.HTTPClient
+	}
+
+	// ShareInfo 分享的基本信息
+	ShareInfo struct {
+		ShareCode      string
+		AccessCode     string
+		ShareId        int64
+		ShareMode      int
+		FileId         string
+		FileName       string
+		FileSize       int64
+		IsFolder       bool
+		NeedAccessCode bool
+		ExpireType     int
+		ExpireTime     int
+	}
+
+	// ShareFile 分享中的文件或目录
+	ShareFile struct {
+		FileId     string
+		FileName   string
+		Path       string // 在分享中的路径, 以 / 开始
+		FileSize   int64
+		MD5        string
+		IsFolder   bool
+		LastOpTime string
+	}
+
+	// ShareFileList 分享中的文件列表
+	ShareFileList []*ShareFile
+
+	// shareResp 接口的通用响应, res_code 可能是数字或字符串
+	shareResp struct {
+		ResCode    interface{} `json:"res_code"`
+		ResMessage string      `json:"res_message"`
+	}
+
+	shareInfoResp struct {
+		shareResp
+		ShareId        int64  `json:"shareId"`
+		ShareMode      int    `json:"shareMode"`
+		FileId         string `json:"fileId"`
+		FileName       string `json:"fileName"`
+		FileSize       int64  `json:"fileSize"`
+		IsFolder       bool   `json:"isFolder"`
+		NeedAccessCode int    `json:"needAccessCode"`
+		ExpireType     int    `json:"expireType"`
+		ExpireTime     int    `json:"expireTime"`
+	}
+
+	listShareDirResp struct {
+		shareResp
+		FileListAO struct {
+			Count    int `json:"count"`
+			FileList []struct {
+				Id         int64  `json:"id"`
+				Name       string `json:"name"`
+				Size       int64  `json:"size"`
+				Md5        string `json:"md5"`
+				LastOpTime string `json:"lastOpTime"`
+			} `json:"fileList"`
+			FolderList []struct {
+				Id         int64  `json:"id"`
+				Name       string `json:"name"`
+				LastOpTime string `json:"lastOpTime"`
+			} `json:"folderList"`
+		} `json:"fileListAO"`
+	}
+)
+
+const (
+	// listShareDirPageSize 列出分享目录时每页的数量
+	listShareDirPageSize = 60
+)
+
+var (
+	// ErrAccessCodeRequired 私密分享没有提供访问码
+	ErrAccessCodeRequired = errors.New("该分享需要访问码")
+)
+
+// NewShareClient 创建访问分享的客户端, 带上网页版登录的 cookie
+func NewShareClient(webToken cloudpan.WebLoginToken) *ShareClient {
+	return &ShareClient{client: functions.NewWebClient(webToken)}
+}
+
+// getJSON 请求网页版接口, 检查 res_code 后解析响应
+func (sc *ShareClient) getJSON(shareCode, api string, params url.Values, v interface{}) error {
+	fullUrl := cloudpan.WEB_URL + api + "?" + params.Encode()
+	header := map[string]string{
+		"accept":     "application/json;charset=UTF-8",
+		"origin":     cloudpan.WEB_URL,
+		"Referer":    cloudpan.WEB_URL + "/web/share?code=" + shareCode,
+		"user-agent": "Mozilla/5.0 (Macintosh; Intel Mac OS X 11_3_0) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/88.0.4324.96 Safari/537.36",
+	}
+	logger.Verboseln("do request url: " + fullUrl)
+	body, err := sc.client.Fetch("GET", fullUrl, nil, header)
+	if err != nil {
+		return err
+	}
+	resp := &shareResp{}
+	if err = json.Unmarshal(body, resp); err != nil {
+		logger.Verboseln("share response: " + string(body))
+		return fmt.Errorf("解析分享接口的响应失败: %s", err)
+	}
+	if code := fmt.Sprint(resp.ResCode); code != "0" && code != "<nil>" {
+		if resp.ResMessage != "" {
+			return errors.New(resp.ResMessage)
+		}
+		return fmt.Errorf("分享接口返回错误: %s", code)
+	}
+	return json.Unmarshal(body, v)
+}
+
+// GetShareInfo 获取分享的基本信息, 私密分享会校验访问码
+func (sc *ShareClient) GetShareInfo(link *ShareLink) (*ShareInfo, error) {
+	resp := &shareInfoResp{}
+	if err := sc.getJSON(link.ShareCode, "/api/open/share/getShareInfoByCode.action", url.Values{
+		"shareCode": {link.ShareCode},
+	}, resp); err != nil {
+		return nil, fmt.Errorf("获取分享信息失败: %s", err)
+	}
+	info := &ShareInfo{
+		ShareCode:      link.ShareCode,
+		AccessCode:     link.AccessCode,
+		ShareId:        resp.ShareId,
+		ShareMode:      resp.ShareMode,
+		FileId:         resp.FileId,
+		FileName:       resp.FileName,
+		FileSize:       resp.FileSize,
+		IsFolder:       resp.IsFolder,
+		NeedAccessCode: resp.NeedAccessCode == 1,
+		ExpireType:     resp.ExpireType,
+		ExpireTime:     resp.ExpireTime,
+	}
+
+	if info.NeedAccessCode || info.ShareId == 0 {
+		if link.AccessCode == "" {
+			return nil, ErrAccessCodeRequired
+		}
+		check := &struct {
+			shareResp
+			ShareId int64 `json:"shareId"`
+		}{}
+		if err := sc.getJSON(link.ShareCode, "/api/open/share/checkAccessCode.action", url.Values{
+			"shareCode":  {link.ShareCode},
+			"accessCode": {link.AccessCode},
+		}, check); err != nil {
+			return nil, fmt.Errorf("访问码错误: %s", err)
+		}
+		if check.ShareId != 0 {
+			info.ShareId = check.ShareId
+		}
+	}
+	if info.ShareId == 0 || info.FileId == "" {
+		return nil, errors.New("获取分享信息失败, 分享不存在或已失效")
+	}
+	return info, nil
+}
+
+// Permanent 分享是否永久有效. ExpireTime 为创建分享时设置的有效天数, 永久分享为 2099
+func (si *ShareInfo) Permanent() bool {
+	return si.ExpireTime <= 0 || si.ExpireTime >= int(cloudpan.ShareExpiredTimeForever)
+}
+
+// ExpireAt 分享的过期时间, 永久分享返回零值
+func (si *ShareInfo) ExpireAt(shareTime time.Time) time.Time {
+	if si.Permanent() {
+		return time.Time{}
+	}
+	return shareTime.AddDate(0, 0, si.ExpireTime)
+}
+
+// listShareDir 列出分享中的目录, 自动翻页. dir 为空时列出分享的根
+func (sc *ShareClient) listShareDir(info *ShareInfo, dir *ShareFile) (ShareFileList, error) {
+	fileId, dirPath, isFolder := info.FileId, "/", info.IsFolder
+	if dir != nil {
+		fileId, dirPath, isFolder = dir.FileId, dir.Path, true
+	}
+
+	list := ShareFileList{}
+	for pageNum := 1; ; pageNum++ {
+		resp := &listShareDirResp{}
+		params := url.Values{
+			"pageNum":    {strconv.Itoa(pageNum)},
+			"pageSize":   {strconv.Itoa(listShareDirPageSize)},
+			"fileId":     {fileId},
+			"isFolder":   {strconv.FormatBool(isFolder)},
+			"shareId":    {strconv.FormatInt(info.ShareId, 10)},
+			"shareMode":  {strconv.Itoa(info.ShareMode)},
+			"iconOption": {"5"},
+			"orderBy":    {"filename"},
+			"descending": {"false"},
+			"accessCode": {info.AccessCode},
+		}
+		if isFolder {
+			params.Set("shareDirFileId", info.FileId)
+		}
+		if err := sc.getJSON(info.ShareCode, "/api/open/share/listShareDir.action", params, resp); err != nil {
+			return nil, fmt.Errorf("获取分享文件列表失败: %s", err)
+		}
+
+		for _, f := range resp.FileListAO.FolderList {
+			list = append(list, &ShareFile{
+				FileId:     strconv.FormatInt(f.Id, 10),
+				FileName:   f.Name,
+				Path:       path.Join(dirPath, f.Name),
+				IsFolder:   true,
+				LastOpTime: f.LastOpTime,
+			})
+		}
+		for _, f := range resp.FileListAO.FileList {
+			list = append(list, &ShareFile{
+				FileId:     strconv.FormatInt(f.Id, 10),
+				FileName:   f.Name,
+				Path:       path.Join(dirPath, f.Name),
+				FileSize:   f.Size,
+				MD5:        f.Md5,
+				LastOpTime: f.LastOpTime,
+			})
+		}
+		got := len(resp.FileListAO.FolderList) + len(resp.FileListAO.FileList)
+		if !isFolder || got == 0 || len(list) >= resp.FileListAO.Count {
+			break
+		}
+	}
+	return list, nil
+}
+
+// ListRoot 列出分享的根, 分享的是文件时只有该文件, 分享的是目录时为目录中的内容
+func (sc *ShareClient) ListRoot(info *ShareInfo) (ShareFileList, error) {
+	return sc.listShareDir(info, nil)
+}
+
+// ListDir 列出分享中的子目录
+func (sc *ShareClient) ListDir(info *ShareInfo, dir *ShareFile) (ShareFileList, error) {
+	return sc.listShareDir(info, dir)
+}
+
+// Walk 递归列出分享中的全部文件和目录, 目录在其内容之前, fn 返回 false 时不进入该目录
+func (sc *ShareClient) Walk(info *ShareInfo, fn func(f *ShareFile) bool) error {
+	list, err := sc.ListRoot(info)
+	if err != nil {
+		return err
+	}
+	return sc.walk(info, list, fn)
+}
+
+func (sc *ShareClient) walk(info *ShareInfo, list ShareFileList, fn func(f *ShareFile) bool) error {
+	for _, f := range list {
+		if !fn(f) || !f.IsFolder {
+			continue
+		}
+		sub, err := sc.ListDir(info, f)
+		if err != nil {
+			return err
+		}
+		if err = sc.walk(info, sub, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetDownloadUrl 获取分享中文件的下载链接, 需要登录
+func (sc *ShareClient) GetDownloadUrl(info *ShareInfo, fileId string) (string, error) {
+	resp := &struct {
+		shareResp
+		FileDownloadUrl string `json:"fileDownloadUrl"`
+	}{}
+	if err := sc.getJSON(info.ShareCode, "/api/open/file/getFileDownloadUrl.action", url.Values{
+		"fileId":  {fileId},
+		"dt":      {"1"},
+		"shareId": {strconv.FormatInt(info.ShareId, 10)},
+	}, resp); err != nil {
+		return "", fmt.Errorf("获取下载链接失败: %s", err)
+	}
+	durl := strings.ReplaceAll(resp.FileDownloadUrl, "&amp;", "&")
+	if strings.HasPrefix(durl, "//") {
+		durl = "https:" + durl
+	}
+	if durl == "" {
+		return "", errors.New("获取下载链接失败")
+	}
+	return durl, nil
+}
+
+// SaveTaskInfos 转存任务的文件列表
+func (fl ShareFileList) SaveTaskInfos() cloudpan.BatchTaskInfoList {
+	infos := make(cloudpan.BatchTaskInfoList, 0, len(fl))
+	for _, f := range fl {
+		isFolder := 0
+		if f.IsFolder {
+			isFolder = 1
+		}
+		infos = append(infos, &cloudpan.BatchTaskInfo{
+			FileId:   f.FileId,
+			FileName: f.FileName,
+			IsFolder: isFolder,
+		})
+	}
+	return infos
+}
@@ -0,0 +1,104 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package panshare
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+
+	"github.com/tickstep/cloudpan189-api/cloudpan"
+)
+
+type (
+	// ShareLink 解析后的分享链接
+	ShareLink struct {
+		ShareCode  string // 分享码, 短链接 /t/ 后面的部分
+		AccessCode string // 访问码, 公开分享为空
+	}
+)
+
+var (
+	// 短链接 /t/<code>, 网页版 share?code=<code>, 手机版 share.html#/t/<code>
+	shareCodeRegexp = regexp.MustCompile(`(?i)(?:/t/|[?&#]code=)([0-9a-z]+)`)
+	// 链接中的访问码 ?pwd=<code>
+	urlAccessCodeRegexp = regexp.MustCompile(`(?i)[?&#](?:pwd|accesscode|access_code)=([0-9a-z]+)`)
+	// 复制的分享文本中的访问码, 如 "（访问码：io7x）"
+	textAccessCodeRegexp = regexp.MustCompile(`(?:访问码|提取码|密码|code)\s*[:：]?\s*([0-9A-Za-z]{4,8})`)
+	// 只有分享码
+	bareShareCodeRegexp = regexp.MustCompile(`^[0-9A-Za-z]{8,}$`)
+	// 单独的访问码
+	bareAccessCodeRegexp = regexp.MustCompile(`^[0-9A-Za-z]{4,8}$`)
+
+	// ErrShareLinkInvalid 无法识别分享链接
+	ErrShareLinkInvalid = errors.New("分享链接错误, 无法识别分享码")
+)
+
+// ParseShareLink 解析分享链接, 支持短链接、带 ?pwd= 的链接和复制的整段分享文本.
+// 访问码可以包含在链接和文本中, 也可以作为最后一个单独的参数
+func ParseShareLink(text string) (*ShareLink, error) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return nil, ErrShareLinkInvalid
+	}
+	// 全角符号统一为半角, 方便匹配
+	text = strings.NewReplacer("（", " (", "）", ") ", "：", ":", "　", " ").Replace(text)
+
+	link := &ShareLink{}
+	fields := strings.Fields(text)
+	urlIndex := -1
+	for i, field := range fields {
+		if m := shareCodeRegexp.FindStringSubmatch(field); m != nil {
+			link.ShareCode = m[1]
+			urlIndex = i
+			if m := urlAccessCodeRegexp.FindStringSubmatch(field); m != nil {
+				link.AccessCode = m[1]
+			}
+			break
+		}
+	}
+	if link.ShareCode == "" {
+		// 直接输入的分享码
+		if len(fields) == 0 || !bareShareCodeRegexp.MatchString(fields[0]) {
+			return nil, ErrShareLinkInvalid
+		}
+		link.ShareCode = fields[0]
+		urlIndex = 0
+	}
+
+	if link.AccessCode == "" {
+		rest := strings.Join(fields[urlIndex+1:], " ")
+		if m := textAccessCodeRegexp.FindStringSubmatch(rest); m != nil {
+			link.AccessCode = m[1]
+		} else if len(fields) > urlIndex+1 {
+			last := strings.Trim(fields[len(fields)-1], "()")
+			if bareAccessCodeRegexp.MatchString(last) {
+				link.AccessCode = last
+			}
+		}
+	}
+	return link, nil
+}
+
+// URL 分享的短链接
+func (l *ShareLink) URL() string {
+	return cloudpan.WEB_URL + "/t/" + l.ShareCode
+}
+
+func (l *ShareLink) String() string {
+	if l.AccessCode == "" {
+		return l.URL()
+	}
+	return l.URL() + "（访问码：" + l.AccessCode + "）"
+}
@@ -0,0 +1,63 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package panshare
+
+import "testing"
+
+func TestParseShareLink(t *testing.T) {
+	testCases := []struct {
+		text       string
+		shareCode  string
+		accessCode string
+	}{
+		{"https://cloud.189.cn/t/2MjYfe2E7ZJz", "2MjYfe2E7ZJz", ""},
+		{"https://cloud.189.cn/t/2MjYfe2E7ZJz（访问码：io7x）", "2MjYfe2E7ZJz", "io7x"},
+		{"https://cloud.189.cn/t/2MjYfe2E7ZJz (访问码:io7x)", "2MjYfe2E7ZJz", "io7x"},
+		{"https://cloud.189.cn/t/2MjYfe2E7ZJz 访问码：io7x", "2MjYfe2E7ZJz", "io7x"},
+		{"https://cloud.189.cn/t/2MjYfe2E7ZJz 提取码 io7x", "2MjYfe2E7ZJz", "io7x"},
+		{"https://cloud.189.cn/t/2MjYfe2E7ZJz io7x", "2MjYfe2E7ZJz", "io7x"},
+		{"链接：https://cloud.189.cn/t/2MjYfe2E7ZJz（访问码：io7x） 复制这段内容打开天翼云盘", "2MjYfe2E7ZJz", "io7x"},
+		{"https://cloud.189.cn/web/share?code=2MjYfe2E7ZJz&pwd=io7x", "2MjYfe2E7ZJz", "io7x"},
+		{"https://h5.cloud.189.cn/share.html#/t/2MjYfe2E7ZJz", "2MjYfe2E7ZJz", ""},
+		{"2MjYfe2E7ZJz", "2MjYfe2E7ZJz", ""},
+		{"  2MjYfe2E7ZJz io7x  ", "2MjYfe2E7ZJz", "io7x"},
+	}
+
+	for _, tc := range testCases {
+		link, err := ParseShareLink(tc.text)
+		if err != nil {
+			t.Errorf("ParseShareLink(%q) error = %v", tc.text, err)
+			continue
+		}
+		if link.ShareCode != tc.shareCode || link.AccessCode != tc.accessCode {
+			t.Errorf("ParseShareLink(%q) = %q, %q, want %q, %q", tc.text, link.ShareCode, link.AccessCode, tc.shareCode, tc.accessCode)
+		}
+	}
+}
+
+func TestParseShareLinkInvalid(t *testing.T) {
+	for _, text := range []string{"", "   ", "io7x", "https://cloud.189.cn/web/main"} {
+		if _, err := ParseShareLink(text); err != ErrShareLinkInvalid {
+			t.Errorf("ParseShareLink(%q) error = %v, want %v", text, err, ErrShareLinkInvalid)
+		}
+	}
+}
+
+func TestShareLinkString(t *testing.T) {
+	link := &ShareLink{ShareCode: "2MjYfe2E7ZJz", AccessCode: "io7x"}
+	parsed, err := ParseShareLink(link.String())
+	if err != nil || *parsed != *link {
+		t.Errorf("ParseShareLink(%q) = %v, %v, want %v", link.String(), parsed, err, link)
+	}
+}
@@ -0,0 +1,205 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package panupload
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/tickstep/cloudpan189-api/cloudpan"
+	"github.com/tickstep/cloudpan189-api/cloudpan/apierror"
+	"github.com/tickstep/cloudpan189-go/internal/config"
+	"github.com/tickstep/cloudpan189-go/internal/functions"
+	"github.com/tickstep/cloudpan189-go/internal/functions/panjournal"
+)
+
+type (
+	// ConflictPolicy 网盘已存在同名文件时的处理策略
+	ConflictPolicy string
+
+	// ConflictAction 同名文件处理后的动作
+	ConflictAction int
+)
+
+const (
+	// ConflictDefault 不检查同名文件, 由网盘处理
+	ConflictDefault ConflictPolicy = ""
+	// ConflictSkip 跳过上传
+	ConflictSkip ConflictPolicy = "skip"
+	// ConflictOverwrite 覆盖, 已存在的文件会被移到回收站
+	ConflictOverwrite ConflictPolicy = "overwrite"
+	// ConflictRenameNew 上传的文件名后追加 (1)
+	ConflictRenameNew ConflictPolicy = "rename-new"
+	// ConflictRenameOld 已存在的文件重命名为带时间戳的文件名
+	ConflictRenameOld ConflictPolicy = "rename-old"
+	// ConflictSkipIfIdentical MD5一致时跳过, 否则覆盖
+	ConflictSkipIfIdentical ConflictPolicy = "skip-if-identical"
+)
+
+const (
+	// ConflictActionUpload 继续上传
+	ConflictActionUpload ConflictAction = iota
+	// ConflictActionSkip 跳过上传
+	ConflictActionSkip
+	// ConflictActionIdentical 网盘已存在相同的文件, 无需上传
+	ConflictActionIdentical
+	// ConflictActionReplace 继续上传, 提交前需要删除或重命名已存在的文件
+	ConflictActionReplace
+)
+
+// ParseConflictPolicy 解析同名文件处理策略, isOverwrite 对应 -ow 参数
+func ParseConflictPolicy(s string, isOverwrite bool) (ConflictPolicy, error) {
+	p := ConflictPolicy(strings.ToLower(strings.TrimSpace(s)))
+	switch p {
+	case ConflictDefault:
+		if isOverwrite {
+			return ConflictSkipIfIdentical, nil
+		}
+		return p, nil
+	case ConflictSkip, ConflictOverwrite, ConflictRenameNew, ConflictRenameOld, ConflictSkipIfIdentical:
+		return p, nil
+	}
+	return "", fmt.Errorf("不支持的同名文件处理策略: %s", s)
+}
+
+// ResolveConflict 按策略处理网盘中的同名文件, md5Str 为待上传文件的MD5, forceProtected 为 true 时允许覆盖受保护的路径
+// 返回最终的保存路径, 以及网盘中已存在的同名文件
+func ResolveConflict(panClient *cloudpan.PanClient, familyId int64, savePath, md5Str string, policy ConflictPolicy, forceProtected bool) (finalPath string, efi *cloudpan.AppFileEntity, action ConflictAction, err error) {
+	finalPath, efi, action, err = CheckConflict(panClient, familyId, savePath, md5Str, policy, forceProtected)
+	if err != nil || action != ConflictActionReplace {
+		return
+	}
+	return finalPath, efi, ConflictActionUpload, ApplyConflict(panClient, familyId, savePath, efi, policy, forceProtected)
+}
+
+// CheckConflict 检查网盘中的同名文件并决定处理方式, 不修改网盘文件.
+// 需要删除或重命名已存在的文件时返回 ConflictActionReplace, 由调用方在提交上传前调用 ApplyConflict
+func CheckConflict(panClient *cloudpan.PanClient, familyId int64, savePath, md5Str string, policy ConflictPolicy, forceProtected bool) (finalPath string, efi *cloudpan.AppFileEntity, action ConflictAction, err error) {
+	finalPath = savePath
+	if policy == ConflictDefault {
+		return
+	}
+
+	// 检查同名文件是否存在
+	efi, apierr := panClient.AppFileInfoByPath(familyId, savePath)
+	if apierr != nil && apierr.Code != apierror.ApiCodeFileNotFoundCode {
+		return "", nil, ConflictActionUpload, fmt.Errorf("检测同名文件失败: %s", apierr)
+	}
+	if efi == nil || efi.FileId == "" {
+		return finalPath, nil, ConflictActionUpload, nil
+	}
+
+	switch policy {
+	case ConflictSkip:
+		return finalPath, efi, ConflictActionSkip, nil
+	case ConflictSkipIfIdentical:
+		if !efi.IsFolder && strings.EqualFold(efi.FileMd5, md5Str) {
+			return finalPath, efi, ConflictActionIdentical, nil
+		}
+		fallthrough
+	case ConflictOverwrite:
+		// 尽早拒绝覆盖受保护的路径
+		if !forceProtected {
+			if err = config.Config.CheckProtectedPath(familyId, savePath); err != nil {
+				return finalPath, efi, ConflictActionUpload, err
+			}
+		}
+		return finalPath, efi, ConflictActionReplace, nil
+	case ConflictRenameNew:
+		finalPath, err = uniquePanPath(panClient, familyId, savePath)
+		return finalPath, efi, ConflictActionUpload, err
+	case ConflictRenameOld:
+		return finalPath, efi, ConflictActionReplace, nil
+	}
+	return finalPath, efi, ConflictActionUpload, nil
+}
+
+// ApplyConflict 按策略删除或重命名网盘中已存在的同名文件 efi
+func ApplyConflict(panClient *cloudpan.PanClient, familyId int64, savePath string, efi *cloudpan.AppFileEntity, policy ConflictPolicy, forceProtected bool) error {
+	switch policy {
+	case ConflictOverwrite, ConflictSkipIfIdentical:
+		return deleteConflictFile(panClient, familyId, savePath, efi, forceProtected)
+	case ConflictRenameOld:
+		return renamePanFile(panClient, familyId, savePath, efi, timestampedName(efi.FileName, time.Now()))
+	}
+	return nil
+}
+
+// journalEntity 操作日志中记录的网盘文件, 路径使用上传的保存路径
+func journalEntity(savePath string, efi *cloudpan.AppFileEntity) *cloudpan.AppFileEntity {
+	e := *efi
+	e.Path = savePath
+	return &e
+}
+
+// deleteConflictFile 将同名文件移到回收站, 并记录到操作日志, 受保护的路径不能覆盖
+func deleteConflictFile(panClient *cloudpan.PanClient, familyId int64, savePath string, efi *cloudpan.AppFileEntity, forceProtected bool) error {
+	if !forceProtected {
+		if err := config.Config.CheckProtectedPath(familyId, savePath); err != nil {
+			return err
+		}
+	}
+	if err := functions.DeletePanFile(panClient, familyId, efi); err != nil {
+		return err
+	}
+	entry := panjournal.NewEntry(panjournal.OpOverwrite, familyId)
+	entry.TypeFlag = cloudpan.BatchTaskTypeDelete
+	entry.AddEntity(journalEntity(savePath, efi))
+	panjournal.Record(entry)
+	return nil
+}
+
+// renamePanFile 重命名网盘文件
+func renamePanFile(panClient *cloudpan.PanClient, familyId int64, savePath string, efi *cloudpan.AppFileEntity, newName string) error {
+	var apierr *apierror.ApiError
+	if familyId > 0 {
+		_, apierr = panClient.AppFamilyRenameFile(familyId, efi.FileId, newName)
+	} else {
+		_, apierr = panClient.AppRenameFile(efi.FileId, newName)
+	}
+	if apierr != nil {
+		return fmt.Errorf("重命名已存在的文件失败: %s", apierr)
+	}
+	entry := panjournal.NewEntry(panjournal.OpRename, familyId)
+	entry.NewName = newName
+	entry.AddEntity(journalEntity(savePath, efi))
+	panjournal.Record(entry)
+	return nil
+}
+
+// uniquePanPath 返回网盘中不存在的文件路径, 如 /a.txt -> /a (1).txt
+func uniquePanPath(panClient *cloudpan.PanClient, familyId int64, savePath string) (string, error) {
+	dir, name := path.Split(savePath)
+	ext := path.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	for i := 1; ; i++ {
+		p := path.Join(dir, fmt.Sprintf("%s (%d)%s", base, i, ext))
+		_, apierr := panClient.AppFileInfoByPath(familyId, p)
+		if apierr == nil {
+			continue
+		}
+		if apierr.Code == apierror.ApiCodeFileNotFoundCode {
+			return p, nil
+		}
+		return "", fmt.Errorf("检测同名文件失败: %s", apierr)
+	}
+}
+
+// timestampedName 返回带时间戳的文件名, 如 a.txt -> a_20060102150405.txt
+func timestampedName(name string, t time.Time) string {
+	ext := path.Ext(name)
+	return strings.TrimSuffix(name, ext) + "_" + t.Format("20060102150405") + ext
+}
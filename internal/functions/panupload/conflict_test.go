@@ -0,0 +1,121 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package panupload
+
+import (
+	"testing"
+	"time"
+
+	"github.com/tickstep/cloudpan189-go/internal/functions"
+)
+
+func TestParseConflictPolicy(t *testing.T) {
+	tests := []struct {
+		in          string
+		isOverwrite bool
+		want        ConflictPolicy
+		wantErr     bool
+	}{
+		{"", false, ConflictDefault, false},
+		{"", true, ConflictSkipIfIdentical, false},
+		{"skip", false, ConflictSkip, false},
+		{"skip", true, ConflictSkip, false},
+		{" Overwrite ", false, ConflictOverwrite, false},
+		{"rename-new", false, ConflictRenameNew, false},
+		{"RENAME-OLD", false, ConflictRenameOld, false},
+		{"skip-if-identical", false, ConflictSkipIfIdentical, false},
+		{"rename", false, "", true},
+		{"replace", true, "", true},
+	}
+	for _, tt := range tests {
+		got, err := ParseConflictPolicy(tt.in, tt.isOverwrite)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseConflictPolicy(%q, %v) err = %v, wantErr %v", tt.in, tt.isOverwrite, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseConflictPolicy(%q, %v) = %q, want %q", tt.in, tt.isOverwrite, got, tt.want)
+		}
+	}
+}
+
+func TestTimestampedName(t *testing.T) {
+	ts := time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"a.txt", "a_20210304050607.txt"},
+		{"a.tar.gz", "a.tar_20210304050607.gz"},
+		{"noext", "noext_20210304050607"},
+	}
+	for _, tt := range tests {
+		if got := timestampedName(tt.in, ts); got != tt.want {
+			t.Errorf("timestampedName(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestPackRemoteDirCheckConflict(t *testing.T) {
+	newRemoteDir := func() *PackRemoteDir {
+		rd := NewPackRemoteDir()
+		rd.Names["loose.txt"] = true
+		rd.addIndex(&functions.PackIndex{Segment: "new.tar", Members: []*functions.PackMember{{Name: "a.txt", Size: 3, MD5: "AAA"}}})
+		// 较旧的 tar 文件中的同名文件被忽略
+		rd.addIndex(&functions.PackIndex{Segment: "old.tar", Members: []*functions.PackMember{{Name: "a.txt", Size: 3, MD5: "old"}, {Name: "a (1).txt", Size: 1, MD5: "x"}}})
+		return rd
+	}
+	md5Of := func(s string) func() (string, error) {
+		return func() (string, error) { return s, nil }
+	}
+
+	if rd := newRemoteDir(); rd.Segment("a.txt") != "new.tar" {
+		t.Errorf("Segment(a.txt) = %s", rd.Segment("a.txt"))
+	}
+
+	tests := []struct {
+		savePath   string
+		size       int64
+		md5        string
+		policy     ConflictPolicy
+		wantPath   string
+		wantAction ConflictAction
+	}{
+		{"/d/b.txt", 3, "aaa", ConflictSkip, "/d/b.txt", ConflictActionUpload},
+		{"/d/a.txt", 3, "aaa", ConflictSkip, "/d/a.txt", ConflictActionSkip},
+		{"/d/a.txt", 3, "aaa", ConflictDefault, "/d/a.txt", ConflictActionIdentical},
+		{"/d/a.txt", 3, "bbb", ConflictDefault, "/d/a.txt", ConflictActionUpload},
+		{"/d/a.txt", 3, "aaa", ConflictSkipIfIdentical, "/d/a.txt", ConflictActionIdentical},
+		{"/d/a.txt", 4, "aaa", ConflictSkipIfIdentical, "/d/a.txt", ConflictActionUpload},
+		{"/d/a.txt", 3, "aaa", ConflictOverwrite, "/d/a.txt", ConflictActionUpload},
+		{"/d/a.txt", 3, "aaa", ConflictRenameNew, "/d/a (2).txt", ConflictActionUpload},
+	}
+	for _, tt := range tests {
+		gotPath, gotAction, err := newRemoteDir().CheckConflict(0, tt.savePath, tt.size, md5Of(tt.md5), tt.policy, true)
+		if err != nil {
+			t.Errorf("CheckConflict(%s, %s) err = %s", tt.savePath, tt.policy, err)
+			continue
+		}
+		if gotPath != tt.wantPath || gotAction != tt.wantAction {
+			t.Errorf("CheckConflict(%s, %s) = %s, %d, want %s, %d", tt.savePath, tt.policy, gotPath, gotAction, tt.wantPath, tt.wantAction)
+		}
+	}
+
+	// 和本次改名后打包的文件同名时也需要改名
+	rd := newRemoteDir()
+	rd.CheckConflict(0, "/d/a.txt", 3, md5Of("aaa"), ConflictRenameNew, true)
+	if gotPath, _, _ := rd.CheckConflict(0, "/d/a (2).txt", 3, md5Of("aaa"), ConflictDefault, true); gotPath != "/d/a (2) (1).txt" {
+		t.Errorf("CheckConflict after rename = %s", gotPath)
+	}
+}
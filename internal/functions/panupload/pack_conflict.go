@@ -0,0 +1,181 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package panupload
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/tickstep/cloudpan189-api/cloudpan"
+	"github.com/tickstep/cloudpan189-api/cloudpan/apierror"
+	"github.com/tickstep/cloudpan189-go/internal/config"
+	"github.com/tickstep/cloudpan189-go/internal/functions"
+	"github.com/tickstep/cloudpan189-go/library/crypto"
+)
+
+type (
+	// PackRemoteDir 网盘目录中已存在的文件, 打包上传前用于处理同名文件
+	PackRemoteDir struct {
+		Names   map[string]bool                  // 单独上传的文件和目录
+		Members map[string]*functions.PackMember // 打包的文件, 同名时为最新的 tar 文件中的
+
+		segments map[string]string // 打包的文件 => 所在的 tar 文件名
+		packed   map[string]bool   // 本次已打包的文件名
+	}
+)
+
+// NewPackRemoteDir 创建空的网盘目录信息
+func NewPackRemoteDir() *PackRemoteDir {
+	return &PackRemoteDir{
+		Names:    map[string]bool{},
+		Members:  map[string]*functions.PackMember{},
+		segments: map[string]string{},
+		packed:   map[string]bool{},
+	}
+}
+
+// LoadPackRemoteDir 读取网盘目录中的文件和打包索引, 目录不存在时返回空的结果, 加密的索引使用 keychain 解密
+func LoadPackRemoteDir(panClient *cloudpan.PanClient, familyId int64, saveDir string, keychain *crypto.Keychain) (*PackRemoteDir, error) {
+	rd := NewPackRemoteDir()
+	dirInfo, apierr := panClient.AppFileInfoByPath(familyId, saveDir)
+	if apierr != nil {
+		if apierr.Code == apierror.ApiCodeFileNotFoundCode {
+			return rd, nil
+		}
+		return nil, fmt.Errorf("获取网盘目录信息失败: %s", apierr)
+	}
+	param := cloudpan.NewAppFileListParam()
+	param.FamilyId = familyId
+	param.FileId = dirInfo.FileId
+	fileResult, apierr := panClient.AppGetAllFileList(param)
+	if apierr != nil {
+		return nil, fmt.Errorf("获取网盘文件列表失败: %s", apierr)
+	}
+
+	indexList := make([]*cloudpan.AppFileEntity, 0)
+	for _, f := range fileResult.FileList {
+		switch {
+		case f.IsFolder || !functions.IsPackFileName(f.FileName):
+			rd.Names[f.FileName] = true
+		case functions.IsPackIndexName(f.FileName):
+			indexList = append(indexList, f)
+		}
+	}
+
+	// 较新的打包文件在前, 同名文件以最新的为准
+	sort.Slice(indexList, func(i, j int) bool {
+		return indexList[i].FileName > indexList[j].FileName
+	})
+	for _, indexInfo := range indexList {
+		data, err := functions.ReadPanFile(panClient, familyId, indexInfo, 0, 0)
+		if err == nil && crypto.IsEncrypted(data) {
+			if keychain == nil {
+				err = errors.New("打包索引已加密, 请使用 --encrypt 参数并提供密码")
+			} else {
+				data, err = keychain.DecryptBytes(data)
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("读取打包索引失败: %s, %s", indexInfo.FileName, err)
+		}
+		index, err := functions.ParsePackIndex(data)
+		if err != nil {
+			// 无效的索引下载时也不会解包
+			continue
+		}
+		rd.addIndex(index)
+	}
+	return rd, nil
+}
+
+// addIndex 加入打包索引中的文件, 已有同名文件时保留原来的
+func (rd *PackRemoteDir) addIndex(index *functions.PackIndex) {
+	for _, m := range index.Members {
+		if rd.Members[m.Name] == nil {
+			rd.Members[m.Name] = m
+			rd.segments[m.Name] = index.Segment
+		}
+	}
+}
+
+// Segment 打包的文件所在的 tar 文件名
+func (rd *PackRemoteDir) Segment(name string) string {
+	return rd.segments[name]
+}
+
+// CheckConflict 按策略处理和网盘上已打包的文件同名的情况, 返回打包使用的保存路径.
+// 打包的文件不能单独删除或重命名, 覆盖和 rename-old 时新文件写入新的 tar 文件, 下载时以最新的为准.
+// localMD5 只在大小一致时调用, 用于判断文件是否相同
+func (rd *PackRemoteDir) CheckConflict(familyId int64, savePath string, size int64, localMD5 func() (string, error), policy ConflictPolicy, forceProtected bool) (finalPath string, action ConflictAction, err error) {
+	finalPath = savePath
+	defer func() {
+		if err == nil && action == ConflictActionUpload {
+			rd.packed[path.Base(finalPath)] = true
+		}
+	}()
+
+	name := path.Base(savePath)
+	if rd.packed[name] {
+		// 和本次改名后打包的文件同名
+		return rd.uniquePath(savePath), ConflictActionUpload, nil
+	}
+	m := rd.Members[name]
+	if m == nil {
+		return finalPath, ConflictActionUpload, nil
+	}
+
+	switch policy {
+	case ConflictSkip:
+		return finalPath, ConflictActionSkip, nil
+	case ConflictRenameNew:
+		return rd.uniquePath(savePath), ConflictActionUpload, nil
+	case ConflictDefault, ConflictSkipIfIdentical:
+		if m.Size == size {
+			md5Str, err := localMD5()
+			if err != nil {
+				return finalPath, ConflictActionUpload, err
+			}
+			if strings.EqualFold(m.MD5, md5Str) {
+				return finalPath, ConflictActionIdentical, nil
+			}
+		}
+		if policy == ConflictDefault {
+			return finalPath, ConflictActionUpload, nil
+		}
+	}
+
+	// 覆盖已打包的文件
+	if !forceProtected {
+		if err = config.Config.CheckProtectedPath(familyId, savePath); err != nil {
+			return finalPath, ConflictActionUpload, err
+		}
+	}
+	return finalPath, ConflictActionUpload, nil
+}
+
+// uniquePath 返回目录中不存在的文件路径, 如 /a.txt -> /a (1).txt
+func (rd *PackRemoteDir) uniquePath(savePath string) string {
+	dir, name := path.Split(savePath)
+	ext := path.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	for i := 1; ; i++ {
+		n := fmt.Sprintf("%s (%d)%s", base, i, ext)
+		if !rd.Names[n] && rd.Members[n] == nil && !rd.packed[n] {
+			return path.Join(dir, n)
+		}
+	}
+}
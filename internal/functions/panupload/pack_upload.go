@@ -0,0 +1,289 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package panupload
+
+import (
+	"archive/tar"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/tickstep/cloudpan189-go/internal/functions"
+	"github.com/tickstep/library-go/converter"
+)
+
+const (
+	// PackSegmentIndex 打包上传中 tar 文件任务的序号
+	PackSegmentIndex = 0
+	// PackIndexIndex 打包上传中索引文件任务的序号
+	PackIndexIndex = 1
+
+	// DefaultPackSegmentSize 单个 tar 文件的大小上限, 超过后开始新的 tar 文件
+	DefaultPackSegmentSize = 256 * converter.MB
+	// MaxPackFileSize 可以打包的文件大小上限
+	MaxPackFileSize = 64 * converter.MB
+)
+
+type (
+	// PackBuilder 将同一目录下的小文件写入本地临时 tar 文件
+	PackBuilder struct {
+		LocalDir string // 本地目录
+		SaveDir  string // 网盘保存目录
+
+		index      *functions.PackIndex
+		localPaths []string
+		file       *os.File
+		counter    *countWriter
+		tw         *tar.Writer
+	}
+
+	// PackUploadGroup 一个 tar 文件和索引的上传任务组
+	// tar 文件上传成功后才上传索引, 下载时根据索引解包
+	PackUploadGroup struct {
+		SaveDir       string               // 网盘保存目录
+		Index         *functions.PackIndex // 打包索引
+		LocalPaths    []string             // 打包的本地文件, 和 Index.Members 一一对应
+		SegmentFile   string               // 本地临时 tar 文件
+		IndexFile     string               // 本地临时索引文件
+		IsMove        bool                 // 索引上传成功后删除本地文件
+		LocalRootPath string               // 移动模式下删除空目录不会超出该路径
+		FolderSyncDb  SyncDb               // 备份数据库, 记录每个打包文件的上传状态
+
+		// OnSegmentUploaded tar 文件上传成功后调用, 用于将索引文件加入上传队列
+		OnSegmentUploaded func()
+
+		segment *UploadedFileMeta // 上传成功的 tar 文件
+	}
+
+	countWriter struct {
+		f *os.File
+		n int64
+	}
+)
+
+func (cw *countWriter) Write(p []byte) (int, error) {
+	n, err := cw.f.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+// NewPackBuilder 创建小文件打包, segmentName 为 tar 文件名
+func NewPackBuilder(localDir, saveDir, segmentName string) (*PackBuilder, error) {
+	f, err := ioutil.TempFile("", "cloudpan189-pack-")
+	if err != nil {
+		return nil, err
+	}
+	cw := &countWriter{f: f}
+	return &PackBuilder{
+		LocalDir: localDir,
+		SaveDir:  saveDir,
+		index: &functions.PackIndex{
+			Version: functions.PackIndexVersion,
+			Segment: segmentName,
+		},
+		file:    f,
+		counter: cw,
+		tw:      tar.NewWriter(cw),
+	}, nil
+}
+
+// Size 已写入的大小
+func (b *PackBuilder) Size() int64 {
+	return b.counter.n
+}
+
+// Len 已打包的文件数量
+func (b *PackBuilder) Len() int {
+	return len(b.localPaths)
+}
+
+// LocalPaths 已打包的本地文件
+func (b *PackBuilder) LocalPaths() []string {
+	return b.localPaths
+}
+
+// SavePath 第 index 个打包文件单独上传时的网盘保存路径
+func (b *PackBuilder) SavePath(index int) string {
+	return path.Join(b.SaveDir, b.index.Members[index].Name)
+}
+
+// Add 将文件加入 tar 文件, 并记录文件数据的偏移, name 为文件在网盘上的文件名
+func (b *PackBuilder) Add(localPath, name string, fi os.FileInfo) error {
+	// 小文件整个读入, 避免读取时文件大小变化导致 tar 文件损坏
+	data, err := ioutil.ReadFile(localPath)
+	if err != nil {
+		return err
+	}
+	hdr := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Mode:     int64(fi.Mode().Perm()),
+		Size:     int64(len(data)),
+		ModTime:  fi.ModTime(),
+	}
+	if err = b.tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	// 头部已写入, 当前位置即为文件数据的偏移
+	offset := b.counter.n
+	if _, err = b.tw.Write(data); err != nil {
+		return err
+	}
+	sum := md5.Sum(data)
+	b.index.Members = append(b.index.Members, &functions.PackMember{
+		Name:    name,
+		Offset:  offset,
+		Size:    int64(len(data)),
+		MD5:     hex.EncodeToString(sum[:]),
+		ModTime: fi.ModTime().Unix(),
+	})
+	b.localPaths = append(b.localPaths, localPath)
+	return nil
+}
+
+// Discard 放弃打包, 删除临时文件
+func (b *PackBuilder) Discard() {
+	b.file.Close()
+	os.Remove(b.file.Name())
+}
+
+// Finish 结束打包, 生成索引文件并返回上传任务组
+func (b *PackBuilder) Finish() (*PackUploadGroup, error) {
+	err := b.tw.Close()
+	if err == nil {
+		err = b.file.Close()
+	} else {
+		b.file.Close()
+	}
+	if err != nil {
+		os.Remove(b.file.Name())
+		return nil, err
+	}
+	b.index.Size = b.counter.n
+
+	data, err := jsoniter.MarshalIndent(b.index, "", "  ")
+	if err != nil {
+		os.Remove(b.file.Name())
+		return nil, err
+	}
+	f, err := ioutil.TempFile("", "cloudpan189-pack-index-")
+	if err != nil {
+		os.Remove(b.file.Name())
+		return nil, err
+	}
+	_, err = f.Write(data)
+	f.Close()
+	if err != nil {
+		os.Remove(f.Name())
+		os.Remove(b.file.Name())
+		return nil, err
+	}
+
+	return &PackUploadGroup{
+		SaveDir:     b.SaveDir,
+		Index:       b.index,
+		LocalPaths:  b.localPaths,
+		SegmentFile: b.file.Name(),
+		IndexFile:   f.Name(),
+	}, nil
+}
+
+// SegmentSavePath tar 文件的网盘保存路径
+func (g *PackUploadGroup) SegmentSavePath() string {
+	return path.Join(g.SaveDir, g.Index.Segment)
+}
+
+// IndexSavePath 索引文件的网盘保存路径
+func (g *PackUploadGroup) IndexSavePath() string {
+	return g.SegmentSavePath() + functions.PackIndexSuffix
+}
+
+// unitDone tar 文件或索引任务结束
+func (g *PackUploadGroup) unitDone(index int, ufm *UploadedFileMeta, ok bool) {
+	if index == PackSegmentIndex {
+		os.Remove(g.SegmentFile)
+		if !ok {
+			os.Remove(g.IndexFile)
+			fmt.Printf("打包文件上传失败, 未上传打包索引: %s\n", g.SegmentSavePath())
+			return
+		}
+		g.segment = ufm
+		g.OnSegmentUploaded()
+		return
+	}
+
+	os.Remove(g.IndexFile)
+	if !ok {
+		return
+	}
+
+	if g.FolderSyncDb != nil {
+		g.putSyncRecords(ufm)
+	}
+
+	if g.IsMove {
+		for _, localPath := range g.LocalPaths {
+			if err := os.Remove(localPath); err != nil {
+				fmt.Printf("删除本地文件失败: %s\n", err)
+				continue
+			}
+		}
+		fmt.Printf("已删除打包上传的 %d 个本地文件\n", len(g.LocalPaths))
+		if len(g.LocalPaths) > 0 {
+			removeEmptyDirs(filepath.Dir(g.LocalPaths[0]), g.LocalRootPath)
+		}
+	}
+}
+
+// putSyncRecords 记录 tar 文件和打包的文件, 打包的文件记录 tar 文件和索引的文件ID, 用于备份同步时删除
+func (g *PackUploadGroup) putSyncRecords(indexMeta *UploadedFileMeta) {
+	seg := g.segment
+	if seg == nil {
+		seg = &UploadedFileMeta{}
+	}
+	indexId := ""
+	if indexMeta != nil {
+		indexId = indexMeta.FileID
+	}
+	g.FolderSyncDb.Put(g.SegmentSavePath(), &UploadedFileMeta{
+		MD5:         seg.MD5,
+		FileID:      seg.FileID,
+		ParentId:    seg.ParentId,
+		Rev:         seg.Rev,
+		Size:        seg.Size,
+		PackSegment: g.Index.Segment,
+		PackIndexId: indexId,
+	})
+	for _, m := range g.Index.Members {
+		g.FolderSyncDb.Put(path.Join(g.SaveDir, m.Name), &UploadedFileMeta{
+			MD5:           m.MD5,
+			ParentId:      seg.ParentId,
+			Size:          m.Size,
+			ModTime:       m.ModTime,
+			PackSegment:   g.Index.Segment,
+			PackSegmentId: seg.FileID,
+			PackIndexId:   indexId,
+		})
+	}
+}
+
+// IsPackSegment 是否为 tar 文件本身的记录
+func (ufm *UploadedFileMeta) IsPackSegment() bool {
+	return ufm.PackSegment != "" && path.Base(ufm.Path) == ufm.PackSegment
+}
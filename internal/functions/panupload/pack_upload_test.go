@@ -0,0 +1,140 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package panupload
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/tickstep/cloudpan189-go/internal/functions"
+)
+
+func TestPackBuilder(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"a.txt": "hello",
+		"b.txt": "",
+		"c.txt": "cloudpan189 pack test",
+	}
+	b, err := NewPackBuilder(dir, "/backup", functions.PackSegmentName("20210101120000", 1))
+	if err != nil {
+		t.Fatalf("NewPackBuilder: %s", err)
+	}
+	for _, name := range []string{"a.txt", "b.txt", "c.txt"} {
+		localPath := filepath.Join(dir, name)
+		if err := os.WriteFile(localPath, []byte(files[name]), 0644); err != nil {
+			t.Fatal(err)
+		}
+		fi, err := os.Stat(localPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := b.Add(localPath, name, fi); err != nil {
+			t.Fatalf("Add(%s): %s", name, err)
+		}
+	}
+	g, err := b.Finish()
+	if err != nil {
+		t.Fatalf("Finish: %s", err)
+	}
+	defer os.Remove(g.SegmentFile)
+	defer os.Remove(g.IndexFile)
+
+	if g.SegmentSavePath() != "/backup/cloudpan189-pack-20210101120000-0001.tar" {
+		t.Errorf("SegmentSavePath = %s", g.SegmentSavePath())
+	}
+	if g.IndexSavePath() != g.SegmentSavePath()+functions.PackIndexSuffix {
+		t.Errorf("IndexSavePath = %s", g.IndexSavePath())
+	}
+
+	segment, err := os.ReadFile(g.SegmentFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(g.IndexFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pi, err := functions.ParsePackIndex(data)
+	if err != nil {
+		t.Fatalf("ParsePackIndex: %s", err)
+	}
+	if pi.Size != int64(len(segment)) {
+		t.Errorf("index size = %d, want %d", pi.Size, len(segment))
+	}
+	if len(pi.Members) != len(files) || len(g.LocalPaths) != len(files) {
+		t.Fatalf("members = %d, local paths = %d, want %d", len(pi.Members), len(g.LocalPaths), len(files))
+	}
+	for i, m := range pi.Members {
+		if filepath.Base(g.LocalPaths[i]) != m.Name {
+			t.Errorf("local path %s does not match member %s", g.LocalPaths[i], m.Name)
+		}
+		// 按索引的偏移读取的数据和原文件一致
+		got := string(segment[m.Offset : m.Offset+m.Size])
+		if got != files[m.Name] {
+			t.Errorf("member %s data = %q, want %q", m.Name, got, files[m.Name])
+		}
+		sum := md5.Sum([]byte(files[m.Name]))
+		if m.MD5 != hex.EncodeToString(sum[:]) {
+			t.Errorf("member %s MD5 = %s", m.Name, m.MD5)
+		}
+	}
+}
+
+func TestPackUploadGroupSyncRecords(t *testing.T) {
+	dir := t.TempDir()
+	db, err := OpenSyncDb(filepath.Join(dir, "db"), "ecloud")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	segmentName := functions.PackSegmentName("20210101120000", 1)
+	g := &PackUploadGroup{
+		SaveDir: "/backup",
+		Index: &functions.PackIndex{
+			Segment: segmentName,
+			Members: []*functions.PackMember{
+				{Name: "a.txt", Size: 5, MD5: "md5a", ModTime: 100},
+				{Name: "b.txt", Size: 0, MD5: "md5b", ModTime: 200},
+			},
+		},
+		SegmentFile:       filepath.Join(dir, "segment"),
+		IndexFile:         filepath.Join(dir, "index"),
+		FolderSyncDb:      db,
+		OnSegmentUploaded: func() {},
+	}
+	g.unitDone(PackSegmentIndex, &UploadedFileMeta{FileID: "seg-id", ParentId: "dir-id", Size: 2048}, true)
+	g.unitDone(PackIndexIndex, &UploadedFileMeta{FileID: "index-id", ParentId: "dir-id"}, true)
+
+	seg := db.Get(g.SegmentSavePath())
+	if !seg.IsPackSegment() || seg.FileID != "seg-id" || seg.PackIndexId != "index-id" || seg.ParentId != "dir-id" {
+		t.Errorf("segment record = %+v", seg)
+	}
+	for _, m := range g.Index.Members {
+		ufm := db.Get("/backup/" + m.Name)
+		if ufm.IsPackSegment() || ufm.PackSegment != segmentName || ufm.FileID != "" {
+			t.Errorf("member %s record = %+v", m.Name, ufm)
+		}
+		if ufm.PackSegmentId != "seg-id" || ufm.PackIndexId != "index-id" || ufm.ParentId != "dir-id" {
+			t.Errorf("member %s ids = %+v", m.Name, ufm)
+		}
+		if ufm.MD5 != m.MD5 || ufm.Size != m.Size || ufm.ModTime != m.ModTime {
+			t.Errorf("member %s meta = %+v", m.Name, ufm)
+		}
+	}
+}
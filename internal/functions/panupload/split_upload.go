@@ -0,0 +1,154 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package panupload
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/tickstep/cloudpan189-go/internal/functions"
+	"github.com/tickstep/cloudpan189-go/internal/localfile"
+)
+
+const (
+	// SplitManifestIndex 分片上传中清单文件任务的序号
+	SplitManifestIndex = -1
+)
+
+type (
+	// UploadGroup 由多个上传任务组成的任务组
+	UploadGroup interface {
+		// unitDone 组内任务结束, index 为任务在组内的序号, ufm 为上传成功的网盘文件信息, 失败时为空
+		unitDone(index int, ufm *UploadedFileMeta, ok bool)
+	}
+
+	// SplitUploadGroup 一个大文件的分片上传任务组
+	// 所有分片上传成功后才生成并上传清单文件, 下载时根据清单文件合并分片
+	SplitUploadGroup struct {
+		LocalPath     string                   // 原文件本地路径
+		SavePath      string                   // 原文件的网盘保存路径, 分片和清单保存在同一目录
+		Manifest      *functions.SplitManifest // 分片清单
+		IsMove        bool                     // 清单上传成功后删除本地原文件
+		LocalRootPath string                   // 移动模式下删除空目录不会超出该路径
+		FolderSyncDb  SyncDb                   // 备份数据库, 记录原文件的上传状态
+
+		// OnPartsUploaded 所有分片上传成功后调用, 用于将清单文件加入上传队列
+		OnPartsUploaded func(manifestFile string)
+
+		pending      int
+		failed       bool
+		manifestFile string
+		mu           sync.Mutex
+	}
+)
+
+// NewSplitUploadGroup 创建分片上传任务组
+func NewSplitUploadGroup(localPath, savePath string, manifest *functions.SplitManifest) *SplitUploadGroup {
+	return &SplitUploadGroup{
+		LocalPath: localPath,
+		SavePath:  savePath,
+		Manifest:  manifest,
+		pending:   len(manifest.Parts),
+	}
+}
+
+// ManifestSavePath 清单文件的网盘保存路径
+func (g *SplitUploadGroup) ManifestSavePath() string {
+	return g.SavePath + functions.SplitManifestSuffix
+}
+
+// unitDone 分片或清单任务结束
+func (g *SplitUploadGroup) unitDone(index int, ufm *UploadedFileMeta, ok bool) {
+	if index == SplitManifestIndex {
+		g.manifestDone(ok)
+		return
+	}
+
+	g.mu.Lock()
+	if ok {
+		g.Manifest.Parts[index].MD5 = ufm.MD5
+	} else {
+		g.failed = true
+	}
+	g.pending--
+	if g.pending > 0 {
+		g.mu.Unlock()
+		return
+	}
+	g.mu.Unlock()
+
+	if g.failed {
+		fmt.Printf("部分分片上传失败, 未上传分片清单: %s\n", g.LocalPath)
+		return
+	}
+
+	// 计算原文件的MD5, 用于下载合并后校验
+	lfc, err := localfile.GetFileSum(g.LocalPath, localfile.CHECKSUM_MD5)
+	if err != nil {
+		fmt.Printf("计算文件MD5失败, 未上传分片清单: %s, %s\n", g.LocalPath, err)
+		return
+	}
+	g.Manifest.MD5 = lfc.MD5
+
+	data, err := jsoniter.MarshalIndent(g.Manifest, "", "  ")
+	if err != nil {
+		fmt.Printf("生成分片清单失败: %s, %s\n", g.LocalPath, err)
+		return
+	}
+	f, err := ioutil.TempFile("", "cloudpan189-split-")
+	if err != nil {
+		fmt.Printf("生成分片清单失败: %s, %s\n", g.LocalPath, err)
+		return
+	}
+	_, err = f.Write(data)
+	f.Close()
+	if err != nil {
+		os.Remove(f.Name())
+		fmt.Printf("生成分片清单失败: %s, %s\n", g.LocalPath, err)
+		return
+	}
+	g.manifestFile = f.Name()
+	g.OnPartsUploaded(g.manifestFile)
+}
+
+// manifestDone 清单文件上传结束
+func (g *SplitUploadGroup) manifestDone(ok bool) {
+	if g.manifestFile != "" {
+		os.Remove(g.manifestFile)
+	}
+	if !ok {
+		return
+	}
+
+	if g.FolderSyncDb != nil {
+		g.FolderSyncDb.Put(g.SavePath, &UploadedFileMeta{
+			MD5:     g.Manifest.MD5,
+			ModTime: g.Manifest.ModTime,
+			Size:    g.Manifest.Size,
+		})
+	}
+
+	if g.IsMove {
+		if err := os.Remove(g.LocalPath); err != nil {
+			fmt.Printf("删除本地文件失败: %s\n", err)
+			return
+		}
+		fmt.Printf("已删除本地文件: %s\n", g.LocalPath)
+		removeEmptyDirs(filepath.Dir(g.LocalPath), g.LocalRootPath)
+	}
+}
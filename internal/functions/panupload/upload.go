@@ -40,6 +40,8 @@ type (
 		fileCommitUrl string
 		// 请求的X-Request-ID
 		xRequestId string
+		// beforeCommit 提交上传前执行, 如处理已存在的同名文件
+		beforeCommit func() error
 	}
 
 	UploadedFileMeta struct {
@@ -52,6 +54,12 @@ type (
 		Size         int64  `json:"length,omitempty"`   // 文件大小
 		ModTime      int64  `json:"modtime,omitempty"`  // 修改日期
 		LastSyncTime int64  `json:"synctime,omitempty"` //最后同步时间
+		Verified     bool   `json:"verified,omitempty"` //上传后是否已校验网盘文件的MD5和大小
+
+		// 小文件打包上传, 打包的文件和 tar 文件本身的记录都设置 tar 文件名, 打包的文件没有单独的网盘文件ID
+		PackSegment   string `json:"packSegment,omitempty"`   // 所在的 tar 文件名, 和文件在同一目录
+		PackSegmentId string `json:"packSegmentId,omitempty"` // tar 文件ID
+		PackIndexId   string `json:"packIndexId,omitempty"`   // 打包索引的文件ID
 	}
 
 	EmptyReaderLen64 struct {
@@ -145,6 +153,11 @@ func (pu *PanUpload) UploadFile(ctx context.Context, partseq int, partOffset int
 func (pu *PanUpload) CommitFile() (cerr error) {
 	time.Sleep(time.Duration(500) * time.Millisecond)
 	pu.lazyInit()
+	if pu.beforeCommit != nil {
+		if err := pu.beforeCommit(); err != nil {
+			return err
+		}
+	}
 	var er *apierror.ApiError
 	if pu.familyId > 0 {
 		_, er = pu.panClient.AppFamilyUploadFileCommit(pu.familyId, pu.fileCommitUrl, pu.uploadFileId, pu.xRequestId)
@@ -14,19 +14,27 @@
 package panupload
 
 import (
-	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
-	"strings"
+	"sync"
 	"time"
 
+	jsoniter "github.com/json-iterator/go"
+	"github.com/tickstep/bolt"
 	"github.com/tickstep/cloudpan189-go/internal/config"
 	"github.com/tickstep/cloudpan189-go/internal/file/uploader"
 	"github.com/tickstep/cloudpan189-go/internal/localfile"
-	"github.com/tickstep/library-go/converter"
 	"github.com/tickstep/library-go/jsonhelper"
 )
 
+const (
+	// UploadingFlushInterval 未完成上传的记录写入数据库的最小间隔
+	UploadingFlushInterval = 2 * time.Second
+
+	uploadingBucket = "uploading"
+)
+
 type (
 	// Uploading 未完成上传的信息
 	Uploading struct {
@@ -35,69 +43,180 @@ type (
 	}
 
 	// UploadingDatabase 未完成上传的数据库
+	// 记录以本地文件绝对路径(分片上传时附加分片起始位置)为键保存在 bolt 数据库中, 修改先记录在内存, 由 Save 批量写入.
+	// 数据库只在写入时打开, 多个程序同时上传不会互相覆盖记录.
 	UploadingDatabase struct {
-		UploadingList []*Uploading `json:"upload_state"`
-		Timestamp     int64        `json:"timestamp"`
+		dataFile string
+		list     map[string]*Uploading // 全部记录, 键为本地绝对路径
+		dirty    map[string][]byte     // 待写入的记录, 值为空表示删除
+		lastSave time.Time
 
-		dataFile *os.File
+		mu      sync.Mutex
+		flushMu sync.Mutex // 保证写入数据库的顺序
 	}
 )
 
 // NewUploadingDatabase 初始化未完成上传的数据库, 从库中读取内容
 func NewUploadingDatabase() (ud *UploadingDatabase, err error) {
-	file, err := os.OpenFile(filepath.Join(config.GetConfigDir(), UploadingFileName), os.O_CREATE|os.O_RDWR, 0777)
+	ud = &UploadingDatabase{
+		dataFile: filepath.Join(config.GetConfigDir(), UploadingFileName),
+		list:     map[string]*Uploading{},
+		dirty:    map[string][]byte{},
+	}
+
+	err = ud.withDB(func(db *bolt.DB) error {
+		return db.View(func(tx *bolt.Tx) error {
+			b := tx.Bucket([]byte(uploadingBucket))
+			if b == nil {
+				return nil
+			}
+			return b.ForEach(func(k, v []byte) error {
+				uploading := &Uploading{}
+				if jsoniter.Unmarshal(v, uploading) != nil || uploading.LocalFileMeta == nil {
+					ud.dirty[string(k)] = nil
+					return nil
+				}
+				ud.list[string(k)] = uploading
+				return nil
+			})
+		})
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	ud = &UploadingDatabase{
-		dataFile: file,
-	}
-	info, err := file.Stat()
-	if err != nil {
+	jsonFile := ud.migrateJSON()
+	ud.clearModTimeChange()
+	if err = ud.flush(); err != nil {
 		return nil, err
 	}
+	// 导入的记录已写入数据库, 删除旧文件
+	if jsonFile != "" {
+		os.Remove(jsonFile)
+	}
+	return ud, nil
+}
 
-	if info.Size() <= 0 {
-		return ud, nil
+// withDB 打开数据库执行操作, 操作完成后立即关闭
+func (ud *UploadingDatabase) withDB(fn func(db *bolt.DB) error) error {
+	db, err := bolt.Open(ud.dataFile, 0600, &bolt.Options{Timeout: 10 * time.Second})
+	if err != nil {
+		return err
 	}
+	defer db.Close()
+	return fn(db)
+}
 
-	err = jsonhelper.UnmarshalData(file, ud)
+// migrateJSON 导入旧版本 JSON 格式的未完成上传记录, 返回导入成功的旧文件路径, 由调用者写入数据库后删除.
+// 旧文件解析失败时保留, 避免丢失未完成上传的记录
+func (ud *UploadingDatabase) migrateJSON() string {
+	jsonFile := filepath.Join(config.GetConfigDir(), uploadingJSONFileName)
+	file, err := os.Open(jsonFile)
 	if err != nil {
-		return nil, err
+		return ""
+	}
+	old := &struct {
+		UploadingList []*Uploading `json:"upload_state"`
+	}{}
+	err = jsonhelper.UnmarshalData(file, old)
+	file.Close()
+	if err != nil {
+		cmdUploadVerbose.Warnf("parse old uploading database failed, keep %s: %s\n", jsonFile, err)
+		return ""
+	}
+	for _, uploading := range old.UploadingList {
+		if uploading == nil || uploading.LocalFileMeta == nil || uploading.Path == "" {
+			continue
+		}
+		if _, ok := ud.list[uploadingKey(uploading.LocalFileMeta)]; ok {
+			continue
+		}
+		ud.put(uploading)
 	}
+	return jsonFile
+}
 
-	return ud, nil
+// uploadingKey 记录的键, 分片上传时加上分片的起始位置
+func uploadingKey(meta *localfile.LocalFileMeta) string {
+	if meta.Offset > 0 {
+		return fmt.Sprintf("%s#%d", meta.Path, meta.Offset)
+	}
+	return meta.Path
 }
 
-// Save 保存内容
-func (ud *UploadingDatabase) Save() error {
-	if ud.dataFile == nil {
-		return errors.New("dataFile is nil")
+// put 保存记录到内存, 等待写入数据库, 调用者需要持有锁
+func (ud *UploadingDatabase) put(uploading *Uploading) {
+	data, err := jsoniter.Marshal(uploading)
+	if err != nil {
+		cmdUploadVerbose.Warnf("marshal uploading state failed: %s\n", err)
+		return
 	}
+	key := uploadingKey(uploading.LocalFileMeta)
+	ud.list[key] = uploading
+	ud.dirty[key] = data
+}
 
-	ud.Timestamp = time.Now().Unix()
+// remove 删除记录, 调用者需要持有锁
+func (ud *UploadingDatabase) remove(key string) {
+	delete(ud.list, key)
+	ud.dirty[key] = nil
+}
 
-	var (
-		builder = &strings.Builder{}
-		err     = jsonhelper.MarshalData(builder, ud)
-	)
-	if err != nil {
-		panic(err)
+// Save 保存内容, 距离上次写入不足 UploadingFlushInterval 时等待下次批量写入
+func (ud *UploadingDatabase) Save() error {
+	ud.mu.Lock()
+	if len(ud.dirty) == 0 || time.Since(ud.lastSave) < UploadingFlushInterval {
+		ud.mu.Unlock()
+		return nil
 	}
+	ud.mu.Unlock()
+	return ud.flush()
+}
 
-	err = ud.dataFile.Truncate(int64(builder.Len()))
-	if err != nil {
-		return err
+// flush 将修改的记录在一个事务中写入数据库
+func (ud *UploadingDatabase) flush() error {
+	ud.flushMu.Lock()
+	defer ud.flushMu.Unlock()
+
+	ud.mu.Lock()
+	dirty := ud.dirty
+	ud.dirty = map[string][]byte{}
+	ud.lastSave = time.Now()
+	ud.mu.Unlock()
+	if len(dirty) == 0 {
+		return nil
 	}
 
-	str := builder.String()
-	_, err = ud.dataFile.WriteAt(converter.ToBytes(str), 0)
+	err := ud.withDB(func(db *bolt.DB) error {
+		return db.Update(func(tx *bolt.Tx) error {
+			b, err := tx.CreateBucketIfNotExists([]byte(uploadingBucket))
+			if err != nil {
+				return err
+			}
+			for k, v := range dirty {
+				if v == nil {
+					err = b.Delete([]byte(k))
+				} else {
+					err = b.Put([]byte(k), v)
+				}
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	})
 	if err != nil {
-		return err
+		// 写入失败, 保留未被更新的修改等待下次写入
+		ud.mu.Lock()
+		for k, v := range dirty {
+			if _, ok := ud.dirty[k]; !ok {
+				ud.dirty[k] = v
+			}
+		}
+		ud.mu.Unlock()
 	}
-
-	return nil
+	return err
 }
 
 // UpdateUploading 更新正在上传
@@ -106,40 +225,34 @@ func (ud *UploadingDatabase) UpdateUploading(meta *localfile.LocalFileMeta, stat
 		return
 	}
 
-	meta.CompleteAbsPath()
-	for k, uploading := range ud.UploadingList {
-		if uploading.LocalFileMeta == nil {
-			continue
-		}
-		if uploading.LocalFileMeta.EqualLengthMD5(meta) || uploading.LocalFileMeta.Path == meta.Path {
-			ud.UploadingList[k].State = state
-			return
-		}
-	}
+	ud.mu.Lock()
+	defer ud.mu.Unlock()
 
-	ud.UploadingList = append(ud.UploadingList, &Uploading{
-		LocalFileMeta: meta,
+	meta.CompleteAbsPath()
+	m := *meta
+	ud.put(&Uploading{
+		LocalFileMeta: &m,
 		State:         state,
 	})
 }
 
-func (ud *UploadingDatabase) deleteIndex(k int) {
-	ud.UploadingList = append(ud.UploadingList[:k], ud.UploadingList[k+1:]...)
-}
-
 // Delete 删除
 func (ud *UploadingDatabase) Delete(meta *localfile.LocalFileMeta) bool {
 	if meta == nil {
 		return false
 	}
 
+	ud.mu.Lock()
+	defer ud.mu.Unlock()
+
 	meta.CompleteAbsPath()
-	for k, uploading := range ud.UploadingList {
-		if uploading.LocalFileMeta == nil {
-			continue
-		}
-		if uploading.LocalFileMeta.EqualLengthMD5(meta) || uploading.LocalFileMeta.Path == meta.Path {
-			ud.deleteIndex(k)
+	if key := uploadingKey(meta); ud.list[key] != nil {
+		ud.remove(key)
+		return true
+	}
+	for k, uploading := range ud.list {
+		if uploading.LocalFileMeta.EqualLengthMD5(meta) {
+			ud.remove(k)
 			return true
 		}
 	}
@@ -152,66 +265,82 @@ func (ud *UploadingDatabase) Search(meta *localfile.LocalFileMeta) *uploader.Ins
 		return nil
 	}
 
+	ud.mu.Lock()
+	defer ud.mu.Unlock()
+
 	meta.CompleteAbsPath()
-	ud.clearModTimeChange()
-	for _, uploading := range ud.UploadingList {
-		if uploading.LocalFileMeta == nil {
-			continue
+	key := uploadingKey(meta)
+	if uploading, ok := ud.list[key]; ok {
+		// 移除旧的信息
+		// 目前只是比较了文件大小和修改时间
+		if meta.Length != uploading.Length || ud.isModified(uploading) {
+			ud.remove(key)
+			return nil
 		}
-		if uploading.LocalFileMeta.EqualLengthMD5(meta) {
-			return uploading.State
-		}
-		if uploading.LocalFileMeta.Path == meta.Path {
-			// 移除旧的信息
-			// 目前只是比较了文件大小
-			if meta.Length != uploading.LocalFileMeta.Length {
-				ud.Delete(meta)
-				return nil
-			}
 
-			// 覆盖数据
-			meta.MD5 = uploading.LocalFileMeta.MD5
-			meta.ParentFolderId = uploading.LocalFileMeta.ParentFolderId
-			meta.UploadFileId = uploading.LocalFileMeta.UploadFileId
-			meta.FileUploadUrl = uploading.LocalFileMeta.FileUploadUrl
-			meta.FileCommitUrl = uploading.LocalFileMeta.FileCommitUrl
-			meta.FileDataExists = uploading.LocalFileMeta.FileDataExists
-			meta.XRequestId = uploading.LocalFileMeta.XRequestId
+		// 覆盖数据
+		meta.MD5 = uploading.LocalFileMeta.MD5
+		meta.ParentFolderId = uploading.LocalFileMeta.ParentFolderId
+		meta.UploadFileId = uploading.LocalFileMeta.UploadFileId
+		meta.FileUploadUrl = uploading.LocalFileMeta.FileUploadUrl
+		meta.FileCommitUrl = uploading.LocalFileMeta.FileCommitUrl
+		meta.FileDataExists = uploading.LocalFileMeta.FileDataExists
+		meta.XRequestId = uploading.LocalFileMeta.XRequestId
+		return uploading.State
+	}
+
+	if meta.MD5 == "" {
+		return nil
+	}
+	for _, uploading := range ud.list {
+		if uploading.LocalFileMeta.EqualLengthMD5(meta) {
 			return uploading.State
 		}
 	}
 	return nil
 }
 
-func (ud *UploadingDatabase) clearModTimeChange() {
-	for i := 0; i < len(ud.UploadingList); i++ {
-		uploading := ud.UploadingList[i]
-		if uploading.LocalFileMeta == nil {
-			continue
-		}
+// HasPath 是否有该路径未完成上传的记录
+func (ud *UploadingDatabase) HasPath(meta *localfile.LocalFileMeta) bool {
+	if meta == nil {
+		return false
+	}
 
-		if uploading.ModTime == -1 { // 忽略
-			continue
-		}
+	ud.mu.Lock()
+	defer ud.mu.Unlock()
 
-		info, err := os.Stat(uploading.LocalFileMeta.Path)
-		if err != nil {
-			ud.deleteIndex(i)
-			i--
-			cmdUploadVerbose.Warnf("clear invalid file path: %s, err: %s\n", uploading.LocalFileMeta.Path, err)
-			continue
-		}
+	meta.CompleteAbsPath()
+	_, ok := ud.list[uploadingKey(meta)]
+	return ok
+}
 
-		if uploading.LocalFileMeta.ModTime != info.ModTime().Unix() {
-			ud.deleteIndex(i)
-			i--
-			cmdUploadVerbose.Infof("clear modified file path: %s\n", uploading.LocalFileMeta.Path)
-			continue
+// isModified 记录对应的本地文件是否已被删除或修改
+func (ud *UploadingDatabase) isModified(uploading *Uploading) bool {
+	if uploading.ModTime == -1 { // 忽略
+		return false
+	}
+
+	info, err := os.Stat(uploading.LocalFileMeta.Path)
+	if err != nil {
+		cmdUploadVerbose.Warnf("clear invalid file path: %s, err: %s\n", uploading.LocalFileMeta.Path, err)
+		return true
+	}
+	if uploading.LocalFileMeta.ModTime != info.ModTime().Unix() {
+		cmdUploadVerbose.Infof("clear modified file path: %s\n", uploading.LocalFileMeta.Path)
+		return true
+	}
+	return false
+}
+
+func (ud *UploadingDatabase) clearModTimeChange() {
+	for k, uploading := range ud.list {
+		if ud.isModified(uploading) {
+			ud.remove(k)
 		}
 	}
 }
 
-// Close 关闭数据库
+// Close 关闭数据库, 写入所有未保存的修改
 func (ud *UploadingDatabase) Close() error {
-	return ud.dataFile.Close()
+	return ud.flush()
 }
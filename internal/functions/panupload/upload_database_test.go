@@ -0,0 +1,136 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package panupload
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/tickstep/cloudpan189-go/internal/config"
+	"github.com/tickstep/cloudpan189-go/internal/localfile"
+)
+
+func TestUploadingDatabaseMigrateJSON(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv(config.EnvConfigDir, dir)
+
+	file := filepath.Join(dir, "data")
+	if err := os.WriteFile(file, []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	missing := filepath.Join(dir, "missing")
+	ignored := filepath.Join(dir, "ignored")
+	data := fmt.Sprintf(`{"upload_state":[
+{"path":%q,"length":5,"modtime":%d,"upload_file_id":"1"},
+{"path":%q,"length":5,"modtime":1,"upload_file_id":"2"},
+{"path":%q,"length":5,"modtime":-1,"upload_file_id":"3"}
+]}`, file, info.ModTime().Unix(), missing, ignored)
+	jsonFile := filepath.Join(dir, uploadingJSONFileName)
+	if err := os.WriteFile(jsonFile, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ud, err := NewUploadingDatabase()
+	if err != nil {
+		t.Fatalf("NewUploadingDatabase: %s", err)
+	}
+	ud.Close()
+	if _, err := os.Stat(jsonFile); !os.IsNotExist(err) {
+		t.Errorf("old JSON file should be removed after migration, err: %v", err)
+	}
+
+	// 重新打开, 从数据库读取导入的记录
+	ud, err = NewUploadingDatabase()
+	if err != nil {
+		t.Fatalf("NewUploadingDatabase: %s", err)
+	}
+	defer ud.Close()
+	testCases := []struct {
+		path string
+		want bool
+	}{
+		{file, true},
+		{missing, false},
+		{ignored, true},
+	}
+	for _, c := range testCases {
+		if got := ud.HasPath(&localfile.LocalFileMeta{Path: c.path}); got != c.want {
+			t.Errorf("HasPath(%s) = %v, want %v", c.path, got, c.want)
+		}
+	}
+
+	meta := &localfile.LocalFileMeta{Path: file, Length: 5}
+	ud.Search(meta)
+	if meta.UploadFileId != "1" {
+		t.Errorf("Search UploadFileId = %q, want %q", meta.UploadFileId, "1")
+	}
+}
+
+func TestUploadingDatabaseUpdateDelete(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv(config.EnvConfigDir, dir)
+
+	ud, err := NewUploadingDatabase()
+	if err != nil {
+		t.Fatalf("NewUploadingDatabase: %s", err)
+	}
+	meta := &localfile.LocalFileMeta{Path: filepath.Join(dir, "a"), Length: 1, ModTime: -1, UploadFileId: "1"}
+	ud.UpdateUploading(meta, nil)
+	ud.Close()
+
+	ud, err = NewUploadingDatabase()
+	if err != nil {
+		t.Fatalf("NewUploadingDatabase: %s", err)
+	}
+	if !ud.HasPath(meta) {
+		t.Fatal("record not saved")
+	}
+	if !ud.Delete(meta) {
+		t.Error("Delete should find the record")
+	}
+	ud.Close()
+
+	ud, err = NewUploadingDatabase()
+	if err != nil {
+		t.Fatalf("NewUploadingDatabase: %s", err)
+	}
+	defer ud.Close()
+	if ud.HasPath(meta) {
+		t.Error("record not deleted")
+	}
+}
+
+func TestUploadingDatabaseKeepInvalidJSON(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv(config.EnvConfigDir, dir)
+
+	jsonFile := filepath.Join(dir, uploadingJSONFileName)
+	if err := os.WriteFile(jsonFile, []byte(`{"upload_state":[`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ud, err := NewUploadingDatabase()
+	if err != nil {
+		t.Fatalf("NewUploadingDatabase: %s", err)
+	}
+	ud.Close()
+	if _, err := os.Stat(jsonFile); err != nil {
+		t.Errorf("invalid JSON file should be kept, err: %s", err)
+	}
+}
@@ -0,0 +1,111 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package panupload
+
+import (
+	"sync"
+
+	"github.com/tickstep/cloudpan189-go/internal/localfile"
+)
+
+type (
+	// UploadPipeline 上传流水线
+	// 由独立的协程池提前计算文件的MD5并创建上传任务(检测秒传), 上传协程只负责上传数据,
+	// 使读盘和网络传输可以同时进行. 提前准备的文件数量有上限, 避免创建的上传任务过早失效.
+	// 网盘没有批量创建上传任务的接口, 每个文件单独创建, 由 lookahead 个文件组成的窗口并发进行.
+	// 提前准备时只检查同名文件, 覆盖或重命名已存在的文件推迟到提交上传前, 中断时网盘上的旧文件不受影响.
+	UploadPipeline struct {
+		queue  chan *UploadTaskUnit
+		tokens chan struct{} // 已准备但未开始上传的文件数量限制
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+	}
+)
+
+// NewUploadPipeline 创建上传流水线, parallel 为计算MD5的并发数量, lookahead 为最多提前准备的文件数量
+func NewUploadPipeline(parallel, lookahead int) *UploadPipeline {
+	if parallel < 1 {
+		parallel = 1
+	}
+	if lookahead < parallel {
+		lookahead = parallel
+	}
+	p := &UploadPipeline{
+		queue:  make(chan *UploadTaskUnit, lookahead),
+		tokens: make(chan struct{}, lookahead),
+	}
+	for i := 0; i < parallel; i++ {
+		p.wg.Add(1)
+		go p.worker()
+	}
+	return p
+}
+
+func (p *UploadPipeline) worker() {
+	defer p.wg.Done()
+	for utu := range p.queue {
+		utu.precreate()
+		close(p.preparedChan(utu))
+	}
+}
+
+// preparedChan 返回文件准备完成的通知通道
+func (p *UploadPipeline) preparedChan(utu *UploadTaskUnit) chan struct{} {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if utu.prepared == nil {
+		utu.prepared = make(chan struct{})
+	}
+	return utu.prepared
+}
+
+// Submit 将上传任务加入流水线, 提前准备的文件数量达到上限时阻塞
+// 任务的 Pipeline 需要在加入上传队列之前设置, 本方法在加入上传队列之后调用
+func (p *UploadPipeline) Submit(utu *UploadTaskUnit) {
+	p.preparedChan(utu)
+	p.tokens <- struct{}{}
+	p.queue <- utu
+}
+
+// wait 等待文件准备完成, 并释放提前准备的名额
+func (p *UploadPipeline) wait(utu *UploadTaskUnit) {
+	<-p.preparedChan(utu)
+	<-p.tokens
+}
+
+// Close 不再接收新的任务, 等待已加入的任务准备完成
+func (p *UploadPipeline) Close() {
+	close(p.queue)
+	p.wg.Wait()
+}
+
+// precreate 计算文件的MD5并提前创建上传任务, 出错时留给上传时重新处理
+func (utu *UploadTaskUnit) precreate() {
+	if err := utu.LocalFileChecksum.OpenPath(); err != nil {
+		return
+	}
+	err := utu.LocalFileChecksum.Sum(localfile.CHECKSUM_MD5)
+	utu.LocalFileChecksum.Close()
+	if err != nil {
+		utu.LocalFileChecksum.MD5 = ""
+		return
+	}
+
+	// 有未完成的上传记录, 由上传时断点续传
+	if utu.UploadingDatabase.HasPath(&utu.LocalFileChecksum.LocalFileMeta) {
+		return
+	}
+	utu.precreateResult = utu.createUploadFile()
+	utu.precreated = true
+}
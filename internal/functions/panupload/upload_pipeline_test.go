@@ -0,0 +1,71 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package panupload
+
+import (
+	"testing"
+	"time"
+
+	"github.com/tickstep/cloudpan189-go/internal/localfile"
+)
+
+func TestNewUploadPipelineNormalize(t *testing.T) {
+	testCases := []struct {
+		parallel, lookahead int
+		want                int
+	}{
+		{0, 0, 1},
+		{2, 1, 2},
+		{2, 8, 8},
+	}
+	for _, c := range testCases {
+		p := NewUploadPipeline(c.parallel, c.lookahead)
+		if cap(p.tokens) != c.want {
+			t.Errorf("NewUploadPipeline(%d, %d) lookahead = %d, want %d", c.parallel, c.lookahead, cap(p.tokens), c.want)
+		}
+		p.Close()
+	}
+}
+
+func TestUploadPipelineLookahead(t *testing.T) {
+	p := NewUploadPipeline(1, 1)
+	defer p.Close()
+
+	// 文件不存在时 precreate 直接返回, 只验证流水线的调度
+	utu1 := &UploadTaskUnit{LocalFileChecksum: localfile.NewLocalFileEntity("/nonexistent/cloudpan189-a")}
+	utu2 := &UploadTaskUnit{LocalFileChecksum: localfile.NewLocalFileEntity("/nonexistent/cloudpan189-b")}
+	p.Submit(utu1)
+
+	submitted := make(chan struct{})
+	go func() {
+		p.Submit(utu2)
+		close(submitted)
+	}()
+	select {
+	case <-submitted:
+		t.Fatal("Submit should block when lookahead is full")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	p.wait(utu1)
+	select {
+	case <-submitted:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Submit should continue after wait releases a token")
+	}
+	p.wait(utu2)
+	if utu1.precreated || utu2.precreated {
+		t.Errorf("precreate should not succeed for missing files")
+	}
+}
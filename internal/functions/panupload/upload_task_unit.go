@@ -15,6 +15,7 @@ package panupload
 
 import (
 	"fmt"
+	"os"
 	"path"
 	"path/filepath"
 	"strings"
@@ -31,7 +32,6 @@ import (
 	"github.com/tickstep/cloudpan189-go/internal/localfile"
 	"github.com/tickstep/cloudpan189-go/internal/taskframework"
 	"github.com/tickstep/library-go/converter"
-	"github.com/tickstep/library-go/requester/rio"
 )
 
 type (
@@ -45,7 +45,9 @@ type (
 		SavePath          string // 保存路径
 		FamilyId          int64
 		FolderCreateMutex *sync.Mutex
-		FolderSyncDb      SyncDb //文件备份状态数据库
+		FolderCache       map[string]*cloudpan.AppMkdirResult // 已创建的网盘目录, 由 FolderCreateMutex 保护
+		Pipeline          *UploadPipeline                     // 上传流水线, 为空则在上传时计算MD5
+		FolderSyncDb      SyncDb                              //文件备份状态数据库
 
 		PanClient         *cloudpan.PanClient
 		UploadingDatabase *UploadingDatabase // 数据库
@@ -59,9 +61,21 @@ type (
 		panDir   string
 		panFile  string
 		state    *uploader.InstanceState
+		verified bool // 网盘文件已校验一致
 
-		ShowProgress bool
-		IsOverwrite  bool // 覆盖已存在的文件，如果同名文件已存在则移到回收站里
+		prepared        chan struct{}                    // 流水线准备完成的通知
+		precreated      bool                             // 是否已由流水线创建上传任务
+		precreateResult *taskframework.TaskUnitRunResult // 流水线创建上传任务的结果, 为空表示需要继续上传
+
+		ShowProgress   bool
+		OnConflict     ConflictPolicy // 网盘已存在同名文件时的处理策略
+		ForceProtected bool           // 允许覆盖受保护的路径
+		IsMove         bool           // 上传并确认网盘文件一致后删除本地文件
+		IsVerify       bool           // 上传完成后校验网盘文件的MD5和大小
+		LocalRootPath  string         // 本地上传的根路径, 移动模式下删除空目录不会超出该路径
+
+		Group      UploadGroup // 所属的任务组, 如分片上传或小文件打包, 为空表示单独上传的文件
+		GroupIndex int         // 在任务组中的序号
 	}
 )
 
@@ -93,6 +107,17 @@ func (utu *UploadTaskUnit) prepareFile() {
 	utu.panDir = path.Clean(panDir)
 	utu.panFile = panFile
 
+	// 流水线已创建上传任务
+	if utu.precreated {
+		utu.precreated = false
+		if utu.NoRapidUpload {
+			utu.Step = StepUploadUpload
+		} else {
+			utu.Step = StepUploadRapidUpload
+		}
+		return
+	}
+
 	// 检测断点续传
 	utu.state = utu.UploadingDatabase.Search(&utu.LocalFileChecksum.LocalFileMeta)
 	if utu.state != nil || utu.LocalFileChecksum.LocalFileMeta.UploadFileId != "" { // 读取到了上一次上传task请求的fileId
@@ -145,6 +170,11 @@ func (utu *UploadTaskUnit) rapidUpload() (isContinue bool, result *taskframework
 	result = &taskframework.TaskUnitRunResult{}
 	fmt.Printf("[%s] 检测秒传中, 请稍候...\n", utu.taskInfo.Id())
 	if utu.LocalFileChecksum.FileDataExists == 1 {
+		if err := utu.replaceConflictFile(); err != nil {
+			result.ResultMessage = "处理同名文件失败"
+			result.Err = err
+			return false, result
+		}
 		var er *apierror.ApiError
 		var ret *cloudpan.AppUploadFileCommitResult
 		if utu.FamilyId > 0 {
@@ -182,9 +212,10 @@ func (utu *UploadTaskUnit) upload() (result *taskframework.TaskUnitRunResult) {
 		blockSize = getBlockSize(utu.LocalFileChecksum.Length)
 	}
 
+	pu := NewPanUpload(utu.PanClient, utu.SavePath, utu.LocalFileChecksum.FileUploadUrl, utu.LocalFileChecksum.FileCommitUrl, utu.LocalFileChecksum.UploadFileId, utu.LocalFileChecksum.XRequestId, utu.FamilyId).(*PanUpload)
+	pu.beforeCommit = utu.replaceConflictFile
 	muer := uploader.NewMultiUploader(utu.LocalFileChecksum.FileUploadUrl, utu.LocalFileChecksum.FileCommitUrl, utu.LocalFileChecksum.UploadFileId, utu.LocalFileChecksum.XRequestId,
-		NewPanUpload(utu.PanClient, utu.SavePath, utu.LocalFileChecksum.FileUploadUrl, utu.LocalFileChecksum.FileCommitUrl, utu.LocalFileChecksum.UploadFileId, utu.LocalFileChecksum.XRequestId, utu.FamilyId),
-		rio.NewFileReaderAtLen64(utu.LocalFileChecksum.GetFile()), &uploader.MultiUploaderConfig{
+		pu, utu.LocalFileChecksum.ReaderAtLen64(), &uploader.MultiUploaderConfig{
 			Parallel:  utu.Parallel,
 			BlockSize: blockSize,
 			MaxRate:   config.Config.MaxUploadRate,
@@ -261,19 +292,36 @@ func (utu *UploadTaskUnit) OnRetry(lastRunResult *taskframework.TaskUnitRunResul
 }
 
 func (utu *UploadTaskUnit) OnSuccess(lastRunResult *taskframework.TaskUnitRunResult) {
+	// 任务组统一处理备份数据库和移动模式
+	if utu.Group != nil {
+		utu.Group.unitDone(utu.GroupIndex, utu.uploadedFileMeta(lastRunResult), true)
+		return
+	}
+
+	if utu.IsMove && lastRunResult != ResultRemoteFileExisted {
+		defer utu.removeLocalFile()
+	}
+
 	//文件上传成功
-	if utu.FolderSyncDb == nil || lastRunResult == ResultLocalFileNotUpdated { //不需要更新数据库
+	if utu.FolderSyncDb == nil || lastRunResult == ResultLocalFileNotUpdated || lastRunResult == ResultRemoteFileExisted { //不需要更新数据库
 		return
 	}
+	utu.FolderSyncDb.Put(utu.SavePath, utu.uploadedFileMeta(lastRunResult))
+}
+
+// uploadedFileMeta 上传成功的文件信息, 网盘文件ID优先从上传结果获取, 没有时查询网盘
+func (utu *UploadTaskUnit) uploadedFileMeta(lastRunResult *taskframework.TaskUnitRunResult) *UploadedFileMeta {
 	ufm := &UploadedFileMeta{
-		MD5:     utu.LocalFileChecksum.MD5,
-		ModTime: utu.LocalFileChecksum.ModTime,
-		Size:    utu.LocalFileChecksum.Length,
+		MD5:      utu.LocalFileChecksum.MD5,
+		ModTime:  utu.LocalFileChecksum.ModTime,
+		Size:     utu.LocalFileChecksum.SourceLength(),
+		Verified: utu.verified,
 	}
 	switch ufo := lastRunResult.Extra.(type) {
 	case *cloudpan.AppUploadFileCommitResult:
 		ufm.FileID = ufo.Id
 		ufm.Rev = ufo.Rev
+		ufm.ParentId = utu.LocalFileChecksum.ParentFolderId
 	case *cloudpan.AppFileEntity:
 		ufm.FileID = ufo.FileId
 		ufm.Rev = ufo.Rev
@@ -286,16 +334,100 @@ func (utu *UploadTaskUnit) OnSuccess(lastRunResult *taskframework.TaskUnitRunRes
 			ufm.ParentId = efi.ParentId
 		}
 	}
+	return ufm
+}
+
+// verifyRemoteFile 查询网盘文件, 校验MD5和大小是否与本地文件一致
+// 查询失败时 efi 为空, 不一致时返回查询到的网盘文件
+func (utu *UploadTaskUnit) verifyRemoteFile() (efi *cloudpan.AppFileEntity, err error) {
+	var apierr *apierror.ApiError
+	for i := 0; i < 3; i++ {
+		if i > 0 {
+			time.Sleep(time.Duration(i) * time.Second)
+		}
+		efi, apierr = utu.PanClient.AppFileInfoByPath(utu.FamilyId, utu.SavePath)
+		if apierr == nil {
+			break
+		}
+	}
+	if apierr != nil {
+		return nil, apierr
+	}
+
+	md5Str := utu.LocalFileChecksum.MD5
+	if utu.LocalFileChecksum.Length == 0 {
+		md5Str = cloudpan.DefaultEmptyFileMd5
+	}
+	if md5Str == "" || efi.FileSize != utu.LocalFileChecksum.Length || !strings.EqualFold(efi.FileMd5, md5Str) {
+		return efi, ErrRemoteFileMismatch
+	}
+	return efi, nil
+}
+
+// checkCommittedFile 上传完成后校验网盘文件, 不一致时删除网盘文件并重新上传
+func (utu *UploadTaskUnit) checkCommittedFile(result *taskframework.TaskUnitRunResult) *taskframework.TaskUnitRunResult {
+	if !utu.IsVerify || !result.Succeed {
+		return result
+	}
+
+	efi, err := utu.verifyRemoteFile()
+	if err == nil {
+		fmt.Printf("[%s] 网盘文件校验成功: %s\n", utu.taskInfo.Id(), utu.SavePath)
+		utu.verified = true
+		result.Extra = efi
+		return result
+	}
 
-	utu.FolderSyncDb.Put(utu.SavePath, ufm)
+	if efi != nil {
+		// 删除不一致的网盘文件
+		if er := functions.DeletePanFile(utu.PanClient, utu.FamilyId, efi); er != nil {
+			fmt.Printf("[%s] 删除校验失败的网盘文件失败: %s, %s\n", utu.taskInfo.Id(), utu.SavePath, er)
+		}
+	}
+
+	// 重新创建上传任务
+	utu.state = nil
+	utu.LocalFileChecksum.UploadFileId = ""
+	utu.LocalFileChecksum.FileUploadUrl = ""
+	utu.LocalFileChecksum.FileCommitUrl = ""
+	utu.LocalFileChecksum.FileDataExists = 0
+	utu.LocalFileChecksum.XRequestId = ""
+
+	return &taskframework.TaskUnitRunResult{
+		NeedRetry:     true,
+		Err:           err,
+		ResultMessage: "网盘文件校验失败",
+	}
+}
+
+// removeLocalFile 确认网盘文件的MD5和大小与本地文件一致后, 删除本地文件以及因此清空的本地目录
+func (utu *UploadTaskUnit) removeLocalFile() {
+	localPath := utu.LocalFileChecksum.Path
+	if !utu.verified {
+		if _, err := utu.verifyRemoteFile(); err != nil {
+			fmt.Printf("[%s] 无法确认网盘文件, 保留本地文件: %s, %s\n", utu.taskInfo.Id(), localPath, err)
+			return
+		}
+	}
+
+	if err := os.Remove(localPath); err != nil {
+		fmt.Printf("[%s] 删除本地文件失败: %s\n", utu.taskInfo.Id(), err)
+		return
+	}
+	fmt.Printf("[%s] 已删除本地文件: %s\n", utu.taskInfo.Id(), localPath)
+	removeEmptyDirs(filepath.Dir(localPath), utu.LocalRootPath)
 }
 
 func (utu *UploadTaskUnit) OnFailed(lastRunResult *taskframework.TaskUnitRunResult) {
 	// 失败
+	if utu.Group != nil {
+		utu.Group.unitDone(utu.GroupIndex, nil, false)
+	}
 }
 
 var ResultLocalFileNotUpdated = &taskframework.TaskUnitRunResult{ResultCode: 1, Succeed: true, ResultMessage: "本地文件未更新，无需上传！"}
 var ResultUpdateLocalDatabase = &taskframework.TaskUnitRunResult{ResultCode: 2, Succeed: true, ResultMessage: "本地文件和云端文件MD5一致，无需上传！"}
+var ResultRemoteFileExisted = &taskframework.TaskUnitRunResult{ResultCode: 3, Succeed: true, ResultMessage: "云端已存在同名文件，跳过上传！"}
 
 func (utu *UploadTaskUnit) OnComplete(lastRunResult *taskframework.TaskUnitRunResult) {
 
@@ -305,158 +437,125 @@ func (utu *UploadTaskUnit) RetryWait() time.Duration {
 	return functions.RetryWait(utu.taskInfo.Retry())
 }
 
-func (utu *UploadTaskUnit) Run() (result *taskframework.TaskUnitRunResult) {
-
-	err := utu.LocalFileChecksum.OpenPath()
-	if err != nil {
-		fmt.Printf("[%s] 文件不可读, 错误信息: %s, 跳过...\n", utu.taskInfo.Id(), err)
-		return
+// panFolder 获取保存目录的信息, 不存在则创建, 同一目录只创建一次
+func (utu *UploadTaskUnit) panFolder(panDir string) (rs *cloudpan.AppMkdirResult, apierr *apierror.ApiError) {
+	if panDir == "/" {
+		rs = &cloudpan.AppMkdirResult{}
+		if utu.FamilyId > 0 {
+			rs.FileId = ""
+		} else {
+			rs.FileId = "-11"
+		}
+		return rs, nil
 	}
-	defer utu.LocalFileChecksum.Close() // 关闭文件
-
-	timeStart := time.Now()
-	result = &taskframework.TaskUnitRunResult{}
 
-	fmt.Printf("[%s] 准备上传: %s=>%s\n", utu.taskInfo.Id(), utu.LocalFileChecksum.Path, utu.SavePath)
+	utu.FolderCreateMutex.Lock()
+	defer utu.FolderCreateMutex.Unlock()
+	if rs = utu.FolderCache[panDir]; rs != nil {
+		return rs, nil
+	}
 
-	defer func() {
-		var msg string
-		if result.Err != nil {
-			msg = "失败！" + result.ResultMessage + "," + result.Err.Error()
-		} else if result.Succeed {
-			msg = "成功！" + result.ResultMessage
-		} else {
-			msg = result.ResultMessage
+	//同步功能先尝试从数据库获取
+	if utu.FolderSyncDb != nil {
+		if test := utu.FolderSyncDb.Get(panDir); test.FileID != "" && test.IsFolder {
+			rs = &cloudpan.AppMkdirResult{FileId: test.FileID, Rev: test.Rev}
 		}
-		fmt.Printf("%s [%s] 文件上传结果：%s  耗时 %s\n", time.Now().Format("2006-01-02 15:04:06"), utu.taskInfo.Id(), msg, time.Now().Sub(timeStart))
-	}()
-	// 准备文件
-	utu.prepareFile()
-
-	var r *cloudpan.AppCreateUploadFileResult
-	var apierr *apierror.ApiError
-	var rs *cloudpan.AppMkdirResult
-	var appCreateUploadFileParam *cloudpan.AppCreateUploadFileParam
-	var md5Str string
-	var saveFilePath string
-	var testFileMeta = &UploadedFileMeta{}
+	}
+	if rs == nil {
+		rs, apierr = utu.PanClient.AppMkdirRecursive(utu.FamilyId, "", "", 0, strings.Split(path.Clean(panDir), "/"))
+		if apierr != nil || rs.FileId == "" {
+			return nil, apierr
+		}
+		time.Sleep(time.Duration(2) * time.Second)
+	}
+	if utu.FolderCache != nil {
+		utu.FolderCache[panDir] = rs
+	}
+	return rs, nil
+}
 
-	switch utu.Step {
-	case StepUploadPrepareUpload:
-		goto StepUploadPrepareUpload
-	case StepUploadRapidUpload:
-		goto stepUploadRapidUpload
-	case StepUploadUpload:
-		goto stepUploadUpload
+// conflictPolicy 同名文件处理策略, 启用了备份功能且未指定策略时, 强制使用覆盖同名文件功能
+func (utu *UploadTaskUnit) conflictPolicy() ConflictPolicy {
+	if utu.FolderSyncDb != nil && utu.OnConflict == ConflictDefault {
+		return ConflictSkipIfIdentical
 	}
+	return utu.OnConflict
+}
 
-StepUploadPrepareUpload:
+// replaceConflictFile 提交上传前删除或重命名网盘中已存在的同名文件.
+// 推迟到提交前处理, 避免上传中断时旧文件已被移到回收站而新文件还没有上传;
+// 断点续传时没有创建上传任务的过程, 因此在这里重新检查
+func (utu *UploadTaskUnit) replaceConflictFile() error {
+	policy := utu.conflictPolicy()
+	if policy != ConflictOverwrite && policy != ConflictSkipIfIdentical && policy != ConflictRenameOld {
+		return nil
+	}
+	_, efi, action, err := CheckConflict(utu.PanClient, utu.FamilyId, utu.SavePath, utu.LocalFileChecksum.MD5, policy, utu.ForceProtected)
+	if err != nil || action != ConflictActionReplace {
+		return err
+	}
+	return ApplyConflict(utu.PanClient, utu.FamilyId, utu.SavePath, efi, policy, utu.ForceProtected)
+}
 
+// createUploadFile 检查同名文件并创建上传任务, 返回空表示需要继续上传
+func (utu *UploadTaskUnit) createUploadFile() (result *taskframework.TaskUnitRunResult) {
+	var testFileMeta = &UploadedFileMeta{}
 	if utu.FolderSyncDb != nil {
-		//启用了备份功能，强制使用覆盖同名文件功能
-		utu.IsOverwrite = true
 		testFileMeta = utu.FolderSyncDb.Get(utu.SavePath)
 	}
-	// 创建上传任务
-	utu.LocalFileChecksum.Sum(localfile.CHECKSUM_MD5)
+	if utu.LocalFileChecksum.MD5 == "" {
+		utu.LocalFileChecksum.Sum(localfile.CHECKSUM_MD5)
+	}
 
 	if testFileMeta.MD5 == utu.LocalFileChecksum.MD5 {
 		return ResultUpdateLocalDatabase
 	}
 
-	utu.FolderCreateMutex.Lock()
-	saveFilePath = path.Dir(utu.SavePath)
-	if saveFilePath != "/" {
-		//同步功能先尝试从数据库获取
-		if utu.FolderSyncDb != nil {
-			if test := utu.FolderSyncDb.Get(saveFilePath); test.FileID != "" && test.IsFolder {
-				rs = &cloudpan.AppMkdirResult{FileId: test.FileID, Rev: test.Rev}
-			}
-		}
-		if rs == nil {
-			rs, apierr = utu.PanClient.AppMkdirRecursive(utu.FamilyId, "", "", 0, strings.Split(path.Clean(saveFilePath), "/"))
-			if apierr != nil || rs.FileId == "" {
-				result.Err = apierr
-				result.ResultMessage = "创建云盘文件夹失败"
-				return
-			}
-		}
-	} else {
-		rs = &cloudpan.AppMkdirResult{}
-		if utu.FamilyId > 0 {
-			rs.FileId = ""
-		} else {
-			rs.FileId = "-11"
-		}
+	result = &taskframework.TaskUnitRunResult{}
+	rs, apierr := utu.panFolder(path.Dir(utu.SavePath))
+	if rs == nil {
+		result.Err = apierr
+		result.ResultMessage = "创建云盘文件夹失败"
+		return
 	}
-	time.Sleep(time.Duration(2) * time.Second)
-	utu.FolderCreateMutex.Unlock()
-
-	if utu.IsOverwrite {
-		// 标记覆盖旧同名文件
-		// 检查同名文件是否存在
-		efi, apierr := utu.PanClient.AppFileInfoByPath(utu.FamilyId, utu.SavePath)
-		if apierr != nil && apierr.Code != apierror.ApiCodeFileNotFoundCode {
-			result.Err = apierr
-			result.ResultMessage = "检测同名文件失败"
-			return
-		}
-		if efi != nil && efi.FileId != "" {
-			if efi.FileMd5 == strings.ToUpper(utu.LocalFileChecksum.MD5) {
-				result.Succeed = true
-				result.Extra = efi
-				return
-			}
-			// existed, delete it
-			infoList := cloudpan.BatchTaskInfoList{}
-			isFolder := 0
-			if efi.IsFolder {
-				isFolder = 1
-			}
-			infoItem := &cloudpan.BatchTaskInfo{
-				FileId:      efi.FileId,
-				FileName:    efi.FileName,
-				IsFolder:    isFolder,
-				SrcParentId: efi.ParentId,
-			}
-			infoList = append(infoList, infoItem)
-			delParam := &cloudpan.BatchTaskParam{
-				TypeFlag:  cloudpan.BatchTaskTypeDelete,
-				TaskInfos: infoList,
-			}
 
-			var taskId string
-			var err *apierror.ApiError
-			if utu.FamilyId > 0 {
-				taskId, err = utu.PanClient.AppCreateBatchTask(utu.FamilyId, delParam)
-			} else {
-				taskId, err = utu.PanClient.CreateBatchTask(delParam)
-			}
-
-			if err != nil || taskId == "" {
-				result.Err = err
-				result.ResultMessage = "无法删除文件，请稍后重试"
-				return
-			}
-			time.Sleep(time.Duration(500) * time.Millisecond)
-			logger.Verbosef("[%s] 检测到同名文件，已移动到回收站: %s", utu.taskInfo.Id(), utu.SavePath)
-		}
+	// 检查同名文件, 删除或重命名已存在的文件推迟到提交上传前
+	saveFilePath, efi, action, err := CheckConflict(utu.PanClient, utu.FamilyId, utu.SavePath, utu.LocalFileChecksum.MD5, utu.conflictPolicy(), utu.ForceProtected)
+	if err != nil {
+		result.Err = err
+		result.ResultMessage = "处理同名文件失败"
+		return
+	}
+	switch action {
+	case ConflictActionSkip:
+		return ResultRemoteFileExisted
+	case ConflictActionIdentical:
+		result.Succeed = true
+		result.Extra = efi
+		return
+	}
+	if saveFilePath != utu.SavePath {
+		fmt.Printf("[%s] 检测到同名文件，重命名保存为: %s\n", utu.taskInfo.Id(), saveFilePath)
+		utu.SavePath = saveFilePath
+	} else if efi != nil {
+		logger.Verbosef("[%s] 检测到同名文件，提交上传前按 %s 策略处理: %s\n", utu.taskInfo.Id(), utu.conflictPolicy(), utu.SavePath)
 	}
 
-	md5Str = utu.LocalFileChecksum.MD5
+	md5Str := utu.LocalFileChecksum.MD5
 	if utu.LocalFileChecksum.Length == 0 {
 		md5Str = cloudpan.DefaultEmptyFileMd5
 	}
 
-	appCreateUploadFileParam = &cloudpan.AppCreateUploadFileParam{
+	appCreateUploadFileParam := &cloudpan.AppCreateUploadFileParam{
 		ParentFolderId: rs.FileId,
-		FileName:       filepath.Base(utu.LocalFileChecksum.Path),
+		FileName:       path.Base(utu.SavePath),
 		Size:           utu.LocalFileChecksum.Length,
 		Md5:            md5Str,
 		LastWrite:      time.Unix(utu.LocalFileChecksum.ModTime, 0).Format("2006-01-02 15:04:05"),
 		LocalPath:      utu.LocalFileChecksum.Path,
 		FamilyId:       utu.FamilyId,
 	}
+	var r *cloudpan.AppCreateUploadFileResult
 	if utu.FamilyId > 0 {
 		r, apierr = utu.PanClient.AppFamilyCreateUploadFile(appCreateUploadFileParam)
 	} else {
@@ -474,6 +573,69 @@ StepUploadPrepareUpload:
 	utu.LocalFileChecksum.FileCommitUrl = r.FileCommitUrl
 	utu.LocalFileChecksum.FileDataExists = r.FileDataExists
 	utu.LocalFileChecksum.XRequestId = r.XRequestId
+	return nil
+}
+
+func (utu *UploadTaskUnit) Run() (result *taskframework.TaskUnitRunResult) {
+	// 等待流水线计算MD5和创建上传任务
+	if utu.Pipeline != nil {
+		utu.Pipeline.wait(utu)
+		utu.Pipeline = nil
+	}
+
+	err := utu.LocalFileChecksum.OpenPath()
+	if err != nil {
+		fmt.Printf("[%s] 文件不可读, 错误信息: %s, 跳过...\n", utu.taskInfo.Id(), err)
+		// 返回失败结果, 使分片或打包的任务组能够结束并清理临时文件
+		return &taskframework.TaskUnitRunResult{
+			ResultMessage: "文件不可读",
+			Err:           err,
+			NeedRetry:     false,
+		}
+	}
+	defer utu.LocalFileChecksum.Close() // 关闭文件
+
+	timeStart := time.Now()
+	result = &taskframework.TaskUnitRunResult{}
+
+	fmt.Printf("[%s] 准备上传: %s=>%s\n", utu.taskInfo.Id(), utu.LocalFileChecksum.Path, utu.SavePath)
+
+	defer func() {
+		var msg string
+		if result.Err != nil {
+			msg = "失败！" + result.ResultMessage + "," + result.Err.Error()
+		} else if result.Succeed {
+			msg = "成功！" + result.ResultMessage
+		} else {
+			msg = result.ResultMessage
+		}
+		fmt.Printf("%s [%s] 文件上传结果：%s  耗时 %s\n", time.Now().Format("2006-01-02 15:04:06"), utu.taskInfo.Id(), msg, time.Now().Sub(timeStart))
+	}()
+
+	// 流水线创建上传任务时已得到结果(跳过, 失败等)
+	if r := utu.precreateResult; r != nil {
+		utu.precreateResult = nil
+		utu.precreated = false
+		return r
+	}
+
+	// 准备文件
+	utu.prepareFile()
+
+	switch utu.Step {
+	case StepUploadPrepareUpload:
+		goto StepUploadPrepareUpload
+	case StepUploadRapidUpload:
+		goto stepUploadRapidUpload
+	case StepUploadUpload:
+		goto stepUploadUpload
+	}
+
+StepUploadPrepareUpload:
+	// 创建上传任务
+	if r := utu.createUploadFile(); r != nil {
+		return r
+	}
 
 stepUploadRapidUpload:
 	// 秒传
@@ -481,7 +643,7 @@ stepUploadRapidUpload:
 		isContinue, rapidUploadResult := utu.rapidUpload()
 		if !isContinue {
 			// 秒传成功, 返回秒传的结果
-			return rapidUploadResult
+			return utu.checkCommittedFile(rapidUploadResult)
 		}
 	}
 
@@ -489,5 +651,5 @@ stepUploadUpload:
 	// 正常上传流程
 	uploadResult := utu.upload()
 
-	return uploadResult
+	return utu.checkCommittedFile(uploadResult)
 }
@@ -0,0 +1,51 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package panupload
+
+import (
+	"testing"
+
+	"github.com/tickstep/cloudpan189-go/internal/localfile"
+	"github.com/tickstep/cloudpan189-go/internal/taskframework"
+)
+
+type testUploadGroup struct {
+	done []int
+	ok   []bool
+}
+
+func (g *testUploadGroup) unitDone(index int, ufm *UploadedFileMeta, ok bool) {
+	g.done = append(g.done, index)
+	g.ok = append(g.ok, ok)
+}
+
+func TestUploadTaskUnitUnreadableFile(t *testing.T) {
+	group := &testUploadGroup{}
+	utu := &UploadTaskUnit{
+		LocalFileChecksum: localfile.NewLocalFileEntity("/nonexistent/cloudpan189-part"),
+		Group:             group,
+		GroupIndex:        2,
+	}
+	executor := &taskframework.TaskExecutor{IsFailedDeque: true}
+	executor.Append(utu, 3)
+	executor.Execute()
+
+	// 文件不可读时任务失败且不重试, 任务组能够结束
+	if len(group.done) != 1 || group.done[0] != 2 || group.ok[0] {
+		t.Errorf("unitDone calls = %v %v, want one failed call for index 2", group.done, group.ok)
+	}
+	if executor.FailedDeque().Size() != 1 {
+		t.Errorf("failed tasks = %d, want 1", executor.FailedDeque().Size())
+	}
+}
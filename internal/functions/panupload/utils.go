@@ -14,6 +14,11 @@
 package panupload
 
 import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+
 	"github.com/tickstep/cloudpan189-go/internal/config"
 	"github.com/tickstep/library-go/converter"
 	"github.com/tickstep/library-go/logger"
@@ -27,11 +32,17 @@ const (
 	// MaxRapidUploadSize 秒传文件支持的最大文件大小
 	MaxRapidUploadSize = 20 * converter.GB
 
-	UploadingFileName = "cloud189_uploading.json"
+	UploadingFileName = "cloud189_uploading.db"
+
+	// 旧版本的未完成上传记录文件, 打开数据库时导入
+	uploadingJSONFileName = "cloud189_uploading.json"
 )
 
 var (
 	cmdUploadVerbose = logger.New("CLOUD189_UPLOAD", config.EnvVerbose)
+
+	// ErrRemoteFileMismatch 网盘文件和本地文件不一致
+	ErrRemoteFileMismatch = errors.New("网盘文件的MD5或大小与本地文件不一致")
 )
 
 func getBlockSize(fileSize int64) int64 {
@@ -41,3 +52,33 @@ func getBlockSize(fileSize int64) int64 {
 	}
 	return MinUploadBlockSize
 }
+
+// removeEmptyDirs 从 dir 开始逐级向上删除空目录, 不会超出 rootPath
+func removeEmptyDirs(dir, rootPath string) {
+	if rootPath == "" {
+		return
+	}
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return
+	}
+	rootPath, err = filepath.Abs(rootPath)
+	if err != nil {
+		return
+	}
+	for {
+		rel, err := filepath.Rel(rootPath, dir)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(os.PathSeparator)) {
+			return
+		}
+		if os.Remove(dir) != nil {
+			// 目录不为空
+			return
+		}
+		cmdUploadVerbose.Infof("remove empty dir: %s\n", dir)
+		if rel == "." {
+			return
+		}
+		dir = filepath.Dir(dir)
+	}
+}
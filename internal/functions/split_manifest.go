@@ -0,0 +1,123 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package functions
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	jsoniter "github.com/json-iterator/go"
+)
+
+const (
+	// SplitManifestSuffix 分片清单文件的后缀, 清单文件名为原文件名加该后缀
+	SplitManifestSuffix = ".cloudpan189-split"
+
+	// SplitManifestVersion 分片清单格式版本
+	SplitManifestVersion = 1
+)
+
+type (
+	// SplitPart 分片信息
+	SplitPart struct {
+		Name   string `json:"name"`
+		Offset int64  `json:"offset"`
+		Size   int64  `json:"size"`
+		MD5    string `json:"md5"`
+	}
+
+	// SplitManifest 分片上传的清单, 和分片文件保存在同一目录
+	SplitManifest struct {
+		Version  int          `json:"version"`
+		Name     string       `json:"name"`    // 原文件名
+		Size     int64        `json:"size"`    // 原文件大小
+		MD5      string       `json:"md5"`     // 原文件的MD5
+		ModTime  int64        `json:"modtime"` // 原文件的修改时间
+		PartSize int64        `json:"part_size"`
+		Parts    []*SplitPart `json:"parts"`
+	}
+)
+
+var (
+	splitPartNameRegexp = regexp.MustCompile(`^(.+)\.part\d{4,}$`)
+
+	// ErrSplitManifestInvalid 分片清单无效
+	ErrSplitManifestInvalid = errors.New("分片清单无效")
+)
+
+// SplitPartName 第 index 个分片(从0开始)的文件名, 如 a.iso.part0001
+func SplitPartName(name string, index int) string {
+	return fmt.Sprintf("%s.part%04d", name, index+1)
+}
+
+// SplitPartBaseName 分片文件对应的原文件名, 不是分片文件名时返回 false
+func SplitPartBaseName(partName string) (string, bool) {
+	m := splitPartNameRegexp.FindStringSubmatch(partName)
+	if m == nil {
+		return "", false
+	}
+	return m[1], true
+}
+
+// IsSplitManifestName 是否为分片清单文件名
+func IsSplitManifestName(name string) bool {
+	return strings.HasSuffix(name, SplitManifestSuffix) && len(name) > len(SplitManifestSuffix)
+}
+
+// NewSplitManifest 按分片大小生成分片清单, 分片的MD5在上传后填写
+func NewSplitManifest(name string, size, modTime, partSize int64) *SplitManifest {
+	m := &SplitManifest{
+		Version:  SplitManifestVersion,
+		Name:     name,
+		Size:     size,
+		ModTime:  modTime,
+		PartSize: partSize,
+	}
+	for offset := int64(0); offset < size; offset += partSize {
+		partLen := partSize
+		if offset+partLen > size {
+			partLen = size - offset
+		}
+		m.Parts = append(m.Parts, &SplitPart{
+			Name:   SplitPartName(name, len(m.Parts)),
+			Offset: offset,
+			Size:   partLen,
+		})
+	}
+	return m
+}
+
+// ParseSplitManifest 解析并检查分片清单
+func ParseSplitManifest(data []byte) (*SplitManifest, error) {
+	m := &SplitManifest{}
+	if err := jsoniter.Unmarshal(data, m); err != nil {
+		return nil, err
+	}
+	if m.Version > SplitManifestVersion {
+		return nil, fmt.Errorf("不支持的分片清单版本: %d", m.Version)
+	}
+	var offset int64
+	for _, part := range m.Parts {
+		if part == nil || part.Offset != offset || part.Size <= 0 || strings.ContainsAny(part.Name, "/\\") {
+			return nil, ErrSplitManifestInvalid
+		}
+		offset += part.Size
+	}
+	if offset != m.Size || m.Name == "" || strings.ContainsAny(m.Name, "/\\") {
+		return nil, ErrSplitManifestInvalid
+	}
+	return m, nil
+}
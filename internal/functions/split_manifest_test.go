@@ -0,0 +1,102 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package functions
+
+import (
+	"testing"
+
+	jsoniter "github.com/json-iterator/go"
+)
+
+func TestSplitPartName(t *testing.T) {
+	tests := []struct {
+		name  string
+		index int
+		want  string
+	}{
+		{"a.iso", 0, "a.iso.part0001"},
+		{"a.iso", 9998, "a.iso.part9999"},
+		{"a.iso", 9999, "a.iso.part10000"},
+	}
+	for _, tt := range tests {
+		got := SplitPartName(tt.name, tt.index)
+		if got != tt.want {
+			t.Errorf("SplitPartName(%q, %d) = %q, want %q", tt.name, tt.index, got, tt.want)
+		}
+		if base, ok := SplitPartBaseName(got); !ok || base != tt.name {
+			t.Errorf("SplitPartBaseName(%q) = %q, %v", got, base, ok)
+		}
+	}
+
+	for _, name := range []string{"a.iso", "a.iso.part1", "a.iso.part001", ".part0001", "a.iso.part0001.tmp"} {
+		if base, ok := SplitPartBaseName(name); ok {
+			t.Errorf("SplitPartBaseName(%q) = %q, want not a part", name, base)
+		}
+	}
+}
+
+func TestIsSplitManifestName(t *testing.T) {
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"a.iso" + SplitManifestSuffix, true},
+		{SplitManifestSuffix, false},
+		{"a.iso", false},
+		{"a.iso" + SplitManifestSuffix + ".bak", false},
+	}
+	for _, tt := range tests {
+		if got := IsSplitManifestName(tt.name); got != tt.want {
+			t.Errorf("IsSplitManifestName(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParseSplitManifest(t *testing.T) {
+	m := NewSplitManifest("a.iso", 25, 1600000000, 10)
+	if len(m.Parts) != 3 || m.Parts[2].Offset != 20 || m.Parts[2].Size != 5 {
+		t.Fatalf("NewSplitManifest parts = %+v", m.Parts)
+	}
+	data, err := jsoniter.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ParseSplitManifest(data)
+	if err != nil {
+		t.Fatalf("ParseSplitManifest: %s", err)
+	}
+	if got.Name != m.Name || got.Size != m.Size || got.ModTime != m.ModTime || len(got.Parts) != len(m.Parts) {
+		t.Errorf("ParseSplitManifest = %+v, want %+v", got, m)
+	}
+
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"not json", `{`},
+		{"newer version", `{"version":2,"name":"a","size":1,"parts":[{"name":"a.part0001","offset":0,"size":1}]}`},
+		{"size mismatch", `{"version":1,"name":"a","size":2,"parts":[{"name":"a.part0001","offset":0,"size":1}]}`},
+		{"gap", `{"version":1,"name":"a","size":3,"parts":[{"name":"a.part0001","offset":0,"size":1},{"name":"a.part0002","offset":2,"size":1}]}`},
+		{"empty part", `{"version":1,"name":"a","size":0,"parts":[{"name":"a.part0001","offset":0,"size":0}]}`},
+		{"null part", `{"version":1,"name":"a","size":0,"parts":[null]}`},
+		{"part path", `{"version":1,"name":"a","size":1,"parts":[{"name":"../a.part0001","offset":0,"size":1}]}`},
+		{"name path", `{"version":1,"name":"x\\a","size":1,"parts":[{"name":"a.part0001","offset":0,"size":1}]}`},
+		{"no name", `{"version":1,"name":"","size":1,"parts":[{"name":"a.part0001","offset":0,"size":1}]}`},
+	}
+	for _, tt := range tests {
+		if _, err := ParseSplitManifest([]byte(tt.data)); err == nil {
+			t.Errorf("%s: ParseSplitManifest succeeded, want error", tt.name)
+		}
+	}
+}
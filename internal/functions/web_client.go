@@ -0,0 +1,45 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package functions
+
+import (
+	"net/http"
+	"net/url"
+
+	"github.com/tickstep/cloudpan189-api/cloudpan"
+	"github.com/tickstep/library-go/requester"
+)
+
+var (
+	cloudpanDomainUrl = &url.URL{
+		Scheme: "http",
+		Host:   ".cloud.189.cn",
+	}
+)
+
+// NewWebClient 创建访问网页版接口的客户端, 带上网页版登录的 cookie.
+// 用于 cloudpan189-api 没有提供或不支持家庭云的网页版接口
+func NewWebClient(webToken cloudpan.WebLoginToken) *requester.HTTPClient {
+	client := requester.NewHTTPClient()
+	client.ResetCookiejar()
+	client.Jar.SetCookies(cloudpanDomainUrl, []*http.Cookie{
+		{
+			Name:   "COOKIE_LOGIN_USER",
+			Value:  webToken.CookieLoginUser,
+			Domain: "cloud.189.cn",
+			Path:   "/",
+		},
+	})
+	return client
+}
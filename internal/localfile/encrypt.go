@@ -0,0 +1,108 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package localfile
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"hash/crc32"
+	"io"
+
+	"github.com/tickstep/cloudpan189-go/library/crypto"
+)
+
+type (
+	// FileEncryption 上传时加密文件内容的参数
+	FileEncryption struct {
+		Keychain  *crypto.Keychain
+		Method    crypto.Method
+		KDFParams crypto.KDFParams
+		// FileSalt 指定文件盐, 用于按网盘文件头部的参数加密本地文件后比较; 为空时由明文派生
+		FileSalt *[16]byte
+	}
+)
+
+// EncryptedSize 明文大小对应的上传大小
+func (fe *FileEncryption) EncryptedSize(plainSize int64) int64 {
+	return crypto.EncryptedSizeOf(plainSize)
+}
+
+// streamCipher 文件盐由明文的SHA-256和大小派生, 相同的内容得到相同的密文,
+// 重复上传、秒传和同名文件比较仍然有效, 不同的内容不会复用 nonce.
+// 代价是网盘可以看出哪些加密文件的内容相同
+func (fe *FileEncryption) streamCipher(plainSHA256 string, plainSize int64) (*crypto.StreamCipher, error) {
+	if fe.FileSalt != nil {
+		return fe.Keychain.NewStreamCipher(fe.Method, fe.KDFParams, *fe.FileSalt, crypto.DefaultChunkSize)
+	}
+	key, err := fe.Keychain.SubKey(fe.KDFParams, "cloudpan189 file salt sha256", 32)
+	if err != nil {
+		return nil, err
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(plainSHA256))
+	binary.Write(mac, binary.BigEndian, plainSize)
+	var fileSalt [16]byte
+	copy(fileSalt[:], mac.Sum(nil))
+	return fe.Keychain.NewStreamCipher(fe.Method, fe.KDFParams, fileSalt, crypto.DefaultChunkSize)
+}
+
+// sumEncrypted 先计算明文的SHA-256 (可使用摘要缓存) 得到文件盐, 再计算密文的摘要值
+func (lfc *LocalFileEntity) sumEncrypted(checkSumFlag int) error {
+	if lfc.Encryption.FileSalt == nil {
+		plain := *lfc
+		plain.Encryption = nil
+		plain.Length = lfc.plainLength
+		plain.buf = nil
+		if err := plain.Sum(CHECKSUM_SHA256); err != nil {
+			return err
+		}
+		lfc.sha256 = plain.sha256
+	}
+	if err := lfc.openEncrypter(); err != nil {
+		return err
+	}
+
+	md5w, crc32w := md5.New(), crc32.NewIEEE()
+	writers := make([]io.Writer, 0, 2)
+	if (checkSumFlag & CHECKSUM_MD5) != 0 {
+		writers = append(writers, md5w)
+	}
+	if (checkSumFlag & CHECKSUM_CRC32) != 0 {
+		writers = append(writers, crc32w)
+	}
+	lfc.initBuf()
+	if _, err := io.CopyBuffer(io.MultiWriter(writers...), io.NewSectionReader(lfc.encrypter, 0, lfc.Length), lfc.buf); err != nil {
+		return err
+	}
+	if (checkSumFlag & CHECKSUM_MD5) != 0 {
+		lfc.MD5 = hex.EncodeToString(md5w.Sum(nil))
+	}
+	if (checkSumFlag & CHECKSUM_CRC32) != 0 {
+		lfc.CRC32 = crc32w.Sum32()
+	}
+	return nil
+}
+
+// openEncrypter 根据明文的SHA-256创建加密读取器
+func (lfc *LocalFileEntity) openEncrypter() error {
+	sc, err := lfc.Encryption.streamCipher(lfc.sha256, lfc.plainLength)
+	if err != nil {
+		return err
+	}
+	lfc.encrypter = sc.NewReaderAt(io.NewSectionReader(lfc.file, lfc.Offset, lfc.plainLength), lfc.plainLength)
+	return nil
+}
@@ -0,0 +1,85 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package localfile
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/tickstep/cloudpan189-go/library/crypto"
+)
+
+func TestSumEncryptedFileSalt(t *testing.T) {
+	dir := t.TempDir()
+	params, err := crypto.NewKDFParams(crypto.KDFNone, make([]byte, 16))
+	if err != nil {
+		t.Fatal(err)
+	}
+	enc := &FileEncryption{
+		Keychain:  crypto.NewKeyFileKeychain(make([]byte, 32)),
+		Method:    crypto.MethodAESGCM,
+		KDFParams: params,
+	}
+	sum := func(name, data string, fe *FileEncryption) string {
+		file := filepath.Join(dir, name)
+		if err := os.WriteFile(file, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		lfc := NewLocalFileEntity(file)
+		lfc.Encryption = fe
+		if err := lfc.OpenPath(); err != nil {
+			t.Fatal(err)
+		}
+		defer lfc.Close()
+		if err := lfc.Sum(CHECKSUM_MD5); err != nil {
+			t.Fatal(err)
+		}
+		return lfc.MD5
+	}
+
+	// 相同的内容得到相同的密文, 不同的内容使用不同的文件盐
+	a := sum("a", "hello", enc)
+	if got := sum("b", "hello", enc); got != a {
+		t.Errorf("same content MD5 = %s, want %s", got, a)
+	}
+	if got := sum("c", "world", enc); got == a {
+		t.Errorf("different content has same MD5 %s", got)
+	}
+
+	// 按密文头部的文件盐加密本地文件, 得到相同的密文
+	lfc := NewLocalFileEntity(filepath.Join(dir, "a"))
+	lfc.Encryption = enc
+	if err := lfc.OpenPath(); err != nil {
+		t.Fatal(err)
+	}
+	defer lfc.Close()
+	header := make([]byte, crypto.StreamHeaderSize)
+	if _, err := lfc.ReaderAtLen64().ReadAt(header, 0); err != nil {
+		t.Fatal(err)
+	}
+	h, err := crypto.ParseHeader(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	salted := *enc
+	salted.FileSalt = &h.FileSalt
+	if got := sum("d", "hello", &salted); got != a {
+		t.Errorf("MD5 with header salt = %s, want %s", got, a)
+	}
+	salted.FileSalt = &[16]byte{1}
+	if got := sum("e", "hello", &salted); got == a {
+		t.Errorf("MD5 with other salt = %s, want different", got)
+	}
+}
@@ -63,3 +63,17 @@ func GetFileSum(localPath string, flag int) (lfc *LocalFileEntity, err error) {
 	}
 	return lfc, nil
 }
+
+// GetFileID 获取文件或目录所在的设备号和 inode (windows 下为卷序列号和文件索引号), 指向的是符号链接时返回链接目标的值
+func GetFileID(localPath string) (dev, ino uint64, ok bool) {
+	f, err := os.Open(localPath)
+	if err != nil {
+		return 0, 0, false
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return 0, 0, false
+	}
+	return fileID(f, info)
+}
@@ -0,0 +1,220 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package localfile
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/tickstep/bolt"
+	"github.com/tickstep/library-go/logger"
+)
+
+const (
+	// HashCacheFileName 本地文件摘要缓存数据库文件名
+	HashCacheFileName = "cloud189_hash_cache.db"
+
+	// HashCacheExpire 缓存项超过该时间未被使用则清理
+	HashCacheExpire = 90 * 24 * time.Hour
+
+	hashCacheBucket     = "hash"
+	hashCacheMetaBucket = "meta"
+	hashCachePruneKey   = "last_prune"
+
+	// 清理和刷新使用时间的间隔
+	hashCachePruneInterval = 24 * time.Hour
+)
+
+type (
+	// HashCache 本地文件摘要缓存
+	// 以 (设备号, inode, 文件大小, 修改时间) 标识文件内容, 文件未改变时不需要重新读取文件计算摘要
+	HashCache struct {
+		db *bolt.DB
+	}
+
+	hashCacheEntry struct {
+		Size     int64  `json:"size"`
+		ModTime  int64  `json:"mtime"` // 纳秒
+		Flag     int    `json:"flag"`  // 已缓存的摘要类型
+		MD5      string `json:"md5,omitempty"`
+		CRC32    uint32 `json:"crc32,omitempty"`
+		SHA256   string `json:"sha256,omitempty"`
+		LastUsed int64  `json:"last_used"`
+	}
+)
+
+var (
+	hashCache      *HashCache
+	hashCacheMutex sync.RWMutex
+)
+
+// OpenHashCache 打开本地文件摘要缓存, 并清理长时间未使用的缓存项
+func OpenHashCache(file string) (*HashCache, error) {
+	// 其他进程正在使用缓存时不等待, 直接不使用缓存
+	db, err := bolt.Open(file, 0600, &bolt.Options{Timeout: 1 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+	hc := &HashCache{db: db}
+	hc.autoPrune()
+	return hc, nil
+}
+
+// SetHashCache 设置全局使用的摘要缓存, 为空则不使用缓存
+func SetHashCache(hc *HashCache) {
+	hashCacheMutex.Lock()
+	defer hashCacheMutex.Unlock()
+	hashCache = hc
+}
+
+func getHashCache() *HashCache {
+	hashCacheMutex.RLock()
+	defer hashCacheMutex.RUnlock()
+	return hashCache
+}
+
+// Close 关闭缓存
+func (hc *HashCache) Close() error {
+	if hc == nil || hc.db == nil {
+		return nil
+	}
+	return hc.db.Close()
+}
+
+func hashCacheKey(dev, ino uint64) []byte {
+	return []byte(fmt.Sprintf("%x-%x", dev, ino))
+}
+
+// lookup 查找文件摘要, 文件大小或修改时间不一致时视为未命中
+func (hc *HashCache) lookup(dev, ino uint64, info os.FileInfo, flag int) (entry *hashCacheEntry) {
+	key := hashCacheKey(dev, ino)
+	hc.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(hashCacheBucket))
+		if b == nil {
+			return nil
+		}
+		v := b.Get(key)
+		if v == nil {
+			return nil
+		}
+		e := &hashCacheEntry{}
+		if err := jsoniter.Unmarshal(v, e); err != nil {
+			return nil
+		}
+		if e.Size != info.Size() || e.ModTime != info.ModTime().UnixNano() || e.Flag&flag != flag {
+			return nil
+		}
+		entry = e
+		return nil
+	})
+	if entry == nil {
+		return nil
+	}
+
+	// 刷新使用时间, 避免每次命中都写入数据库
+	now := time.Now()
+	if now.Sub(time.Unix(entry.LastUsed, 0)) > hashCachePruneInterval {
+		entry.LastUsed = now.Unix()
+		hc.put(key, entry)
+	}
+	return entry
+}
+
+// store 保存文件摘要, 和已有的同一文件内容的缓存合并
+func (hc *HashCache) store(dev, ino uint64, info os.FileInfo, flag int, md5Str string, crc uint32, sha256Str string) {
+	// 文件可能仍在写入, 不缓存
+	if time.Now().Sub(info.ModTime()) < 2*time.Second {
+		return
+	}
+
+	key := hashCacheKey(dev, ino)
+	entry := hc.lookup(dev, ino, info, 0)
+	if entry == nil {
+		entry = &hashCacheEntry{
+			Size:    info.Size(),
+			ModTime: info.ModTime().UnixNano(),
+		}
+	}
+	if flag&CHECKSUM_MD5 != 0 {
+		entry.MD5 = md5Str
+	}
+	if flag&CHECKSUM_CRC32 != 0 {
+		entry.CRC32 = crc
+	}
+	if flag&CHECKSUM_SHA256 != 0 {
+		entry.SHA256 = sha256Str
+	}
+	entry.Flag |= flag
+	entry.LastUsed = time.Now().Unix()
+	hc.put(key, entry)
+}
+
+func (hc *HashCache) put(key []byte, entry *hashCacheEntry) {
+	err := hc.db.Update(func(tx *bolt.Tx) error {
+		data, err := jsoniter.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		b, err := tx.CreateBucketIfNotExists([]byte(hashCacheBucket))
+		if err != nil {
+			return err
+		}
+		return b.Put(key, data)
+	})
+	if err != nil {
+		logger.Verbosef("保存文件摘要缓存失败: %s\n", err)
+	}
+}
+
+// autoPrune 每天最多清理一次长时间未使用的缓存项
+func (hc *HashCache) autoPrune() {
+	now := time.Now()
+	err := hc.db.Update(func(tx *bolt.Tx) error {
+		meta, err := tx.CreateBucketIfNotExists([]byte(hashCacheMetaBucket))
+		if err != nil {
+			return err
+		}
+		if v := meta.Get([]byte(hashCachePruneKey)); v != nil {
+			if last, err := strconv.ParseInt(string(v), 10, 64); err == nil && now.Sub(time.Unix(last, 0)) < hashCachePruneInterval {
+				return nil
+			}
+		}
+
+		if b := tx.Bucket([]byte(hashCacheBucket)); b != nil {
+			expire := now.Add(-HashCacheExpire).Unix()
+			var expired [][]byte
+			c := b.Cursor()
+			for k, v := c.First(); k != nil; k, v = c.Next() {
+				e := &hashCacheEntry{}
+				if jsoniter.Unmarshal(v, e) != nil || e.LastUsed < expire {
+					expired = append(expired, append([]byte{}, k...))
+				}
+			}
+			for _, k := range expired {
+				b.Delete(k)
+			}
+			if len(expired) > 0 {
+				logger.Verbosef("清理文件摘要缓存 %d 项\n", len(expired))
+			}
+		}
+		return meta.Put([]byte(hashCachePruneKey), []byte(strconv.FormatInt(now.Unix(), 10)))
+	})
+	if err != nil {
+		logger.Verbosef("清理文件摘要缓存失败: %s\n", err)
+	}
+}
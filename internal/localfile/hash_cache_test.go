@@ -0,0 +1,192 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package localfile
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/tickstep/bolt"
+)
+
+type testFileInfo struct {
+	size    int64
+	modTime time.Time
+}
+
+func (fi testFileInfo) Name() string       { return "test" }
+func (fi testFileInfo) Size() int64        { return fi.size }
+func (fi testFileInfo) Mode() os.FileMode  { return 0644 }
+func (fi testFileInfo) ModTime() time.Time { return fi.modTime }
+func (fi testFileInfo) IsDir() bool        { return false }
+func (fi testFileInfo) Sys() interface{}   { return nil }
+
+func openTestHashCache(t *testing.T) *HashCache {
+	hc, err := OpenHashCache(filepath.Join(t.TempDir(), HashCacheFileName))
+	if err != nil {
+		t.Fatalf("OpenHashCache: %s", err)
+	}
+	t.Cleanup(func() { hc.Close() })
+	return hc
+}
+
+func TestHashCacheLookup(t *testing.T) {
+	hc := openTestHashCache(t)
+	mtime := time.Now().Add(-time.Hour)
+	info := testFileInfo{size: 100, modTime: mtime}
+	hc.store(1, 2, info, CHECKSUM_MD5, "md5", 0, "")
+
+	testCases := []struct {
+		name string
+		dev  uint64
+		ino  uint64
+		info os.FileInfo
+		flag int
+		hit  bool
+	}{
+		{"same", 1, 2, info, CHECKSUM_MD5, true},
+		{"other inode", 1, 3, info, CHECKSUM_MD5, false},
+		{"other device", 2, 2, info, CHECKSUM_MD5, false},
+		{"size changed", 1, 2, testFileInfo{size: 101, modTime: mtime}, CHECKSUM_MD5, false},
+		{"mtime changed", 1, 2, testFileInfo{size: 100, modTime: mtime.Add(time.Nanosecond)}, CHECKSUM_MD5, false},
+		{"flag not cached", 1, 2, info, CHECKSUM_MD5 | CHECKSUM_CRC32, false},
+	}
+	for _, c := range testCases {
+		entry := hc.lookup(c.dev, c.ino, c.info, c.flag)
+		if (entry != nil) != c.hit {
+			t.Errorf("%s: hit = %v, want %v", c.name, entry != nil, c.hit)
+		}
+		if entry != nil && entry.MD5 != "md5" {
+			t.Errorf("%s: MD5 = %q, want %q", c.name, entry.MD5, "md5")
+		}
+	}
+}
+
+func TestHashCacheStore(t *testing.T) {
+	hc := openTestHashCache(t)
+	info := testFileInfo{size: 100, modTime: time.Now().Add(-time.Hour)}
+	hc.store(1, 2, info, CHECKSUM_MD5, "md5", 0, "")
+	hc.store(1, 2, info, CHECKSUM_CRC32, "", 123, "")
+	hc.store(1, 2, info, CHECKSUM_SHA256, "", 0, "sha256")
+
+	entry := hc.lookup(1, 2, info, CHECKSUM_MD5|CHECKSUM_CRC32|CHECKSUM_SHA256)
+	if entry == nil {
+		t.Fatal("merged entry not found")
+	}
+	if entry.MD5 != "md5" || entry.CRC32 != 123 || entry.SHA256 != "sha256" {
+		t.Errorf("merged entry = %+v", entry)
+	}
+
+	// 刚修改的文件可能仍在写入, 不缓存
+	recent := testFileInfo{size: 100, modTime: time.Now()}
+	hc.store(1, 4, recent, CHECKSUM_MD5, "md5", 0, "")
+	if hc.lookup(1, 4, recent, CHECKSUM_MD5) != nil {
+		t.Error("recently modified file should not be cached")
+	}
+}
+
+func hasHashCacheEntry(hc *HashCache, dev, ino uint64) (ok bool) {
+	hc.db.View(func(tx *bolt.Tx) error {
+		if b := tx.Bucket([]byte(hashCacheBucket)); b != nil {
+			ok = b.Get(hashCacheKey(dev, ino)) != nil
+		}
+		return nil
+	})
+	return
+}
+
+func TestHashCachePrune(t *testing.T) {
+	file := filepath.Join(t.TempDir(), HashCacheFileName)
+	hc, err := OpenHashCache(file)
+	if err != nil {
+		t.Fatalf("OpenHashCache: %s", err)
+	}
+	info := testFileInfo{size: 100, modTime: time.Now().Add(-time.Hour)}
+	hc.put(hashCacheKey(1, 1), &hashCacheEntry{Size: 100, ModTime: info.ModTime().UnixNano(), Flag: CHECKSUM_MD5, MD5: "old",
+		LastUsed: time.Now().Add(-HashCacheExpire - time.Hour).Unix()})
+	hc.put(hashCacheKey(1, 2), &hashCacheEntry{Size: 100, ModTime: info.ModTime().UnixNano(), Flag: CHECKSUM_MD5, MD5: "new",
+		LastUsed: time.Now().Unix()})
+	hc.Close()
+
+	// 距上次清理不足清理间隔, 不清理
+	hc, err = OpenHashCache(file)
+	if err != nil {
+		t.Fatalf("OpenHashCache: %s", err)
+	}
+	if !hasHashCacheEntry(hc, 1, 1) {
+		t.Error("entry pruned before prune interval")
+	}
+
+	// 上次清理时间超过清理间隔, 清理过期项
+	last := time.Now().Add(-hashCachePruneInterval - time.Hour).Unix()
+	hc.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(hashCacheMetaBucket)).Put([]byte(hashCachePruneKey), []byte(strconv.FormatInt(last, 10)))
+	})
+	hc.Close()
+	hc, err = OpenHashCache(file)
+	if err != nil {
+		t.Fatalf("OpenHashCache: %s", err)
+	}
+	defer hc.Close()
+	if hasHashCacheEntry(hc, 1, 1) {
+		t.Error("expired entry not pruned")
+	}
+	if !hasHashCacheEntry(hc, 1, 2) {
+		t.Error("recently used entry pruned")
+	}
+}
+
+func TestSumUsesHashCache(t *testing.T) {
+	hc := openTestHashCache(t)
+	SetHashCache(hc)
+	defer SetHashCache(nil)
+
+	file := filepath.Join(t.TempDir(), "data")
+	mtime := time.Now().Add(-time.Hour)
+	writeFile := func(data string) {
+		if err := os.WriteFile(file, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(file, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sum := func() string {
+		lfc := NewLocalFileEntity(file)
+		if err := lfc.OpenPath(); err != nil {
+			t.Fatal(err)
+		}
+		defer lfc.Close()
+		if err := lfc.Sum(CHECKSUM_MD5); err != nil {
+			t.Fatal(err)
+		}
+		return lfc.MD5
+	}
+
+	writeFile("hello")
+	first := sum()
+	// 内容改变但大小和修改时间不变, 命中缓存
+	writeFile("world")
+	if got := sum(); got != first {
+		t.Errorf("Sum with cache = %s, want cached %s", got, first)
+	}
+
+	SetHashCache(nil)
+	if got := sum(); got == first {
+		t.Errorf("Sum without cache = %s, want new digest", got)
+	}
+}
@@ -15,14 +15,18 @@ package localfile
 
 import (
 	"crypto/md5"
+	"crypto/sha256"
 	"encoding/hex"
 	"github.com/tickstep/cloudpan189-api/cloudpan"
 	"hash/crc32"
 	"io"
 	"os"
+	"sync/atomic"
 
+	"github.com/tickstep/cloudpan189-go/library/crypto"
 	"github.com/tickstep/library-go/cachepool"
 	"github.com/tickstep/library-go/converter"
+	"github.com/tickstep/library-go/requester/rio"
 )
 
 const (
@@ -36,6 +40,9 @@ const (
 
 	// CHECKSUM_CRC32 获取文件的 crc32 值
 	CHECKSUM_CRC32
+
+	// CHECKSUM_SHA256 获取文件的 sha256 值, 只用于加密上传时派生文件盐
+	CHECKSUM_SHA256
 )
 
 type (
@@ -46,6 +53,7 @@ type (
 		MD5     string `json:"md5,omitempty"`    // 文件的 md5
 		CRC32   uint32 `json:"crc32,omitempty"`  // 文件的 crc32
 		ModTime int64  `json:"modtime"`          // 修改日期
+		Offset  int64  `json:"offset,omitempty"` // 只处理文件的一部分时, 该部分在文件中的起始位置
 
 		// ParentFolderId 存储云盘的目录ID
 		ParentFolderId string `json:"parent_folder_id,omitempty"`
@@ -64,9 +72,21 @@ type (
 	// LocalFileEntity 校验本地文件
 	LocalFileEntity struct {
 		LocalFileMeta
-		bufSize int
-		buf     []byte
-		file    *os.File // 文件
+		NoHashCache   bool            // 不使用摘要缓存, 用于修改时间不能反映内容变化的临时文件
+		Encryption    *FileEncryption // 上传时加密文件内容, Length 和摘要值均为密文的
+		sectionLength int64           // 只处理文件的一部分时, 该部分的长度
+		plainLength   int64           // 加密上传时明文的长度
+		sha256        string          // 文件的 sha256, 加密上传时为明文的
+		encrypter     *crypto.EncryptedReaderAt
+		bufSize       int
+		buf           []byte
+		file          *os.File // 文件
+	}
+
+	// sectionReaderAtLen64 读取文件的一部分, 实现 rio.ReaderAtLen64 接口
+	sectionReaderAtLen64 struct {
+		sr     *io.SectionReader
+		readed int64
 	}
 )
 
@@ -83,6 +103,19 @@ func NewLocalFileEntityWithBufSize(localPath string, bufSize int) *LocalFileEnti
 	}
 }
 
+// NewLocalFileSectionEntity 只处理文件从 offset 开始的 length 字节, 用于分片上传
+func NewLocalFileSectionEntity(localPath string, offset, length int64) *LocalFileEntity {
+	lfc := NewLocalFileEntity(localPath)
+	lfc.Offset = offset
+	lfc.sectionLength = length
+	return lfc
+}
+
+// IsSection 是否只处理文件的一部分
+func (lfc *LocalFileEntity) IsSection() bool {
+	return lfc.sectionLength > 0
+}
+
 // OpenPath 检查文件状态并获取文件的大小 (Length)
 func (lfc *LocalFileEntity) OpenPath() error {
 	if lfc.file != nil {
@@ -102,9 +135,76 @@ func (lfc *LocalFileEntity) OpenPath() error {
 
 	lfc.Length = info.Size()
 	lfc.ModTime = info.ModTime().Unix()
+	if lfc.IsSection() {
+		lfc.Length -= lfc.Offset
+		if lfc.Length > lfc.sectionLength {
+			lfc.Length = lfc.sectionLength
+		}
+		if lfc.Length < 0 {
+			lfc.Length = 0
+		}
+	}
+	if lfc.Encryption != nil {
+		if lfc.plainLength != lfc.Length {
+			lfc.sha256 = ""
+		}
+		lfc.plainLength = lfc.Length
+		lfc.Length = lfc.Encryption.EncryptedSize(lfc.plainLength)
+		lfc.encrypter = nil
+	}
 	return nil
 }
 
+// SourceLength 本地文件需要处理部分的长度, 加密上传时 Length 为密文的长度
+func (lfc *LocalFileEntity) SourceLength() int64 {
+	if lfc.Encryption != nil {
+		return lfc.plainLength
+	}
+	return lfc.Length
+}
+
+// ReaderAtLen64 读取文件需要处理的部分
+func (lfc *LocalFileEntity) ReaderAtLen64() rio.ReaderAtLen64 {
+	if lfc.file == nil {
+		return nil
+	}
+	if lfc.Encryption != nil {
+		if lfc.encrypter == nil {
+			// 流水线已计算过摘要值时, 只需重新创建加密读取器
+			if lfc.sha256 != "" && lfc.MD5 != "" {
+				if lfc.openEncrypter() != nil {
+					return nil
+				}
+			} else if lfc.Sum(CHECKSUM_MD5) != nil {
+				return nil
+			}
+		}
+		return &sectionReaderAtLen64{
+			sr: io.NewSectionReader(lfc.encrypter, 0, lfc.Length),
+		}
+	}
+	return &sectionReaderAtLen64{
+		sr: io.NewSectionReader(lfc.file, lfc.Offset, lfc.Length),
+	}
+}
+
+// Read 读文件, 并记录已读取数据量
+func (sr *sectionReaderAtLen64) Read(b []byte) (n int, err error) {
+	n, err = sr.sr.Read(b)
+	atomic.AddInt64(&sr.readed, int64(n))
+	return n, err
+}
+
+// ReadAt 读文件, 不记录已读取数据量
+func (sr *sectionReaderAtLen64) ReadAt(b []byte, off int64) (n int, err error) {
+	return sr.sr.ReadAt(b, off)
+}
+
+// Len 返回剩余的长度
+func (sr *sectionReaderAtLen64) Len() int64 {
+	return sr.sr.Size() - atomic.LoadInt64(&sr.readed)
+}
+
 // GetFile 获取文件
 func (lfc *LocalFileEntity) GetFile() *os.File {
 	return lfc.file
@@ -160,11 +260,12 @@ func (lfc *LocalFileEntity) repeatRead(wus ...*ChecksumWriteUnit) (err error) {
 
 	// 读文件
 	var (
-		n int
+		n  int
+		sr = io.NewSectionReader(lfc.file, lfc.Offset, lfc.Length)
 	)
 read:
 	for {
-		n, err = lfc.file.Read(lfc.buf)
+		n, err = sr.Read(lfc.buf)
 		switch err {
 		case io.EOF:
 			err = lfc.writeChecksum(lfc.buf[:n], wus...)
@@ -200,6 +301,32 @@ func (lfc *LocalFileEntity) createChecksumWriteUnit(cw ChecksumWriter, isAll boo
 // Sum 计算文件摘要值
 func (lfc *LocalFileEntity) Sum(checkSumFlag int) (err error) {
 	lfc.fix()
+	if lfc.Encryption != nil {
+		return lfc.sumEncrypted(checkSumFlag)
+	}
+
+	// 优先从摘要缓存读取
+	hc, dev, ino, info := lfc.hashCacheKey()
+	if hc != nil {
+		if entry := hc.lookup(dev, ino, info, checkSumFlag); entry != nil {
+			if (checkSumFlag & CHECKSUM_MD5) != 0 {
+				lfc.MD5 = entry.MD5
+			}
+			if (checkSumFlag & CHECKSUM_CRC32) != 0 {
+				lfc.CRC32 = entry.CRC32
+			}
+			if (checkSumFlag & CHECKSUM_SHA256) != 0 {
+				lfc.sha256 = entry.SHA256
+			}
+			return nil
+		}
+		defer func() {
+			if err == nil {
+				hc.store(dev, ino, info, checkSumFlag&(CHECKSUM_MD5|CHECKSUM_CRC32|CHECKSUM_SHA256), lfc.MD5, lfc.CRC32, lfc.sha256)
+			}
+		}()
+	}
+
 	wus := make([]*ChecksumWriteUnit, 0, 2)
 	if (checkSumFlag & (CHECKSUM_MD5)) != 0 {
 		md5w := md5.New()
@@ -237,10 +364,42 @@ func (lfc *LocalFileEntity) Sum(checkSumFlag int) (err error) {
 		defer d(err)
 	}
 
+	if (checkSumFlag & CHECKSUM_SHA256) != 0 {
+		wu, d := lfc.createChecksumWriteUnit(
+			NewHashChecksumWriter(sha256.New()),
+			true,
+			func(sum interface{}) {
+				if sum != nil {
+					lfc.sha256 = hex.EncodeToString(sum.([]byte))
+				}
+			},
+		)
+
+		wus = append(wus, wu)
+		defer d(err)
+	}
+
 	err = lfc.repeatRead(wus...)
 	return
 }
 
+// hashCacheKey 获取文件在摘要缓存中的标识, 未启用缓存或无法获取标识时返回空
+func (lfc *LocalFileEntity) hashCacheKey() (hc *HashCache, dev, ino uint64, info os.FileInfo) {
+	hc = getHashCache()
+	if hc == nil || lfc.file == nil || lfc.NoHashCache || lfc.IsSection() {
+		return nil, 0, 0, nil
+	}
+	info, err := lfc.file.Stat()
+	if err != nil || info.Size() == 0 {
+		return nil, 0, 0, nil
+	}
+	dev, ino, ok := fileID(lfc.file, info)
+	if !ok {
+		return nil, 0, 0, nil
+	}
+	return hc, dev, ino, info
+}
+
 func (lfc *LocalFileEntity) fix() {
 	if lfc.bufSize < DefaultBufSize {
 		lfc.bufSize = DefaultBufSize
@@ -24,6 +24,12 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
+)
+
+var (
+	// cloudTimeLocation 网盘返回的时间均为北京时间
+	cloudTimeLocation = time.FixedZone("CST", 8*3600)
 )
 
 // TrimPathPrefix 去除目录的前缀
@@ -130,3 +136,13 @@ func IsExcludeFile(filePath string, excludeNames *[]string) bool {
 	}
 	return false
 }
+
+// ParseCloudTime 解析网盘返回的时间字符串, 格式如 2006-01-02 15:04:05
+func ParseCloudTime(timeStr string) (time.Time, error) {
+	return time.ParseInLocation("2006-01-02 15:04:05", strings.TrimSpace(timeStr), cloudTimeLocation)
+}
+
+// FormatCloudTime 按网盘的时间格式输出, 和 ParseCloudTime 对应
+func FormatCloudTime(t time.Time) string {
+	return t.In(cloudTimeLocation).Format("2006-01-02 15:04:05")
+}
@@ -0,0 +1,66 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package utils
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCloudTime(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    time.Time
+		wantErr bool
+	}{
+		{"2021-03-04 05:06:07", time.Date(2021, 3, 3, 21, 6, 7, 0, time.UTC), false},
+		{" 2021-03-04 00:00:00 ", time.Date(2021, 3, 3, 16, 0, 0, 0, time.UTC), false},
+		{"2021-03-04T05:06:07", time.Time{}, true},
+		{"", time.Time{}, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseCloudTime(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseCloudTime(%q) err = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && !got.Equal(tt.want) {
+			t.Errorf("ParseCloudTime(%q) = %v, want %v", tt.in, got.UTC(), tt.want)
+		}
+		if !tt.wantErr {
+			if _, offset := got.Zone(); offset != 8*3600 {
+				t.Errorf("ParseCloudTime(%q) offset = %d, want +8", tt.in, offset)
+			}
+		}
+	}
+}
+
+func TestFormatCloudTime(t *testing.T) {
+	tests := []struct {
+		in   time.Time
+		want string
+	}{
+		{time.Date(2021, 3, 3, 21, 6, 7, 0, time.UTC), "2021-03-04 05:06:07"},
+		{time.Date(2021, 3, 4, 5, 6, 7, 0, time.FixedZone("EST", -5*3600)), "2021-03-04 18:06:07"},
+	}
+	for _, tt := range tests {
+		if got := FormatCloudTime(tt.in); got != tt.want {
+			t.Errorf("FormatCloudTime(%v) = %q, want %q", tt.in, got, tt.want)
+		}
+		back, err := ParseCloudTime(FormatCloudTime(tt.in))
+		if err != nil || !back.Equal(tt.in) {
+			t.Errorf("round trip of %v = %v, %v", tt.in, back, err)
+		}
+	}
+}
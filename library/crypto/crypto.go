@@ -14,15 +14,21 @@
 package crypto
 
 import (
+	"bufio"
+	"compress/gzip"
 	"fmt"
-	"github.com/tickstep/library-go/archive"
 	"github.com/tickstep/library-go/crypto"
 	"io"
 	"os"
-	"strings"
+	"path/filepath"
 )
 
-// CryptoMethodSupport 检测是否支持加密解密方法
+const (
+	// EncryptedFileSuffix 加密后文件的默认后缀
+	EncryptedFileSuffix = ".encrypt"
+)
+
+// CryptoMethodSupport 检测是否为旧版本的加密解密方法
 func CryptoMethodSupport(method string) bool {
 	switch method {
 	case "aes-128-ctr", "aes-192-ctr", "aes-256-ctr", "aes-128-cfb", "aes-192-cfb", "aes-256-cfb", "aes-128-ofb", "aes-192-ofb", "aes-256-ofb":
@@ -32,87 +38,124 @@ func CryptoMethodSupport(method string) bool {
 	return false
 }
 
-// EncryptFile 加密本地文件
-func EncryptFile(method string, key []byte, filePath string, isGzip bool) (encryptedFilePath string, err error) {
-	if !CryptoMethodSupport(method) {
-		return "", fmt.Errorf("unknown encrypt method: %s", method)
+// EncryptFile 加密本地文件, 写入新的文件 dstPath, 不修改源文件
+func EncryptFile(k *Keychain, method Method, p KDFParams, srcPath, dstPath string) error {
+	sc, err := k.NewRandomStreamCipher(method, p, DefaultChunkSize)
+	if err != nil {
+		return err
 	}
 
-	if isGzip {
-		err = archive.GZIPCompressFile(filePath)
-		if err != nil {
-			return
+	plainFile, err := os.Open(srcPath)
+	if err != nil {
+		return err
+	}
+	defer plainFile.Close()
+
+	return writeFile(srcPath, dstPath, func(w io.Writer) error {
+		ew := sc.NewWriter(w)
+		if _, err := io.Copy(ew, plainFile); err != nil {
+			return err
 		}
+		return ew.Close()
+	})
+}
+
+// DecryptFile 解密本地文件, 写入新的文件 dstPath, 不修改源文件.
+// 认证失败时不会留下解密了一部分的文件
+func DecryptFile(k *Keychain, srcPath, dstPath string) error {
+	cipherFile, err := os.Open(srcPath)
+	if err != nil {
+		return err
 	}
+	defer cipherFile.Close()
 
-	plainFile, err := os.OpenFile(filePath, os.O_RDONLY, 0)
+	br := bufio.NewReader(cipherFile)
+	header, _ := br.Peek(StreamHeaderSize)
+	if !IsEncrypted(header) {
+		return ErrNotEncrypted
+	}
+	plainReader, err := k.NewReader(br)
 	if err != nil {
-		return
+		return err
 	}
 
+	return writeFile(srcPath, dstPath, func(w io.Writer) error {
+		_, err := io.Copy(w, plainReader)
+		return err
+	})
+}
+
+// LegacyEncryptFile 使用旧版本的方法加密本地文件, 没有完整性校验, 仅用于兼容
+func LegacyEncryptFile(method string, key []byte, srcPath, dstPath string, isGzip bool) error {
+	if !CryptoMethodSupport(method) {
+		return fmt.Errorf("unknown encrypt method: %s", method)
+	}
+
+	plainFile, err := os.Open(srcPath)
+	if err != nil {
+		return err
+	}
 	defer plainFile.Close()
 
+	var plainReader io.Reader = plainFile
+	if isGzip {
+		// 边压缩边加密, 不再原地压缩源文件
+		pr, pw := io.Pipe()
+		go func() {
+			gw := gzip.NewWriter(pw)
+			_, err := io.Copy(gw, plainFile)
+			if err == nil {
+				err = gw.Close()
+			}
+			pw.CloseWithError(err)
+		}()
+		defer pr.Close()
+		plainReader = pr
+	}
+
 	var cipherReader io.Reader
 	switch method {
 	case "aes-128-ctr":
-		cipherReader, err = crypto.Aes128CTREncrypt(crypto.Convert16bytes(key), plainFile)
+		cipherReader, err = crypto.Aes128CTREncrypt(crypto.Convert16bytes(key), plainReader)
 	case "aes-192-ctr":
-		cipherReader, err = crypto.Aes192CTREncrypt(crypto.Convert24bytes(key), plainFile)
+		cipherReader, err = crypto.Aes192CTREncrypt(crypto.Convert24bytes(key), plainReader)
 	case "aes-256-ctr":
-		cipherReader, err = crypto.Aes256CTREncrypt(crypto.Convert32bytes(key), plainFile)
+		cipherReader, err = crypto.Aes256CTREncrypt(crypto.Convert32bytes(key), plainReader)
 	case "aes-128-cfb":
-		cipherReader, err = crypto.Aes128CFBEncrypt(crypto.Convert16bytes(key), plainFile)
+		cipherReader, err = crypto.Aes128CFBEncrypt(crypto.Convert16bytes(key), plainReader)
 	case "aes-192-cfb":
-		cipherReader, err = crypto.Aes192CFBEncrypt(crypto.Convert24bytes(key), plainFile)
+		cipherReader, err = crypto.Aes192CFBEncrypt(crypto.Convert24bytes(key), plainReader)
 	case "aes-256-cfb":
-		cipherReader, err = crypto.Aes256CFBEncrypt(crypto.Convert32bytes(key), plainFile)
+		cipherReader, err = crypto.Aes256CFBEncrypt(crypto.Convert32bytes(key), plainReader)
 	case "aes-128-ofb":
-		cipherReader, err = crypto.Aes128OFBEncrypt(crypto.Convert16bytes(key), plainFile)
+		cipherReader, err = crypto.Aes128OFBEncrypt(crypto.Convert16bytes(key), plainReader)
 	case "aes-192-ofb":
-		cipherReader, err = crypto.Aes192OFBEncrypt(crypto.Convert24bytes(key), plainFile)
+		cipherReader, err = crypto.Aes192OFBEncrypt(crypto.Convert24bytes(key), plainReader)
 	case "aes-256-ofb":
-		cipherReader, err = crypto.Aes256OFBEncrypt(crypto.Convert32bytes(key), plainFile)
-	default:
-		return "", fmt.Errorf("unknown encrypt method: %s", method)
-	}
-
-	if err != nil {
-		return
-	}
-
-	plainFileInfo, err := plainFile.Stat()
-	if err != nil {
-		return
+		cipherReader, err = crypto.Aes256OFBEncrypt(crypto.Convert32bytes(key), plainReader)
 	}
-
-	encryptedFilePath = filePath + ".encrypt"
-	encryptedFile, err := os.OpenFile(encryptedFilePath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, plainFileInfo.Mode())
-	if err != nil {
-		return
-	}
-
-	defer encryptedFile.Close()
-
-	_, err = io.Copy(encryptedFile, cipherReader)
 	if err != nil {
-		return
+		return err
 	}
 
-	os.Remove(filePath)
-
-	return encryptedFilePath, nil
+	return writeFile(srcPath, dstPath, func(w io.Writer) error {
+		_, err := io.Copy(w, cipherReader)
+		return err
+	})
 }
 
-// DecryptFile 加密本地文件
-func DecryptFile(method string, key []byte, filePath string, isGzip bool) (decryptedFilePath string, err error) {
+// LegacyDecryptFile 解密旧版本方法加密的本地文件, 写入新的文件 dstPath, 不修改源文件.
+// 启用GZIP时可以检测出错误的密钥, 否则无法判断是否解密成功
+func LegacyDecryptFile(method string, key []byte, srcPath, dstPath string, isGzip bool) error {
 	if !CryptoMethodSupport(method) {
-		return "", fmt.Errorf("unknown decrypt method: %s", method)
+		return fmt.Errorf("unknown decrypt method: %s", method)
 	}
 
-	cipherFile, err := os.OpenFile(filePath, os.O_RDONLY, 0644)
+	cipherFile, err := os.Open(srcPath)
 	if err != nil {
-		return
+		return err
 	}
+	defer cipherFile.Close()
 
 	var plainReader io.Reader
 	switch method {
@@ -134,50 +177,55 @@ func DecryptFile(method string, key []byte, filePath string, isGzip bool) (decry
 		plainReader, err = crypto.Aes192OFBDecrypt(crypto.Convert24bytes(key), cipherFile)
 	case "aes-256-ofb":
 		plainReader, err = crypto.Aes256OFBDecrypt(crypto.Convert32bytes(key), cipherFile)
-	default:
-		return "", fmt.Errorf("unknown decrypt method: %s", method)
 	}
-
 	if err != nil {
-		return
+		return err
 	}
 
-	cipherFileInfo, err := cipherFile.Stat()
-	if err != nil {
-		return
+	if isGzip {
+		gr, err := gzip.NewReader(plainReader)
+		if err != nil {
+			return fmt.Errorf("解密失败, 密钥错误或文件已损坏: %s", err)
+		}
+		defer gr.Close()
+		plainReader = gr
 	}
 
-	decryptedFilePath = strings.TrimSuffix(filePath, ".encrypt")
-	decryptedTmpFilePath := decryptedFilePath + ".decrypted"
-	decryptedTmpFile, err := os.OpenFile(decryptedTmpFilePath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, cipherFileInfo.Mode())
-	if err != nil {
-		return
-	}
+	return writeFile(srcPath, dstPath, func(w io.Writer) error {
+		_, err := io.Copy(w, plainReader)
+		return err
+	})
+}
 
-	_, err = io.Copy(decryptedTmpFile, plainReader)
+// writeFile 写入同目录的临时文件, 成功后重命名为 dstPath, 失败时删除临时文件. 新文件使用源文件的权限
+func writeFile(srcPath, dstPath string, write func(w io.Writer) error) error {
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(srcPath); err == nil {
+		mode = info.Mode().Perm()
+	}
+	if err := os.MkdirAll(filepath.Dir(dstPath), 0755); err != nil {
+		return err
+	}
+	tmpPath := dstPath + ".cloudpan189-tmp"
+	tmpFile, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
 	if err != nil {
-		return
+		return err
 	}
 
-	decryptedTmpFile.Close()
-	cipherFile.Close()
-
-	if isGzip {
-		err = archive.GZIPUnompressFile(decryptedTmpFilePath)
-		if err != nil {
-			os.Remove(decryptedTmpFilePath)
-			return
-		}
-
-		// 删除已加密的文件
-		os.Remove(filePath)
+	bw := bufio.NewWriter(tmpFile)
+	err = write(bw)
+	if err == nil {
+		err = bw.Flush()
 	}
-
-	if filePath != decryptedFilePath {
-		os.Rename(decryptedTmpFilePath, decryptedFilePath)
-	} else {
-		decryptedFilePath = decryptedTmpFilePath
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
 	}
-
-	return decryptedFilePath, nil
+	if err == nil {
+		err = os.Rename(tmpPath, dstPath)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return nil
 }
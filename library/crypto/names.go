@@ -0,0 +1,125 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package crypto
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base32"
+	"errors"
+	"strings"
+)
+
+type (
+	// NameCipher 文件名加密, 相同的文件名总是得到相同的密文, 以便按路径查找
+	NameCipher struct {
+		aead   cipher.AEAD
+		macKey []byte
+	}
+)
+
+const (
+	// nameSalt 文件名没有头部可以保存盐, 使用固定的盐派生文件名密钥
+	nameSalt = "cloudpan189-go filename v1"
+
+	// MaxEncryptedNameLength 加密后文件名的长度上限, 为分片文件名等的后缀保留空间
+	MaxEncryptedNameLength = 230
+)
+
+var (
+	nameEncoding = base32.NewEncoding("0123456789abcdefghijklmnopqrstuv").WithPadding(base32.NoPadding)
+
+	// ErrNameTooLong 文件名加密后超过长度上限
+	ErrNameTooLong = errors.New("文件名过长, 加密后超过网盘的长度限制")
+)
+
+// NameKDFParams 派生文件名密钥的参数, 在不同设备上都能得到相同的文件名密钥
+func NameKDFParams(kdf KDF) KDFParams {
+	p, _ := NewKDFParams(kdf, []byte(nameSalt)[:16])
+	return p
+}
+
+// NewNameCipher 创建文件名加密, 密码模式下使用固定盐的 scrypt 派生密钥
+func (k *Keychain) NewNameCipher() (*NameCipher, error) {
+	p := NameKDFParams(KDFScrypt)
+	if k.isKeyFile {
+		p = NameKDFParams(KDFNone)
+	}
+	key, err := k.SubKey(p, "cloudpan189 name v1", 64)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key[:32])
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &NameCipher{
+		aead:   aead,
+		macKey: key[32:],
+	}, nil
+}
+
+// EncryptName 加密单个文件名, nonce 由文件名的 HMAC 生成 (SIV 构造)
+func (nc *NameCipher) EncryptName(name string) (string, error) {
+	mac := hmac.New(sha256.New, nc.macKey)
+	mac.Write([]byte(name))
+	nonce := mac.Sum(nil)[:nc.aead.NonceSize()]
+	encrypted := nameEncoding.EncodeToString(nc.aead.Seal(nonce, nonce, []byte(name), nil))
+	if len(encrypted) > MaxEncryptedNameLength {
+		return "", ErrNameTooLong
+	}
+	return encrypted, nil
+}
+
+// DecryptName 解密单个文件名, 不是加密文件名时返回错误
+func (nc *NameCipher) DecryptName(encrypted string) (string, error) {
+	data, err := nameEncoding.DecodeString(strings.ToLower(encrypted))
+	if err != nil || len(data) < nc.aead.NonceSize()+tagSize {
+		return "", ErrAuthFailed
+	}
+	nonce := data[:nc.aead.NonceSize()]
+	name, err := nc.aead.Open(nil, nonce, data[nc.aead.NonceSize():], nil)
+	if err != nil {
+		return "", ErrAuthFailed
+	}
+	// 校验 nonce, 确保同一文件名只有一种密文
+	mac := hmac.New(sha256.New, nc.macKey)
+	mac.Write(name)
+	if !hmac.Equal(mac.Sum(nil)[:nc.aead.NonceSize()], nonce) {
+		return "", ErrAuthFailed
+	}
+	return string(name), nil
+}
+
+// EncryptPath 逐级加密相对路径中的文件名
+func (nc *NameCipher) EncryptPath(relPath string) (string, error) {
+	names := strings.Split(relPath, "/")
+	for i, name := range names {
+		if name == "" || name == "." || name == ".." {
+			continue
+		}
+		encrypted, err := nc.EncryptName(name)
+		if err != nil {
+			return "", err
+		}
+		names[i] = encrypted
+	}
+	return strings.Join(names, "/"), nil
+}
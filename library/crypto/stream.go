@@ -0,0 +1,723 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package crypto
+
+// 分块认证加密 (AEAD) 格式:
+//
+//	头部: "C189ENC" | 版本(1) | 加密方法(1) | KDF(1) | KDF参数(3*4) | KDF盐(16) | 文件盐(16) | 分块大小(4)
+//	数据: 明文按分块大小切分, 每块加密后附带16字节认证标签
+//
+// 文件密钥和 nonce 前缀由主密钥和文件盐经 HKDF 派生, 每块的 nonce 为
+// nonce前缀(7) | 块序号(4) | 是否最后一块(1), 头部作为每块的附加数据参与认证.
+// 分块格式支持按偏移随机读取, 上传时无需生成临时文件.
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+	"golang.org/x/crypto/scrypt"
+)
+
+type (
+	// Method 认证加密方法
+	Method byte
+
+	// KDF 密钥派生函数
+	KDF byte
+
+	// KDFParams 密钥派生参数, scrypt 为 log2(N), r, p; argon2id 为 time, memory(KiB), threads
+	KDFParams struct {
+		KDF        KDF
+		P1, P2, P3 uint32
+		Salt       [16]byte
+	}
+
+	// Header 加密文件头部
+	Header struct {
+		Method    Method
+		KDFParams KDFParams
+		FileSalt  [16]byte
+		ChunkSize uint32
+	}
+
+	// Keychain 密码或密钥文件, 缓存派生的主密钥, 可并发使用
+	Keychain struct {
+		secret    []byte
+		isKeyFile bool
+		mu        sync.Mutex
+		masters   map[KDFParams][]byte
+	}
+
+	// StreamCipher 单个文件的分块加密
+	StreamCipher struct {
+		header      Header
+		headerBytes []byte
+		aead        cipher.AEAD
+		noncePrefix [7]byte
+	}
+)
+
+const (
+	// MethodAESGCM AES-256-GCM
+	MethodAESGCM Method = 1
+	// MethodChaCha20Poly1305 ChaCha20-Poly1305
+	MethodChaCha20Poly1305 Method = 2
+
+	// KDFNone 不派生, 使用密钥文件
+	KDFNone KDF = 0
+	// KDFScrypt scrypt
+	KDFScrypt KDF = 1
+	// KDFArgon2id argon2id
+	KDFArgon2id KDF = 2
+
+	// StreamMagic 加密文件头部的标识
+	StreamMagic = "C189ENC"
+	// StreamVersion 加密格式版本
+	StreamVersion = 1
+	// StreamHeaderSize 加密文件头部的大小
+	StreamHeaderSize = len(StreamMagic) + 3 + 12 + 16 + 16 + 4
+	// DefaultChunkSize 默认的分块大小
+	DefaultChunkSize = 64 * 1024
+	// MaxChunkSize 分块大小上限
+	MaxChunkSize = 16 * 1024 * 1024
+
+	tagSize = 16
+)
+
+var (
+	// ErrNotEncrypted 不是加密文件
+	ErrNotEncrypted = errors.New("不是加密文件")
+	// ErrAuthFailed 解密失败, 密码错误或文件已损坏
+	ErrAuthFailed = errors.New("解密失败, 密码错误或文件已损坏")
+	// ErrTruncated 加密文件不完整
+	ErrTruncated = errors.New("加密文件不完整")
+)
+
+// ParseMethod 解析加密方法
+func ParseMethod(s string) (Method, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "", "aes-256-gcm", "aes-gcm":
+		return MethodAESGCM, nil
+	case "chacha20-poly1305", "chacha20":
+		return MethodChaCha20Poly1305, nil
+	}
+	return 0, fmt.Errorf("不支持的加密方法: %s, 可选值: aes-256-gcm, chacha20-poly1305", s)
+}
+
+func (m Method) String() string {
+	switch m {
+	case MethodAESGCM:
+		return "aes-256-gcm"
+	case MethodChaCha20Poly1305:
+		return "chacha20-poly1305"
+	}
+	return fmt.Sprintf("unknown(%d)", byte(m))
+}
+
+// ParseKDF 解析密钥派生函数
+func ParseKDF(s string) (KDF, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "", "scrypt":
+		return KDFScrypt, nil
+	case "argon2id", "argon2":
+		return KDFArgon2id, nil
+	}
+	return 0, fmt.Errorf("不支持的密钥派生函数: %s, 可选值: scrypt, argon2id", s)
+}
+
+func (k KDF) String() string {
+	switch k {
+	case KDFNone:
+		return "keyfile"
+	case KDFScrypt:
+		return "scrypt"
+	case KDFArgon2id:
+		return "argon2id"
+	}
+	return fmt.Sprintf("unknown(%d)", byte(k))
+}
+
+// NewKDFParams 默认强度的密钥派生参数, salt 为空时随机生成
+func NewKDFParams(kdf KDF, salt []byte) (KDFParams, error) {
+	p := KDFParams{KDF: kdf}
+	switch kdf {
+	case KDFScrypt:
+		p.P1, p.P2, p.P3 = 15, 8, 1
+	case KDFArgon2id:
+		p.P1, p.P2, p.P3 = 3, 64*1024, 4
+	case KDFNone:
+	default:
+		return p, fmt.Errorf("不支持的密钥派生函数: %s", kdf)
+	}
+	if len(salt) == 0 {
+		if _, err := io.ReadFull(rand.Reader, p.Salt[:]); err != nil {
+			return p, err
+		}
+	} else {
+		copy(p.Salt[:], salt)
+	}
+	return p, nil
+}
+
+// check 参数来自文件头部, 上限按加密时写入的默认值留出余量, 避免恶意头部消耗大量内存和时间.
+// scrypt 内存约为 128*N*r 字节, 上限 N=2^20, r=8 即 1GiB; argon2id 内存上限 1GiB
+func (p KDFParams) check() error {
+	switch p.KDF {
+	case KDFNone:
+		return nil
+	case KDFScrypt:
+		if p.P1 < 10 || p.P1 > 20 || p.P2 == 0 || p.P2 > 8 || p.P3 == 0 || p.P3 > 4 {
+			return errors.New("scrypt 参数无效")
+		}
+		return nil
+	case KDFArgon2id:
+		if p.P1 == 0 || p.P1 > 10 || p.P2 < 8*1024 || p.P2 > 1024*1024 || p.P3 == 0 || p.P3 > 16 {
+			return errors.New("argon2id 参数无效")
+		}
+		return nil
+	}
+	return fmt.Errorf("不支持的密钥派生函数: %s", p.KDF)
+}
+
+// NewPassphraseKeychain 使用密码, 主密钥由 KDF 派生
+func NewPassphraseKeychain(passphrase string) *Keychain {
+	return &Keychain{
+		secret:  []byte(passphrase),
+		masters: map[KDFParams][]byte{},
+	}
+}
+
+// NewKeyFileKeychain 使用密钥文件的内容, 主密钥为其 SHA-256
+func NewKeyFileKeychain(key []byte) *Keychain {
+	return &Keychain{
+		secret:    key,
+		isKeyFile: true,
+		masters:   map[KDFParams][]byte{},
+	}
+}
+
+// IsKeyFile 是否使用密钥文件
+func (k *Keychain) IsKeyFile() bool {
+	return k.isKeyFile
+}
+
+// MasterKey 按参数派生主密钥, 结果会被缓存
+func (k *Keychain) MasterKey(p KDFParams) ([]byte, error) {
+	if k.isKeyFile != (p.KDF == KDFNone) {
+		if k.isKeyFile {
+			return nil, errors.New("该文件使用密码加密, 不能使用密钥文件解密")
+		}
+		return nil, errors.New("该文件使用密钥文件加密, 请指定密钥文件")
+	}
+	if err := p.check(); err != nil {
+		return nil, err
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if key, ok := k.masters[p]; ok {
+		return key, nil
+	}
+	var (
+		key []byte
+		err error
+	)
+	switch p.KDF {
+	case KDFNone:
+		sum := sha256.Sum256(k.secret)
+		key = sum[:]
+	case KDFScrypt:
+		key, err = scrypt.Key(k.secret, p.Salt[:], 1<<p.P1, int(p.P2), int(p.P3), 32)
+	case KDFArgon2id:
+		key = argon2.IDKey(k.secret, p.Salt[:], p.P1, p.P2, uint8(p.P3), 32)
+	}
+	if err != nil {
+		return nil, err
+	}
+	k.masters[p] = key
+	return key, nil
+}
+
+// SubKey 由主密钥派生指定用途的子密钥
+func (k *Keychain) SubKey(p KDFParams, info string, size int) ([]byte, error) {
+	master, err := k.MasterKey(p)
+	if err != nil {
+		return nil, err
+	}
+	key := make([]byte, size)
+	if _, err = io.ReadFull(hkdf.New(sha256.New, master, nil, []byte(info)), key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// NewStreamCipher 创建单个文件的分块加密, fileSalt 决定文件密钥
+func (k *Keychain) NewStreamCipher(method Method, p KDFParams, fileSalt [16]byte, chunkSize int) (*StreamCipher, error) {
+	if chunkSize <= 0 || chunkSize > MaxChunkSize {
+		return nil, fmt.Errorf("分块大小无效: %d", chunkSize)
+	}
+	master, err := k.MasterKey(p)
+	if err != nil {
+		return nil, err
+	}
+
+	// 派生文件密钥和 nonce 前缀
+	material := make([]byte, 32+7)
+	if _, err = io.ReadFull(hkdf.New(sha256.New, master, fileSalt[:], []byte("cloudpan189 stream v1")), material); err != nil {
+		return nil, err
+	}
+	var aead cipher.AEAD
+	switch method {
+	case MethodAESGCM:
+		block, err := aes.NewCipher(material[:32])
+		if err != nil {
+			return nil, err
+		}
+		aead, err = cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+	case MethodChaCha20Poly1305:
+		aead, err = chacha20poly1305.New(material[:32])
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("不支持的加密方法: %s", method)
+	}
+
+	sc := &StreamCipher{
+		header: Header{
+			Method:    method,
+			KDFParams: p,
+			FileSalt:  fileSalt,
+			ChunkSize: uint32(chunkSize),
+		},
+		aead: aead,
+	}
+	copy(sc.noncePrefix[:], material[32:])
+	sc.headerBytes = sc.header.marshal()
+	return sc, nil
+}
+
+// NewRandomStreamCipher 使用随机的文件盐
+func (k *Keychain) NewRandomStreamCipher(method Method, p KDFParams, chunkSize int) (*StreamCipher, error) {
+	var fileSalt [16]byte
+	if _, err := io.ReadFull(rand.Reader, fileSalt[:]); err != nil {
+		return nil, err
+	}
+	return k.NewStreamCipher(method, p, fileSalt, chunkSize)
+}
+
+// OpenStreamCipher 解析加密文件头部, 创建对应的分块解密
+func (k *Keychain) OpenStreamCipher(headerData []byte) (*StreamCipher, error) {
+	h, err := ParseHeader(headerData)
+	if err != nil {
+		return nil, err
+	}
+	return k.NewStreamCipher(h.Method, h.KDFParams, h.FileSalt, int(h.ChunkSize))
+}
+
+// IsEncrypted 数据是否以加密文件头部开始
+func IsEncrypted(data []byte) bool {
+	return len(data) >= len(StreamMagic) && string(data[:len(StreamMagic)]) == StreamMagic
+}
+
+// ParseHeader 解析加密文件头部
+func ParseHeader(data []byte) (*Header, error) {
+	if len(data) < StreamHeaderSize || !IsEncrypted(data) {
+		return nil, ErrNotEncrypted
+	}
+	data = data[len(StreamMagic):]
+	if data[0] != StreamVersion {
+		return nil, fmt.Errorf("不支持的加密格式版本: %d", data[0])
+	}
+	h := &Header{
+		Method: Method(data[1]),
+		KDFParams: KDFParams{
+			KDF: KDF(data[2]),
+			P1:  binary.BigEndian.Uint32(data[3:]),
+			P2:  binary.BigEndian.Uint32(data[7:]),
+			P3:  binary.BigEndian.Uint32(data[11:]),
+		},
+		ChunkSize: binary.BigEndian.Uint32(data[47:]),
+	}
+	copy(h.KDFParams.Salt[:], data[15:31])
+	copy(h.FileSalt[:], data[31:47])
+	if h.ChunkSize == 0 || h.ChunkSize > MaxChunkSize {
+		return nil, fmt.Errorf("分块大小无效: %d", h.ChunkSize)
+	}
+	if err := h.KDFParams.check(); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+func (h *Header) marshal() []byte {
+	buf := make([]byte, StreamHeaderSize)
+	n := copy(buf, StreamMagic)
+	buf[n] = StreamVersion
+	buf[n+1] = byte(h.Method)
+	buf[n+2] = byte(h.KDFParams.KDF)
+	binary.BigEndian.PutUint32(buf[n+3:], h.KDFParams.P1)
+	binary.BigEndian.PutUint32(buf[n+7:], h.KDFParams.P2)
+	binary.BigEndian.PutUint32(buf[n+11:], h.KDFParams.P3)
+	copy(buf[n+15:], h.KDFParams.Salt[:])
+	copy(buf[n+31:], h.FileSalt[:])
+	binary.BigEndian.PutUint32(buf[n+47:], h.ChunkSize)
+	return buf
+}
+
+// Header 加密文件头部
+func (sc *StreamCipher) Header() Header {
+	return sc.header
+}
+
+func (sc *StreamCipher) chunkSize() int64 {
+	return int64(sc.header.ChunkSize)
+}
+
+// chunkCount 明文大小对应的分块数量, 空文件也有一个分块
+func (sc *StreamCipher) chunkCount(plainSize int64) int64 {
+	if plainSize <= 0 {
+		return 1
+	}
+	return (plainSize + sc.chunkSize() - 1) / sc.chunkSize()
+}
+
+// EncryptedSize 明文大小对应的密文大小
+func (sc *StreamCipher) EncryptedSize(plainSize int64) int64 {
+	return int64(StreamHeaderSize) + plainSize + sc.chunkCount(plainSize)*tagSize
+}
+
+// EncryptedSizeOf 按默认分块大小计算密文大小
+func EncryptedSizeOf(plainSize int64) int64 {
+	chunks := int64(1)
+	if plainSize > 0 {
+		chunks = (plainSize + DefaultChunkSize - 1) / DefaultChunkSize
+	}
+	return int64(StreamHeaderSize) + plainSize + chunks*tagSize
+}
+
+// PlainSize 密文大小对应的明文大小
+func (sc *StreamCipher) PlainSize(encryptedSize int64) (int64, error) {
+	dataSize := encryptedSize - int64(StreamHeaderSize)
+	full := sc.chunkSize() + tagSize
+	if dataSize < tagSize {
+		return 0, ErrTruncated
+	}
+	chunks := (dataSize + full - 1) / full
+	plainSize := dataSize - chunks*tagSize
+	if plainSize < 0 || (chunks > 1 && dataSize%full != 0 && dataSize%full <= tagSize) {
+		return 0, ErrTruncated
+	}
+	return plainSize, nil
+}
+
+func (sc *StreamCipher) nonce(index uint64, last bool) []byte {
+	nonce := make([]byte, sc.aead.NonceSize())
+	copy(nonce, sc.noncePrefix[:])
+	binary.BigEndian.PutUint32(nonce[7:], uint32(index))
+	if last {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+func (sc *StreamCipher) seal(dst, plain []byte, index uint64, last bool) []byte {
+	return sc.aead.Seal(dst, sc.nonce(index, last), plain, sc.headerBytes)
+}
+
+func (sc *StreamCipher) open(dst, data []byte, index uint64, last bool) ([]byte, error) {
+	plain, err := sc.aead.Open(dst, sc.nonce(index, last), data, sc.headerBytes)
+	if err != nil {
+		return nil, ErrAuthFailed
+	}
+	return plain, nil
+}
+
+type (
+	// EncryptedReaderAt 按偏移读取明文加密后的数据
+	EncryptedReaderAt struct {
+		sc        *StreamCipher
+		plain     io.ReaderAt
+		plainSize int64
+		chunks    int64
+
+		mu        sync.Mutex
+		lastIndex int64
+		lastChunk []byte
+	}
+)
+
+// NewReaderAt 返回明文加密后的随机读取器, 相同的文件盐和明文得到相同的密文
+func (sc *StreamCipher) NewReaderAt(plain io.ReaderAt, plainSize int64) *EncryptedReaderAt {
+	return &EncryptedReaderAt{
+		sc:        sc,
+		plain:     plain,
+		plainSize: plainSize,
+		chunks:    sc.chunkCount(plainSize),
+		lastIndex: -1,
+	}
+}
+
+// Size 密文大小
+func (er *EncryptedReaderAt) Size() int64 {
+	return er.sc.EncryptedSize(er.plainSize)
+}
+
+// sealedChunk 加密第 index 块
+func (er *EncryptedReaderAt) sealedChunk(index int64) ([]byte, error) {
+	er.mu.Lock()
+	defer er.mu.Unlock()
+	if index == er.lastIndex {
+		return er.lastChunk, nil
+	}
+	cs := er.sc.chunkSize()
+	start := index * cs
+	size := cs
+	if start+size > er.plainSize {
+		size = er.plainSize - start
+	}
+	buf := make([]byte, size, size+tagSize)
+	if size > 0 {
+		if _, err := er.plain.ReadAt(buf, start); err != nil && err != io.EOF {
+			return nil, err
+		}
+	}
+	er.lastChunk = er.sc.seal(buf[:0], buf, uint64(index), index == er.chunks-1)
+	er.lastIndex = index
+	return er.lastChunk, nil
+}
+
+// ReadAt 读取密文
+func (er *EncryptedReaderAt) ReadAt(p []byte, off int64) (n int, err error) {
+	size := er.Size()
+	if off >= size {
+		return 0, io.EOF
+	}
+	for n < len(p) && off < size {
+		if off < int64(StreamHeaderSize) {
+			c := copy(p[n:], er.sc.headerBytes[off:])
+			n += c
+			off += int64(c)
+			continue
+		}
+		full := er.sc.chunkSize() + tagSize
+		index := (off - int64(StreamHeaderSize)) / full
+		chunk, err := er.sealedChunk(index)
+		if err != nil {
+			return n, err
+		}
+		c := copy(p[n:], chunk[(off-int64(StreamHeaderSize))%full:])
+		n += c
+		off += int64(c)
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+type (
+	streamWriter struct {
+		sc     *StreamCipher
+		w      io.Writer
+		buf    []byte
+		index  uint64
+		header bool
+	}
+
+	streamReader struct {
+		sc    *StreamCipher
+		r     *bufio.Reader
+		buf   []byte
+		plain []byte
+		index uint64
+		done  bool
+	}
+)
+
+// NewWriter 返回加密写入器, 必须调用 Close 写入最后一块
+func (sc *StreamCipher) NewWriter(w io.Writer) io.WriteCloser {
+	return &streamWriter{
+		sc:  sc,
+		w:   w,
+		buf: make([]byte, 0, sc.chunkSize()+tagSize),
+	}
+}
+
+func (sw *streamWriter) writeHeader() error {
+	if sw.header {
+		return nil
+	}
+	sw.header = true
+	_, err := sw.w.Write(sw.sc.headerBytes)
+	return err
+}
+
+func (sw *streamWriter) Write(p []byte) (n int, err error) {
+	if err = sw.writeHeader(); err != nil {
+		return 0, err
+	}
+	cs := int(sw.sc.chunkSize())
+	for len(p) > 0 {
+		// 缓冲区满且还有数据时, 才能确定不是最后一块
+		if len(sw.buf) == cs {
+			if _, err = sw.w.Write(sw.sc.seal(sw.buf[:0], sw.buf, sw.index, false)); err != nil {
+				return n, err
+			}
+			sw.index++
+			sw.buf = sw.buf[:0]
+		}
+		c := cs - len(sw.buf)
+		if c > len(p) {
+			c = len(p)
+		}
+		sw.buf = append(sw.buf, p[:c]...)
+		p = p[c:]
+		n += c
+	}
+	return n, nil
+}
+
+func (sw *streamWriter) Close() error {
+	if err := sw.writeHeader(); err != nil {
+		return err
+	}
+	_, err := sw.w.Write(sw.sc.seal(sw.buf[:0], sw.buf, sw.index, true))
+	return err
+}
+
+// NewReader 读取加密文件头部, 返回解密读取器. 认证失败或文件不完整时读取返回错误
+func (k *Keychain) NewReader(r io.Reader) (io.Reader, error) {
+	header := make([]byte, StreamHeaderSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		if err == io.ErrUnexpectedEOF || err == io.EOF {
+			return nil, ErrNotEncrypted
+		}
+		return nil, err
+	}
+	sc, err := k.OpenStreamCipher(header)
+	if err != nil {
+		return nil, err
+	}
+	return &streamReader{
+		sc:  sc,
+		r:   bufio.NewReaderSize(r, int(sc.chunkSize())+tagSize+1),
+		buf: make([]byte, sc.chunkSize()+tagSize),
+	}, nil
+}
+
+func (sr *streamReader) Read(p []byte) (int, error) {
+	for len(sr.plain) == 0 {
+		if sr.done {
+			return 0, io.EOF
+		}
+		n, err := io.ReadFull(sr.r, sr.buf)
+		last := false
+		switch err {
+		case nil:
+			// 完整的一块, 之后没有数据则为最后一块
+			if _, peekErr := sr.r.Peek(1); peekErr == io.EOF {
+				last = true
+			}
+		case io.ErrUnexpectedEOF:
+			last = true
+		case io.EOF:
+			return 0, ErrTruncated
+		default:
+			return 0, err
+		}
+		if n < tagSize {
+			return 0, ErrTruncated
+		}
+		sr.plain, err = sr.sc.open(sr.buf[:0], sr.buf[:n], sr.index, last)
+		if err != nil {
+			return 0, err
+		}
+		sr.index++
+		sr.done = last
+	}
+	n := copy(p, sr.plain)
+	sr.plain = sr.plain[n:]
+	return n, nil
+}
+
+// EncryptedRange 明文区间所在的密文区间, 按分块对齐
+func (sc *StreamCipher) EncryptedRange(plainOffset, plainLength int64) (offset, length int64, firstChunk int64) {
+	full := sc.chunkSize() + tagSize
+	firstChunk = plainOffset / sc.chunkSize()
+	lastChunk := firstChunk
+	if plainLength > 0 {
+		lastChunk = (plainOffset + plainLength - 1) / sc.chunkSize()
+	}
+	offset = int64(StreamHeaderSize) + firstChunk*full
+	length = (lastChunk - firstChunk + 1) * full
+	return
+}
+
+// DecryptRange 解密 EncryptedRange 读取的密文, 返回其中的明文区间
+// encryptedSize 为整个加密文件的大小, 用于判断最后一块
+func (sc *StreamCipher) DecryptRange(data []byte, firstChunk, encryptedSize, plainOffset, plainLength int64) ([]byte, error) {
+	plainSize, err := sc.PlainSize(encryptedSize)
+	if err != nil {
+		return nil, err
+	}
+	chunks := sc.chunkCount(plainSize)
+	full := int(sc.chunkSize() + tagSize)
+	plain := make([]byte, 0, len(data))
+	for index := firstChunk; len(data) > 0; index++ {
+		n := full
+		if n > len(data) {
+			n = len(data)
+		}
+		plain, err = sc.open(plain, data[:n], uint64(index), index == chunks-1)
+		if err != nil {
+			return nil, err
+		}
+		data = data[n:]
+	}
+	start := plainOffset - firstChunk*sc.chunkSize()
+	if start < 0 || start+plainLength > int64(len(plain)) {
+		return nil, ErrTruncated
+	}
+	return plain[start : start+plainLength], nil
+}
+
+// DecryptBytes 解密整个加密文件的内容
+func (k *Keychain) DecryptBytes(data []byte) ([]byte, error) {
+	r, err := k.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	buf := &bytes.Buffer{}
+	if _, err = io.Copy(buf, r); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,71 @@
+// Copyright (c) 2020 tickstep.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package crypto
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestParseHeaderKDFParams(t *testing.T) {
+	testCases := []struct {
+		name   string
+		params KDFParams
+		ok     bool
+	}{
+		{"scrypt default", KDFParams{KDF: KDFScrypt, P1: 15, P2: 8, P3: 1}, true},
+		{"scrypt max", KDFParams{KDF: KDFScrypt, P1: 20, P2: 8, P3: 4}, true},
+		{"scrypt large N", KDFParams{KDF: KDFScrypt, P1: 22, P2: 8, P3: 1}, false},
+		{"scrypt large r", KDFParams{KDF: KDFScrypt, P1: 15, P2: 32, P3: 1}, false},
+		{"scrypt large p", KDFParams{KDF: KDFScrypt, P1: 15, P2: 8, P3: 16}, false},
+		{"scrypt zero r", KDFParams{KDF: KDFScrypt, P1: 15, P2: 0, P3: 1}, false},
+		{"argon2id default", KDFParams{KDF: KDFArgon2id, P1: 3, P2: 64 * 1024, P3: 4}, true},
+		{"argon2id 1GiB", KDFParams{KDF: KDFArgon2id, P1: 3, P2: 1024 * 1024, P3: 4}, true},
+		{"argon2id 4GiB", KDFParams{KDF: KDFArgon2id, P1: 3, P2: 4 * 1024 * 1024, P3: 4}, false},
+		{"argon2id many passes", KDFParams{KDF: KDFArgon2id, P1: 64, P2: 64 * 1024, P3: 4}, false},
+		{"argon2id many threads", KDFParams{KDF: KDFArgon2id, P1: 3, P2: 64 * 1024, P3: 255}, false},
+		{"unknown kdf", KDFParams{KDF: 9}, false},
+	}
+
+	for _, tc := range testCases {
+		h := &Header{Method: MethodAESGCM, KDFParams: tc.params, ChunkSize: DefaultChunkSize}
+		_, err := ParseHeader(h.marshal())
+		if (err == nil) != tc.ok {
+			t.Errorf("%s: ParseHeader() error = %v, want ok %v", tc.name, err, tc.ok)
+		}
+	}
+}
+
+func TestNewReaderMaliciousHeader(t *testing.T) {
+	// 恶意头部应在派生主密钥之前被拒绝, 否则会分配数十 GiB 内存
+	testCases := []struct {
+		name   string
+		params KDFParams
+	}{
+		{"scrypt", KDFParams{KDF: KDFScrypt, P1: 30, P2: 32, P3: 16}},
+		{"argon2id", KDFParams{KDF: KDFArgon2id, P1: 64, P2: 4 * 1024 * 1024, P3: 255}},
+	}
+
+	k := NewPassphraseKeychain("password")
+	for _, tc := range testCases {
+		h := &Header{Method: MethodAESGCM, KDFParams: tc.params, ChunkSize: DefaultChunkSize}
+		data := append(h.marshal(), make([]byte, 64)...)
+		if _, err := k.NewReader(bytes.NewReader(data)); err == nil {
+			t.Errorf("%s: NewReader() accepted malicious header", tc.name)
+		}
+		if _, err := k.MasterKey(tc.params); err == nil {
+			t.Errorf("%s: MasterKey() accepted malicious params", tc.name)
+		}
+	}
+}
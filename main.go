@@ -440,6 +440,9 @@ func main() {
 		// 下载文件/目录 download
 		command.CmdDownload(),
 
+		// 输出文件内容 cat
+		command.CmdCat(),
+
 		// 导出文件/目录元数据 export
 		command.CmdExport(),
 
@@ -447,7 +450,13 @@ func main() {
 		command.CmdImport(),
 
 		// 回收站
-		//command.CmdRecycle(),
+		command.CmdRecycle(),
+
+		// 操作日志 history
+		command.CmdHistory(),
+
+		// 撤销操作 undo
+		command.CmdUndo(),
 
 		// 显示和修改程序配置项 config
 		command.CmdConfig(),